@@ -27,6 +27,7 @@ const json2html = require('node-json2html');
 const http = require('http');
 const url = require('url');
 const fs = require('fs');
+const crypto = require('crypto');
 const yaml = require('js-yaml');
 const { FileSystemWallet, Gateway } = require('fabric-network');
 const Client = require('fabric-client')
@@ -92,24 +93,25 @@ async function main() {
 	//if user has supplied args then we assume he wants to make a single tx.
 	  //if not, then we multiple txs will be made (see the loop below).
 	  var args = process.argv.slice(2);
-	  if (args.length >= 2) {
+	  if (args.length >= 1) {
 		  let resp;
 		  console.log(args);
 		  switch (args[0]) {
+			  //args are IDs of existing assets (e.g. Crude12), new IDs are minted by the chaincode.
 			  case 'deliverCrude':
-				resp = await deliverCrudeRand(contract,args[1]);
+				resp = await deliverCrudeRand(contract);
 				break;
 			  case 'transferCrude':
 				resp = await transferCrude(contract,args[1]);
 				break;
 			  case 'refineRand':
-				resp = await refineRand(contract,args[1],args[1]);
+				resp = await refineRand(contract,args[1]);
 				break;
 			  case 'addFuelOrderRand':
-				resp = await addFuelOrderRand(contract,args[1],args[2]);
+				resp = await addFuelOrderRand(contract,args[1]);
 				break;
 			  case 'deliverFuelRand':
-				resp = await deliverFuelRand(contract,args[1],args.slice(2));
+				resp = await deliverFuelRand(contract,args.slice(1));
 				break;
 			  case 'transferFuel':
 				resp = await transferFuel(contract,args[1],args[2]);
@@ -121,33 +123,27 @@ async function main() {
 		  return;
 	  }
 
-	let init_id = 0;
 	let i;
-	let forder_count = 1;
     let resp;
 	  //submit transactions .
 	  //create Crude oil ->transfer -> refine -> create fuelOrder(s) -> deliver orders -> transfer fuel to retailers.
+	  //every tx that creates an asset returns the ID the chaincode gave to it.
 	for (i = 1;i < 5; i++) {
-		resp = await deliverCrudeRand(contract,i);
-		console.log(resp);
+		let crude = (await deliverCrudeRand(contract)).toString();
+		console.log(crude);
 	
-		resp = await transferCrude(contract,i)
-		console.log(resp);
-		resp = await refineRand(contract,i,i);
-		console.log(resp);
-		resp = await addFuelOrderRand(contract,forder_count++,i);
-		console.log(resp);
-		
-		resp = await addFuelOrderRand(contract,forder_count++,i);
-		console.log(resp);
-		resp = await addFuelOrderRand(contract,forder_count++,i);
-		console.log(resp);
-		let forders = [forder_count-3,forder_count-2,forder_count-1]
-		console.log(resp);
-		resp = await deliverFuelRand(contract,i,forders);
-		console.log(resp);
-		resp = await transferFuel(contract,forders[0],i)
+		resp = await transferCrude(contract,crude)
 		console.log(resp);
+		let fuel = (await refineRand(contract,crude)).toString();
+		console.log(fuel);
+		let forders = [];
+		forders.push((await addFuelOrderRand(contract,fuel)).toString());
+		forders.push((await addFuelOrderRand(contract,fuel)).toString());
+		forders.push((await addFuelOrderRand(contract,fuel)).toString());
+		console.log(forders);
+		let plan = (await deliverFuelRand(contract,forders)).toString();
+		console.log(plan);
+		resp = await transferFuel(contract,forders[0],plan)
 		console.log(resp);
 	}
 
@@ -171,7 +167,7 @@ async function queryByRange(contract,type) {
 		return 'wrong type in queryByRange';
 	}
 	try {
-		let resp = await contract.submitTransaction('queryAssetByRange',type);
+		let resp = await contract.evaluateTransaction('queryAssetByRange',type);
 		let data = resp.toString()
 		fs.writeFile(type+'s',data,(err) => {
 		  if (err) console.log(err);
@@ -193,7 +189,7 @@ function queryByRange2(contract,type) {
 		return 'wrong type in queryByRange';
 	}
 	try {
-		let resp = contract.submitTransaction('queryAssetByRange',type);
+		let resp = contract.evaluateTransaction('queryAssetByRange',type);
 		let data = resp.toString()
 		fs.writeFile(type+'s',data,(err) => {
 		  if (err) console.log(err);
@@ -245,12 +241,30 @@ async function queryAsset(contract,asset_id) {
 }
 
 
-function deliverCrude(contract,crude_num,value,quant,owner,estTime,startLoc,dest,vessel_id) {
-	return contract.submitTransaction('deliverCrude','Crude'+crude_num,value,quant,'org'+owner,estTime,dest,vessel_id)
+//transactions are submitted as JSON requests (see supply_chainCode/schema.go), so the order of the args can't go wrong.
+function submitRequest(contract,fn,req) {
+	req.schemaVersion = 1;
+	return contract.submitTransaction(fn,JSON.stringify(req))
 }
 
+//the value is a term between the supplier and the buyer only: it goes in the transient map, and the
+//chaincode keeps it in the private data collection of the pair (see supply_chainCode/privacy.go).
+function submitPrivateRequest(contract,fn,req,value) {
+	req.schemaVersion = 1;
+	req.value = 0;
+	let terms = {Value:Number(value).toFixed(2),Salt:crypto.randomBytes(16).toString('hex')};
+	return contract.createTransaction(fn)
+		.setTransient({terms:Buffer.from(JSON.stringify(terms))})
+		.submit(JSON.stringify(req))
+}
 
-function deliverCrudeRand(contract,crude_num) {
+function deliverCrude(contract,value,quant,owner,estTime,startLoc,dest,vessel_id) {
+	return submitPrivateRequest(contract,'deliverCrude',{quantity:quant,owner:'org'+owner,
+		estTime:estTime,startLocation:startLoc,destination:dest,vesselID:vessel_id,timestamp:(new Date()).toISOString()},value)
+}
+
+
+function deliverCrudeRand(contract) {
 	let value = Math.floor(Math.random()*101) +1;
 	let quant = Math.floor(Math.random()*101) +1;
 	let owner = 'org1';
@@ -262,19 +276,21 @@ function deliverCrudeRand(contract,crude_num) {
 	let startLoc = owner;
 	let dest = 'org3';
 	let vessel_id = Math.floor(Math.random()*1001) +1;
-	return contract.submitTransaction('deliverCrude','Crude'+crude_num,value.toString(),quant.toString(),owner,estTime,startLoc,dest,vessel_id.toString(),(new Date()).toISOString())
+	return submitPrivateRequest(contract,'deliverCrude',{quantity:quant,owner:owner,
+		estTime:estTime,startLocation:startLoc,destination:dest,vesselID:vessel_id.toString(),timestamp:(new Date()).toISOString()},value)
 }
 
-function refineRand(contract,fuel_num,crude_num) {
+function refineRand(contract,crude_id) {
 	let value = Math.floor(Math.random()*101) +1;
 	let quant = Math.floor(Math.random()*101) +1;
 	let owner = 'org3';
 	let density = Math.floor(Math.random()*101) +1;
 	let type = 'fuel';
-	return contract.submitTransaction('refine','Fuel'+fuel_num,value.toString(),quant.toString(),owner,density.toString(),type,'Crude'+crude_num,(new Date).toISOString())
+	return submitRequest(contract,'refine',{value:value,quantity:quant,owner:owner,
+		density:density,fuelType:type,crudeID:crude_id,timestamp:(new Date).toISOString()})
 }
 
-function addFuelOrderRand(contract,fuelOrder_num,fuel_num) {
+function addFuelOrderRand(contract,fuel_id) {
 	let value = Math.floor(Math.random()*101) +1;
 	let quant = Math.floor(Math.random()*101) +1;
 	let owner = 'org3';
@@ -284,40 +300,41 @@ function addFuelOrderRand(contract,fuelOrder_num,fuel_num) {
 		dest = 'org5';
 	else if (rcoin == 1) 
 		dest = 'org6';
-	return contract.submitTransaction('addFuelOrder','FuelOrder'+fuelOrder_num,value.toString(),quant.toString(),owner,dest,'Fuel'+fuel_num,(new Date()).toISOString())
+	return submitPrivateRequest(contract,'addFuelOrder',{quantity:quant,owner:owner,
+		destination:dest,fuelID:fuel_id,timestamp:(new Date()).toISOString()},value)
 }
 
-function deliverFuelRand(contract,plan_num,fuelOrders) {
+async function deliverFuelRand(contract,fuelOrders) {
 	let trackid = Math.floor(Math.random()*10001) +1;
-	let i,dest,startLoc,time,estTime,dur;
+	//trucks should be registered with their capacity before they get a plan
+	await submitRequest(contract,'registerVehicle',{type:'Truck',vehicleID:trackid.toString(),capacity:1000});
+	let i,dest,startLoc,time,estTime,dur,order;
 	startLoc = 'org3';
-	let rcoin = Math.floor(Math.random()*2);
-	if (rcoin == 0) 
-		dest = 'org5';
-	else if (rcoin == 1) 
-		dest = 'org6';
-	let args_arr = ['deliverFuel','Plan'+plan_num,trackid.toString()]
+	let deliveries = [];
 	for (i = 0; i < fuelOrders.length; i++) {
 		dur = Math.floor(Math.random()*101) +1;
 		time = new Date();
 		time.setSeconds(time.getSeconds() + dur)
 		estTime = time.toISOString();
-		args_arr.push('FuelOrder'+fuelOrders[i],estTime,startLoc,dest)
+		//the plan should deliver each order where the refiner said it goes
+		order = JSON.parse((await contract.evaluateTransaction('queryAsset',fuelOrders[i])).toString());
+		dest = order.Dest;
+		deliveries.push({fuelOrderID:fuelOrders[i],estTime:estTime,startLocation:startLoc,destination:dest})
 	}
-	return contract.submitTransaction(...args_arr)
+	return submitRequest(contract,'deliverFuel',{truckID:trackid.toString(),deliveries:deliveries})
 }
 
-function transferFuel(contract,fuelOrder_num,plan_num) {
+function transferFuel(contract,fuelOrder_id,plan_id) {
 	let rcoin = Math.floor(Math.random()*2);
 	let dest;
 	if (rcoin == 0) 
 		dest = 'org5';
 	else if (rcoin == 1) 
 		dest = 'org6';
-	return contract.submitTransaction('transfer','FuelOrder'+fuelOrder_num,dest,(new Date()).toISOString(),'Plan'+plan_num)
+	return submitRequest(contract,'transfer',{assetID:fuelOrder_id,owner:dest,timestamp:(new Date()).toISOString(),planID:plan_id})
 }
-function transferCrude(contract,crude_num) {
-	return contract.submitTransaction('transfer','Crude'+crude_num,'org3',(new Date()).toISOString())
+function transferCrude(contract,crude_id) {
+	return submitRequest(contract,'transfer',{assetID:crude_id,owner:'org3',timestamp:(new Date()).toISOString()})
 }
 
 main().then(() => {
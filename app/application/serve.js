@@ -16,6 +16,7 @@ const querystring = require('querystring');
 const http = require('http');
 const url = require('url');
 const fs = require('fs');
+const crypto = require('crypto');
 const yaml = require('js-yaml');
 const { FileSystemWallet, Gateway } = require('fabric-network');
 const Client = require('fabric-client')
@@ -75,6 +76,20 @@ async function main() {
     console.log(`Block: ${block}`);
 	});
 
+	//every tx that changes the ledger emits a chaincode event with all of its typed events.
+	const contractListener = await contract.addContractListener('my-contract-listener', '.*', (error, event) => {
+		if (error) {
+			console.error(error);
+			return;
+		}
+		let payload = JSON.parse(event.payload.toString());
+		payload.Events.forEach((e) => {
+			fs.appendFile('events',JSON.stringify(e)+'\n',(err) => {
+			  if (err) console.log(err);
+			});
+		});
+	});
+
 	//start server
 	serve(gateway,contract);
 
@@ -91,14 +106,15 @@ async function main() {
   }
 }
 
-async function queryByRange(contract,type) {
+//results come in pages of pageSize assets. bookmark is the Bookmark of the previous page ('' for the first one).
+async function queryByRange(contract,type,pageSize,bookmark) {
 	console.log(type)
 	if (type != 'Plan' && type != 'Fuel' && type != 'FuelOrder' && type != 'Crude' && type != 'org') {
 		console.log('wrong type in queryByRange');
 		return 'wrong type in queryByRange';
 	}
 	try {
-		let resp = await contract.submitTransaction('queryAssetByRange',type);
+		let resp = await contract.evaluateTransaction('queryAssetByRange',type,(pageSize || '').toString(),bookmark || '');
 		let data = resp.toString()
 		fs.writeFile(type+'s',data,(err) => {
 		  if (err) console.log(err);
@@ -120,7 +136,7 @@ function queryByRange2(contract,type) {
 		return 'wrong type in queryByRange';
 	}
 	try {
-		let resp = contract.submitTransaction('queryAssetByRange',type);
+		let resp = contract.evaluateTransaction('queryAssetByRange',type);
 		let data = resp.toString()
 		fs.writeFile(type+'s',data,(err) => {
 		  if (err) console.log(err);
@@ -171,12 +187,21 @@ async function queryAsset(contract,asset_id) {
 }
 
 
-function deliverCrude(contract,crude_num,value,quant,owner,estTime,startLoc,dest,vessel_id) {
-	return contract.submitTransaction('deliverCrude','Crude'+crude_num,value,quant,'org'+owner,estTime,dest,vessel_id)
+//the value goes in the transient map and the value arg is 0, so only the trading pair sees it (see supply_chainCode/privacy.go).
+function submitPrivate(contract,fn,value,...args) {
+	let terms = {Value:Number(value).toFixed(2),Salt:crypto.randomBytes(16).toString('hex')};
+	return contract.createTransaction(fn)
+		.setTransient({terms:Buffer.from(JSON.stringify(terms))})
+		.submit('0',...args)
+}
+
+//the chaincode mints the IDs of new assets and returns them (see supply_chainCode/ids.go).
+function deliverCrude(contract,value,quant,owner,estTime,startLoc,dest,vessel_id) {
+	return submitPrivate(contract,'deliverCrude',value,quant,'org'+owner,estTime,startLoc,dest,vessel_id,(new Date()).toISOString())
 }
 
 
-function deliverCrudeRand(contract,crude_num) {
+function deliverCrudeRand(contract) {
 	let value = Math.floor(Math.random()*101) +1;
 	let quant = Math.floor(Math.random()*101) +1;
 	let owner = 'org1';
@@ -188,19 +213,19 @@ function deliverCrudeRand(contract,crude_num) {
 	let startLoc = owner;
 	let dest = 'org3';
 	let vessel_id = Math.floor(Math.random()*1001) +1;
-	return contract.submitTransaction('deliverCrude','Crude'+crude_num,value.toString(),quant.toString(),owner,estTime,startLoc,dest,vessel_id.toString(),(new Date()).toISOString())
+	return submitPrivate(contract,'deliverCrude',value,quant.toString(),owner,estTime,startLoc,dest,vessel_id.toString(),(new Date()).toISOString())
 }
 
-function refineRand(contract,fuel_num,crude_num) {
+function refineRand(contract,crude_num) {
 	let value = Math.floor(Math.random()*101) +1;
 	let quant = Math.floor(Math.random()*101) +1;
 	let owner = 'org3';
 	let density = Math.floor(Math.random()*101) +1;
 	let type = 'fuel';
-	return contract.submitTransaction('refine','Fuel'+fuel_num,value.toString(),quant.toString(),owner,density.toString(),type,'Crude'+crude_num,(new Date).toISOString())
+	return contract.submitTransaction('refine',value.toString(),quant.toString(),owner,density.toString(),type,'Crude'+crude_num,(new Date).toISOString())
 }
 
-function addFuelOrderRand(contract,fuelOrder_num,fuel_num) {
+function addFuelOrderRand(contract,fuel_num) {
 	let value = Math.floor(Math.random()*101) +1;
 	let quant = Math.floor(Math.random()*101) +1;
 	let owner = 'org3';
@@ -210,15 +235,15 @@ function addFuelOrderRand(contract,fuelOrder_num,fuel_num) {
 		dest = 'org5';
 	else if (rcoin == 1) 
 		dest = 'org6';
-	return contract.submitTransaction('addFuelOrder','FuelOrder'+fuelOrder_num,value.toString(),quant.toString(),owner,dest,'Fuel'+fuel_num,(new Date()).toISOString())
+	return submitPrivate(contract,'addFuelOrder',value,quant.toString(),owner,dest,'Fuel'+fuel_num,(new Date()).toISOString())
 }
 
-function deliverFuelRand(contract,plan_num,fuelOrders) {
+function deliverFuelRand(contract,fuelOrders) {
 	let trackid = Math.floor(Math.random()*10001) +1;
 	let i,dest,rcoin,startLoc,time,estTime,dur;
 	startLoc = 'org3';
 	dest = 'org5/6';
-	let args_arr = ['deliverFuel','Plan'+plan_num,trackid.toString()]
+	let args_arr = ['deliverFuel',trackid.toString()]
 	for (i = 0; i < fuelOrders.length; i++) {
 		dur = Math.floor(Math.random()*101) +1;
 		time = new Date();
@@ -236,8 +261,9 @@ function transferCrude(contract,crude_num) {
 	return contract.submitTransaction('transfer','Crude'+crude_num,'org3',(new Date()).toISOString())
 }
 /* a client can make GET request to this server with URLs:
- /Plan , /Fuel, /FuelOrder , /Crude . These commands show all assets that exist e.g Crude1 , Crude2 ... CrudeN 
- /PlanID , /FuelID, /FuelOrderID , /CrudeID . Here ID is a number. These commands show the details of the specific asset e.g. Crude1 , Crude2413 , Plan2312
+ /Plan , /Fuel, /FuelOrder , /Crude . These commands show all assets that exist e.g Crude0000000001 , Crude0000000002 ... 
+   a page at a time. Add ?pageSize=N to change the size of the page and ?bookmark=B (the Bookmark of the page) for the next one.
+ /PlanID , /FuelID, /FuelOrderID , /CrudeID . Here ID is a number. These commands show the details of the specific asset e.g. Crude0000000001 , Crude0000002413 , Plan0000002312
  /blocks . showing the last commited block.
  /history/AssetID . showing the history of changes in db of this asset (currently not available).
  /org1 /org2 /org3 ... to see the account balance of these orgs.
@@ -262,7 +288,7 @@ async function serve(gateway,contract) {
 			let resp;
 			switch (params['m']) {
 				case 'deliverCrude':
-                  resp = await deliverCrudeRand(contract,params['id']).catch ((e) => {
+                  resp = await deliverCrudeRand(contract).catch ((e) => {
 				  console.log(e);
 			      });
                   break;
@@ -272,12 +298,12 @@ async function serve(gateway,contract) {
 			      });
                   break;
                 case 'refineRand':
-                  resp = await refineRand(contract,params['id'],params['id']).catch ((e) => {
+                  resp = await refineRand(contract,params['id']).catch ((e) => {
 				  console.log(e);
 			      });
                   break;
                 case 'addFuelOrderRand':
-                  resp = await addFuelOrderRand(contract,params['id'],params['id2']).catch ((e) => {
+                  resp = await addFuelOrderRand(contract,params['id']).catch ((e) => {
 				  console.log(e);
 			      });
                   break;
@@ -295,7 +321,8 @@ async function serve(gateway,contract) {
 					res.write('Thats not a valid update option.Please try again.');
 					return res.end();
 			}
-		  return res.end('Update was successful');
+		  //creating an asset returns its new ID
+		  return res.end('Update was successful' + (resp ? ': ' + resp.toString() : ''));
 		}
 	  let regex = /[0-9]+$/;
 		let transforms;
@@ -318,7 +345,8 @@ async function serve(gateway,contract) {
 	  let qres;
 	  if (ind < 0 ) {
 		  res.writeHead(200, {'Content-Type': 'application/json'});
-		  let ret = await queryByRange(contract,q.pathname.slice(1)).catch ((e) => {
+		  let page = querystring.parse(q.query);
+		  let ret = await queryByRange(contract,q.pathname.slice(1),page['pageSize'],page['bookmark']).catch ((e) => {
 			  console.log(e);
 			  res.write('Could not locate asset');
 			  return res.end();
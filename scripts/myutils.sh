@@ -167,6 +167,9 @@ installChaincode() {
   echo
 }
 
+#private data collections of the trading pairs (see supply_chainCode/privacy.go)
+: ${COLLECTIONS_CONFIG:="/opt/gopath/src/github.com/chaincode/supply_chainCode/collections_config.json"}
+
 #chaincode name is the third arg
 #we dont care about non-TLS because we I use TLS.
 instantiateChaincode() {
@@ -186,7 +189,7 @@ instantiateChaincode() {
     set +x
   else
     set -x
-    peer chaincode instantiate -o orderer.example.com:7050 --tls $CORE_PEER_TLS_ENABLED --cafile $ORDERER_CA -C $CHANNEL_NAME -n ${NAME} -l ${LANGUAGE} -v 1.0 -c '{"Args":["init","a","100","b","200"]}' -P "OR ('Org1MSP.peer','Org2MSP.peer','Org3MSP.peer','Org4MSP.peer','Org5MSP.peer','Org6MSP.peer')" >&log.txt
+    peer chaincode instantiate -o orderer.example.com:7050 --tls $CORE_PEER_TLS_ENABLED --cafile $ORDERER_CA -C $CHANNEL_NAME -n ${NAME} -l ${LANGUAGE} -v 1.0 -c '{"Args":["init","a","100","b","200"]}' --collections-config $COLLECTIONS_CONFIG -P "OR ('Org1MSP.peer','Org2MSP.peer','Org3MSP.peer','Org4MSP.peer','Org5MSP.peer','Org6MSP.peer')" >&log.txt
     res=$?
     set +x
   fi
@@ -203,7 +206,7 @@ upgradeChaincode() {
   setGlobals $PEER $ORG
 
   set -x
-  peer chaincode upgrade -o orderer.example.com:7050 --tls $CORE_PEER_TLS_ENABLED --cafile $ORDERER_CA -C mychannel -n scthreediff6 -v $VERS -c '{"Args":["init","a","90","b","210"]}' -P "AND ('Org1MSP.peer','Org2MSP.peer','Org3MSP.peer','Org4MSP.peer','Org5MSP.peer','Org6MSP.peer')" >&log.txt
+  peer chaincode upgrade -o orderer.example.com:7050 --tls $CORE_PEER_TLS_ENABLED --cafile $ORDERER_CA -C mychannel -n scthreediff6 -v $VERS -c '{"Args":["init","a","90","b","210"]}' --collections-config $COLLECTIONS_CONFIG -P "OutOf(2, 'Org1MSP.peer','Org2MSP.peer','Org3MSP.peer','Org4MSP.peer','Org5MSP.peer','Org6MSP.peer')" >&log.txt
   res=$?
   set +x
   cat log.txt
@@ -220,7 +223,7 @@ upgradeChaincodeSimple() {
   setGlobals $PEER $ORG
 
   set -x
-  peer chaincode upgrade -o orderer.example.com:7050 --tls $CORE_PEER_TLS_ENABLED --cafile $ORDERER_CA -C mychannel -n scthreediff6 -v $VERS -c '{"Args":["init","a","90","b","210"]}' -P "OR ('Org1MSP.peer','Org2MSP.peer','Org3MSP.peer','Org4MSP.peer','Org5MSP.peer','Org6MSP.peer')" >&log.txt
+  peer chaincode upgrade -o orderer.example.com:7050 --tls $CORE_PEER_TLS_ENABLED --cafile $ORDERER_CA -C mychannel -n scthreediff6 -v $VERS -c '{"Args":["init","a","90","b","210"]}' --collections-config $COLLECTIONS_CONFIG -P "OR ('Org1MSP.peer','Org2MSP.peer','Org3MSP.peer','Org4MSP.peer','Org5MSP.peer','Org6MSP.peer')" >&log.txt
   res=$?
   set +x
   cat log.txt
@@ -249,7 +252,7 @@ chaincodeQuery() {
     sleep $DELAY
     echo "Attempting to Query peer${PEER}.org${ORG} ...$(($(date +%s) - starttime)) secs"
     set -x
-    peer chaincode query -C $CHANNEL_NAME -n ${NAME} -c '{"Args":["queryAsset","Crude1"]}' >&log.txt
+    peer chaincode query -C $CHANNEL_NAME -n ${NAME} -c '{"Args":["queryAsset","Crude0000000001"]}' >&log.txt
     res=$?
     set +x
     test $res -eq 0 && VALUE=$(cat log.txt | awk '/Query Result/ {print $NF}')
@@ -8,6 +8,8 @@ org3 -> refiner
 org4 -> distributor
 org5/6 -> retailer / fuel stations
 
+These are the orgs registered on instantiate. More orgs join through the registry (see orgs.go).
+
 
 
 API:
@@ -16,16 +18,38 @@ deliverCrude
 refine
 addFuelOrder - coupled with a retailer.
 deliverFuel - make a plan for distributing to different retailers. accumulate addFuelDelivery tx's.
+(the four above return the ID of the new asset, see ids.go)
 transfer - either crude or fuel
 query asset
 query asset by range
+query assets by owner, state or destination - through the indexes of the storage layout (see keys.go)
+query assets - CouchDB rich query with a Mango selector (see richquery.go)
+query history for key - provenance of an asset or org account
+trace lineage - the whole Crude -> Fuel -> FuelOrder -> Plan tree of an asset
+audit quantities - quantity conservation between a crude and its fuels and orders (see quantity.go)
+register vehicle - trucks should be registered with their capacity before they get a plan
+reconcile plan - mismatches between a delivery plan and the FuelOrders it carries (see plan.go)
+query/verify terms, pair settlement - values of Crude and FuelOrders private to the trading pair (see privacy.go)
+cancel order, amend/cancel plan - with the reason and the history of the amendments (see amend.go)
+query receipt - proof of delivery of a transferred asset (see receipt.go)
+open/respond/resolve/query disputes - contest a delivery or payment (see disputes.go)
+account statement, journal by reference - every payment is a double-entry journal entry (see journal.go)
+set/query FX rate - amounts are fixed-point money in the currency of the asset (see money.go)
+set credit limit, query available funds - payments can't overdraw an account past its credit limit (see credit.go)
+query/refund escrow - the buyer of a Crude or FuelOrder pays into escrow when it is created (see escrow.go)
+set/query tariff - what carriers are paid per route, with penalties and bonuses for the delay (see tariff.go)
+register/update/deactivate/reactivate/query orgs - owners, locations and payees should be active orgs of the registry (see orgs.go)
+migrate keys - move a ledger with plain keys to the composite-key storage layout (see keys.go)
+reindex assets - build the index entries of the assets in db after an upgrade that added an index (see keys.go)
 
+Every function also accepts a single JSON request instead of positional args (see schema.go).
+Every tx that changes the ledger emits chaincode events (see events.go).
+Every tx that changes an asset is checked against the role of the caller's org (see roles.go).
 
 */
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -43,8 +67,9 @@ type SmartContract struct {
  */
 
 type Vehicle struct {
-	Type string
-	ID   string
+	Type     string
+	ID       string
+	Capacity int
 }
 type DeliveryDetails struct {
 	EstTime          time.Time
@@ -57,27 +82,47 @@ type TxProof struct {
 	Hash string
 }
 type AssetDetails struct {
-	Value    float64
-	Quantity int
-	Owner    string
-	State    string
+	Value      Money
+	Quantity   int
+	Owner      string
+	State      string
+	Currency   string //of Value and of the payments for the asset (see money.go)
+	Collection string `json:",omitempty"` //of the private terms, if Value is private (see privacy.go)
+	TermsHash  string `json:",omitempty"`
 }
 
 /*
-Put in db with key CrudeID
-Crude ID should be like this: CrudeXXXX where XXXX is an ever increasing number.
+A payment made when an asset was transferred. Kept on the asset so that every hop
+of the supply chain shows who paid whom.
+Currency is the currency of the asset. An empty currency is the currency of the payer's account.
+Rule is the tariff the amount was computed with (see tariff.go), if any.
+*/
+type Payment struct {
+	Payer    string
+	Payee    string
+	Amount   Money
+	Currency string
+	Rule     string
+}
+
+/*
+Put in db with key (Crude, CrudeID)
+Crude ID is like this: CrudeXXXX where XXXX is an ever increasing number minted by the chaincode (see ids.go).
 */
 type Crude struct {
-	AD        AssetDetails
-	DD        DeliveryDetails
-	Proof     TxProof
-	Veh       Vehicle
-	Timestamp time.Time
+	AD           AssetDetails
+	DD           DeliveryDetails
+	Proof        TxProof
+	Veh          Vehicle
+	Timestamp    time.Time
+	Payments     []Payment
+	Allocated    int           //quantity already refined into fuels
+	Cancellation *Cancellation `json:",omitempty"` //see amend.go
 }
 
 /*
-Put in db with key FuelID
-Fuel ID should be like this: FuelXXXX where XXXX is an ever increasing number.
+Put in db with key (Fuel, FuelID)
+Fuel ID is like this: FuelXXXX where XXXX is an ever increasing number minted by the chaincode (see ids.go).
 */
 type Fuel struct {
 	AD        AssetDetails
@@ -85,39 +130,56 @@ type Fuel struct {
 	Type      string
 	CrudeID   string //like parent ID
 	Timestamp time.Time
+	Allocated int     //quantity already cut into fuel orders
+	CrudeUsed int     //quantity of crude consumed to refine this fuel
+	Yield     float64 //refining yield at the time of refining
 }
 
 /*
-Put in db with key FuelOrderID
-FuelOrder ID should be like this: FuelOrderXXXX where XXXX is an ever increasing number.
+Put in db with key (FuelOrder, FuelOrderID)
+FuelOrder ID is like this: FuelOrderXXXX where XXXX is an ever increasing number minted by the chaincode (see ids.go).
 */
 type FuelOrder struct {
-	AD        AssetDetails
-	Dest      string
-	Proof     TxProof
-	FuelID    string //like parent ID
-	Timestamp time.Time
+	AD           AssetDetails
+	Dest         string
+	Seller       string //refiner of the fuel, the owner until the order is delivered
+	Proof        TxProof
+	FuelID       string //like parent ID
+	Timestamp    time.Time
+	Payments     []Payment
+	Cancellation *Cancellation `json:",omitempty"` //see amend.go
 }
 
 type FuelOrderID = string
 
 /*
-ID form : 'PlanXXXX'
+ID form : 'PlanXXXX' (minted by the chaincode), put in db with key (Plan, PlanID)
 A delivery plan from refinary towards the gas stations.
 Contains the vehicle that will deliver the fuels at many fueling stations
 A map for easy access to delivery details with key the orders that org2 has added.
+Amendments are the changes of the plan since it was made, oldest first (see amend.go).
 */
 type FuelDeliveryPlan struct {
-	Veh  Vehicle
-	Plan map[FuelOrderID]DeliveryDetails
+	Veh        Vehicle
+	Plan       map[FuelOrderID]DeliveryDetails
+	Status     string          `json:",omitempty"`
+	Amendments []PlanAmendment `json:",omitempty"`
+	CreatedBy  string          `json:",omitempty"` //org, empty for plans before it was kept
 }
 
 type OrgAmount struct {
-	amount float64
+	amount Money
 	org    string
+	rule   string
 }
 
 func (s *SmartContract) Init(APIstub shim.ChaincodeStubInterface) sc.Response {
+	if err := initRoleMap(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := initOrgs(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(nil)
 }
 
@@ -127,9 +189,26 @@ func (s *SmartContract) Init(APIstub shim.ChaincodeStubInterface) sc.Response {
  The app also specifies the specific smart contract function to call with args
 */
 func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) sc.Response {
+	//collect the events of the tx and emit them once it has succeeded (see events.go)
+	stub := NewEventStub(APIstub)
+	resp := s.invoke(stub)
+	if resp.Status != shim.OK {
+		return resp
+	}
+	if err := stub.Flush(); err != nil {
+		return shim.Error(err.Error())
+	}
+	return resp
+}
 
+func (s *SmartContract) invoke(APIstub shim.ChaincodeStubInterface) sc.Response {
 	// Retrieve the requested Smart Contract function and arguments
 	function, args := APIstub.GetFunctionAndParameters()
+	// JSON requests are turned into positional args (see schema.go)
+	args, err := RequestArgs(function, args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	// Route to the appropriate handler function to interact with the ledger
 	if function == "deliverCrude" {
 		return s.deliverCrude(APIstub, args)
@@ -145,133 +224,329 @@ func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) sc.Response
 		return s.queryAsset(APIstub, args)
 	} else if function == "queryAssetByRange" {
 		return s.queryAssetByRange(APIstub, args)
+	} else if function == "queryAssetsByOwner" {
+		return s.queryAssetsByOwner(APIstub, args)
+	} else if function == "queryAssetsByState" {
+		return s.queryAssetsByState(APIstub, args)
+	} else if function == "queryAssetsByDestination" {
+		return s.queryAssetsByDestination(APIstub, args)
+	} else if function == "queryAssets" {
+		return s.queryAssets(APIstub, args)
+	} else if function == "queryHistoryForKey" {
+		return s.queryHistoryForKey(APIstub, args)
+	} else if function == "traceLineage" {
+		return s.traceLineage(APIstub, args)
+	} else if function == "auditQuantities" {
+		return s.auditQuantities(APIstub, args)
+	} else if function == "setRefiningYield" {
+		return s.setRefiningYield(APIstub, args)
+	} else if function == "registerVehicle" {
+		return s.registerVehicle(APIstub, args)
+	} else if function == "reconcilePlan" {
+		return s.reconcilePlan(APIstub, args)
+	} else if function == "queryReceipt" {
+		return s.queryReceipt(APIstub, args)
+	} else if function == "setTolerances" {
+		return s.setTolerances(APIstub, args)
+	} else if function == "openDispute" {
+		return s.openDispute(APIstub, args)
+	} else if function == "respondDispute" {
+		return s.respondDispute(APIstub, args)
+	} else if function == "resolveDispute" {
+		return s.resolveDispute(APIstub, args)
+	} else if function == "queryDisputes" {
+		return s.queryDisputes(APIstub, args)
 	} else if function == "initLedger" {
 		return s.initLedger(APIstub, args)
+	} else if function == "setRoleMSPs" {
+		return s.setRoleMSPs(APIstub, args)
+	} else if function == "queryRoles" {
+		return s.queryRoles(APIstub, args)
+	} else if function == "querySchemas" {
+		return s.querySchemas(APIstub, args)
+	} else if function == "getAccountStatement" {
+		return s.getAccountStatement(APIstub, args)
+	} else if function == "queryJournalByReference" {
+		return s.queryJournalByReference(APIstub, args)
+	} else if function == "migrateKeys" {
+		return s.migrateKeys(APIstub, args)
+	} else if function == "reindexAssets" {
+		return s.reindexAssets(APIstub, args)
+	} else if function == "setFXRate" {
+		return s.setFXRate(APIstub, args)
+	} else if function == "queryFXRate" {
+		return s.queryFXRate(APIstub, args)
+	} else if function == "migrateMoney" {
+		return s.migrateMoney(APIstub, args)
+	} else if function == "setCreditLimit" {
+		return s.setCreditLimit(APIstub, args)
+	} else if function == "queryAvailableFunds" {
+		return s.queryAvailableFunds(APIstub, args)
+	} else if function == "setTariff" {
+		return s.setTariff(APIstub, args)
+	} else if function == "queryTariff" {
+		return s.queryTariff(APIstub, args)
+	} else if function == "queryEscrow" {
+		return s.queryEscrow(APIstub, args)
+	} else if function == "refundEscrow" {
+		return s.refundEscrow(APIstub, args)
+	} else if function == "setEscrowPeriod" {
+		return s.setEscrowPeriod(APIstub, args)
+	} else if function == "queryTerms" {
+		return s.queryTerms(APIstub, args)
+	} else if function == "verifyTerms" {
+		return s.verifyTerms(APIstub, args)
+	} else if function == "queryPairSettlement" {
+		return s.queryPairSettlement(APIstub, args)
+	} else if function == "cancelOrder" {
+		return s.cancelOrder(APIstub, args)
+	} else if function == "amendPlan" {
+		return s.amendPlan(APIstub, args)
+	} else if function == "cancelPlan" {
+		return s.cancelPlan(APIstub, args)
+	} else if function == "registerOrg" {
+		return s.registerOrg(APIstub, args)
+	} else if function == "updateOrg" {
+		return s.updateOrg(APIstub, args)
+	} else if function == "deactivateOrg" {
+		return s.deactivateOrg(APIstub, args)
+	} else if function == "reactivateOrg" {
+		return s.reactivateOrg(APIstub, args)
+	} else if function == "queryOrg" {
+		return s.queryOrg(APIstub, args)
+	} else if function == "queryOrgs" {
+		return s.queryOrgs(APIstub, args)
 	}
 
 	return shim.Error("Invalid Smart Contract function name.")
 }
 
 /*
-args[0] = crudeID like 'CrudeXXXX'
-arg1 = value,arg2 = quantity, arg3 = owner
-arg4 = estTime, arg5 = startLoc, arg6 = dest
-arg7 = vesselID , arg8 = timestamp
+arg0 = value,arg1 = quantity, arg2 = owner
+arg3 = estTime, arg4 = startLoc, arg5 = dest
+arg6 = vesselID , arg7 = timestamp
+arg8 = currency of the value (optional, BaseCurrency if missing)
+The owner is the org of the caller.
+The value is private to the owner and the destination if it is in the transient map (see privacy.go).
+Returns the ID of the new crude (see ids.go).
 */
 func (s *SmartContract) deliverCrude(stub shim.ChaincodeStubInterface, args []string) sc.Response {
-	//check if creator is org1-shipper??
-	if len(args) != 9 {
-		return shim.Error("Incorrect number of arguments. Expecting 9")
+	if err := checkRole(stub, RoleDriller, RoleShipper); err != nil {
+		return shim.Error(err.Error())
 	}
-	AD, err := NewAssetDetails(args[1], args[2], args[3], "ON_WAY")
+	args = dropLegacyID("deliverCrude", TypeCrude, args)
+	if len(args) != 8 && len(args) != 9 {
+		return shim.Error("Incorrect number of arguments. Expecting 8 and optionally a currency")
+	}
+	AD, err := NewAssetDetails(stub, args[0], args[1], args[2], "ON_WAY", OptionalArg(args, 8))
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	DD, err := NewDeliveryDetails(args[4], args[5], args[6])
+	if err = checkOwner(stub, AD.Owner); err != nil {
+		return shim.Error(err.Error())
+	}
+	DD, err := NewDeliveryDetails(stub, args[3], args[4], args[5])
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	crudeAsBytes, _ := stub.GetState(args[0])
-	if crudeAsBytes != nil {
-		return shim.Error(fmt.Sprintf("Crude with id %s already exists", args[0]))
+	terms, err := NewPrivateTerms(stub, &AD, AD.Owner, DD.Destination)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
 	Proof := NewProof()
 	//hardcoded vehID.TODO: construct base on the Hash(args[1]+args[2]...+)
-	Veh := NewVehicle("Vessel", args[7])
-	Timestamp, err := RFCtoTime(args[8])
+	Veh := NewVehicle("Vessel", args[6])
+	Timestamp, err := RFCtoTime(args[7])
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	crude := Crude{AD, DD, Proof, Veh, Timestamp}
-	crudeAsBytes, _ = json.Marshal(crude)
-	err = stub.PutState(args[0], crudeAsBytes) //if an crude with the same ID already exists, this will override it.
+	id, err := NewAssetID(stub, TypeCrude)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to add crude: %s", args[0]))
+		return shim.Error(err.Error())
+	}
+	if err = PutNewTerms(stub, id, &AD, terms); err != nil {
+		return shim.Error(err.Error())
+	}
+	crude := Crude{AD, DD, Proof, Veh, Timestamp, nil, 0, nil}
+	crudeAsBytes, _ := json.Marshal(crude)
+	err = PutAsset(stub, id, crudeAsBytes)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to add crude: %s", id))
+	}
+	tariff, err := GetTariff(stub, TypeCrude, DD.StartingLocation, DD.Destination)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	e, err := LockEscrow(stub, id, crude.Buyer(), AD, tariff.Fee(AD.Quantity))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = LockPrivateEscrow(stub, terms, e.Debited); err != nil {
+		return shim.Error(err.Error())
 	}
+	Emit(stub, EventCrudeDispatched, id, crude)
 
-	return shim.Success(nil)
+	return shim.Success([]byte(id))
 }
 
 /*
 Transform Crude oil into something useful (e.g. Fuel)
-args[0] = fuelID like 'FuelXXXX'
-arg1 = value,arg2 = quantity, arg3 = owner
-arg4 = density,arg5 = type_of_fuel, arg6 = CrudeID (ancestor ID)
-arg7 = timestamp.
+arg0 = value,arg1 = quantity, arg2 = owner
+arg3 = density,arg4 = type_of_fuel, arg5 = CrudeID (ancestor ID)
+arg6 = timestamp, arg7 = currency (optional).
+The owner is the org of the caller, which should own the crude, DELIVERED.
+The crude used (quantity/refining yield) is subtracted from what remains of the crude.
+Returns the ID of the new fuel.
 */
 func (s *SmartContract) refine(stub shim.ChaincodeStubInterface, args []string) sc.Response {
-	if len(args) != 8 {
-		return shim.Error("Incorrect number of arguments. Expecting 8")
+	if err := checkRole(stub, RoleRefiner); err != nil {
+		return shim.Error(err.Error())
 	}
-	AD, err := NewAssetDetails(args[1], args[2], args[3], "REFINED")
+	args = dropLegacyID("refine", TypeFuel, args)
+	if len(args) != 7 && len(args) != 8 {
+		return shim.Error("Incorrect number of arguments. Expecting 7 and optionally a currency")
+	}
+	AD, err := NewAssetDetails(stub, args[0], args[1], args[2], "REFINED", OptionalArg(args, 7))
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	Density, err := strconv.ParseFloat(args[4], 64)
+	if err = checkOwner(stub, AD.Owner); err != nil {
+		return shim.Error(err.Error())
+	}
+	Density, err := strconv.ParseFloat(args[3], 64)
 	if err != nil {
 		return shim.Error("Density should be a float number!")
 	}
-	Timestamp, err := RFCtoTime(args[7])
+	Timestamp, err := RFCtoTime(args[6])
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	//ensure crudeID exists in db.
-	crudebytes, _ := stub.GetState(args[6])
+	crudebytes, _ := GetAsset(stub, args[5])
 	if crudebytes == nil {
 		return shim.Error("ID of crude doesn't exist!")
 	}
-	if fuelbytes, _ := stub.GetState(args[0]); fuelbytes != nil {
-		return shim.Error("ID of fuel already exists.")
+	yield, err := GetRefiningYield(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	crude := Crude{}
+	json.Unmarshal(crudebytes, &crude)
+	//a crude is refined once it was delivered to the refiner and paid from its escrow
+	if crude.AD.State != "DELIVERED" {
+		return shim.Error(fmt.Sprintf("%s is %s. Only a DELIVERED crude can be refined", args[5], crude.AD.State))
+	}
+	if crude.AD.Owner != AD.Owner {
+		return shim.Error(fmt.Sprintf("Access denied: %s is owned by %s, not %s", args[5], crude.AD.Owner, AD.Owner))
+	}
+	crudeUsed := CrudeNeeded(AD.Quantity, yield)
+	if err = crude.allocate(crudeUsed); err != nil {
+		return shim.Error(err.Error())
 	}
-	fuel := Fuel{AD, Density, args[5], args[6], Timestamp}
+	crudebytes, _ = json.Marshal(crude)
+	err = PutAsset(stub, args[5], crudebytes)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to put %s in db", args[5]))
+	}
+	id, err := NewAssetID(stub, TypeFuel)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fuel := Fuel{AD, Density, args[4], args[5], Timestamp, 0, crudeUsed, yield}
 	fuelAsBytes, _ := json.Marshal(fuel)
-	err = stub.PutState(args[0], fuelAsBytes)
+	err = PutAsset(stub, id, fuelAsBytes)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to add fuel: %s", args[0]))
+		return shim.Error(fmt.Sprintf("Failed to add fuel: %s", id))
 	}
-	return shim.Success(nil)
+	Emit(stub, EventFuelRefined, id, fuel)
+	return shim.Success([]byte(id))
 }
 
 /*
 Refiner adds this when a fueling station asks for an order of fuel.
-arg1-3 = asset_details
-arg4 = dest, arg5 = fuelID
-arg6 = timestamp
+arg0-2 = asset_details
+arg3 = dest, arg4 = fuelID
+arg5 = timestamp, arg6 = currency (optional)
+The owner is the org of the caller, which should own the fuel.
+The value is private to the refiner and the fueling station if it is in the transient map (see privacy.go).
+The quantity of the order is subtracted from what remains of the fuel.
+Returns the ID of the new fuel order.
 */
 func (s *SmartContract) addFuelOrder(stub shim.ChaincodeStubInterface, args []string) sc.Response {
-	if len(args) != 7 {
-		return shim.Error("Incorrect number of arguments. Expecting 7")
+	if err := checkRole(stub, RoleRefiner); err != nil {
+		return shim.Error(err.Error())
 	}
-	AD, err := NewAssetDetails(args[1], args[2], args[3], "READY_FOR_DISTRIBUTION")
+	args = dropLegacyID("addFuelOrder", TypeFuelOrder, args)
+	if len(args) != 6 && len(args) != 7 {
+		return shim.Error("Incorrect number of arguments. Expecting 6 and optionally a currency")
+	}
+	AD, err := NewAssetDetails(stub, args[0], args[1], args[2], "READY_FOR_DISTRIBUTION", OptionalArg(args, 6))
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	if HasPrefixOrg(args[4]) == false {
-		return shim.Error("Destination doesn't start with org!")
+	if err = checkOwner(stub, AD.Owner); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = CheckOrg(stub, args[3], RoleRetailer); err != nil {
+		return shim.Error(fmt.Sprintf("Destination should be a fueling station: %s", err))
+	}
+	terms, err := NewPrivateTerms(stub, &AD, AD.Owner, args[3])
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 	Proof := NewProof()
 	//check that fuelID exists
-
-	if fuelbytes, _ := stub.GetState(args[5]); fuelbytes == nil {
+	fuelbytes, _ := GetAsset(stub, args[4])
+	if fuelbytes == nil {
 		return shim.Error("FuelID doens't exist!")
 	}
-	Timestamp, err := RFCtoTime(args[6])
+	Timestamp, err := RFCtoTime(args[5])
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	//check that fuelOrderID doens't exist
-	if fuelOrderbytes, _ := stub.GetState(args[1]); fuelOrderbytes != nil {
-		return shim.Error("FuelOrderID already exists")
+	fuel := Fuel{}
+	json.Unmarshal(fuelbytes, &fuel)
+	//only the refiner of the fuel sells it
+	if fuel.AD.Owner != AD.Owner {
+		return shim.Error(fmt.Sprintf("Access denied: %s is owned by %s, not %s", args[4], fuel.AD.Owner, AD.Owner))
+	}
+	if err = fuel.allocate(AD.Quantity); err != nil {
+		return shim.Error(err.Error())
+	}
+	fuelbytes, _ = json.Marshal(fuel)
+	err = PutAsset(stub, args[4], fuelbytes)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to put %s in db", args[4]))
 	}
 
-	fuelOrder := FuelOrder{AD, args[4], Proof, args[5], Timestamp}
+	id, err := NewAssetID(stub, TypeFuelOrder)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = PutNewTerms(stub, id, &AD, terms); err != nil {
+		return shim.Error(err.Error())
+	}
+	fuelOrder := FuelOrder{AD, args[3], AD.Owner, Proof, args[4], Timestamp, nil, nil}
 	fuelAsBytes, _ := json.Marshal(fuelOrder)
-	err = stub.PutState(args[0], fuelAsBytes)
+	err = PutAsset(stub, id, fuelAsBytes)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to add fuelOrder: %s", args[0]))
+		return shim.Error(fmt.Sprintf("Failed to add fuelOrder: %s", id))
 	}
-	return shim.Success(nil)
+	//the route of the order is only known when it is put in a plan
+	tariff, err := GetTariff(stub, TypeFuelOrder, "", fuelOrder.Dest)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	e, err := LockEscrow(stub, id, fuelOrder.Buyer(), AD, tariff.Fee(AD.Quantity))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = LockPrivateEscrow(stub, terms, e.Debited); err != nil {
+		return shim.Error(err.Error())
+	}
+	Emit(stub, EventFuelOrderAdded, id, fuelOrder)
+	return shim.Success([]byte(id))
 
 }
 
@@ -279,7 +554,6 @@ func (s *SmartContract) addFuelOrder(stub shim.ChaincodeStubInterface, args []st
 Make a Fuel Delivery Plan based on existing FuelOrders. A track should deliver fuel to all fueling stations mentioned in the
 Delivery Plan.
 args of this invokation:
-	PlanID
 	TruckID
 	{FuelOrderID,EstTime,Sloc,Dest}
 	{FuelOrderID,EstTime,Sloc,Dest}
@@ -287,121 +561,198 @@ args of this invokation:
 	.
 	.
 	{FuelOrderID,EstTime,Sloc,Dest}
+The plan should match the FuelOrders added by the refiner: Dest is the Dest of the order, every order
+is READY_FOR_DISTRIBUTION (so it isn't in another plan) and the registered truck can carry all of them.
+Returns the ID of the new plan.
 */
 func (s *SmartContract) deliverFuel(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleRefiner, RoleDistributor); err != nil {
+		return shim.Error(err.Error())
+	}
+	args = dropLegacyID("deliverFuel", TypePlan, args)
 	//check that client supplied properly the # of args
-	if len(args) < 2 {
+	if len(args) < 1 {
 		return shim.Error("Expecting more args")
 	}
-	Veh := NewVehicle("Truck", args[1])
-	orders := args[2:]
+	creator, err := callerOrg(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	Veh, err := GetVehicle(stub, "Truck", args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	orders := args[1:]
 	if len(orders) == 0 {
 		return shim.Error("At least one delivery should be specified")
 	} else if len(orders)%4 != 0 {
 		return shim.Error(fmt.Sprintf("Arguments dont match!Pattern should be {FuelOrderID,EstTime,Sloc,Dest}... Instead args are %d", len(orders)))
 	}
 	Plan := make(map[FuelOrderID]DeliveryDetails)
+	total := 0
 	//orders[i] = FuelorderID , orders[i+1] = estTime , i+2 = sloc , i+3 = dest
 	//change everys FuelOrder's state to ON_WAY and create a new DeliveryDetail for it.
 	for i := 0; i < len(orders); i += 4 {
 		var id FuelOrderID = orders[i]
-		fuelOrderbytes, _ := stub.GetState(id)
+		if _, ok := Plan[id]; ok {
+			return shim.Error(fmt.Sprintf("FuelOrderID %s is more than once in the plan", id))
+		}
+		fuelOrderbytes, _ := GetAsset(stub, id)
 		if fuelOrderbytes == nil {
 			return shim.Error(fmt.Sprintf("FuelOrderID %s does not exist", id))
 		}
+		DD, err := NewDeliveryDetails(stub, orders[i+1], orders[i+2], orders[i+3])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 		fuelOrder := FuelOrder{}
 		json.Unmarshal(fuelOrderbytes, &fuelOrder)
+		if err = validatePlanOrder(id, fuelOrder, DD); err != nil {
+			return shim.Error(err.Error())
+		}
+		total += fuelOrder.AD.Quantity
 		fuelOrder.AD.State = "ON_WAY"
 		newFuelOrderbytes, _ := json.Marshal(fuelOrder)
-		err := stub.PutState(id, newFuelOrderbytes)
+		err = PutAsset(stub, id, newFuelOrderbytes)
 		if err != nil {
-			return shim.Error(fmt.Sprint("Failed to add %s with different state", id))
+			return shim.Error(fmt.Sprintf("Failed to add %s with different state", id))
 
 		}
-		DD, err := NewDeliveryDetails(orders[i+1], orders[i+2], orders[i+3])
-		if err != nil {
-			return shim.Error(err.Error())
-		}
 		Plan[id] = DD
 	}
+	if err = validatePlanCapacity(Veh, total); err != nil {
+		return shim.Error(err.Error())
+	}
 
-	fuelDeliveryPlan := FuelDeliveryPlan{Veh, Plan}
+	id, err := NewAssetID(stub, TypePlan)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fuelDeliveryPlan := FuelDeliveryPlan{Veh, Plan, PlanActive, nil, creator}
 	fuelDeliveryPlanAsBytes, _ := json.Marshal(fuelDeliveryPlan)
-	err := stub.PutState(args[0], fuelDeliveryPlanAsBytes)
+	err = PutAsset(stub, id, fuelDeliveryPlanAsBytes)
 	if err != nil {
-		return shim.Error(fmt.Sprint("Failed to add Plan %s in db", args[0]))
+		return shim.Error(fmt.Sprintf("Failed to add Plan %s in db", id))
 
 	}
+	Emit(stub, EventDeliveryPlanCreated, id, fuelDeliveryPlan)
 
-	return shim.Success(nil)
+	return shim.Success([]byte(id))
 
 }
 
 /*
 if we want to transfer FuelOrder then we should supply {FuelOrderID,owner,curtime,PlanID}
 if we want to transfer Crude then we should supply {Crude,owner,curtime}
+Both can be followed by {measuredQuantity,measuredDensity} as a proof of delivery (see receipt.go).
 
-Transportation orgs get paid based on the quantity of fuel or crude oil they are delivering.
+Transportation orgs get paid based on the quantity of fuel or crude oil they are delivering and the delay,
+by the tariff of the route (see tariff.go). The supplier, the previous owner, gets the value of the asset.
+Only the buyer (the destination of a Crude, the Dest of a FuelOrder) can make a transfer, to itself, and it
+should play the receiving role (refiner for Crude, retailer for FuelOrder).
 
 */
 func (s *SmartContract) transfer(stub shim.ChaincodeStubInterface, args []string) sc.Response {
-	if len(args) != 4 && len(args) != 3 {
+	if len(args) < 3 || len(args) > 6 {
 		return shim.Error("Wrong # of arguments.")
 	}
-	if ok := HasPrefixOrg(args[1]); ok == false {
-		return shim.Error("Owner is not an org")
+	if err := CheckOrg(stub, args[1]); err != nil {
+		return shim.Error(fmt.Sprintf("Owner should be an active org: %s", err))
 	}
 	Timestamp, err := RFCtoTime(args[2])
 	if err != nil {
 		return shim.Error("Timestamp not in RFC3339 format.")
 	}
-	assetAsBytes, _ := stub.GetState(args[0])
+	assetAsBytes, _ := GetAsset(stub, args[0])
 	if assetAsBytes == nil {
 		return shim.Error("Could not locate Asset")
 	}
+	tol, err := GetTolerances(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	switch id := args[0]; {
 	case strings.HasPrefix(id, "Crude"):
+		if len(args) != 3 && len(args) != 5 {
+			return shim.Error("Expecting {CrudeID,owner,curtime} and optionally {measuredQuantity,measuredDensity}")
+		}
+		//crude oil is received by the refiner
+		if err := checkRole(stub, RoleRefiner); err != nil {
+			return shim.Error(err.Error())
+		}
 		crude := Crude{}
 		json.Unmarshal(assetAsBytes, &crude)
+		if err := checkReceiver(stub, crude.Buyer(), args[1]); err != nil {
+			return shim.Error(err.Error())
+		}
 
-		logger := shim.NewLogger("myloger")
-
-		fmt.Println("OK BEFORE dd transfer")
-		logger.Critical("OK BEFORE dd transfer")
-		timePenalty := crude.DD.transfer(Timestamp)
-		fmt.Println("OK BEFORE ad transfer")
-		logger.Critical("OK BEFORE ad transfer")
-		err := crude.AD.transfer(args[1])
-		fmt.Println("OK AFTER ad transfer")
+		tariff, err := GetTariff(stub, TypeCrude, crude.DD.StartingLocation, crude.DD.Destination)
 		if err != nil {
 			return shim.Error(err.Error())
 		}
+		crude.DD.transfer(Timestamp, crude.DD.EstTime)
+		supplier := crude.AD.Owner
+		err = crude.AD.transfer(args[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		payableQuantity := crude.AD.Quantity
+		if len(args) == 5 {
+			receipt, err := NewDeliveryReceipt(id, args[1], args[3], args[4], crude.AD.Quantity, 0, Timestamp, tol)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if receipt.Status == ReceiptDisputed {
+				receipt.DisputeID, err = OpenDispute(stub, id, &crude, crude.AD.State, ReasonQuality, "")
+				if err != nil {
+					return shim.Error(err.Error())
+				}
+			}
+			if err = PutReceipt(stub, receipt); err != nil {
+				return shim.Error(err.Error())
+			}
+			payableQuantity = receipt.PayableQuantity()
+		}
 
 		//the new owner shall pay shipper based on the quantity he delivered
 		//and driller based on the value of the crude oil.
-		shipperPayment := float64(crude.AD.Quantity)/10.0 - timePenalty
-		if shipperPayment < 0 {
-			shipperPayment = 0
-		}
-		drillerPayment := crude.AD.Value
-		payments := []OrgAmount{{shipperPayment, "org2"}, {drillerPayment, "org1"}}
-		logger.Critical("OK BEFORE PAY")
-		err = Pay(stub, crude.AD, payments)
-		logger.Critical("OK AFTER PAY")
-		if err != nil {
-			return shim.Error(err.Error())
+		//a private value is paid in the collection of the pair (see privacy.go)
+		if crude.AD.State != "DISPUTED" {
+			terms, err := GetTerms(stub, id, crude.AD)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			shipperPayment := tariff.CarrierPay(payableQuantity, crude.DD.Delay)
+			drillerPayment := PayableValue(terms.Details(crude.AD), payableQuantity)
+			payments := SupplyPayments(crude.AD, OrgAmount{shipperPayment, tariff.Carrier, tariff.Rule()}, OrgAmount{drillerPayment, supplier, ""})
+			paid, err := PayDelivery(stub, id, crude.AD, terms, payments)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			crude.Payments = append(crude.Payments, paid...)
 		}
 
 		assetAsBytes, _ = json.Marshal(crude)
-		err = stub.PutState(id, assetAsBytes)
-		fmt.Println("OK AFTER pputstate")
+		err = PutAsset(stub, id, assetAsBytes)
 		if err != nil {
 			return shim.Error(fmt.Sprintf("Failed to put %s in db", id))
 		}
+		Emit(stub, EventCrudeDelivered, id, crude)
 	//change state of fuel and compute delay in deliveryPlan struct
 	case strings.HasPrefix(id, "FuelOrder"):
+		if len(args) != 4 && len(args) != 6 {
+			return shim.Error("Expecting {FuelOrderID,owner,curtime,PlanID} and optionally {measuredQuantity,measuredDensity}")
+		}
+		//fuel orders are received by the fuel stations
+		if err := checkRole(stub, RoleRetailer); err != nil {
+			return shim.Error(err.Error())
+		}
 		fuelOrder := FuelOrder{}
 		json.Unmarshal(assetAsBytes, &fuelOrder)
+		if err := checkReceiver(stub, fuelOrder.Buyer(), args[1]); err != nil {
+			return shim.Error(err.Error())
+		}
+		supplier := fuelOrder.AD.Owner
 		err := fuelOrder.AD.transfer(args[1])
 		if err != nil {
 			return shim.Error(err.Error())
@@ -409,149 +760,207 @@ func (s *SmartContract) transfer(stub shim.ChaincodeStubInterface, args []string
 		if strings.HasPrefix(args[3], "Plan") == false {
 			return shim.Error("PlanID is not of the form 'PlanXXX'")
 		}
-		dplanAsBytes, _ := stub.GetState(args[3])
+		dplanAsBytes, _ := GetAsset(stub, args[3])
 		if dplanAsBytes == nil {
 			return shim.Error("Could not locate Plan")
 		}
 		dplan := FuelDeliveryPlan{}
 		json.Unmarshal(dplanAsBytes, &dplan)
+		if dplan.Cancelled() {
+			return shim.Error(fmt.Sprintf("%s is cancelled", args[3]))
+		}
 		dd, ok := dplan.Plan[id]
 		if ok == false {
 			return shim.Error("FuelOrderID didn't exist in any plan")
 		}
 
-		timePenalty := dd.transfer(Timestamp)
+		tariff, err := GetTariff(stub, TypeFuelOrder, dd.StartingLocation, dd.Destination)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		//against the EstTime in force at the delivery, which can't be later than this tx (see amend.go)
+		now, err := TxTime(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		deliveredAt := Timestamp
+		if deliveredAt.After(now) {
+			deliveredAt = now
+		}
+		dd.transfer(Timestamp, dplan.EstTimeAt(id, deliveredAt))
 		dplan.Plan[id] = dd
 		dplanAsBytes, _ = json.Marshal(dplan)
-		err = stub.PutState(args[3], dplanAsBytes)
+		err = PutAsset(stub, args[3], dplanAsBytes)
 		if err != nil {
 			return shim.Error(fmt.Sprintf("Failed to put %s in db", args[3]))
 		}
 
+		payableQuantity := fuelOrder.AD.Quantity
+		if len(args) == 6 {
+			fuelbytes, _ := GetAsset(stub, fuelOrder.FuelID)
+			if fuelbytes == nil {
+				return shim.Error("Could not locate Fuel of the order")
+			}
+			fuel := Fuel{}
+			json.Unmarshal(fuelbytes, &fuel)
+			receipt, err := NewDeliveryReceipt(id, args[1], args[4], args[5], fuelOrder.AD.Quantity, fuel.Density, Timestamp, tol)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if receipt.Status == ReceiptDisputed {
+				receipt.DisputeID, err = OpenDispute(stub, id, &fuelOrder, fuelOrder.AD.State, ReasonQuality, "")
+				if err != nil {
+					return shim.Error(err.Error())
+				}
+			}
+			if err = PutReceipt(stub, receipt); err != nil {
+				return shim.Error(err.Error())
+			}
+			payableQuantity = receipt.PayableQuantity()
+		}
+
 		//the new owner shall pay tracker based on the quantity he delivered
 		//and refiner based on the value of the fuel order.
-		trackPayment := float64(fuelOrder.AD.Quantity)/10.0 - timePenalty
-		if trackPayment < 0 {
-			trackPayment = 0
-		}
-		refinerPayment := fuelOrder.AD.Value
-		payments := []OrgAmount{{trackPayment, "org4"}, {refinerPayment, "org3"}}
-		err = Pay(stub, fuelOrder.AD, payments)
-		if err != nil {
-			return shim.Error(err.Error())
+		if fuelOrder.AD.State != "DISPUTED" {
+			terms, err := GetTerms(stub, id, fuelOrder.AD)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			trackPayment := tariff.CarrierPay(payableQuantity, dd.Delay)
+			refinerPayment := PayableValue(terms.Details(fuelOrder.AD), payableQuantity)
+			payments := SupplyPayments(fuelOrder.AD, OrgAmount{trackPayment, tariff.Carrier, tariff.Rule()}, OrgAmount{refinerPayment, supplier, ""})
+			paid, err := PayDelivery(stub, id, fuelOrder.AD, terms, payments)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			fuelOrder.Payments = append(fuelOrder.Payments, paid...)
 		}
 
 		assetAsBytes, _ = json.Marshal(fuelOrder)
-		err = stub.PutState(id, assetAsBytes)
+		err = PutAsset(stub, id, assetAsBytes)
 		if err != nil {
 			return shim.Error(fmt.Sprintf("Failed to put %s in db", id))
 		}
+		Emit(stub, EventFuelOrderDelivered, id, fuelOrder)
 	default:
 		return shim.Error("Either this is not a valid ID or it's not deliverable")
 	}
 	return shim.Success(nil)
 }
 
+/*
+An asset is received by its buyer only, who becomes its new owner. The role of the caller isn't enough:
+an org with the role of the buyer could receive the assets of any other.
+*/
+func checkReceiver(stub shim.ChaincodeStubInterface, buyer, owner string) error {
+	if owner != buyer {
+		return fmt.Errorf("New owner should be %s, the buyer of the asset", buyer)
+	}
+	if callerIsOrg(stub, buyer) == false {
+		return fmt.Errorf("Access denied: only %s, the buyer of the asset, receives it", buyer)
+	}
+	return nil
+}
+
+/*
+args[0] = ID of an asset or an org account
+*/
 func (s *SmartContract) queryAsset(stub shim.ChaincodeStubInterface, args []string) sc.Response {
 	if len(args) != 1 {
 		return shim.Error("Incorect # of args")
 	}
-	assetAsBytes, _ := stub.GetState(args[0])
+	var assetAsBytes []byte
+	if AssetType(args[0]) == "" {
+		assetAsBytes, _ = GetAccount(stub, args[0])
+	} else {
+		assetAsBytes, _ = GetAsset(stub, args[0])
+	}
 	if assetAsBytes == nil {
 		return shim.Error("Could not locate asset")
 	}
 	return shim.Success(assetAsBytes)
 }
 
+/*
+args[0] = type of the assets, one of {Crude,Fuel,FuelOrder,Plan}
+args[1] = page size, args[2] = bookmark (optional, see page.go)
+Returns a page of the assets of the type, in the order they were created in (see ids.go).
+*/
 func (s *SmartContract) queryAssetByRange(stub shim.ChaincodeStubInterface, args []string) sc.Response {
-	var startKey, endKey string
-	if len(args) != 1 {
-		return shim.Error("Expecting 1 arg")
+	if len(args) < 1 {
+		return shim.Error("Expecting at least 1 arg")
 	}
-	switch id := args[0]; id {
-	case "Crude":
-	case "Fuel":
-	case "FuelOrder":
-	case "Plan":
-	default:
+	if IsAssetType(args[0]) == false {
 		return shim.Error("Arg should be one of {Crude,Fuel,FuelOrder,Plan}")
 	}
-	startKey = args[0] + "0"
-	endKey = args[0] + "999"
-
-	resultsIterator, err := stub.GetStateByRange(startKey, endKey)
+	pageSize, bookmark, err := PageArgs(args[1:])
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	defer resultsIterator.Close()
-
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add comma before array members,suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
-		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
+	page, err := GetAssetsPage(stub, args[0], pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	buffer.WriteString("]")
-	fmt.Printf("- query:\n%s\n", buffer.String())
-	return shim.Success(buffer.Bytes())
+	pageAsBytes, _ := json.Marshal(page)
+	return shim.Success(pageAsBytes)
 }
 
 /*
-Create accounts for each organization.
-Form of accounts : key=org_name (e.g 'org1') and value=100000 (arbitrary starting amount)
+Create accounts for each registered organization (see orgs.go).
+Form of accounts : key=(Account, org_name) (e.g 'org1', see keys.go) and value=Account with Balance 100000 (arbitrary starting amount).
+The starting amounts are journal entries from the capital account of their currency (see journal.go).
+args = org:currency pairs (optional, e.g. 'org5:USD'). The accounts of the other orgs are in BaseCurrency.
+Orgs that already have an account (registered with registerOrg before) keep it.
 An adversary can call initLedger multiple times in order to eliminate his debt,
-so we make a check before proceeding into actions.
+so we make a check before proceeding into actions. Only an admin can call it.
 */
 func (s *SmartContract) initLedger(stub shim.ChaincodeStubInterface, args []string) sc.Response {
-	if bytes, _ := stub.GetState("org1"); bytes != nil {
-		return shim.Error("initLedger has been called already and should be called only once!")
-	}
-	jbytes, _ := json.Marshal(100000.0)
-	err := stub.PutState("org1", jbytes)
-	if err != nil {
-		return shim.Error("Failed to create account for org1")
+	if err := checkRole(stub, RoleAdmin); err != nil {
+		return shim.Error(err.Error())
 	}
-	err = stub.PutState("org2", jbytes)
-	if err != nil {
-		return shim.Error("Failed to create account for org2")
+	if bytes, _ := GetAccount(stub, "org1"); bytes != nil {
+		return shim.Error("initLedger has been called already and should be called only once!")
 	}
-	err = stub.PutState("org3", jbytes)
-	if err != nil {
-		return shim.Error("Failed to create account for org3")
+	pairs := map[string]string{}
+	for _, arg := range args {
+		pair := strings.Split(arg, ":")
+		if len(pair) != 2 || IsCurrency(pair[1]) == false {
+			return shim.Error("Currencies of accounts should be org:currency pairs, e.g. org5:USD")
+		}
+		if err := CheckOrg(stub, pair[0]); err != nil {
+			return shim.Error(err.Error())
+		}
+		pairs[pair[0]] = pair[1]
 	}
-	err = stub.PutState("org4", jbytes)
+	orgs, err := GetOrgs(stub)
 	if err != nil {
-		return shim.Error("Failed to create account for org4")
+		return shim.Error(err.Error())
 	}
-	err = stub.PutState("org5", jbytes)
-	if err != nil {
-		return shim.Error("Failed to create account for org5")
+	currencies := map[string]string{}
+	balances := map[string]Money{}
+	for _, org := range orgs {
+		if accbytes, _ := GetAccount(stub, org.Name); accbytes != nil || org.Active == false {
+			continue
+		}
+		currencies[org.Name] = BaseCurrency
+		if c, ok := pairs[org.Name]; ok {
+			currencies[org.Name] = c
+		}
+		balances[org.Name] = 100000 * MinorUnits
 	}
-	err = stub.PutState("org6", jbytes)
-	if err != nil {
-		return shim.Error("Failed to create account for org6")
+	if err = openAccounts(stub, currencies, 100000*MinorUnits); err != nil {
+		return shim.Error(err.Error())
 	}
+	Emit(stub, EventLedgerInitialized, "", balances)
 	return shim.Success(nil)
 }
 
-func HasPrefixOrg(s string) bool {
-	return strings.HasPrefix(s, "org")
+//args[i] or "" if there are fewer args.
+func OptionalArg(args []string, i int) string {
+	if len(args) > i {
+		return args[i]
+	}
+	return ""
 }
 
 func (ad *AssetDetails) transfer(own string) error {
@@ -563,44 +972,47 @@ func (ad *AssetDetails) transfer(own string) error {
 	return nil
 }
 
-func (dd *DeliveryDetails) transfer(tstamp time.Time) float64 {
-	dd.Delay = tstamp.Sub(dd.EstTime).Seconds()
-	timePenalty := dd.Delay / 100.0
-	if timePenalty < 0 {
-		return 0
-	}
-	return timePenalty
+//the delay (seconds) is what the tariff of the delivery pays the carrier by (see tariff.go)
+func (dd *DeliveryDetails) transfer(tstamp, estTime time.Time) {
+	dd.Delay = tstamp.Sub(estTime).Seconds()
 }
 
-//construct a new AssetDetails type based on supplied args
-func NewAssetDetails(val, quant, own, st string) (AssetDetails, error) {
+//construct a new AssetDetails type based on supplied args. An empty currency is BaseCurrency.
+//The owner should be an active org of the registry (see orgs.go).
+func NewAssetDetails(stub shim.ChaincodeStubInterface, val, quant, own, st, currency string) (AssetDetails, error) {
 	//value can be zero if shipper doesn't want to make it public.
-	value, err := strconv.ParseFloat(val, 64)
+	value, err := ParseMoney(val)
 	if err != nil || value < 0 {
-		return AssetDetails{}, errors.New("Value is not a float number")
+		return AssetDetails{}, errors.New("Value is not an amount of money with up to 2 decimals")
+	}
+	if currency == "" {
+		currency = BaseCurrency
+	}
+	if IsCurrency(currency) == false {
+		return AssetDetails{}, errors.New("Currency should be a 3 letter code, e.g. EUR")
 	}
 	quantity, err := strconv.ParseInt(quant, 10, 64)
 	if err != nil || quantity < 0 {
 		return AssetDetails{}, errors.New("Quantity is not an int number")
 	}
-	if HasPrefixOrg(own) == false {
-		return AssetDetails{}, errors.New("Owner value is not prefixed with string 'org'")
+	if err = CheckOrg(stub, own); err != nil {
+		return AssetDetails{}, fmt.Errorf("Owner should be an active org: %s", err)
 	}
-	return AssetDetails{value, int(quantity), own, st}, nil
+	return AssetDetails{value, int(quantity), own, st, currency, "", ""}, nil
 }
 
-//construct a new DeliveryDetails type based on supplied args
-func NewDeliveryDetails(est, sloc, dest string) (DeliveryDetails, error) {
+//construct a new DeliveryDetails type based on supplied args. The locations should be active orgs.
+func NewDeliveryDetails(stub shim.ChaincodeStubInterface, est, sloc, dest string) (DeliveryDetails, error) {
 
 	estTime, err := time.Parse(time.RFC3339, est)
 	if err != nil {
 		return DeliveryDetails{}, errors.New("Time is not in RFC3339 format")
 	}
-	if HasPrefixOrg(sloc) == false {
-		return DeliveryDetails{}, errors.New("Starting Location value is not prefixed with 'org'")
+	if err = CheckOrg(stub, sloc); err != nil {
+		return DeliveryDetails{}, fmt.Errorf("Starting Location should be an active org: %s", err)
 	}
-	if HasPrefixOrg(dest) == false {
-		return DeliveryDetails{}, errors.New("Destination value is not prefixed with 'org'")
+	if err = CheckOrg(stub, dest); err != nil {
+		return DeliveryDetails{}, fmt.Errorf("Destination should be an active org: %s", err)
 	}
 	return DeliveryDetails{estTime, 0, sloc, dest}, nil
 }
@@ -608,50 +1020,43 @@ func NewDeliveryDetails(est, sloc, dest string) (DeliveryDetails, error) {
 /*
 OrgAmount slice contains which orgs the current owner should pay from the asset delivery
 and how much (the amount).Amounts should be always non negative.
-oa[0].org = organization who delivers (e.g. shipper)
-oa[1].org = organization who supplies (e.g. refiner or driller)
+oa[0].org = organization who delivers (the carrier of the tariff)
+oa[1].org = organization who supplies (the previous owner, e.g. refiner or driller), if it isn't the new owner
+The payments are in the currency of the asset and are posted to the journal with the delivered asset id
+as reference (see journal.go). What the buyer pays comes from the escrow of the asset (see escrow.go).
 */
-func Pay(stub shim.ChaincodeStubInterface, ad AssetDetails, oa []OrgAmount) error {
-	//get the current account amounts
-	orgBuyAccBytes, err := stub.GetState(ad.Owner)
-	if err != nil {
-		return errors.New("Please call initLedger before transfer")
-	}
-	orgSell1AccBytes, err := stub.GetState(oa[0].org)
-	if err != nil {
-		return errors.New("Please call initLedger before transfer")
-	}
-	orgSell2AccBytes, err := stub.GetState(oa[1].org)
-	if err != nil {
-		return errors.New("Please call initLedger before transfer")
-	}
-	if oa[0].amount < 0 || oa[1].amount < 0 {
-		return errors.New("Amounts to be paid should be positive")
+func Pay(stub shim.ChaincodeStubInterface, id string, ad AssetDetails, oa []OrgAmount) error {
+	for _, p := range oa {
+		if p.amount < 0 {
+			return errors.New("Amounts to be paid should be positive")
+		}
 	}
-	var orgBuyAmount, orgSell1Amount, orgSell2Amount float64
-	json.Unmarshal(orgBuyAccBytes, &orgBuyAmount)
-	json.Unmarshal(orgSell1AccBytes, &orgSell1Amount)
-	json.Unmarshal(orgSell2AccBytes, &orgSell2Amount)
-	//update the accounts
-	orgBuyAmount -= oa[0].amount + oa[1].amount //buyer should pay both the dristributor and supplier
-	orgSell1Amount += oa[0].amount              //distributor gets paid by buyer
-	orgSell2Amount += oa[1].amount              //supplier gets paid by buyer
-	orgBuyAccBytes, err = json.Marshal(orgBuyAmount)
-	orgSell1AccBytes, err = json.Marshal(orgSell1Amount)
-	orgSell2AccBytes, err = json.Marshal(orgSell2Amount)
-	err = stub.PutState(ad.Owner, orgBuyAccBytes)
-	if err != nil {
-		errors.New(fmt.Sprintf("Failed to add new amount for %s org", ad.Owner))
+	return SettleEscrow(stub, id, "delivery of "+id, NewPayments(ad, oa))
+}
+
+//payments of the new owner of an asset to the carrier and the supplier. An owner doesn't pay itself.
+func SupplyPayments(ad AssetDetails, carrier, supplier OrgAmount) []OrgAmount {
+	if supplier.org == ad.Owner {
+		return []OrgAmount{carrier}
 	}
-	err = stub.PutState(oa[0].org, orgSell1AccBytes)
-	if err != nil {
-		errors.New(fmt.Sprintf("Failed to add new amount for %s org", oa[0].org))
+	return []OrgAmount{carrier, supplier}
+}
+
+//value of the part of the asset that is paid for (all of it unless the delivery was short).
+func PayableValue(ad AssetDetails, payableQuantity int) Money {
+	if ad.Quantity == 0 || payableQuantity >= ad.Quantity {
+		return ad.Value
 	}
-	err = stub.PutState(oa[1].org, orgSell2AccBytes)
-	if err != nil {
-		errors.New(fmt.Sprintf("Failed to add new amount for %s org", oa[1].org))
+	return ad.Value.MulDiv(int64(payableQuantity), int64(ad.Quantity))
+}
+
+//construct the Payment records of what the owner of the asset paid to each org
+func NewPayments(ad AssetDetails, oa []OrgAmount) []Payment {
+	payments := make([]Payment, 0, len(oa))
+	for _, p := range oa {
+		payments = append(payments, Payment{ad.Owner, p.org, p.amount, ad.AssetCurrency(), p.rule})
 	}
-	return nil
+	return payments
 }
 
 /*
@@ -662,7 +1067,15 @@ func NewProof() TxProof {
 	return TxProof{"www.ait.gr", "7cb0d761a60f4968299cda86c333dafe318fbf87b0979f60befd0499e39e21d6"}
 }
 func NewVehicle(typ, id string) Vehicle {
-	return Vehicle{typ, id}
+	return Vehicle{typ, id, 0}
+}
+//time of the tx as set by the client that proposed it. Same on every endorsing peer.
+func TxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, errors.New("Failed to get tx timestamp")
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
 }
 func RFCtoTime(rfc string) (time.Time, error) {
 	currtime, err := time.Parse(time.RFC3339, rfc)
@@ -671,12 +1084,3 @@ func RFCtoTime(rfc string) (time.Time, error) {
 	}
 	return currtime, nil
 }
-
-func main() {
-
-	// Create a new Smart Contract
-	err := shim.Start(new(SmartContract))
-	if err != nil {
-		fmt.Printf("Error creating new Smart Contract: %s", err)
-	}
-}
@@ -0,0 +1,558 @@
+/*
+Cancellation and amendment of crude shipments, fuel orders and delivery plans.
+
+A Crude that is still ON_WAY and hasn't been refined, or a FuelOrder that isn't in a plan, can be
+cancelled: it becomes CANCELLED, the fuel a FuelOrder was cut from gets its quantity back and the
+escrow of the buyer is refunded (see escrow.go). A FuelOrder in a plan is first removed from it.
+The refund of an escrow (refundEscrow) cancels its asset the same way.
+
+A plan can be amended while it is on its way: stops added or removed and the EstTime of a stop changed,
+always with a reason. A cancelled plan returns the orders it hasn't delivered to READY_FOR_DISTRIBUTION,
+so they can be put in another plan. Every amendment is kept on the plan with the tx time it was made at,
+and the delay of a delivery is computed against the EstTime that was in force at the time of the delivery
+the receiver gives, or at the tx time of the transfer if that is earlier. The EstTime of a stop can only be
+changed before it passes, so a late delivery can't be excused by an amendment made after the fact.
+
+API:
+
+cancelOrder - args[0] = CrudeID or FuelOrderID, args[1] = reason. By the orgs on it (see cancelOrder).
+amendPlan - args[0] = PlanID, args[1] = reason, args[2] = JSON amendment, e.g.
+	{"Add":{"FuelOrder0000000007":{"EstTime":"2019-01-02T10:00:00Z","StartingLocation":"org3","Destination":"org5"}},
+	 "Remove":["FuelOrder0000000003"],"EstTime":{"FuelOrder0000000004":"2019-01-02T12:00:00Z"}}
+cancelPlan - args[0] = PlanID, args[1] = reason
+amendPlan and cancelPlan are by the org that created the plan.
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	StateCancelled = "CANCELLED"
+	PlanActive     = "ACTIVE"
+	PlanCancelled  = "CANCELLED"
+)
+
+/*
+Kept on a cancelled Crude or FuelOrder.
+*/
+type Cancellation struct {
+	Reason      string
+	CancelledBy string //MSP ID
+	TxID        string
+	Timestamp   time.Time
+}
+
+type EstTimeChange struct {
+	Before time.Time
+	After  time.Time
+}
+
+/*
+A change of a plan. Timestamp is the tx time, which decides whether it applies to a delivery (see EstTimeAt).
+*/
+type PlanAmendment struct {
+	Version   int
+	Reason    string
+	AmendedBy string //MSP ID
+	TxID      string
+	Timestamp time.Time
+	Added     map[FuelOrderID]DeliveryDetails `json:",omitempty"`
+	Removed   []FuelOrderID                   `json:",omitempty"`
+	EstTimes  map[FuelOrderID]EstTimeChange   `json:",omitempty"`
+	Cancelled bool                            `json:",omitempty"`
+}
+
+/*
+args[2] of amendPlan.
+*/
+type PlanAmendmentRequest struct {
+	Add     map[FuelOrderID]DeliveryDetails
+	Remove  []FuelOrderID
+	EstTime map[FuelOrderID]time.Time
+}
+
+//plans before amendments have no status
+func (p FuelDeliveryPlan) Cancelled() bool {
+	return p.Status == PlanCancelled
+}
+
+/*
+The EstTime of the order that was agreed at time t: the current one, without the changes of the
+amendments made after t.
+*/
+func (p FuelDeliveryPlan) EstTimeAt(id FuelOrderID, t time.Time) time.Time {
+	est := p.Plan[id].EstTime
+	for i := len(p.Amendments) - 1; i >= 0; i-- {
+		a := p.Amendments[i]
+		if a.Timestamp.After(t) == false {
+			break
+		}
+		if _, ok := a.Added[id]; ok {
+			break
+		}
+		if c, ok := a.EstTimes[id]; ok {
+			est = c.Before
+		}
+	}
+	return est
+}
+
+//whether the caller's MSP ID is the MSP ID of the registered org (see orgs.go)
+func callerIsOrg(stub shim.ChaincodeStubInterface, name string) bool {
+	msp, err := getCallerMSPID(stub)
+	if err != nil {
+		return false
+	}
+	org, err := GetOrg(stub, name)
+	return err == nil && org != nil && org.MSPID == msp
+}
+
+//the owner of a new asset should be the org of the caller
+func checkOwner(stub shim.ChaincodeStubInterface, owner string) error {
+	if callerIsOrg(stub, owner) == false {
+		return fmt.Errorf("Access denied: only %s can create an asset it owns", owner)
+	}
+	return nil
+}
+
+//name of the active org of the caller's MSP ID
+func callerOrg(stub shim.ChaincodeStubInterface) (string, error) {
+	msp, err := getCallerMSPID(stub)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get MSP ID of caller: %s", err)
+	}
+	org, err := GetOrgOfMSP(stub, msp)
+	if err != nil {
+		return "", err
+	}
+	if org == nil {
+		return "", fmt.Errorf("Access denied: %s is not the MSP ID of an active org", msp)
+	}
+	return org.Name, nil
+}
+
+//whether the caller is one of the registered orgs, e.g. the orgs on an asset
+func callerIsOneOf(stub shim.ChaincodeStubInterface, names ...string) bool {
+	for _, name := range names {
+		if callerIsOrg(stub, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func newCancellation(stub shim.ChaincodeStubInterface, reason string) (*Cancellation, error) {
+	msp, err := getCallerMSPID(stub)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get MSP ID of caller: %s", err)
+	}
+	tstamp, err := TxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	return &Cancellation{reason, msp, stub.GetTxID(), tstamp}, nil
+}
+
+//refunds the escrow of a cancelled asset, if it has a locked one, and the private escrow of its value
+func refundCancelled(stub shim.ChaincodeStubInterface, id string) error {
+	ad, err := getAssetDetails(stub, id)
+	if err != nil {
+		return err
+	}
+	t, err := GetTerms(stub, id, ad)
+	if err != nil {
+		return err
+	}
+	if t != nil {
+		if err = RefundPrivateEscrow(stub, t, "cancellation of "+id); err != nil {
+			return err
+		}
+	}
+	e, err := GetEscrow(stub, id)
+	if err != nil || e == nil || e.Status != EscrowLocked {
+		return err
+	}
+	return RefundEscrow(stub, *e, "cancellation of "+id)
+}
+
+/*
+args[0] = CrudeID or FuelOrderID, args[1] = reason
+A Crude is cancelled by its owner, the org it was dispatched from or its destination, as long as it is
+ON_WAY, so none of it has been refined (see refine). A FuelOrder is cancelled by its refiner (the owner) or by its
+fueling station, as long as it is READY_FOR_DISTRIBUTION.
+*/
+func (s *SmartContract) cancelOrder(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Expecting {ID,reason}")
+	}
+	if strings.TrimSpace(args[1]) == "" {
+		return shim.Error("Reason should not be empty")
+	}
+	id := args[0]
+	assetAsBytes, _ := GetAsset(stub, id)
+	if assetAsBytes == nil {
+		return shim.Error("Could not locate Asset")
+	}
+	var orgs []string
+	switch AssetType(id) {
+	case TypeCrude:
+		crude := Crude{}
+		json.Unmarshal(assetAsBytes, &crude)
+		orgs = []string{crude.AD.Owner, crude.DD.StartingLocation, crude.DD.Destination}
+	case TypeFuelOrder:
+		fuelOrder := FuelOrder{}
+		json.Unmarshal(assetAsBytes, &fuelOrder)
+		orgs = []string{fuelOrder.AD.Owner, fuelOrder.Dest}
+	default:
+		return shim.Error("Only a Crude or a FuelOrder can be cancelled")
+	}
+	if callerIsOneOf(stub, orgs...) == false {
+		return shim.Error(fmt.Sprintf("Access denied: only the orgs on %s can cancel it", id))
+	}
+	if err := cancelAsset(stub, id, args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+//whether the Crude or FuelOrder is CANCELLED
+func assetCancelled(stub shim.ChaincodeStubInterface, id string) (bool, error) {
+	assetAsBytes, err := GetAsset(stub, id)
+	if err != nil {
+		return false, err
+	}
+	if assetAsBytes == nil {
+		return false, errors.New("Could not locate Asset")
+	}
+	asset := struct{ AD AssetDetails }{}
+	if err = json.Unmarshal(assetAsBytes, &asset); err != nil {
+		return false, fmt.Errorf("Failed to decode %s", id)
+	}
+	return asset.AD.State == StateCancelled, nil
+}
+
+/*
+Cancels a Crude or a FuelOrder that can still be cancelled and refunds its escrow. The caller is checked by
+the function that calls it.
+*/
+func cancelAsset(stub shim.ChaincodeStubInterface, id, reason string) error {
+	assetAsBytes, _ := GetAsset(stub, id)
+	if assetAsBytes == nil {
+		return errors.New("Could not locate Asset")
+	}
+	c, err := newCancellation(stub, reason)
+	if err != nil {
+		return err
+	}
+	var cancelled interface{}
+	switch AssetType(id) {
+	case TypeCrude:
+		crude := Crude{}
+		json.Unmarshal(assetAsBytes, &crude)
+		if crude.AD.State != "ON_WAY" {
+			return fmt.Errorf("%s is %s. Only a crude on its way can be cancelled", id, crude.AD.State)
+		}
+		crude.AD.State = StateCancelled
+		crude.Cancellation = c
+		cancelled = crude
+	case TypeFuelOrder:
+		fuelOrder := FuelOrder{}
+		json.Unmarshal(assetAsBytes, &fuelOrder)
+		if fuelOrder.AD.State != "READY_FOR_DISTRIBUTION" {
+			return fmt.Errorf("%s is %s. Remove it from its plan first", id, fuelOrder.AD.State)
+		}
+		//the quantity of the order goes back to its fuel
+		fuelbytes, _ := GetAsset(stub, fuelOrder.FuelID)
+		if fuelbytes == nil {
+			return errors.New("Could not locate Fuel of the order")
+		}
+		fuel := Fuel{}
+		json.Unmarshal(fuelbytes, &fuel)
+		fuel.Allocated -= fuelOrder.AD.Quantity
+		if fuel.Allocated < 0 {
+			return fmt.Errorf("%s has less allocated than the quantity of %s", fuelOrder.FuelID, id)
+		}
+		fuelbytes, _ = json.Marshal(fuel)
+		if err = PutAsset(stub, fuelOrder.FuelID, fuelbytes); err != nil {
+			return fmt.Errorf("Failed to put %s in db", fuelOrder.FuelID)
+		}
+		fuelOrder.AD.State = StateCancelled
+		fuelOrder.Cancellation = c
+		cancelled = fuelOrder
+	default:
+		return errors.New("Only a Crude or a FuelOrder can be cancelled")
+	}
+	if err = refundCancelled(stub, id); err != nil {
+		return err
+	}
+	assetAsBytes, _ = json.Marshal(cancelled)
+	if err = PutAsset(stub, id, assetAsBytes); err != nil {
+		return fmt.Errorf("Failed to put %s in db", id)
+	}
+	Emit(stub, EventOrderCancelled, id, cancelled)
+	return nil
+}
+
+/*
+A plan is amended or cancelled by the org that created it. Plans from before CreatedBy was kept can be by
+any refiner or distributor, as before.
+*/
+func checkPlanCreator(stub shim.ChaincodeStubInterface, id string, dplan FuelDeliveryPlan) error {
+	if dplan.CreatedBy != "" && callerIsOrg(stub, dplan.CreatedBy) == false {
+		return fmt.Errorf("Access denied: only %s, which created %s, can change it", dplan.CreatedBy, id)
+	}
+	return nil
+}
+
+func getPlan(stub shim.ChaincodeStubInterface, id string) (FuelDeliveryPlan, error) {
+	dplanAsBytes, _ := GetAsset(stub, id)
+	if dplanAsBytes == nil || AssetType(id) != TypePlan {
+		return FuelDeliveryPlan{}, errors.New("Could not locate Plan")
+	}
+	dplan := FuelDeliveryPlan{}
+	json.Unmarshal(dplanAsBytes, &dplan)
+	if dplan.Cancelled() {
+		return FuelDeliveryPlan{}, fmt.Errorf("%s is cancelled", id)
+	}
+	return dplan, nil
+}
+
+func getPlanOrder(stub shim.ChaincodeStubInterface, id FuelOrderID) (FuelOrder, error) {
+	fuelOrderbytes, _ := GetAsset(stub, id)
+	if fuelOrderbytes == nil {
+		return FuelOrder{}, fmt.Errorf("FuelOrderID %s does not exist", id)
+	}
+	fuelOrder := FuelOrder{}
+	json.Unmarshal(fuelOrderbytes, &fuelOrder)
+	return fuelOrder, nil
+}
+
+func putPlanOrder(stub shim.ChaincodeStubInterface, id FuelOrderID, fuelOrder FuelOrder) error {
+	fuelOrderbytes, _ := json.Marshal(fuelOrder)
+	if err := PutAsset(stub, id, fuelOrderbytes); err != nil {
+		return fmt.Errorf("Failed to put %s in db", id)
+	}
+	return nil
+}
+
+func newPlanAmendment(stub shim.ChaincodeStubInterface, dplan FuelDeliveryPlan, reason string) (PlanAmendment, error) {
+	if strings.TrimSpace(reason) == "" {
+		return PlanAmendment{}, errors.New("Reason should not be empty")
+	}
+	msp, err := getCallerMSPID(stub)
+	if err != nil {
+		return PlanAmendment{}, fmt.Errorf("Failed to get MSP ID of caller: %s", err)
+	}
+	tstamp, err := TxTime(stub)
+	if err != nil {
+		return PlanAmendment{}, err
+	}
+	return PlanAmendment{len(dplan.Amendments) + 1, reason, msp, stub.GetTxID(), tstamp, nil, nil, nil, false}, nil
+}
+
+/*
+args[0] = PlanID, args[1] = reason, args[2] = JSON PlanAmendmentRequest
+Removed orders go back to READY_FOR_DISTRIBUTION and added ones should be READY_FOR_DISTRIBUTION,
+as in deliverFuel. Only the stops that haven't been delivered can be removed, and only those whose
+EstTime hasn't passed at the tx time can get a new EstTime.
+*/
+func (s *SmartContract) amendPlan(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleRefiner, RoleDistributor); err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) != 3 {
+		return shim.Error("Expecting {PlanID,reason,amendment}")
+	}
+	dplan, err := getPlan(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = checkPlanCreator(stub, args[0], dplan); err != nil {
+		return shim.Error(err.Error())
+	}
+	req := PlanAmendmentRequest{}
+	dec := json.NewDecoder(strings.NewReader(args[2]))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&req); err != nil {
+		return shim.Error(fmt.Sprintf("Invalid amendment: %s", err))
+	}
+	if len(req.Add) == 0 && len(req.Remove) == 0 && len(req.EstTime) == 0 {
+		return shim.Error("Amendment should add, remove or change the EstTime of a stop")
+	}
+	a, err := newPlanAmendment(stub, dplan, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	//an order written twice in a tx would only keep the last write, so each order is in one change
+	changed := map[FuelOrderID]bool{}
+	for _, id := range req.Remove {
+		if changed[id] {
+			return shim.Error(fmt.Sprintf("FuelOrderID %s is more than once in the amendment", id))
+		}
+		changed[id] = true
+		if _, ok := dplan.Plan[id]; !ok {
+			return shim.Error(fmt.Sprintf("FuelOrderID %s is not in the plan", id))
+		}
+		fuelOrder, err := getPlanOrder(stub, id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if fuelOrder.AD.State != "ON_WAY" {
+			return shim.Error(fmt.Sprintf("FuelOrderID %s is %s and can't be removed", id, fuelOrder.AD.State))
+		}
+		fuelOrder.AD.State = "READY_FOR_DISTRIBUTION"
+		if err = putPlanOrder(stub, id, fuelOrder); err != nil {
+			return shim.Error(err.Error())
+		}
+		delete(dplan.Plan, id)
+		a.Removed = append(a.Removed, id)
+	}
+	ids := make([]FuelOrderID, 0, len(req.EstTime))
+	for id := range req.EstTime {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if changed[id] {
+			return shim.Error(fmt.Sprintf("FuelOrderID %s is more than once in the amendment", id))
+		}
+		changed[id] = true
+		dd, ok := dplan.Plan[id]
+		if !ok {
+			return shim.Error(fmt.Sprintf("FuelOrderID %s is not in the plan", id))
+		}
+		fuelOrder, err := getPlanOrder(stub, id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if fuelOrder.AD.State != "ON_WAY" {
+			return shim.Error(fmt.Sprintf("FuelOrderID %s is %s and its EstTime can't be changed", id, fuelOrder.AD.State))
+		}
+		if dd.EstTime.After(a.Timestamp) == false {
+			return shim.Error(fmt.Sprintf("EstTime of FuelOrderID %s passed at %s and can't be changed", id, dd.EstTime.Format(time.RFC3339)))
+		}
+		if a.EstTimes == nil {
+			a.EstTimes = map[FuelOrderID]EstTimeChange{}
+		}
+		a.EstTimes[id] = EstTimeChange{dd.EstTime, req.EstTime[id]}
+		dd.EstTime = req.EstTime[id]
+		dplan.Plan[id] = dd
+	}
+	ids = make([]FuelOrderID, 0, len(req.Add))
+	for id := range req.Add {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if changed[id] {
+			return shim.Error(fmt.Sprintf("FuelOrderID %s is more than once in the amendment", id))
+		}
+		changed[id] = true
+		if _, ok := dplan.Plan[id]; ok {
+			return shim.Error(fmt.Sprintf("FuelOrderID %s is already in the plan", id))
+		}
+		fuelOrder, err := getPlanOrder(stub, id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		add := req.Add[id]
+		if err = CheckOrgs(stub, add.StartingLocation, add.Destination); err != nil {
+			return shim.Error(err.Error())
+		}
+		dd := DeliveryDetails{add.EstTime, 0, add.StartingLocation, add.Destination}
+		if err = validatePlanOrder(id, fuelOrder, dd); err != nil {
+			return shim.Error(err.Error())
+		}
+		fuelOrder.AD.State = "ON_WAY"
+		if err = putPlanOrder(stub, id, fuelOrder); err != nil {
+			return shim.Error(err.Error())
+		}
+		if a.Added == nil {
+			a.Added = map[FuelOrderID]DeliveryDetails{}
+		}
+		a.Added[id] = dd
+		dplan.Plan[id] = dd
+	}
+	if len(dplan.Plan) == 0 {
+		return shim.Error("A plan should have at least one stop. Use cancelPlan instead")
+	}
+	total := 0
+	for id := range dplan.Plan {
+		fuelOrder, err := getPlanOrder(stub, id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		total += fuelOrder.AD.Quantity
+	}
+	if err = validatePlanCapacity(dplan.Veh, total); err != nil {
+		return shim.Error(err.Error())
+	}
+	dplan.Amendments = append(dplan.Amendments, a)
+	dplanAsBytes, _ := json.Marshal(dplan)
+	if err = PutAsset(stub, args[0], dplanAsBytes); err != nil {
+		return shim.Error(fmt.Sprintf("Failed to put %s in db", args[0]))
+	}
+	Emit(stub, EventPlanAmended, args[0], a)
+	return shim.Success(nil)
+}
+
+/*
+args[0] = PlanID, args[1] = reason
+The orders the plan has delivered stay delivered, the rest go back to READY_FOR_DISTRIBUTION.
+The stops stay on the plan, for its history.
+A plan with a DISPUTED stop is cancelled once the dispute is resolved.
+*/
+func (s *SmartContract) cancelPlan(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleRefiner, RoleDistributor); err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) != 2 {
+		return shim.Error("Expecting {PlanID,reason}")
+	}
+	dplan, err := getPlan(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = checkPlanCreator(stub, args[0], dplan); err != nil {
+		return shim.Error(err.Error())
+	}
+	a, err := newPlanAmendment(stub, dplan, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, id := range dplan.OrderIDs() {
+		fuelOrder, err := getPlanOrder(stub, id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if fuelOrder.AD.State == "DISPUTED" {
+			return shim.Error(fmt.Sprintf("%s is DISPUTED. Resolve its dispute before cancelling %s", id, args[0]))
+		}
+		if fuelOrder.AD.State != "ON_WAY" {
+			continue
+		}
+		fuelOrder.AD.State = "READY_FOR_DISTRIBUTION"
+		if err = putPlanOrder(stub, id, fuelOrder); err != nil {
+			return shim.Error(err.Error())
+		}
+		a.Removed = append(a.Removed, id)
+	}
+	a.Cancelled = true
+	dplan.Status = PlanCancelled
+	dplan.Amendments = append(dplan.Amendments, a)
+	dplanAsBytes, _ := json.Marshal(dplan)
+	if err = PutAsset(stub, args[0], dplanAsBytes); err != nil {
+		return shim.Error(fmt.Sprintf("Failed to put %s in db", args[0]))
+	}
+	Emit(stub, EventPlanCancelled, args[0], a)
+	return shim.Success(nil)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCancelCrude(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.newCrude()
+	n.as("Org5MSP").fails("Access denied", "cancelOrder", crudeID, "not needed")
+	//a shipper that isn't on the crude
+	n.as("Org2MSP").fails("Access denied: only the orgs on Crude0000000001", "cancelOrder", crudeID, "not needed")
+	n.as("Org3MSP").fails("Reason should not be empty", "cancelOrder", crudeID, " ")
+	//its destination cancels it and gets its escrow back
+	n.ok("cancelOrder", crudeID, "not needed")
+	crude := Crude{}
+	n.asset(crudeID, &crude)
+	if crude.AD.State != StateCancelled || crude.Cancellation == nil || crude.Cancellation.CancelledBy != "Org3MSP" {
+		t.Fatalf("%s after its cancellation is %+v", crudeID, crude)
+	}
+	n.balances(map[string]Money{"org3": openingBalance, EscrowAccountOf(crudeID): 0})
+	if types := n.eventTypes(); len(types) == 0 || types[len(types)-1] != EventOrderCancelled {
+		t.Errorf("Events of cancelOrder are %v", types)
+	}
+	n.fails("Crude0000000001 is CANCELLED", "cancelOrder", crudeID, "again")
+	n.fails("state is not ON_WAY", "transfer", crudeID, "org3", crudeEst)
+
+	//a crude that has been delivered, and refined, stays
+	crudeID = n.newCrude()
+	fuelID := n.newFuel(crudeID)
+	n.as("Org1MSP").fails("Crude0000000002 is DELIVERED. Only a crude on its way can be cancelled", "cancelOrder", crudeID, "too late")
+	n.fails("Only a Crude or a FuelOrder can be cancelled", "cancelOrder", fuelID, "too late")
+	n.fails("Could not locate Asset", "cancelOrder", "Crude0000000009", "lost")
+	n.fails("Expecting {ID,reason}", "cancelOrder", crudeID)
+	n.checkBooks()
+}
+
+func TestCancelFuelOrder(t *testing.T) {
+	n := newTestNet(t)
+	fuelID := n.newFuel(n.newCrude())
+	orderID := n.newFuelOrder(fuelID, "org5")
+	n.as("Org6MSP").fails("Access denied", "cancelOrder", orderID, "not mine")
+	//another retailer can't either, nor a refiner that isn't its owner
+	n.as("Org1MSP").ok("registerOrg", `{"Name":"org7","MSPID":"Org7MSP","Roles":["refiner"]}`)
+	n.as("Org7MSP").fails("Access denied: only the orgs on FuelOrder0000000001", "cancelOrder", orderID, "not mine")
+	n.as("Org5MSP").ok("cancelOrder", orderID, "station closed")
+	n.balances(map[string]Money{"org5": openingBalance})
+	//the quantity of the order goes back to its fuel
+	fuel := Fuel{}
+	n.asset(fuelID, &fuel)
+	if fuel.Allocated != 0 {
+		t.Errorf("%s has %d allocated after the cancellation of %s", fuelID, fuel.Allocated, orderID)
+	}
+	n.as("Org4MSP").ok("registerVehicle", "Truck", "Truck1", "100")
+	n.fails("FuelOrderID FuelOrder0000000001 is CANCELLED", "deliverFuel", "Truck1", orderID, fuelEst, "org3", "org5")
+
+	//an order in a plan has to be removed from it first
+	orderID = n.newFuelOrder(fuelID, "org5")
+	n.newPlan(orderID)
+	n.as("Org3MSP").fails("FuelOrder0000000002 is ON_WAY. Remove it from its plan first", "cancelOrder", orderID, "too late")
+	n.checkBooks()
+}
+
+func TestAmendPlan(t *testing.T) {
+	n := newTestNet(t)
+	at := func(s string) time.Time {
+		tm, _ := time.Parse(time.RFC3339, s)
+		return tm
+	}
+	n.stub.Now = at("2019-01-04T08:00:00Z")
+	fuelID := n.newFuel(n.newCrude())
+	first := n.newFuelOrder(fuelID, "org5")
+	second := n.newFuelOrder(fuelID, "org5")
+	planID := n.newPlan(first)
+
+	amendment := `{"Add":{"` + second + `":{"EstTime":"` + fuelEst + `","StartingLocation":"org3","Destination":"org5"}},` +
+		`"EstTime":{"` + first + `":"` + halfHourLate + `"}}`
+	n.ok("amendPlan", planID, "second stop, first one later", amendment)
+	dplan := FuelDeliveryPlan{}
+	n.asset(planID, &dplan)
+	if len(dplan.Plan) != 2 || len(dplan.Amendments) != 1 || dplan.Amendments[0].AmendedBy != "Org4MSP" {
+		t.Fatalf("%s after its amendment is %+v", planID, dplan)
+	}
+	if est := dplan.Plan[first].EstTime.Format(time.RFC3339); est != halfHourLate {
+		t.Errorf("EstTime of %s is %s", first, est)
+	}
+	if state := n.state(second); state != "ON_WAY" {
+		t.Errorf("%s is %s after it was added to %s", second, state, planID)
+	}
+	//before the EstTime of the first stop passes, it is put off again
+	n.stub.Now = at("2019-01-04T09:20:00Z")
+	n.ok("amendPlan", planID, "traffic", `{"EstTime":{"`+first+`":"2019-01-04T10:00:00Z"}}`)
+
+	//the stop was delivered at 09:15 and recorded at 09:40, so the delay is against the EstTime in force
+	//at 09:15 (09:30, from the first amendment), not the one of the later amendment (10:00)
+	n.stub.Now = at("2019-01-04T09:40:00Z")
+	n.as("Org5MSP").ok("transfer", first, "org5", "2019-01-04T09:15:00Z", planID)
+	n.asset(planID, &dplan)
+	if delay := dplan.Plan[first].Delay; delay != -900 {
+		t.Errorf("Delay of %s is %v, expecting -900", first, delay)
+	}
+	//on time, so the carrier gets the whole fee of 2.00
+	n.balances(map[string]Money{"org4": openingBalance + money("2.00")})
+
+	n.as("Org4MSP")
+	n.fails("FuelOrderID FuelOrder0000000001 is DELIVERED and can't be removed", "amendPlan", planID, "undo", `{"Remove":["`+first+`"]}`)
+	n.fails("FuelOrderID FuelOrder0000000001 is DELIVERED and its EstTime", "amendPlan", planID, "undo", `{"EstTime":{"`+first+`":"`+fuelEst+`"}}`)
+	//the EstTime of the second stop passed at 09:00, so a late delivery can't be excused after the fact
+	n.fails("EstTime of FuelOrderID FuelOrder0000000002 passed at "+fuelEst+" and can't be changed", "amendPlan", planID, "late", `{"EstTime":{"`+second+`":"2019-01-04T11:00:00Z"}}`)
+	n.ok("amendPlan", planID, "station closed", `{"Remove":["`+second+`"]}`)
+	if state := n.state(second); state != "READY_FOR_DISTRIBUTION" {
+		t.Errorf("%s is %s after it was removed from %s", second, state, planID)
+	}
+	dplan = FuelDeliveryPlan{}
+	n.asset(planID, &dplan)
+	if len(dplan.Plan) != 1 || len(dplan.Amendments) != 3 || dplan.Amendments[2].Version != 3 {
+		t.Errorf("%s after the removal is %+v", planID, dplan)
+	}
+	n.checkBooks()
+}
+
+func TestAmendPlanErrors(t *testing.T) {
+	n := newTestNet(t)
+	fuelID := n.newFuel(n.newCrude())
+	first := n.newFuelOrder(fuelID, "org5")
+	second := n.newFuelOrder(fuelID, "org6")
+	n.as("Org4MSP").ok("registerVehicle", "Truck", "Truck2", "30")
+	planID := n.ok("deliverFuel", "Truck2", first, fuelEst, "org3", "org5")
+	add := func(id, dest string) string {
+		return `{"Add":{"` + id + `":{"EstTime":"` + fuelEst + `","StartingLocation":"org3","Destination":"` + dest + `"}}}`
+	}
+
+	n.fails("Expecting {PlanID,reason,amendment}", "amendPlan", planID, "reason")
+	n.fails("Could not locate Plan", "amendPlan", "Plan0000000009", "reason", `{"Remove":["`+first+`"]}`)
+	n.fails("Could not locate Plan", "amendPlan", first, "reason", `{"Remove":["`+first+`"]}`)
+	n.fails("Invalid amendment", "amendPlan", planID, "reason", `{"Drop":["`+first+`"]}`)
+	n.fails("Amendment should add, remove or change", "amendPlan", planID, "reason", `{}`)
+	n.fails("Reason should not be empty", "amendPlan", planID, "", `{"Remove":["`+first+`"]}`)
+	n.fails("is more than once in the amendment", "amendPlan", planID, "reason", `{"Remove":["`+first+`"],"EstTime":{"`+first+`":"`+fuelEst+`"}}`)
+	n.fails("FuelOrderID FuelOrder0000000002 is not in the plan", "amendPlan", planID, "reason", `{"Remove":["`+second+`"]}`)
+	n.fails("FuelOrderID FuelOrder0000000001 is already in the plan", "amendPlan", planID, "reason", add(first, "org5"))
+	n.fails("FuelOrderID FuelOrder0000000009 does not exist", "amendPlan", planID, "reason", add("FuelOrder0000000009", "org5"))
+	n.fails("FuelOrderID FuelOrder0000000002 should be delivered to org6, not org5", "amendPlan", planID, "reason", add(second, "org5"))
+	n.fails("Truck Truck2 can carry 30 but the plan has 40", "amendPlan", planID, "reason", add(second, "org6"))
+	n.fails("A plan should have at least one stop. Use cancelPlan instead", "amendPlan", planID, "reason", `{"Remove":["`+first+`"]}`)
+	n.as("Org5MSP").fails("Access denied", "amendPlan", planID, "reason", `{"Remove":["`+first+`"]}`)
+	//a refiner that didn't create the plan
+	n.as("Org3MSP").fails("Access denied: only org4, which created Plan0000000001", "amendPlan", planID, "reason", `{"Remove":["`+first+`"]}`)
+
+	//the failed txs left the plan and its orders as they were
+	dplan := FuelDeliveryPlan{}
+	n.asset(planID, &dplan)
+	if len(dplan.Plan) != 1 || len(dplan.Amendments) != 0 {
+		t.Errorf("%s after the failed amendments is %+v", planID, dplan)
+	}
+	if state := n.state(second); state != "READY_FOR_DISTRIBUTION" {
+		t.Errorf("%s is %s", second, state)
+	}
+}
+
+func TestCancelPlan(t *testing.T) {
+	n := newTestNet(t)
+	fuelID := n.newFuel(n.newCrude())
+	first := n.newFuelOrder(fuelID, "org5")
+	second := n.newFuelOrder(fuelID, "org5")
+	planID := n.newPlan(first, second)
+	n.as("Org5MSP").ok("transfer", first, "org5", fuelEst, planID)
+
+	n.fails("Access denied", "cancelPlan", planID, "truck broke down")
+	n.as("Org3MSP").fails("Access denied: only org4, which created Plan0000000001", "cancelPlan", planID, "truck broke down")
+	n.as("Org4MSP").fails("Expecting {PlanID,reason}", "cancelPlan", planID)
+	n.fails("Reason should not be empty", "cancelPlan", planID, "")
+	n.fails("Could not locate Plan", "cancelPlan", "Plan0000000009", "truck broke down")
+	n.ok("cancelPlan", planID, "truck broke down")
+	dplan := FuelDeliveryPlan{}
+	n.asset(planID, &dplan)
+	if dplan.Cancelled() == false || len(dplan.Plan) != 2 || len(dplan.Amendments) != 1 {
+		t.Fatalf("%s after its cancellation is %+v", planID, dplan)
+	}
+	if a := dplan.Amendments[0]; a.Cancelled == false || len(a.Removed) != 1 || a.Removed[0] != second {
+		t.Errorf("Cancellation of %s is %+v", planID, a)
+	}
+	//the delivered order stays delivered, the other can be put in another plan
+	if state := n.state(first); state != "DELIVERED" {
+		t.Errorf("%s is %s after the cancellation of %s", first, state, planID)
+	}
+	n.fails("Plan0000000001 is cancelled", "cancelPlan", planID, "again")
+	n.fails("Plan0000000001 is cancelled", "amendPlan", planID, "reason", `{"Remove":["`+second+`"]}`)
+	n.as("Org5MSP").fails("state is not ON_WAY", "transfer", second, "org5", fuelEst, planID)
+	if other := n.newPlan(second); other == planID {
+		t.Errorf("%s got the ID of the cancelled plan", other)
+	}
+	n.checkBooks()
+}
+
+func TestCancelPlanWithDispute(t *testing.T) {
+	n := newTestNet(t)
+	orderID := n.newFuelOrder(n.newFuel(n.newCrude()), "org5")
+	planID := n.newPlan(orderID)
+	disputeID := n.as("Org5MSP").ok("openDispute", orderID, ReasonLateDelivery, "2c26b46b")
+	//the order would be left ON_WAY under a cancelled plan once its dispute is resolved
+	n.as("Org4MSP").fails("FuelOrder0000000001 is DISPUTED. Resolve its dispute before cancelling Plan0000000001", "cancelPlan", planID, "truck broke down")
+	n.as("Org1MSP").ok("resolveDispute", disputeID, "no delay yet")
+	n.as("Org4MSP").ok("cancelPlan", planID, "truck broke down")
+	if state := n.state(orderID); state != "READY_FOR_DISTRIBUTION" {
+		t.Errorf("%s is %s after the cancellation of %s", orderID, state, planID)
+	}
+	n.newPlan(orderID)
+}
@@ -0,0 +1,214 @@
+/*
+Go client of the supply chain chaincode.
+
+Client has a typed method for each function of the chaincode a backend service needs. The requests are sent
+as JSON requests (see schema.go), private terms in the transient map (see privacy.go), and the assets the
+chaincode returns are decoded into the structs of types.go. The chaincode is reached through a Transport:
+MockTransport runs it in process, on a MockStub, and the Transport of the fabric package over the Execute
+and Query of a channel client of the Fabric Go SDK (see fabric/fabric.go).
+
+	c := client.New(transport)
+	id, err := c.DeliverCrude(ctx, client.CrudeRequest{Value: 125000, Quantity: 100, Owner: "org1", ...})
+	crude, err := c.QueryCrude(ctx, id)
+
+API:
+
+DeliverCrude, Refine, AddFuelOrder, DeliverFuel - return the ID of the new asset
+Transfer - either crude or fuel, with the proof of delivery of the receiver
+RegisterVehicle, CancelOrder
+QueryAsset - into any struct; QueryCrude, QueryFuel, QueryFuelOrder and QueryPlan decode the asset
+QueryAssetsByOwner, QueryAssetsByState, QueryAssetsByType, QueryAssets - a page of assets (see page.go)
+*/
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+//version of the JSON requests the client sends (see schema.go)
+const SchemaVersion = 1
+
+//key of the terms in the transient map (see privacy.go)
+const TransientTermsKey = "terms"
+
+/*
+Sends the txs of the client to the chaincode. Submit is for the txs that change the ledger, which are
+ordered and committed, Evaluate for the queries. Both return the payload of the chaincode.
+*/
+type Transport interface {
+	Submit(ctx context.Context, function string, args []string, transient map[string][]byte) ([]byte, error)
+	Evaluate(ctx context.Context, function string, args []string) ([]byte, error)
+}
+
+/*
+A tx the chaincode failed, with the message of the chaincode, e.g. 'INSUFFICIENT_FUNDS: ...'.
+*/
+type Error struct {
+	Function string
+	Status   int32
+	Message  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s failed: %s", e.Function, e.Message)
+}
+
+type Client struct {
+	transport Transport
+}
+
+func New(transport Transport) *Client {
+	return &Client{transport}
+}
+
+func (c *Client) submit(ctx context.Context, function string, req request, terms *Terms) (string, error) {
+	var transient map[string][]byte
+	if terms != nil {
+		b, err := json.Marshal(terms)
+		if err != nil {
+			return "", err
+		}
+		transient = map[string][]byte{TransientTermsKey: b}
+	}
+	payload, err := c.transport.Submit(ctx, function, []string{req.arg()}, transient)
+	return string(payload), err
+}
+
+/*
+Returns the ID of the new Crude. With Terms the value is private to the owner and the destination.
+*/
+func (c *Client) DeliverCrude(ctx context.Context, req CrudeRequest) (string, error) {
+	return c.submit(ctx, "deliverCrude", req.request(), req.Terms)
+}
+
+/*
+Returns the ID of the new Fuel.
+*/
+func (c *Client) Refine(ctx context.Context, req RefineRequest) (string, error) {
+	return c.submit(ctx, "refine", req.request(), nil)
+}
+
+/*
+Returns the ID of the new FuelOrder. With Terms the value is private to the refiner and the station.
+*/
+func (c *Client) AddFuelOrder(ctx context.Context, req FuelOrderRequest) (string, error) {
+	return c.submit(ctx, "addFuelOrder", req.request(), req.Terms)
+}
+
+/*
+Returns the ID of the new plan.
+*/
+func (c *Client) DeliverFuel(ctx context.Context, req DeliverFuelRequest) (string, error) {
+	return c.submit(ctx, "deliverFuel", req.request(), nil)
+}
+
+func (c *Client) Transfer(ctx context.Context, req TransferRequest) error {
+	_, err := c.submit(ctx, "transfer", req.request(), nil)
+	return err
+}
+
+/*
+vehicleType is 'Truck' or 'Vessel'. Registering an existing vehicle again updates its capacity, only by the org
+that registered it.
+*/
+func (c *Client) RegisterVehicle(ctx context.Context, vehicleType, id string, capacity int) error {
+	req := newRequest(map[string]interface{}{"type": vehicleType, "vehicleID": id, "capacity": capacity})
+	_, err := c.submit(ctx, "registerVehicle", req, nil)
+	return err
+}
+
+/*
+Cancels a Crude or a FuelOrder and refunds the escrow of its buyer (see amend.go).
+*/
+func (c *Client) CancelOrder(ctx context.Context, id, reason string) error {
+	_, err := c.transport.Submit(ctx, "cancelOrder", []string{id, reason}, nil)
+	return err
+}
+
+/*
+Decodes the asset (or the account of an org) with the ID into v.
+*/
+func (c *Client) QueryAsset(ctx context.Context, id string, v interface{}) error {
+	payload, err := c.transport.Evaluate(ctx, "queryAsset", []string{id})
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return fmt.Errorf("%s is not a %T: %s", id, v, err)
+	}
+	return nil
+}
+
+func (c *Client) QueryCrude(ctx context.Context, id string) (Crude, error) {
+	crude := Crude{}
+	err := c.QueryAsset(ctx, id, &crude)
+	return crude, err
+}
+
+func (c *Client) QueryFuel(ctx context.Context, id string) (Fuel, error) {
+	fuel := Fuel{}
+	err := c.QueryAsset(ctx, id, &fuel)
+	return fuel, err
+}
+
+func (c *Client) QueryFuelOrder(ctx context.Context, id string) (FuelOrder, error) {
+	order := FuelOrder{}
+	err := c.QueryAsset(ctx, id, &order)
+	return order, err
+}
+
+func (c *Client) QueryPlan(ctx context.Context, id string) (FuelDeliveryPlan, error) {
+	plan := FuelDeliveryPlan{}
+	err := c.QueryAsset(ctx, id, &plan)
+	return plan, err
+}
+
+/*
+The assets of an owner, or those in a state, of assetType (all types if empty). pageSize 0 is the default
+page size of the chaincode and bookmark is empty for the first page (see page.go).
+*/
+func (c *Client) QueryAssetsByOwner(ctx context.Context, owner, assetType string, pageSize int, bookmark string) (Page, error) {
+	return c.queryPage(ctx, "queryAssetsByOwner", []string{owner, assetType}, pageSize, bookmark)
+}
+
+func (c *Client) QueryAssetsByState(ctx context.Context, state, assetType string, pageSize int, bookmark string) (Page, error) {
+	return c.queryPage(ctx, "queryAssetsByState", []string{state, assetType}, pageSize, bookmark)
+}
+
+/*
+The assets of assetType, in the order they were created in.
+*/
+func (c *Client) QueryAssetsByType(ctx context.Context, assetType string, pageSize int, bookmark string) (Page, error) {
+	return c.queryPage(ctx, "queryAssetByRange", []string{assetType}, pageSize, bookmark)
+}
+
+/*
+The assets of assetType matching a Mango selector, e.g. {"AD.State":"ON_WAY","Dest":"org5"}.
+Needs CouchDB as state database (see richquery.go).
+*/
+func (c *Client) QueryAssets(ctx context.Context, assetType string, selector map[string]interface{}, pageSize int, bookmark string) (Page, error) {
+	b, err := json.Marshal(selector)
+	if err != nil {
+		return Page{}, err
+	}
+	return c.queryPage(ctx, "queryAssets", []string{assetType, string(b), ""}, pageSize, bookmark)
+}
+
+func (c *Client) queryPage(ctx context.Context, function string, args []string, pageSize int, bookmark string) (Page, error) {
+	size := ""
+	if pageSize > 0 {
+		size = strconv.Itoa(pageSize)
+	}
+	payload, err := c.transport.Evaluate(ctx, function, append(args, size, bookmark))
+	if err != nil {
+		return Page{}, err
+	}
+	page := Page{}
+	if err := json.Unmarshal(payload, &page); err != nil {
+		return Page{}, fmt.Errorf("%s returned an invalid page: %s", function, err)
+	}
+	return page, nil
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+//a transport that records the txs and returns payload
+type recorder struct {
+	function  string
+	args      []string
+	transient map[string][]byte
+	payload   []byte
+}
+
+func (r *recorder) Submit(ctx context.Context, function string, args []string, transient map[string][]byte) ([]byte, error) {
+	r.function, r.args, r.transient = function, args, transient
+	return r.payload, nil
+}
+
+func (r *recorder) Evaluate(ctx context.Context, function string, args []string) ([]byte, error) {
+	return r.Submit(ctx, function, args, nil)
+}
+
+//the only arg of the last tx, decoded
+func (r *recorder) request(t *testing.T) map[string]interface{} {
+	t.Helper()
+	if len(r.args) != 1 {
+		t.Fatalf("%s was sent with args %q, expecting a JSON request", r.function, r.args)
+	}
+	req := map[string]interface{}{}
+	dec := json.NewDecoder(strings.NewReader(r.args[0]))
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestRequests(t *testing.T) {
+	r := &recorder{payload: []byte("Crude0000000001")}
+	c := New(r)
+	ctx := context.Background()
+	est := time.Date(2019, 1, 3, 12, 0, 0, 0, time.UTC)
+
+	id, _ := c.DeliverCrude(ctx, CrudeRequest{Value: 125050, Quantity: 100, Owner: "org1", EstTime: est,
+		StartLocation: "org1", Destination: "org3", VesselID: "Vessel1", Timestamp: est})
+	want := map[string]interface{}{"schemaVersion": json.Number("1"), "value": json.Number("1250.50"),
+		"quantity": json.Number("100"), "owner": "org1", "estTime": "2019-01-03T12:00:00Z", "startLocation": "org1",
+		"destination": "org3", "vesselID": "Vessel1", "timestamp": "2019-01-03T12:00:00Z"}
+	if got := r.request(t); id != "Crude0000000001" || r.function != "deliverCrude" || reflect.DeepEqual(got, want) == false {
+		t.Errorf("DeliverCrude sent %s %v, expecting %v", r.function, got, want)
+	}
+	if r.transient != nil {
+		t.Errorf("DeliverCrude without terms sent the transient map %v", r.transient)
+	}
+
+	//private terms go in the transient map and the value in the request is 0
+	c.AddFuelOrder(ctx, FuelOrderRequest{Value: 50000, Quantity: 20, Owner: "org3", Destination: "org5",
+		FuelID: "Fuel0000000001", Timestamp: est, Currency: "USD", Terms: &Terms{50000, "6f1d"}})
+	if got := r.request(t); got["value"] != json.Number("0.00") || got["currency"] != "USD" {
+		t.Errorf("AddFuelOrder with terms sent %v", got)
+	}
+	if terms := string(r.transient[TransientTermsKey]); terms != `{"Value":"500.00","Salt":"6f1d"}` {
+		t.Errorf("Terms in the transient map are %s", terms)
+	}
+
+	c.DeliverFuel(ctx, DeliverFuelRequest{"Truck1", []Delivery{{"FuelOrder0000000001", est, "org3", "org5"}}})
+	deliveries, _ := r.request(t)["deliveries"].([]interface{})
+	if len(deliveries) != 1 || reflect.DeepEqual(deliveries[0], map[string]interface{}{"fuelOrderID": "FuelOrder0000000001",
+		"estTime": "2019-01-03T12:00:00Z", "startLocation": "org3", "destination": "org5"}) == false {
+		t.Errorf("DeliverFuel sent the deliveries %v", deliveries)
+	}
+
+	//a transfer without measurements or plan has no such fields
+	c.Transfer(ctx, TransferRequest{AssetID: "Crude0000000001", Owner: "org3", Timestamp: est})
+	if got := r.request(t); len(got) != 4 {
+		t.Errorf("Transfer sent %v", got)
+	}
+	c.Transfer(ctx, TransferRequest{"FuelOrder0000000001", "org5", est, "Plan0000000001", 20, 0.85})
+	if got := r.request(t); got["planID"] != "Plan0000000001" || got["measuredDensity"] != json.Number("0.85") {
+		t.Errorf("Transfer sent %v", got)
+	}
+
+	c.CancelOrder(ctx, "Crude0000000001", "not needed")
+	if r.function != "cancelOrder" || reflect.DeepEqual(r.args, []string{"Crude0000000001", "not needed"}) == false {
+		t.Errorf("CancelOrder sent %s %q", r.function, r.args)
+	}
+}
+
+func TestQueryAsset(t *testing.T) {
+	r := &recorder{payload: []byte(`{"AD":{"Value":"800.00","Quantity":50,"Owner":"org3","State":"REFINED"},` +
+		`"Density":0.85,"Type":"Diesel","CrudeID":"Crude0000000001","Timestamp":"2019-01-03T14:00:00Z"}`)}
+	c := New(r)
+	fuel, err := c.QueryFuel(context.Background(), "Fuel0000000001")
+	if err != nil || r.function != "queryAsset" || r.args[0] != "Fuel0000000001" {
+		t.Fatalf("QueryFuel sent %s %q and returned %v", r.function, r.args, err)
+	}
+	if fuel.AD.Value != 80000 || fuel.CrudeID != "Crude0000000001" || fuel.Timestamp.Hour() != 14 {
+		t.Errorf("Fuel0000000001 is %+v", fuel)
+	}
+	r.payload = []byte(`{"AD":{"Value":"8.001"}}`)
+	if _, err := c.QueryFuel(context.Background(), "Fuel0000000001"); err == nil {
+		t.Error("A value with 3 decimals was decoded")
+	}
+}
+
+func TestMoney(t *testing.T) {
+	for s, want := range map[string]Money{"12": 1200, "-12.5": -1250, "0.05": 5, "1250.50": 125050} {
+		if m, err := ParseMoney(s); err != nil || m != want {
+			t.Errorf("ParseMoney(%q) is %d (%v), expecting %d", s, m, err, want)
+		}
+	}
+	for _, s := range []string{"", "1.", "1.234", "1e3", "+1", "--1", "1.-5"} {
+		if _, err := ParseMoney(s); err == nil {
+			t.Errorf("ParseMoney(%q) succeeded", s)
+		}
+	}
+	var m Money
+	if err := json.Unmarshal([]byte(`12.5`), &m); err != nil || m != 1250 {
+		t.Errorf("Float 12.5 is %s (%v)", m, err)
+	}
+	if s := Money(-5).String(); s != "-0.05" {
+		t.Errorf("-5 cents is %s", s)
+	}
+}
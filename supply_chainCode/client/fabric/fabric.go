@@ -0,0 +1,106 @@
+/*
+Transport of the Go client (see client.go) over a Fabric network.
+
+The txs are sent through a ChannelClient: the Execute and Query of the channel client of the Fabric Go SDK,
+behind an interface so that this package doesn't depend on the SDK (see gateway/fabricgw for the adapter).
+Execute endorses the tx, sends it to the orderer and waits for its commit; Query only endorses it. The txs
+are signed by the identity of the SDK, so the chaincode sees it as the caller (see roles.go).
+
+	t := fabric.New(channelClient, "scthreediff6")
+	c := client.New(t)
+
+An error of the chaincode is a *client.Error with the status and the message of shim.Error, as with the
+MockTransport, so that the gateway maps it to the same HTTP status (see gateway/errors.go).
+*/
+package fabric
+
+import (
+	"context"
+	"fmt"
+	"github.com/chaincode/supply_chainCode/client"
+)
+
+/*
+A proposal for a chaincode, as channel.Request of the SDK. Fcn is the function, which the SDK sends as the
+first arg.
+*/
+type Request struct {
+	ChaincodeID  string
+	Fcn          string
+	Args         [][]byte
+	TransientMap map[string][]byte
+}
+
+/*
+What the SDK returns for a proposal, as channel.Response.
+*/
+type Response struct {
+	Payload       []byte
+	TransactionID string
+}
+
+/*
+The chaincode failed the tx: what a ChannelClient returns for an error in the ChaincodeStatus group of the
+SDK, with its code (the status of shim.Error) and message.
+*/
+type ChaincodeError struct {
+	Status  int32
+	Message string
+}
+
+func (e *ChaincodeError) Error() string {
+	return fmt.Sprintf("chaincode error (status: %d, message: %s)", e.Status, e.Message)
+}
+
+/*
+The channel client of the SDK. ctx bounds the request (channel.WithParentContext), an error of the chaincode
+is a *ChaincodeError.
+*/
+type ChannelClient interface {
+	Execute(ctx context.Context, req Request) (Response, error)
+	Query(ctx context.Context, req Request) (Response, error)
+}
+
+type Transport struct {
+	Channel     ChannelClient
+	ChaincodeID string
+}
+
+func New(channel ChannelClient, chaincodeID string) *Transport {
+	return &Transport{channel, chaincodeID}
+}
+
+func (t *Transport) Submit(ctx context.Context, function string, args []string, transient map[string][]byte) ([]byte, error) {
+	resp, err := t.Channel.Execute(ctx, t.request(function, args, transient))
+	if err != nil {
+		return nil, txError(ctx, function, err)
+	}
+	return resp.Payload, nil
+}
+
+func (t *Transport) Evaluate(ctx context.Context, function string, args []string) ([]byte, error) {
+	resp, err := t.Channel.Query(ctx, t.request(function, args, nil))
+	if err != nil {
+		return nil, txError(ctx, function, err)
+	}
+	return resp.Payload, nil
+}
+
+func (t *Transport) request(function string, args []string, transient map[string][]byte) Request {
+	bargs := make([][]byte, 0, len(args))
+	for _, a := range args {
+		bargs = append(bargs, []byte(a))
+	}
+	return Request{t.ChaincodeID, function, bargs, transient}
+}
+
+//the error of a tx as the client returns it: the error of the chaincode, or of ctx if it is done
+func txError(ctx context.Context, function string, err error) error {
+	if e, ok := err.(*ChaincodeError); ok {
+		return &client.Error{Function: function, Status: e.Status, Message: e.Message}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package fabric
+
+import (
+	"context"
+	"errors"
+	"github.com/chaincode/supply_chainCode/client"
+	"reflect"
+	"testing"
+)
+
+//a channel that records the last request, and returns payload or fails with err
+type channel struct {
+	method  string
+	req     Request
+	payload []byte
+	err     error
+}
+
+func (c *channel) Execute(ctx context.Context, req Request) (Response, error) {
+	c.method, c.req = "Execute", req
+	return Response{c.payload, "tx1"}, c.err
+}
+
+func (c *channel) Query(ctx context.Context, req Request) (Response, error) {
+	c.method, c.req = "Query", req
+	return Response{c.payload, ""}, c.err
+}
+
+func TestTransport(t *testing.T) {
+	ch := &channel{payload: []byte("Crude0000000001")}
+	tr := New(ch, "scthreediff6")
+	transient := map[string][]byte{"terms": []byte(`{}`)}
+	if out, err := tr.Submit(context.Background(), "deliverCrude", []string{`{"quantity":100}`}, transient); err != nil || string(out) != "Crude0000000001" {
+		t.Fatalf("Submit returned %q, %v", out, err)
+	}
+	want := Request{"scthreediff6", "deliverCrude", [][]byte{[]byte(`{"quantity":100}`)}, transient}
+	if ch.method != "Execute" || reflect.DeepEqual(ch.req, want) == false {
+		t.Errorf("Submit sent %s %+v, expecting Execute %+v", ch.method, ch.req, want)
+	}
+	tr.Evaluate(context.Background(), "queryAsset", []string{"Crude0000000001"})
+	if ch.method != "Query" || ch.req.Fcn != "queryAsset" || ch.req.TransientMap != nil {
+		t.Errorf("Evaluate sent %s %+v", ch.method, ch.req)
+	}
+}
+
+func TestTransportErrors(t *testing.T) {
+	ch := &channel{err: &ChaincodeError{500, "Access denied: Org5MSP should have one of the roles [refiner]"}}
+	tr := New(ch, "scthreediff6")
+	_, err := tr.Submit(context.Background(), "refine", nil, nil)
+	if e, ok := err.(*client.Error); ok == false || e.Function != "refine" || e.Status != 500 || e.Message != "Access denied: Org5MSP should have one of the roles [refiner]" {
+		t.Errorf("Error of the chaincode is %#v", err)
+	}
+	//an error of the network is returned as is, or as the error of ctx once it is done
+	ch.err = errors.New("connection refused")
+	if _, err = tr.Evaluate(context.Background(), "queryAsset", nil); err != ch.err {
+		t.Errorf("Error of the network is %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = tr.Evaluate(ctx, "queryAsset", nil); err != context.Canceled {
+		t.Errorf("Error of a cancelled request is %v", err)
+	}
+}
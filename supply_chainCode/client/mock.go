@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"strconv"
+)
+
+/*
+A ledger running the chaincode in process, e.g. the Stub of the memstub package,
+which is a MockStub that also rolls back failed txs and passes the transient map. args[0] is the function.
+*/
+type Ledger interface {
+	Invoke(txID string, args [][]byte, transient map[string][]byte) sc.Response
+}
+
+/*
+Transport to a Ledger, for tests. Queries are invoked like the other txs. Who the caller is, is up to
+the ledger, e.g. the Caller of a memstub.Stub.
+*/
+type MockTransport struct {
+	Ledger Ledger
+	txs    int
+}
+
+func NewMockTransport(ledger Ledger) *MockTransport {
+	return &MockTransport{ledger, 0}
+}
+
+func (t *MockTransport) Submit(ctx context.Context, function string, args []string, transient map[string][]byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	t.txs++
+	resp := t.Ledger.Invoke("client"+strconv.Itoa(t.txs), proposalArgs(function, args), transient)
+	if resp.Status >= shim.ERRORTHRESHOLD {
+		return nil, &Error{function, resp.Status, resp.Message}
+	}
+	return resp.Payload, nil
+}
+
+func (t *MockTransport) Evaluate(ctx context.Context, function string, args []string) ([]byte, error) {
+	return t.Submit(ctx, function, args, nil)
+}
+
+//args of a proposal for function
+func proposalArgs(function string, args []string) [][]byte {
+	bargs := [][]byte{[]byte(function)}
+	for _, a := range args {
+		bargs = append(bargs, []byte(a))
+	}
+	return bargs
+}
@@ -0,0 +1,353 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//minor units in a unit of every currency, as in the chaincode (see money.go)
+const MinorUnits = 100
+
+/*
+An amount of money in cents. Assets carry it as a decimal string, e.g. "1250.00".
+*/
+type Money int64
+
+/*
+Parses a decimal amount with up to 2 decimals, e.g. "12", "-12.5", "12.50".
+*/
+func ParseMoney(s string) (Money, error) {
+	neg := strings.HasPrefix(s, "-")
+	units, cents := strings.TrimPrefix(s, "-"), ""
+	if i := strings.Index(units, "."); i >= 0 {
+		units, cents = units[:i], units[i+1:]
+		if len(cents) == 0 || len(cents) > 2 {
+			return 0, fmt.Errorf("%s should have 1 or 2 decimals", s)
+		}
+	}
+	u, err := strconv.ParseUint(units, 10, 63)
+	if err != nil || u > math.MaxInt64/MinorUnits-1 {
+		return 0, fmt.Errorf("%s is not an amount of money", s)
+	}
+	c, err := strconv.ParseUint((cents + "00")[:2], 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not an amount of money", s)
+	}
+	m := Money(int64(u)*MinorUnits + int64(c))
+	if neg {
+		m = -m
+	}
+	return m, nil
+}
+
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, m/MinorUnits, m%MinorUnits)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+/*
+Reads a decimal string or, for records before fixed-point money, a float number of units.
+*/
+func (m *Money) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		v, err := ParseMoney(s)
+		if err != nil {
+			return err
+		}
+		*m = v
+		return nil
+	}
+	var f float64
+	if err := json.Unmarshal(b, &f); err != nil {
+		return errors.New("Amount of money should be a decimal string")
+	}
+	*m = Money(math.Round(f * MinorUnits))
+	return nil
+}
+
+//the assets as the chaincode stores them (see all-orgsCC.go)
+
+type Vehicle struct {
+	Type     string
+	ID       string
+	Capacity int
+}
+
+type DeliveryDetails struct {
+	EstTime          time.Time
+	Delay            float64 //seconds
+	StartingLocation string
+	Destination      string
+}
+
+type TxProof struct {
+	URL  string
+	Hash string
+}
+
+type AssetDetails struct {
+	Value      Money //0 if it is private to the trading pair
+	Quantity   int
+	Owner      string
+	State      string
+	Currency   string
+	Collection string `json:",omitempty"`
+	TermsHash  string `json:",omitempty"`
+}
+
+type Payment struct {
+	Payer    string
+	Payee    string
+	Amount   Money
+	Currency string
+	Rule     string
+}
+
+type Cancellation struct {
+	Reason      string
+	CancelledBy string
+	TxID        string
+	Timestamp   time.Time
+}
+
+type Crude struct {
+	AD           AssetDetails
+	DD           DeliveryDetails
+	Proof        TxProof
+	Veh          Vehicle
+	Timestamp    time.Time
+	Payments     []Payment
+	Allocated    int
+	Cancellation *Cancellation `json:",omitempty"`
+}
+
+type Fuel struct {
+	AD        AssetDetails
+	Density   float64
+	Type      string
+	CrudeID   string
+	Timestamp time.Time
+	Allocated int
+	CrudeUsed int
+	Yield     float64
+}
+
+type FuelOrder struct {
+	AD           AssetDetails
+	Dest         string
+	Seller       string
+	Proof        TxProof
+	FuelID       string
+	Timestamp    time.Time
+	Payments     []Payment
+	Cancellation *Cancellation `json:",omitempty"`
+}
+
+type EstTimeChange struct {
+	Before time.Time
+	After  time.Time
+}
+
+type PlanAmendment struct {
+	Version   int
+	Reason    string
+	AmendedBy string
+	TxID      string
+	Timestamp time.Time
+	Added     map[string]DeliveryDetails `json:",omitempty"`
+	Removed   []string                   `json:",omitempty"`
+	EstTimes  map[string]EstTimeChange   `json:",omitempty"`
+	Cancelled bool                       `json:",omitempty"`
+}
+
+/*
+Plan is keyed by FuelOrderID.
+*/
+type FuelDeliveryPlan struct {
+	Veh        Vehicle
+	Plan       map[string]DeliveryDetails
+	Status     string          `json:",omitempty"`
+	Amendments []PlanAmendment `json:",omitempty"`
+	CreatedBy  string          `json:",omitempty"` //the org that can amend or cancel it
+}
+
+/*
+Commercial terms private to the supplier and the buyer of an asset (see privacy.go). They are sent in the
+transient map and the public asset only keeps their salted hash, so Salt should be random.
+*/
+type Terms struct {
+	Value Money
+	Salt  string
+}
+
+//the requests, with the fields of the JSON requests of the chaincode (see schema.go)
+
+/*
+Value is ignored if Terms is set.
+*/
+type CrudeRequest struct {
+	Value         Money     `json:"value"`
+	Quantity      int       `json:"quantity"`
+	Owner         string    `json:"owner"`
+	EstTime       time.Time `json:"estTime"`
+	StartLocation string    `json:"startLocation"`
+	Destination   string    `json:"destination"`
+	VesselID      string    `json:"vesselID"`
+	Timestamp     time.Time `json:"timestamp"`
+	Currency      string    `json:"currency,omitempty"` //EUR if empty
+	Terms         *Terms    `json:"terms,omitempty"`
+}
+
+type RefineRequest struct {
+	Value     Money     `json:"value"`
+	Quantity  int       `json:"quantity"`
+	Owner     string    `json:"owner"`
+	Density   float64   `json:"density"`
+	FuelType  string    `json:"fuelType"`
+	CrudeID   string    `json:"crudeID"`
+	Timestamp time.Time `json:"timestamp"`
+	Currency  string    `json:"currency,omitempty"`
+}
+
+/*
+Value is ignored if Terms is set.
+*/
+type FuelOrderRequest struct {
+	Value       Money     `json:"value"`
+	Quantity    int       `json:"quantity"`
+	Owner       string    `json:"owner"`
+	Destination string    `json:"destination"`
+	FuelID      string    `json:"fuelID"`
+	Timestamp   time.Time `json:"timestamp"`
+	Currency    string    `json:"currency,omitempty"`
+	Terms       *Terms    `json:"terms,omitempty"`
+}
+
+type Delivery struct {
+	FuelOrderID   string    `json:"fuelOrderID"`
+	EstTime       time.Time `json:"estTime"`
+	StartLocation string    `json:"startLocation"`
+	Destination   string    `json:"destination"`
+}
+
+type DeliverFuelRequest struct {
+	TruckID    string     `json:"truckID"`
+	Deliveries []Delivery `json:"deliveries"`
+}
+
+/*
+PlanID is required for a FuelOrder. The measurements are the proof of delivery of the receiver
+(see receipt.go), zero if there are none.
+*/
+type TransferRequest struct {
+	AssetID          string    `json:"assetID"`
+	Owner            string    `json:"owner"`
+	Timestamp        time.Time `json:"timestamp"`
+	PlanID           string    `json:"planID,omitempty"`
+	MeasuredQuantity int       `json:"measuredQuantity,omitempty"`
+	MeasuredDensity  float64   `json:"measuredDensity,omitempty"`
+}
+
+/*
+A page of a query of assets (see page.go). Record is the JSON of the asset, to decode into its struct.
+*/
+type Page struct {
+	Records             []AssetRecord
+	FetchedRecordsCount int
+	Bookmark            string //to ask the next page with, empty after the last page
+}
+
+type AssetRecord struct {
+	Key    string
+	Record json.RawMessage
+}
+
+//a JSON request, or an item of one, without the empty optional strings
+type request map[string]interface{}
+
+func newRequest(fields map[string]interface{}) request {
+	r := request{}
+	for name, v := range fields {
+		switch v := v.(type) {
+		case string:
+			if v != "" {
+				r[name] = v
+			}
+		case Money:
+			r[name] = json.Number(v.String())
+		case time.Time:
+			r[name] = v.Format(time.RFC3339)
+		default:
+			r[name] = v
+		}
+	}
+	return r
+}
+
+//the request as the only arg of its function
+func (r request) arg() string {
+	doc := map[string]interface{}{"schemaVersion": SchemaVersion}
+	for name, v := range r {
+		doc[name] = v
+	}
+	b, _ := json.Marshal(doc)
+	return string(b)
+}
+
+func (req CrudeRequest) request() request {
+	value := req.Value
+	if req.Terms != nil {
+		value = 0
+	}
+	return newRequest(map[string]interface{}{"value": value, "quantity": req.Quantity, "owner": req.Owner,
+		"estTime": req.EstTime, "startLocation": req.StartLocation, "destination": req.Destination,
+		"vesselID": req.VesselID, "timestamp": req.Timestamp, "currency": req.Currency})
+}
+
+func (req RefineRequest) request() request {
+	return newRequest(map[string]interface{}{"value": req.Value, "quantity": req.Quantity, "owner": req.Owner,
+		"density": req.Density, "fuelType": req.FuelType, "crudeID": req.CrudeID, "timestamp": req.Timestamp,
+		"currency": req.Currency})
+}
+
+func (req FuelOrderRequest) request() request {
+	value := req.Value
+	if req.Terms != nil {
+		value = 0
+	}
+	return newRequest(map[string]interface{}{"value": value, "quantity": req.Quantity, "owner": req.Owner,
+		"destination": req.Destination, "fuelID": req.FuelID, "timestamp": req.Timestamp, "currency": req.Currency})
+}
+
+func (req DeliverFuelRequest) request() request {
+	deliveries := []request{}
+	for _, d := range req.Deliveries {
+		deliveries = append(deliveries, newRequest(map[string]interface{}{"fuelOrderID": d.FuelOrderID,
+			"estTime": d.EstTime, "startLocation": d.StartLocation, "destination": d.Destination}))
+	}
+	return newRequest(map[string]interface{}{"truckID": req.TruckID, "deliveries": deliveries})
+}
+
+func (req TransferRequest) request() request {
+	r := newRequest(map[string]interface{}{"assetID": req.AssetID, "owner": req.Owner, "timestamp": req.Timestamp,
+		"planID": req.PlanID})
+	if req.MeasuredQuantity != 0 || req.MeasuredDensity != 0 {
+		r["measuredQuantity"] = req.MeasuredQuantity
+		r["measuredDensity"] = req.MeasuredDensity
+	}
+	return r
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"github.com/chaincode/supply_chainCode/client"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"testing"
+	"time"
+)
+
+//the client over the in-memory ledger, so its requests go through the checks of the chaincode
+func TestClient(t *testing.T) {
+	n := newTestNet(t)
+	c := client.New(client.NewMockTransport(n.stub))
+	ctx := context.Background()
+	at := func(s string) time.Time {
+		tm, _ := time.Parse(time.RFC3339, s)
+		return tm
+	}
+
+	n.as("Org1MSP")
+	crudeID, err := c.DeliverCrude(ctx, client.CrudeRequest{Value: 1000 * client.MinorUnits, Quantity: 100, Owner: "org1",
+		EstTime: at(crudeEst), StartLocation: "org1", Destination: "org3", VesselID: "Vessel1", Timestamp: at(dispatched)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crude, err := c.QueryCrude(ctx, crudeID)
+	if err != nil || crude.AD.Value != 1000*client.MinorUnits || crude.DD.Destination != "org3" || crude.AD.State != "ON_WAY" {
+		t.Fatalf("%s is %+v (%v)", crudeID, crude, err)
+	}
+	n.as("Org3MSP")
+	if err := c.Transfer(ctx, client.TransferRequest{AssetID: crudeID, Owner: "org3", Timestamp: at(crudeEst),
+		MeasuredQuantity: 100, MeasuredDensity: 0.85}); err != nil {
+		t.Fatal(err)
+	}
+
+	fuelID, err := c.Refine(ctx, client.RefineRequest{Value: 800 * client.MinorUnits, Quantity: 50, Owner: "org3",
+		Density: 0.85, FuelType: "Diesel", CrudeID: crudeID, Timestamp: at(refined)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	terms := &client.Terms{Value: 500 * client.MinorUnits, Salt: salt}
+	orderID, err := c.AddFuelOrder(ctx, client.FuelOrderRequest{Quantity: 20, Owner: "org3", Destination: "org5",
+		FuelID: fuelID, Timestamp: at(ordered), Terms: terms})
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := c.QueryFuelOrder(ctx, orderID)
+	if err != nil || order.AD.Value != 0 || order.AD.TermsHash == "" || order.FuelID != fuelID {
+		t.Fatalf("%s is %+v (%v)", orderID, order, err)
+	}
+
+	n.as("Org4MSP")
+	if err := c.RegisterVehicle(ctx, "Truck", "Truck1", 100); err != nil {
+		t.Fatal(err)
+	}
+	planID, err := c.DeliverFuel(ctx, client.DeliverFuelRequest{TruckID: "Truck1",
+		Deliveries: []client.Delivery{{FuelOrderID: orderID, EstTime: at(fuelEst), StartLocation: "org3", Destination: "org5"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.as("Org5MSP")
+	if err := c.Transfer(ctx, client.TransferRequest{AssetID: orderID, Owner: "org5", Timestamp: at(halfHourLate),
+		PlanID: planID}); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := c.QueryPlan(ctx, planID)
+	if err != nil || plan.Veh.ID != "Truck1" || plan.Plan[orderID].Delay != 1800 {
+		t.Errorf("%s is %+v (%v)", planID, plan, err)
+	}
+	n.checkBooks()
+}
+
+func TestClientErrors(t *testing.T) {
+	n := newTestNet(t)
+	c := client.New(client.NewMockTransport(n.stub))
+	ctx := context.Background()
+	crudeID := n.newCrude()
+
+	//the error of the chaincode, and the failed tx left nothing behind
+	n.as("Org5MSP")
+	err := c.CancelOrder(ctx, crudeID, "not mine")
+	if e, ok := err.(*client.Error); ok == false || e.Function != "cancelOrder" || e.Status != shim.ERROR {
+		t.Errorf("CancelOrder returned %#v", err)
+	}
+	if _, err := c.QueryPlan(ctx, "Plan0000000009"); err == nil || err.Error() != "queryAsset failed: Could not locate asset" {
+		t.Errorf("QueryPlan of Plan0000000009 returned %v", err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.QueryCrude(cancelled, crudeID); err != context.Canceled {
+		t.Errorf("QueryCrude with a cancelled context returned %v", err)
+	}
+}
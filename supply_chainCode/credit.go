@@ -0,0 +1,119 @@
+/*
+Overdraft protection.
+
+The balance of an org account may not go below minus its credit limit, 0 unless an admin sets one.
+Every payment (deliveries, compensations of disputes) is checked after all the payments of its tx,
+and if a payer doesn't have the funds the whole tx fails and nothing is put in db.
+The capital accounts the opening balances come from have no limit.
+The value of a private asset is checked the same way on the peers of its pair, together with what its buyer
+has locked in the other private escrows of the pair (see privacy.go). Those locks aren't public, so
+queryAvailableFunds doesn't count them.
+
+Errors a client can act on start with an error code, e.g.
+
+	INSUFFICIENT_FUNDS: org5 is 10.50 EUR short of the funds for its payments (credit limit 0.00)
+
+API:
+
+setCreditLimit - admin only. args[0] = org, args[1] = credit limit, in the currency of its account
+queryAvailableFunds - args[0] = org. Balance + credit limit, and the funds locked in escrows.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"strings"
+)
+
+//error codes
+const (
+	ErrInsufficientFunds = "INSUFFICIENT_FUNDS"
+)
+
+/*
+An error with a code the client can check, returned as 'CODE: message'.
+*/
+type CodedError struct {
+	Code    string
+	Message string
+}
+
+func (e CodedError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func IsCapitalAccount(org string) bool {
+	return strings.HasPrefix(org, CapitalAccount)
+}
+
+//what the account can still pay
+func (acc Account) Available() Money {
+	return acc.Balance + acc.CreditLimit
+}
+
+/*
+Checks that the balance of acc, after the payments of the tx, is within its credit limit.
+*/
+func CheckFunds(acc Account) error {
+	if IsCapitalAccount(acc.Org) || acc.Available() >= 0 {
+		return nil
+	}
+	return CodedError{ErrInsufficientFunds, fmt.Sprintf("%s is %s %s short of the funds for its payments (credit limit %s)",
+		acc.Org, -acc.Available(), acc.Currency, acc.CreditLimit)}
+}
+
+/*
+args[0] = org, args[1] = credit limit (>= 0)
+A limit below what the org already owes doesn't change its balance, it only stops its payments.
+*/
+func (s *SmartContract) setCreditLimit(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) != 2 {
+		return shim.Error("Expecting 2 args")
+	}
+	if IsCapitalAccount(args[0]) || IsEscrowAccount(args[0]) {
+		return shim.Error("Capital and escrow accounts have no credit limit")
+	}
+	limit, err := ParseMoney(args[1])
+	if err != nil || limit < 0 {
+		return shim.Error("Credit limit should be a non negative amount of money with up to 2 decimals")
+	}
+	acc, err := GetAccountState(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	acc.CreditLimit = limit
+	if err = PutAccountState(stub, acc); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func (s *SmartContract) queryAvailableFunds(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 arg")
+	}
+	acc, err := GetAccountState(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	locked, err := GetLockedFunds(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	funds := struct {
+		Org         string
+		Currency    string
+		Balance     Money
+		CreditLimit Money
+		Available   Money
+		Locked      Money //in escrows, not part of Balance (see escrow.go)
+	}{acc.Org, acc.Currency, acc.Balance, acc.CreditLimit, acc.Available(), locked}
+	fundsAsBytes, _ := json.Marshal(funds)
+	return shim.Success(fundsAsBytes)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCreditLimit(t *testing.T) {
+	n := newTestNet(t)
+	fuelID := n.newFuel(n.newCrude())
+	n.as("Org1MSP").fails("Expecting 2 args", "setCreditLimit", "org5")
+	n.fails("Capital and escrow accounts have no credit limit", "setCreditLimit", CapitalAccount, "10")
+	n.fails("Capital and escrow accounts have no credit limit", "setCreditLimit", EscrowAccountOf("Crude0000000001"), "10")
+	n.fails("non negative amount", "setCreditLimit", "org5", "-1")
+	n.fails("Account of org9 doesn't exist", "setCreditLimit", "org9", "10")
+	n.as("Org5MSP").fails("Access denied", "setCreditLimit", "org5", "1000")
+
+	n.as("Org1MSP").ok("setCreditLimit", "org5", "1000")
+	n.as("Org3MSP").fails(ErrInsufficientFunds, "addFuelOrder", "101000.00", "20", "org3", "org5", fuelID, ordered)
+	orderID := n.as("Org3MSP").ok("addFuelOrder", "100990.00", "20", "org3", "org5", fuelID, ordered)
+	funds := struct {
+		Balance     Money
+		CreditLimit Money
+		Available   Money
+		Locked      Money
+	}{}
+	n.query(&funds, "queryAvailableFunds", "org5")
+	if funds.Balance != money("-992.00") || funds.Available != money("8.00") || funds.Locked != money("100992.00") {
+		t.Errorf("Funds of org5 are %+v", funds)
+	}
+	n.fails("Expecting 1 arg", "queryAvailableFunds")
+	n.fails("Account of org9 doesn't exist", "queryAvailableFunds", "org9")
+
+	//a lower limit only stops the payments of org5
+	n.as("Org1MSP").ok("setCreditLimit", "org5", "0")
+	n.as("Org3MSP").fails(ErrInsufficientFunds, "addFuelOrder", "1.00", "1", "org3", "org5", fuelID, ordered)
+	n.as("Org5MSP").ok("cancelOrder", orderID, "too expensive")
+	n.balances(map[string]Money{"org5": openingBalance})
+	n.checkBooks()
+}
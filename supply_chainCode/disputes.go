@@ -0,0 +1,415 @@
+/*
+Disputes on deliveries and payments.
+
+Any party on a Crude (driller, shipper, refiner) or a FuelOrder (refiner, distributor, retailer) can open a
+dispute on it with a reason code and the hash of its evidence, if its org is on the asset (Disputable.Orgs).
+The other orgs on the asset respond and the arbiter resolves it, optionally with a compensating payment between
+two org accounts. A delivery disputed before it was paid gets its escrow settled by the resolution: the
+compensation the buyer pays comes from the escrow and the rest of it is paid back (see escrow.go).
+While a dispute is open the asset is DISPUTED, so it can't be put in a plan or transferred.
+When it is resolved the asset gets back the state it had before.
+A delivery whose receipt is DISPUTED (see receipt.go) opens a dispute automatically.
+
+Disputes are put in db with key 'Dispute'+txID of the tx that opened them.
+
+API:
+
+openDispute - args[0] = assetID, args[1] = reason code, args[2] = evidence hash
+respondDispute - args[0] = DisputeID, args[1] = statement, args[2] = evidence hash
+resolveDispute - arbiter only. args[0] = DisputeID, args[1] = resolution, optionally args[2] = payer org,
+	args[3] = payee org, args[4] = amount of the compensating payment (in the currency of the asset)
+queryDisputes - args[0] = status, args[1] = org (MSP ID or org name). Empty strings match everything.
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"strings"
+	"time"
+)
+
+const (
+	DisputeOpen      = "OPEN"
+	DisputeResponded = "RESPONDED"
+	DisputeResolved  = "RESOLVED"
+)
+
+const (
+	ReasonShortDelivery = "SHORT_DELIVERY"
+	ReasonQuality       = "QUALITY"
+	ReasonLateDelivery  = "LATE_DELIVERY"
+	ReasonPayment       = "PAYMENT"
+	ReasonOther         = "OTHER"
+)
+
+type DisputeResponse struct {
+	MSP          string
+	Statement    string
+	EvidenceHash string
+	Timestamp    time.Time
+}
+
+type Dispute struct {
+	AssetID      string
+	Reason       string
+	EvidenceHash string
+	OpenedBy     string   //MSP ID
+	Orgs         []string //orgs on the asset
+	PrevState    string   //state of the asset before the dispute
+	Status       string
+	Responses    []DisputeResponse
+	Resolution   string
+	Compensation []Payment
+	Opened       time.Time
+	Resolved     time.Time
+}
+
+/*
+Crude and FuelOrder can be disputed.
+*/
+type Disputable interface {
+	Details() *AssetDetails
+	AddPayments(p ...Payment)
+	Orgs() []string
+}
+
+func (c *Crude) Details() *AssetDetails {
+	return &c.AD
+}
+
+func (c *Crude) AddPayments(p ...Payment) {
+	c.Payments = append(c.Payments, p...)
+}
+
+func (c *Crude) Orgs() []string {
+	orgs := []string{c.AD.Owner, c.DD.StartingLocation, c.DD.Destination}
+	for _, p := range c.Payments {
+		orgs = append(orgs, p.Payer, p.Payee)
+	}
+	return uniqueStrings(orgs)
+}
+
+func (o *FuelOrder) Details() *AssetDetails {
+	return &o.AD
+}
+
+func (o *FuelOrder) AddPayments(p ...Payment) {
+	o.Payments = append(o.Payments, p...)
+}
+
+func (o *FuelOrder) Orgs() []string {
+	orgs := []string{o.AD.Owner, o.Dest, o.Seller}
+	for _, p := range o.Payments {
+		orgs = append(orgs, p.Payer, p.Payee)
+	}
+	return uniqueStrings(orgs)
+}
+
+func IsReason(reason string) bool {
+	switch reason {
+	case ReasonShortDelivery, ReasonQuality, ReasonLateDelivery, ReasonPayment, ReasonOther:
+		return true
+	}
+	return false
+}
+
+func DisputeKey(id string) string {
+	return "Dispute" + id
+}
+
+//roles of the parties on an asset
+func partyRoles(assetID string) []string {
+	if strings.HasPrefix(assetID, "FuelOrder") {
+		return []string{RoleRefiner, RoleDistributor, RoleRetailer}
+	}
+	return []string{RoleDriller, RoleShipper, RoleRefiner}
+}
+
+func GetDisputable(stub shim.ChaincodeStubInterface, id string) (Disputable, error) {
+	var asset Disputable
+	switch {
+	case strings.HasPrefix(id, "FuelOrder"):
+		asset = &FuelOrder{}
+	case strings.HasPrefix(id, "Crude"):
+		asset = &Crude{}
+	default:
+		return nil, errors.New("Only a Crude or a FuelOrder can be disputed")
+	}
+	assetAsBytes, _ := GetAsset(stub, id)
+	if assetAsBytes == nil {
+		return nil, errors.New("Could not locate Asset")
+	}
+	if err := json.Unmarshal(assetAsBytes, asset); err != nil {
+		return nil, fmt.Errorf("%s is corrupted", id)
+	}
+	return asset, nil
+}
+
+func PutDisputable(stub shim.ChaincodeStubInterface, id string, asset Disputable) error {
+	assetAsBytes, _ := json.Marshal(asset)
+	if err := PutAsset(stub, id, assetAsBytes); err != nil {
+		return fmt.Errorf("Failed to put %s in db", id)
+	}
+	return nil
+}
+
+func GetDispute(stub shim.ChaincodeStubInterface, id string) (Dispute, error) {
+	dbytes, _ := stub.GetState(DisputeKey(id))
+	if dbytes == nil {
+		return Dispute{}, errors.New("Could not locate dispute")
+	}
+	d := Dispute{}
+	if err := json.Unmarshal(dbytes, &d); err != nil {
+		return Dispute{}, errors.New("Dispute is corrupted")
+	}
+	return d, nil
+}
+
+func PutDispute(stub shim.ChaincodeStubInterface, id string, d Dispute) error {
+	dbytes, _ := json.Marshal(d)
+	if err := stub.PutState(DisputeKey(id), dbytes); err != nil {
+		return fmt.Errorf("Failed to put dispute %s in db", id)
+	}
+	return nil
+}
+
+/*
+Opens a dispute on asset and makes it DISPUTED. prevState is the state it gets back on resolution.
+The asset should be put in db by the caller. Returns the ID of the dispute.
+*/
+func OpenDispute(stub shim.ChaincodeStubInterface, assetID string, asset Disputable, prevState, reason, evidence string) (string, error) {
+	msp, err := getCallerMSPID(stub)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get MSP ID of caller: %s", err)
+	}
+	opened, err := TxTime(stub)
+	if err != nil {
+		return "", err
+	}
+	id := stub.GetTxID()
+	d := Dispute{assetID, reason, evidence, msp, asset.Orgs(), prevState, DisputeOpen, []DisputeResponse{}, "", []Payment{}, opened, time.Time{}}
+	if err = PutDispute(stub, id, d); err != nil {
+		return "", err
+	}
+	asset.Details().State = "DISPUTED"
+	Emit(stub, EventDisputeOpened, id, d)
+	return id, nil
+}
+
+/*
+args[0] = ID of a Crude or FuelOrder
+args[1] = reason code, one of {SHORT_DELIVERY,QUALITY,LATE_DELIVERY,PAYMENT,OTHER}
+args[2] = hash of the evidence kept off chain
+The ID of the new dispute is returned.
+*/
+func (s *SmartContract) openDispute(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if err := checkRole(stub, partyRoles(args[0])...); err != nil {
+		return shim.Error(err.Error())
+	}
+	if IsReason(args[1]) == false {
+		return shim.Error("Reason should be one of {SHORT_DELIVERY,QUALITY,LATE_DELIVERY,PAYMENT,OTHER}")
+	}
+	if args[2] == "" {
+		return shim.Error("Evidence hash should not be empty")
+	}
+	asset, err := GetDisputable(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if callerIsOneOf(stub, asset.Orgs()...) == false {
+		return shim.Error(fmt.Sprintf("Access denied: only the orgs on %s can dispute it", args[0]))
+	}
+	prevState := asset.Details().State
+	if prevState == "DISPUTED" {
+		return shim.Error(fmt.Sprintf("%s is already disputed", args[0]))
+	}
+	id, err := OpenDispute(stub, args[0], asset, prevState, args[1], args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = PutDisputable(stub, args[0], asset); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(id))
+}
+
+/*
+args[0] = DisputeID
+args[1] = statement, args[2] = hash of the evidence
+Only the parties other than the one that opened the dispute can respond.
+*/
+func (s *SmartContract) respondDispute(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	d, err := GetDispute(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = checkRole(stub, partyRoles(d.AssetID)...); err != nil {
+		return shim.Error(err.Error())
+	}
+	if callerIsOneOf(stub, d.Orgs...) == false {
+		return shim.Error(fmt.Sprintf("Access denied: only the orgs on %s can respond to the dispute", d.AssetID))
+	}
+	if d.Status == DisputeResolved {
+		return shim.Error("Dispute is resolved")
+	}
+	msp, err := getCallerMSPID(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to get MSP ID of caller: %s", err))
+	}
+	if msp == d.OpenedBy {
+		return shim.Error("Only a counterparty can respond to the dispute")
+	}
+	tstamp, err := TxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	d.Responses = append(d.Responses, DisputeResponse{msp, args[1], args[2], tstamp})
+	d.Status = DisputeResponded
+	if err = PutDispute(stub, args[0], d); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/*
+args[0] = DisputeID, args[1] = resolution
+args[2] = payer org, args[3] = payee org, args[4] = amount (optional compensating payment)
+The payer and the payee are two different orgs on the disputed asset.
+*/
+func (s *SmartContract) resolveDispute(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleArbiter); err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) != 2 && len(args) != 5 {
+		return shim.Error("Expecting {DisputeID,resolution} and optionally {payer,payee,amount}")
+	}
+	d, err := GetDispute(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if d.Status == DisputeResolved {
+		return shim.Error("Dispute is already resolved")
+	}
+	asset, err := GetDisputable(stub, d.AssetID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	payments := []Payment{}
+	if len(args) == 5 {
+		if err = CheckOrgs(stub, args[2], args[3]); err != nil {
+			return shim.Error(fmt.Sprintf("Payer and payee should be active orgs: %s", err))
+		}
+		if containsString(d.Orgs, args[2]) == false || containsString(d.Orgs, args[3]) == false {
+			return shim.Error(fmt.Sprintf("Payer and payee should be orgs on %s: %s", d.AssetID, strings.Join(d.Orgs, ",")))
+		}
+		if args[2] == args[3] {
+			return shim.Error("Payer and payee should be different orgs")
+		}
+		amount, err := ParseMoney(args[4])
+		if err != nil || amount <= 0 {
+			return shim.Error("Amount should be a positive amount of money with up to 2 decimals")
+		}
+		compensation := Payment{args[2], args[3], amount, asset.Details().AssetCurrency(), ""}
+		payments = append(payments, compensation)
+		d.Compensation = append(d.Compensation, compensation)
+		asset.AddPayments(compensation)
+	}
+	//a delivery disputed on its receipt wasn't paid, so its escrow is settled now (see escrow.go)
+	//and the private escrow of its value goes back to the buyer, the arbiter's award being public
+	if d.PrevState == "DELIVERED" {
+		err = SettleEscrow(stub, d.AssetID, "resolution of dispute "+args[0], payments)
+		if err == nil {
+			err = releasePrivateEscrow(stub, d.AssetID, "resolution of dispute "+args[0])
+		}
+	} else if len(payments) > 0 {
+		_, err = Post(stub, d.AssetID, "compensation of dispute "+args[0], payments...)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	d.Resolved, err = TxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	d.Resolution = args[1]
+	d.Status = DisputeResolved
+	asset.Details().State = d.PrevState
+	if err = PutDispute(stub, args[0], d); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = PutDisputable(stub, d.AssetID, asset); err != nil {
+		return shim.Error(err.Error())
+	}
+	Emit(stub, EventDisputeResolved, args[0], d)
+	return shim.Success(nil)
+}
+
+/*
+args[0] = status or "" , args[1] = MSP ID or org name (e.g. 'org5') or ""
+An org matches a dispute it opened or a dispute on an asset it is on.
+*/
+func (s *SmartContract) queryDisputes(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Expecting 2 args")
+	}
+	status, org := args[0], args[1]
+	resultsIterator, err := stub.GetStateByRange("Dispute", "Dispute~")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	type disputeRecord struct {
+		Key    string
+		Record Dispute
+	}
+	disputes := []disputeRecord{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		d := Dispute{}
+		if err = json.Unmarshal(kv.Value, &d); err != nil {
+			return shim.Error(fmt.Sprintf("Failed to decode %s", kv.Key))
+		}
+		if status != "" && d.Status != status {
+			continue
+		}
+		if org != "" && d.OpenedBy != org && containsString(d.Orgs, org) == false {
+			continue
+		}
+		disputes = append(disputes, disputeRecord{strings.TrimPrefix(kv.Key, "Dispute"), d})
+	}
+	disputesAsBytes, _ := json.Marshal(disputes)
+	return shim.Success(disputesAsBytes)
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func uniqueStrings(ss []string) []string {
+	unique := []string{}
+	for _, s := range ss {
+		if s != "" && containsString(unique, s) == false {
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
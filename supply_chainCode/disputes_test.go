@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+)
+
+type disputeRecord struct {
+	Key    string
+	Record Dispute
+}
+
+func TestDispute(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.newCrude()
+	n.as("Org3MSP").ok("transfer", crudeID, "org3", crudeEst)
+
+	disputeID := n.ok("openDispute", crudeID, ReasonShortDelivery, "9f86d081884c7d65")
+	if state := n.state(crudeID); state != "DISPUTED" {
+		t.Fatalf("%s is %s after a dispute was opened", crudeID, state)
+	}
+	if types := n.eventTypes(); len(types) != 1 || types[0] != EventDisputeOpened {
+		t.Errorf("Events of openDispute are %v", types)
+	}
+	n.fails("is already disputed", "openDispute", crudeID, ReasonQuality, "9f86d081884c7d65")
+	n.fails("Only a counterparty can respond", "respondDispute", disputeID, "we measured 100", "e3b0c442")
+	n.as("Org5MSP").fails("Access denied", "respondDispute", disputeID, "we measured 100", "e3b0c442")
+	n.as("Org1MSP").ok("respondDispute", disputeID, "we measured 100", "e3b0c442")
+
+	var disputes []disputeRecord
+	n.query(&disputes, "queryDisputes", DisputeResponded, "")
+	if len(disputes) != 1 || disputes[0].Key != disputeID || len(disputes[0].Record.Responses) != 1 {
+		t.Fatalf("Responded disputes are %+v", disputes)
+	}
+	if d := disputes[0].Record; d.OpenedBy != "Org3MSP" || d.PrevState != "DELIVERED" {
+		t.Errorf("Dispute %s is %+v", disputeID, d)
+	}
+
+	//the driller pays back 50.00
+	n.as("Org3MSP").fails("Access denied", "resolveDispute", disputeID, "short by 5")
+	n.as("Org1MSP").ok("resolveDispute", disputeID, "short by 5", "org1", "org3", "50.00")
+	if state := n.state(crudeID); state != "DELIVERED" {
+		t.Errorf("%s is %s after its dispute was resolved", crudeID, state)
+	}
+	n.balances(map[string]Money{
+		"org1": openingBalance + money("1000.00") - money("50.00"),
+		"org3": openingBalance - money("1010.00") + money("50.00"),
+	})
+	crude := Crude{}
+	n.asset(crudeID, &crude)
+	if p := crude.Payments[len(crude.Payments)-1]; p.Payer != "org1" || p.Payee != "org3" || p.Amount != money("50.00") {
+		t.Errorf("Last payment of %s is %+v", crudeID, p)
+	}
+	n.fails("Dispute is already resolved", "resolveDispute", disputeID, "again")
+	n.as("Org3MSP").fails("Dispute is resolved", "respondDispute", disputeID, "ok", "e3b0c442")
+
+	//a new dispute on the same asset
+	n.as("Org1MSP").ok("openDispute", crudeID, ReasonPayment, "2c26b46b")
+	n.query(&disputes, "queryDisputes", "", "org3")
+	if len(disputes) != 2 {
+		t.Errorf("org3 has %d disputes, expecting 2", len(disputes))
+	}
+	n.query(&disputes, "queryDisputes", DisputeOpen, "Org1MSP")
+	if len(disputes) != 1 || disputes[0].Record.Reason != ReasonPayment {
+		t.Errorf("Open disputes of Org1MSP are %+v", disputes)
+	}
+	n.query(&disputes, "queryDisputes", "", "org5")
+	if len(disputes) != 0 {
+		t.Errorf("org5 has %d disputes", len(disputes))
+	}
+	n.checkBooks()
+}
+
+func TestDisputedOrder(t *testing.T) {
+	n := newTestNet(t)
+	orderID := n.newFuelOrder(n.newFuel(n.newCrude()), "org5")
+	//another station can't dispute the order of org5, nor respond to its dispute
+	n.as("Org6MSP").fails("Access denied: only the orgs on "+orderID+" can dispute it", "openDispute", orderID, ReasonLateDelivery, "2c26b46b")
+	disputeID := n.as("Org5MSP").ok("openDispute", orderID, ReasonLateDelivery, "2c26b46b")
+	n.as("Org6MSP").fails("Access denied: only the orgs on "+orderID+" can respond", "respondDispute", disputeID, "not late", "e3b0c442")
+	n.as("Org3MSP").ok("respondDispute", disputeID, "not late", "e3b0c442")
+	//a disputed order can't be put in a plan
+	n.as("Org4MSP").ok("registerVehicle", "Truck", "Truck1", "100")
+	n.fails("FuelOrderID FuelOrder0000000001 is DISPUTED", "deliverFuel", "Truck1", orderID, fuelEst, "org3", "org5")
+	n.as("Org1MSP").ok("resolveDispute", disputeID, "no delay yet")
+	if state := n.state(orderID); state != "READY_FOR_DISTRIBUTION" {
+		t.Fatalf("%s is %s after its dispute was resolved", orderID, state)
+	}
+	n.as("Org4MSP").ok("deliverFuel", "Truck1", orderID, fuelEst, "org3", "org5")
+}
+
+func TestDisputeErrors(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.newCrude()
+	fuelID := n.newFuel(crudeID)
+	n.as("Org3MSP")
+	n.fails("Expecting 3", "openDispute", crudeID, ReasonQuality)
+	n.fails("Reason should be one of", "openDispute", crudeID, "LATE", "2c26b46b")
+	n.fails("Evidence hash should not be empty", "openDispute", crudeID, ReasonQuality, "")
+	n.fails("Only a Crude or a FuelOrder can be disputed", "openDispute", fuelID, ReasonQuality, "2c26b46b")
+	n.fails("Could not locate Asset", "openDispute", "Crude0000000009", ReasonQuality, "2c26b46b")
+	n.as("Org5MSP").fails("Access denied", "openDispute", crudeID, ReasonQuality, "2c26b46b")
+
+	disputeID := n.as("Org3MSP").ok("openDispute", crudeID, ReasonQuality, "2c26b46b")
+	//a crude on its way that is disputed can't be transferred
+	n.fails("state is not ON_WAY", "transfer", crudeID, "org3", crudeEst)
+
+	n.fails("Expecting 3", "respondDispute", disputeID, "ok")
+	n.fails("Could not locate dispute", "respondDispute", "tx999", "ok", "2c26b46b")
+	n.as("Org1MSP")
+	n.fails("Expecting {DisputeID,resolution}", "resolveDispute", disputeID, "ok", "org1")
+	n.fails("Could not locate dispute", "resolveDispute", "tx999", "ok")
+	n.fails("Payer and payee should be active orgs", "resolveDispute", disputeID, "ok", "org9", "org3", "1.00")
+	n.fails("Amount should be a positive amount", "resolveDispute", disputeID, "ok", "org1", "org3", "0")
+	n.fails("Payer and payee should be different orgs", "resolveDispute", disputeID, "ok", "org1", "org1", "1.00")
+	n.fails("Payer and payee should be orgs on Crude0000000001", "resolveDispute", disputeID, "ok", "org1", "org5", "1.00")
+	n.fails("Payer and payee should be orgs on Crude0000000001", "resolveDispute", disputeID, "ok", "org6", "org3", "1.00")
+	n.fails(ErrInsufficientFunds, "resolveDispute", disputeID, "ok", "org1", "org3", "200000.00")
+	n.fails("Expecting 2 args", "queryDisputes", DisputeOpen)
+
+	//the failed txs left the dispute open
+	var disputes []disputeRecord
+	n.query(&disputes, "queryDisputes", DisputeOpen, "")
+	if len(disputes) != 1 {
+		t.Errorf("%d disputes are open, expecting 1", len(disputes))
+	}
+}
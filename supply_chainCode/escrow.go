@@ -0,0 +1,373 @@
+/*
+Escrow of the payments for a delivery.
+
+When a Crude or a FuelOrder is created the buyer (DD.Destination of a Crude, Dest of a FuelOrder) pays
+its value plus the estimated transport fee into the escrow account of the asset, 'escrow.'+assetID,
+so the supplier ships with a guarantee of payment. A buyer without the funds can't be shipped to (see credit.go).
+When the asset is transferred the supplier and the carrier are paid from the escrow account and what is left
+is paid back to the buyer. If the payments are more than what is held the buyer pays the rest.
+A delivery that was disputed on its receipt pays nothing, so its escrow is settled when the dispute is resolved:
+the compensation the buyer pays comes from the escrow and the rest goes back to the buyer (see disputes.go).
+An escrow that isn't released by the time it expires (escrow period after the asset was created)
+can be refunded to the buyer, which cancels the asset if it isn't cancelled yet.
+
+Escrows are put in db with key 'Escrow'+assetID, and the LOCKED ones are indexed by buyer under
+(escrow~buyer~id, buyer, assetID) so that the locked funds of an org are a partial key query.
+Assets created before escrows have none and are paid by their new owner at transfer, as before.
+The value of an asset with private terms is escrowed in the collection of the pair, so only its fee is escrowed here (see privacy.go).
+
+API:
+
+queryEscrow - args[0] = assetID
+refundEscrow - args[0] = assetID. By the buyer once the escrow expired, or by an admin. Cancels the asset.
+setEscrowPeriod - admin only. args[0] = period in hours
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EscrowLocked   = "LOCKED"
+	EscrowReleased = "RELEASED"
+	EscrowRefunded = "REFUNDED"
+)
+
+const (
+	escrowAccountPrefix = "escrow."
+	escrowPeriodKey     = "escrowPeriod"
+	IndexEscrowBuyer    = "escrow~buyer~id"
+)
+
+//period used until an admin sets one
+const DefaultEscrowPeriod = 7 * 24 * time.Hour
+
+/*
+Amount is in the currency of the asset, Debited what it cost the buyer in the currency of its account.
+*/
+type Escrow struct {
+	AssetID  string
+	Buyer    string
+	Amount   Money
+	Currency string
+	Debited  Money
+	Locked   time.Time
+	Expires  time.Time
+	Closed   time.Time
+	Status   string
+}
+
+func EscrowKey(assetID string) string {
+	return "Escrow" + assetID
+}
+
+func EscrowAccountOf(assetID string) string {
+	return escrowAccountPrefix + assetID
+}
+
+func IsEscrowAccount(org string) bool {
+	return strings.HasPrefix(org, escrowAccountPrefix)
+}
+
+//buyer of a new Crude or FuelOrder
+func (c *Crude) Buyer() string {
+	return c.DD.Destination
+}
+
+func (o *FuelOrder) Buyer() string {
+	return o.Dest
+}
+
+func GetEscrowPeriod(stub shim.ChaincodeStubInterface) (time.Duration, error) {
+	pbytes, err := stub.GetState(escrowPeriodKey)
+	if err != nil {
+		return 0, errors.New("Failed to read the escrow period")
+	}
+	if pbytes == nil {
+		return DefaultEscrowPeriod, nil
+	}
+	var period time.Duration
+	if err = json.Unmarshal(pbytes, &period); err != nil {
+		return 0, errors.New("Escrow period is corrupted")
+	}
+	return period, nil
+}
+
+/*
+Returns the escrow of the asset or nil if it has none.
+*/
+func GetEscrow(stub shim.ChaincodeStubInterface, assetID string) (*Escrow, error) {
+	ebytes, err := stub.GetState(EscrowKey(assetID))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read escrow of %s", assetID)
+	}
+	if ebytes == nil {
+		return nil, nil
+	}
+	e := Escrow{}
+	if err = json.Unmarshal(ebytes, &e); err != nil {
+		return nil, fmt.Errorf("Escrow of %s is corrupted", assetID)
+	}
+	return &e, nil
+}
+
+//puts the escrow in db and keeps it in the index of its buyer only while it is LOCKED.
+func PutEscrow(stub shim.ChaincodeStubInterface, e Escrow) error {
+	ebytes, _ := json.Marshal(e)
+	if err := stub.PutState(EscrowKey(e.AssetID), ebytes); err != nil {
+		return fmt.Errorf("Failed to put escrow of %s in db", e.AssetID)
+	}
+	entry, err := stub.CreateCompositeKey(IndexEscrowBuyer, []string{e.Buyer, e.AssetID})
+	if err != nil {
+		return fmt.Errorf("Failed to create index entry of escrow of %s", e.AssetID)
+	}
+	if e.Status == EscrowLocked {
+		err = stub.PutState(entry, []byte{0x00})
+	} else {
+		err = stub.DelState(entry)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to update index entry of escrow of %s", e.AssetID)
+	}
+	return nil
+}
+
+/*
+Locks what the buyer will pay for a new asset (its value and the transport fee of its quantity) in the
+escrow account of the asset. fee is the fee of the carrier by the tariff of the delivery, without penalties
+or bonuses, as the time of delivery isn't known yet.
+*/
+func LockEscrow(stub shim.ChaincodeStubInterface, assetID, buyer string, ad AssetDetails, fee Money) (Escrow, error) {
+	tstamp, err := TxTime(stub)
+	if err != nil {
+		return Escrow{}, err
+	}
+	period, err := GetEscrowPeriod(stub)
+	if err != nil {
+		return Escrow{}, err
+	}
+	buyerAcc, err := GetAccountState(stub, buyer)
+	if err != nil {
+		return Escrow{}, err
+	}
+	//the escrow account holds the currency of the asset, so what it pays out needs no conversion
+	escrowAcc := NewAccount(EscrowAccountOf(assetID))
+	escrowAcc.Currency = ad.AssetCurrency()
+	accounts := map[string]*Account{buyer: &buyerAcc, escrowAcc.Org: &escrowAcc}
+	amount := ad.Value + fee
+	entries, err := postEntries(stub, accounts, assetID, "escrow of "+assetID,
+		[]Payment{{buyer, escrowAcc.Org, amount, ad.AssetCurrency(), ""}})
+	if err != nil {
+		return Escrow{}, err
+	}
+	e := Escrow{assetID, buyer, amount, ad.AssetCurrency(), entries[0].DebitAmount, tstamp, tstamp.Add(period), time.Time{}, EscrowLocked}
+	if err = PutEscrow(stub, e); err != nil {
+		return Escrow{}, err
+	}
+	Emit(stub, EventEscrowLocked, assetID, e)
+	return e, nil
+}
+
+/*
+Pays what the buyer owes from the escrow account, as far as it goes, and pays the rest of the escrow back
+to the buyer. Returns the payments to post instead of payments.
+*/
+func (e *Escrow) release(payments []Payment, tstamp time.Time) []Payment {
+	held := e.Amount
+	released := []Payment{}
+	for _, p := range payments {
+		if p.Payer != e.Buyer || p.Currency != e.Currency {
+			released = append(released, p)
+			continue
+		}
+		fromEscrow := p.Amount
+		if fromEscrow > held {
+			fromEscrow = held
+		}
+		held -= fromEscrow
+		released = append(released, Payment{EscrowAccountOf(e.AssetID), p.Payee, fromEscrow, e.Currency, p.Rule})
+		if rest := p.Amount - fromEscrow; rest > 0 {
+			released = append(released, Payment{p.Payer, p.Payee, rest, p.Currency, p.Rule})
+		}
+	}
+	if held > 0 {
+		released = append(released, Payment{EscrowAccountOf(e.AssetID), e.Buyer, held, e.Currency, ""})
+	}
+	e.Status = EscrowReleased
+	e.Closed = tstamp
+	return released
+}
+
+/*
+Posts the payments for the delivery of an asset. What the buyer owes is paid from the escrow of the asset if it
+is still LOCKED, which is then released (see release). Without payments a LOCKED escrow is paid back.
+*/
+func SettleEscrow(stub shim.ChaincodeStubInterface, id, memo string, payments []Payment) error {
+	escrow, err := GetEscrow(stub, id)
+	if err != nil {
+		return err
+	}
+	if escrow != nil && escrow.Status == EscrowLocked {
+		tstamp, err := TxTime(stub)
+		if err != nil {
+			return err
+		}
+		payments = escrow.release(payments, tstamp)
+		if err = PutEscrow(stub, *escrow); err != nil {
+			return err
+		}
+		Emit(stub, EventEscrowReleased, id, *escrow)
+	}
+	if len(payments) == 0 {
+		return nil
+	}
+	_, err = Post(stub, id, memo, payments...)
+	return err
+}
+
+/*
+Pays the escrow of the asset back to the buyer. memo says why, e.g. the order was cancelled.
+*/
+func RefundEscrow(stub shim.ChaincodeStubInterface, e Escrow, memo string) error {
+	if e.Status != EscrowLocked {
+		return fmt.Errorf("Escrow of %s is %s", e.AssetID, e.Status)
+	}
+	tstamp, err := TxTime(stub)
+	if err != nil {
+		return err
+	}
+	refund := Payment{EscrowAccountOf(e.AssetID), e.Buyer, e.Amount, e.Currency, ""}
+	if _, err = Post(stub, e.AssetID, memo, refund); err != nil {
+		return err
+	}
+	e.Status = EscrowRefunded
+	e.Closed = tstamp
+	if err = PutEscrow(stub, e); err != nil {
+		return err
+	}
+	Emit(stub, EventEscrowRefunded, e.AssetID, e)
+	return nil
+}
+
+/*
+What org has locked in escrows, in the currency of its account.
+*/
+func GetLockedFunds(stub shim.ChaincodeStubInterface, org string) (Money, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(IndexEscrowBuyer, []string{org})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+	var locked Money
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		_, attrs, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil || len(attrs) != 2 {
+			return 0, fmt.Errorf("Invalid entry of index %s", IndexEscrowBuyer)
+		}
+		e, err := GetEscrow(stub, attrs[1])
+		if err != nil {
+			return 0, err
+		}
+		if e == nil {
+			return 0, fmt.Errorf("Index %s points to escrow of %s which doesn't exist", IndexEscrowBuyer, attrs[1])
+		}
+		locked += e.Debited
+	}
+	return locked, nil
+}
+
+func (s *SmartContract) queryEscrow(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 arg")
+	}
+	e, err := GetEscrow(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if e == nil {
+		return shim.Error(fmt.Sprintf("%s has no escrow", args[0]))
+	}
+	ebytes, _ := json.Marshal(e)
+	return shim.Success(ebytes)
+}
+
+/*
+args[0] = assetID
+The buyer can refund an expired escrow, an admin can refund it anytime. Only the escrow of a cancelled asset
+or of one that can still be cancelled is refunded, and the asset is cancelled with it (see amend.go), so it
+can't be delivered without its escrow.
+*/
+func (s *SmartContract) refundEscrow(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 arg")
+	}
+	e, err := GetEscrow(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if e == nil {
+		return shim.Error(fmt.Sprintf("%s has no escrow", args[0]))
+	}
+	tstamp, err := TxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if checkRole(stub, RoleAdmin) != nil {
+		if callerIsOrg(stub, e.Buyer) == false {
+			return shim.Error(fmt.Sprintf("Access denied: only %s, the buyer of %s, or an admin can refund its escrow", e.Buyer, e.AssetID))
+		}
+		if tstamp.Before(e.Expires) {
+			return shim.Error(fmt.Sprintf("Escrow of %s expires at %s", e.AssetID, e.Expires.Format(time.RFC3339)))
+		}
+	}
+	if e.Status != EscrowLocked {
+		return shim.Error(fmt.Sprintf("Escrow of %s is %s", e.AssetID, e.Status))
+	}
+	cancelled, err := assetCancelled(stub, e.AssetID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if cancelled {
+		err = RefundEscrow(stub, *e, "refund of escrow of "+e.AssetID)
+	} else {
+		//cancelling the asset refunds its escrow
+		err = cancelAsset(stub, e.AssetID, "refund of escrow of "+e.AssetID)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/*
+args[0] = escrow period in hours, an int > 0
+*/
+func (s *SmartContract) setEscrowPeriod(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 arg")
+	}
+	hours, err := strconv.ParseInt(args[0], 10, 32)
+	if err != nil || hours <= 0 {
+		return shim.Error("Escrow period should be an int number of hours > 0")
+	}
+	pbytes, _ := json.Marshal(time.Duration(hours) * time.Hour)
+	if err = stub.PutState(escrowPeriodKey, pbytes); err != nil {
+		return shim.Error("Failed to put escrow period in db")
+	}
+	return shim.Success(nil)
+}
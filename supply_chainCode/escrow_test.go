@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+//makes the escrow of assetID expire, as if it was locked a while ago
+func (n *testNet) expireEscrow(assetID string) {
+	n.t.Helper()
+	e, err := GetEscrow(n.stub, assetID)
+	if err != nil || e == nil {
+		n.t.Fatalf("%s has no escrow", assetID)
+	}
+	e.Expires = time.Now().Add(-time.Hour).UTC()
+	n.stub.MockTransactionStart("expire")
+	PutEscrow(n.stub, *e)
+	n.stub.MockTransactionEnd("expire")
+}
+
+func TestEscrow(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.newCrude()
+	e := Escrow{}
+	n.query(&e, "queryEscrow", crudeID)
+	if e.Buyer != "org3" || e.Amount != money("1010.00") || e.Status != EscrowLocked {
+		t.Errorf("Escrow of %s is %+v", crudeID, e)
+	}
+	if period := e.Expires.Sub(e.Locked); period != DefaultEscrowPeriod {
+		t.Errorf("Escrow of %s expires after %s", crudeID, period)
+	}
+	n.fails("Crude0000000009 has no escrow", "queryEscrow", "Crude0000000009")
+	n.fails("Expecting 1 arg", "queryEscrow")
+
+	//the buyer has to wait for the escrow to expire, and no one else can refund it
+	n.as("Org3MSP").fails("expires at", "refundEscrow", crudeID)
+	n.expireEscrow(crudeID)
+	n.as("Org5MSP").fails("Access denied", "refundEscrow", crudeID)
+	n.as("Org2MSP").fails("Access denied", "refundEscrow", crudeID)
+	n.as("Org3MSP").ok("refundEscrow", crudeID)
+	n.balances(map[string]Money{"org3": openingBalance, EscrowAccountOf(crudeID): 0})
+	//the crude is cancelled with it, so it can't be delivered without its escrow
+	if state := n.state(crudeID); state != StateCancelled {
+		t.Errorf("%s is %s after the refund of its escrow", crudeID, state)
+	}
+	n.fails("Cannot transfer asset", "transfer", crudeID, "org3", crudeEst)
+	n.fails("Escrow of Crude0000000001 is REFUNDED", "refundEscrow", crudeID)
+	n.fails("Crude0000000009 has no escrow", "refundEscrow", "Crude0000000009")
+	n.fails("Expecting 1 arg", "refundEscrow")
+	n.checkBooks()
+}
+
+func TestEscrowRefundOfDelivered(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.newCrude()
+	fuelID := n.newFuel(crudeID)
+	//a crude is refined once delivered, so its escrow was released
+	n.expireEscrow(crudeID)
+	n.as("Org3MSP").fails("Escrow of Crude0000000001 is RELEASED", "refundEscrow", crudeID)
+	n.as("Org1MSP").fails("Escrow of Crude0000000001 is RELEASED", "refundEscrow", crudeID)
+	//nor can the escrow of an order in a plan
+	orderID := n.newFuelOrder(fuelID, "org5")
+	n.newPlan(orderID)
+	n.as("Org1MSP").fails("Remove it from its plan first", "refundEscrow", orderID)
+	n.checkBooks()
+}
+
+func TestEscrowRefundByAdmin(t *testing.T) {
+	n := newTestNet(t)
+	orderID := n.newFuelOrder(n.newFuel(n.newCrude()), "org5")
+	n.as("Org1MSP").ok("refundEscrow", orderID)
+	n.balances(map[string]Money{"org5": openingBalance})
+	if types := n.eventTypes(); containsString(types, EventEscrowRefunded) == false || containsString(types, EventOrderCancelled) == false {
+		t.Errorf("Events of the refund are %v", types)
+	}
+	if state := n.state(orderID); state != StateCancelled {
+		t.Errorf("%s is %s after the refund of its escrow", orderID, state)
+	}
+	funds := struct{ Locked Money }{}
+	n.query(&funds, "queryAvailableFunds", "org5")
+	if funds.Locked != 0 {
+		t.Errorf("org5 has %s locked after the refund", funds.Locked)
+	}
+}
+
+func TestEscrowPeriod(t *testing.T) {
+	n := newTestNet(t)
+	n.fails("Expecting 1 arg", "setEscrowPeriod")
+	n.fails("int number of hours > 0", "setEscrowPeriod", "0")
+	n.fails("int number of hours > 0", "setEscrowPeriod", "1.5")
+	n.as("Org3MSP").fails("Access denied", "setEscrowPeriod", "48")
+	n.as("Org1MSP").ok("setEscrowPeriod", "48")
+	crudeID := n.newCrude()
+	e := Escrow{}
+	n.query(&e, "queryEscrow", crudeID)
+	if period := e.Expires.Sub(e.Locked); period != 48*time.Hour {
+		t.Errorf("Escrow of %s expires after %s", crudeID, period)
+	}
+}
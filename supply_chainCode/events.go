@@ -0,0 +1,111 @@
+/*
+Chaincode events.
+
+Every tx that changes an asset or an account emits typed events, so apps can subscribe to the
+contract instead of parsing blocks.
+Fabric keeps only one chaincode event per tx, so the events of a tx are collected while it runs and
+emitted together when it succeeds. The chaincode event is named after the last (main) event of the tx,
+e.g. a transfer of a FuelOrder is named 'FuelOrderDelivered' and also carries its 'PaymentSettled' events.
+
+Payload (EventsVersion 1):
+
+{
+	"Version": 1,
+	"TxID": "...",
+	"Timestamp": "2019-01-02T15:04:05Z",
+	"Events": [
+		{"Type": "PaymentSettled", "Key": "org5", "Data": {"Payer": "org5", "Payee": "org4", "Amount": "10.00", "Currency": "EUR"}},
+		{"Type": "FuelOrderDelivered", "Key": "FuelOrder0000000003", "Data": {...FuelOrder...}}
+	]
+}
+
+Key is the asset, plan, dispute or account the event is about and Data is its state after the tx
+(or the payment for PaymentSettled).
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"time"
+)
+
+const EventsVersion = 1
+
+const (
+	EventLedgerInitialized   = "LedgerInitialized"
+	EventCrudeDispatched     = "CrudeDispatched"
+	EventFuelRefined         = "FuelRefined"
+	EventFuelOrderAdded      = "FuelOrderAdded"
+	EventDeliveryPlanCreated = "DeliveryPlanCreated"
+	EventCrudeDelivered      = "CrudeDelivered"
+	EventFuelOrderDelivered  = "FuelOrderDelivered"
+	EventPaymentSettled      = "PaymentSettled"
+	EventDisputeOpened       = "DisputeOpened"
+	EventDisputeResolved     = "DisputeResolved"
+	EventEscrowLocked        = "EscrowLocked"
+	EventEscrowReleased      = "EscrowReleased"
+	EventEscrowRefunded      = "EscrowRefunded"
+	EventOrderCancelled      = "OrderCancelled"
+	EventPlanAmended         = "PlanAmended"
+	EventPlanCancelled       = "PlanCancelled"
+	EventOrgRegistered       = "OrgRegistered"
+	EventOrgUpdated          = "OrgUpdated"
+)
+
+type Event struct {
+	Type string
+	Key  string
+	Data interface{}
+}
+
+type EventsPayload struct {
+	Version   int
+	TxID      string
+	Timestamp time.Time
+	Events    []Event
+}
+
+/*
+The stub every tx runs with. It collects the events of the tx until Flush.
+*/
+type EventStub struct {
+	shim.ChaincodeStubInterface
+	events []Event
+}
+
+func NewEventStub(stub shim.ChaincodeStubInterface) *EventStub {
+	return &EventStub{stub, nil}
+}
+
+/*
+Adds an event to the tx. Does nothing if the stub doesn't collect events (e.g. Init).
+*/
+func Emit(stub shim.ChaincodeStubInterface, typ, key string, data interface{}) {
+	if es, ok := stub.(*EventStub); ok {
+		es.events = append(es.events, Event{typ, key, data})
+	}
+}
+
+/*
+Sets the collected events as the chaincode event of the tx.
+*/
+func (es *EventStub) Flush() error {
+	if len(es.events) == 0 {
+		return nil
+	}
+	tstamp, err := TxTime(es)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(EventsPayload{EventsVersion, es.GetTxID(), tstamp, es.events})
+	if err != nil {
+		return errors.New("Failed to encode events")
+	}
+	if err = es.SetEvent(es.events[len(es.events)-1].Type, payload); err != nil {
+		return errors.New("Failed to set event")
+	}
+	es.events = nil
+	return nil
+}
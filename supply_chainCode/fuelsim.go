@@ -0,0 +1,157 @@
+//go:build fuelsim
+// +build fuelsim
+
+/*
+fuelsim, the offline simulator of the supply chain (see sim.go). Built from this directory with
+
+	go build -tags fuelsim -o fuelsim .
+
+it runs the chaincode in memory, with no network, and reports the metrics of the run:
+
+	./fuelsim -days 90 -trucks 4 -late-rate 0.3 -tariffs tariffs.json
+	./fuelsim -seed 7 -fraud-rate 0.1 -json
+
+-tariffs is a file with a JSON array of tariffs, as setTariff takes them. Without the tag this package is
+the chaincode (see main.go).
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+func main() {
+	cfg := DefaultSimConfig
+	start := cfg.Start.Format("2006-01-02")
+	crudePrice, fuelPrice := cfg.CrudePrice.String(), cfg.FuelPrice.String()
+	var tariffs string
+	var asJSON bool
+	flag.IntVar(&cfg.Days, "days", cfg.Days, "days to simulate")
+	flag.Int64Var(&cfg.Seed, "seed", cfg.Seed, "seed of the random traffic")
+	flag.StringVar(&start, "start", start, "first day, YYYY-MM-DD")
+	flag.IntVar(&cfg.Stations, "stations", cfg.Stations, "fuel stations")
+	flag.Float64Var(&cfg.VesselsPerDay, "vessels", cfg.VesselsPerDay, "vessels of crude a day, on average")
+	flag.IntVar(&cfg.VesselQuantity, "vessel-quantity", cfg.VesselQuantity, "crude a vessel brings")
+	flag.StringVar(&crudePrice, "crude-price", crudePrice, "price of crude per unit")
+	flag.StringVar(&fuelPrice, "fuel-price", fuelPrice, "price of fuel per unit")
+	flag.Float64Var(&cfg.OrderRate, "order-rate", cfg.OrderRate, "probability that a station orders on a day")
+	flag.IntVar(&cfg.MinOrder, "min-order", cfg.MinOrder, "smallest order of a station")
+	flag.IntVar(&cfg.MaxOrder, "max-order", cfg.MaxOrder, "largest order of a station")
+	flag.IntVar(&cfg.Trucks, "trucks", cfg.Trucks, "trucks of the distributor")
+	flag.IntVar(&cfg.TruckCapacity, "truck-capacity", cfg.TruckCapacity, "fuel a truck carries")
+	flag.DurationVar(&cfg.StopInterval, "stop-interval", cfg.StopInterval, "time between the stops of a trip")
+	flag.Float64Var(&cfg.LateRate, "late-rate", cfg.LateRate, "probability that a delivery is late")
+	flag.DurationVar(&cfg.MaxDelay, "max-delay", cfg.MaxDelay, "longest delay of a late delivery")
+	flag.Float64Var(&cfg.FraudRate, "fraud-rate", cfg.FraudRate, "probability that the carrier shorts a delivery")
+	flag.Float64Var(&cfg.MaxShortfall, "max-shortfall", cfg.MaxShortfall, "fraction of a delivery shorted at most")
+	flag.Float64Var(&cfg.Tolerances.Quantity, "quantity-tolerance", cfg.Tolerances.Quantity, "quantity tolerance of the receipts")
+	flag.Float64Var(&cfg.Tolerances.Density, "density-tolerance", cfg.Tolerances.Density, "density tolerance of the receipts")
+	flag.StringVar(&tariffs, "tariffs", "", "file with a JSON array of tariffs")
+	flag.BoolVar(&asJSON, "json", false, "print the metrics as JSON")
+	flag.Parse()
+
+	var err error
+	if cfg.Start, err = time.Parse("2006-01-02", start); err != nil {
+		fail("-start: %s", err)
+	}
+	if cfg.CrudePrice, err = ParseMoney(crudePrice); err != nil {
+		fail("-crude-price: %s", err)
+	}
+	if cfg.FuelPrice, err = ParseMoney(fuelPrice); err != nil {
+		fail("-fuel-price: %s", err)
+	}
+	if tariffs != "" {
+		if cfg.Tariffs, err = readTariffs(tariffs); err != nil {
+			fail("-tariffs: %s", err)
+		}
+	}
+
+	m, err := RunSimulation(cfg)
+	if err != nil {
+		fail("%s", err)
+	}
+	if asJSON {
+		out, _ := json.MarshalIndent(m, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	report(m)
+}
+
+func fail(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "fuelsim: "+format+"\n", a...)
+	os.Exit(1)
+}
+
+//the tariffs of a file, each as the JSON setTariff takes
+func readTariffs(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	tariffs := []string{}
+	for _, r := range raw {
+		tariffs = append(tariffs, string(r))
+	}
+	return tariffs, nil
+}
+
+func report(m SimMetrics) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Days\t%d\n", m.Days)
+	fmt.Fprintf(w, "Transactions\t%d (%d failed)\n", m.Txs, failed(m))
+	fmt.Fprintf(w, "Crude delivered\t%d\n", m.CrudeDelivered)
+	fmt.Fprintf(w, "Fuel refined\t%d\n", m.FuelRefined)
+	fmt.Fprintf(w, "Orders\t%d placed, %d unfilled, %d delivered, %d backlog\n",
+		m.OrdersPlaced, m.OrdersUnfilled, m.OrdersDelivered, m.Backlog)
+	fmt.Fprintf(w, "Fuel delivered\t%d (%.1f a day)\n", m.FuelDelivered, m.Throughput)
+	fmt.Fprintf(w, "Trips\t%d (load %.0f%%)\n", m.Trips, 100*m.TruckLoad)
+	fmt.Fprintf(w, "Deliveries\t%d (%d late, %.0fs late on average)\n", m.Deliveries, m.LateDeliveries, m.AvgDelay)
+	fmt.Fprintf(w, "Carrier pay\t%s (penalties %s, bonuses %s)\n", m.CarrierPay, m.PenaltiesPaid, m.BonusesPaid)
+	fmt.Fprintf(w, "Frauds\t%d attempted, %d detected, %d shorted undetected\n",
+		m.FraudAttempts, m.FraudsDetected, m.ShortfallUndetected)
+	w.Flush()
+
+	if len(m.FailedTxs) > 0 {
+		fmt.Println("\nFailed transactions:")
+		keys := []string{}
+		for k := range m.FailedTxs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "  %s\t%d\n", k, m.FailedTxs[k])
+		}
+		w.Flush()
+	}
+
+	fmt.Println("\nBalances:")
+	orgs := []string{}
+	for org := range m.Balances {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+	for _, org := range orgs {
+		fmt.Fprintf(w, "  %s\t%s\n", org, m.Balances[org])
+	}
+	w.Flush()
+}
+
+func failed(m SimMetrics) int {
+	n := 0
+	for _, c := range m.FailedTxs {
+		n += c
+	}
+	return n
+}
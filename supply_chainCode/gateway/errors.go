@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"context"
+	"github.com/chaincode/supply_chainCode/client"
+	"net/http"
+	"regexp"
+)
+
+/*
+The chaincode fails a tx with the message of shim.Error only, so its errors are mapped to HTTP status codes
+by their message. The first rule that matches wins; a message no rule matches is a request the chaincode
+rejected (400). The rules aren't anchored to the start of the message, as the SDK or the peer may prefix it,
+e.g. 'chaincode error (status: 500, message: Access denied: ...)'.
+*/
+type StatusRule struct {
+	Pattern *regexp.Regexp
+	Status  int
+}
+
+var StatusRules = []StatusRule{
+	{regexp.MustCompile(`Access denied|Only a counterparty`), http.StatusForbidden},
+	{regexp.MustCompile(`INSUFFICIENT_FUNDS`), http.StatusConflict},
+	{regexp.MustCompile(`Could not locate|does not exist|doesn't exist|doens't exist|didn't exist|Invalid Smart Contract function name`), http.StatusNotFound},
+	{regexp.MustCompile(`not supported by this peer|Failed to get history`), http.StatusNotImplemented},
+	//the state of an asset, a plan or a dispute doesn't allow the tx
+	{regexp.MustCompile(` is [A-Z_]{4,}\b| is cancelled| is resolved| is deactivated|already|state is not|has been refined`), http.StatusConflict},
+	{regexp.MustCompile(`Failed to|is corrupted`), http.StatusInternalServerError},
+}
+
+/*
+HTTP status code of an error of a transport: the status of the message of the chaincode for a client.Error,
+504 if the request timed out and 502 if the backend couldn't be reached.
+*/
+func Status(err error) int {
+	switch err {
+	case context.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case context.Canceled:
+		return http.StatusServiceUnavailable
+	}
+	e, ok := err.(*client.Error)
+	if ok == false {
+		return http.StatusBadGateway
+	}
+	for _, rule := range StatusRules {
+		if rule.Pattern.MatchString(e.Message) {
+			return rule.Status
+		}
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,101 @@
+//go:build fabricsdk
+// +build fabricsdk
+
+/*
+fabricgw, the REST gateway (see gateway.go) over a Fabric network, through the Fabric Go SDK. Built with
+
+	go build -tags fabricsdk -o fabricgw ./gateway/fabricgw
+
+from the chaincode directory, with github.com/hyperledger/fabric-sdk-go in the GOPATH (the tag keeps the
+other builds free of the SDK). It needs the connection profile of the network and a user of an org:
+
+	./fabricgw -config connection-org3.yaml -org Org3 -user User1 -addr :8080
+
+Every tx is signed by that user, so the gateway acts as its org only: the X-MSP-ID header is rejected, as
+only the local gateway honors it (see gatewaysrv.go). An org that serves several users runs a gateway per
+identity, behind its own authentication.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/chaincode/supply_chainCode/client/fabric"
+	"github.com/chaincode/supply_chainCode/gateway"
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/errors/multi"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/errors/status"
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
+	"log"
+	"net/http"
+	"os"
+)
+
+func main() {
+	configPath := flag.String("config", "connection.yaml", "connection profile of the network")
+	channelID := flag.String("channel", "mychannel", "channel of the chaincode")
+	chaincodeID := flag.String("chaincode", "scthreediff6", "name of the chaincode")
+	org := flag.String("org", "Org1", "org of the user, as in the connection profile")
+	user := flag.String("user", "User1", "user that signs the txs")
+	addr := flag.String("addr", ":8080", "address to listen on")
+	flag.Parse()
+
+	sdk, err := fabsdk.New(config.FromFile(*configPath))
+	if err != nil {
+		fail("SDK: %s", err)
+	}
+	defer sdk.Close()
+	cc, err := channel.New(sdk.ChannelContext(*channelID, fabsdk.WithUser(*user), fabsdk.WithOrg(*org)))
+	if err != nil {
+		fail("channel client of %s: %s", *channelID, err)
+	}
+	backend := fabric.New(sdkChannel{cc}, *chaincodeID)
+	log.Printf("gateway of %s@%s to %s on %s listening on %s", *user, *org, *chaincodeID, *channelID, *addr)
+	log.Fatal(http.ListenAndServe(*addr, gateway.NewServer(backend)))
+}
+
+func fail(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "fabricgw: "+format+"\n", a...)
+	os.Exit(1)
+}
+
+//the channel client of the SDK as a fabric.ChannelClient
+type sdkChannel struct {
+	cc *channel.Client
+}
+
+func (c sdkChannel) Execute(ctx context.Context, req fabric.Request) (fabric.Response, error) {
+	resp, err := c.cc.Execute(request(req), channel.WithParentContext(ctx))
+	return fabric.Response{Payload: resp.Payload, TransactionID: string(resp.TransactionID)}, chaincodeError(err)
+}
+
+func (c sdkChannel) Query(ctx context.Context, req fabric.Request) (fabric.Response, error) {
+	resp, err := c.cc.Query(request(req), channel.WithParentContext(ctx))
+	return fabric.Response{Payload: resp.Payload, TransactionID: string(resp.TransactionID)}, chaincodeError(err)
+}
+
+func request(req fabric.Request) channel.Request {
+	return channel.Request{ChaincodeID: req.ChaincodeID, Fcn: req.Fcn, Args: req.Args, TransientMap: req.TransientMap}
+}
+
+/*
+The error of the chaincode in err, which the SDK returns in the ChaincodeStatus group, alone or as the error
+of one of the endorsers. Other errors are returned as they are.
+*/
+func chaincodeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	errs, ok := err.(multi.Errors)
+	if ok == false {
+		errs = multi.Errors{err}
+	}
+	for _, e := range errs {
+		if s, ok := status.FromError(e); ok && s.Group == status.ChaincodeStatus {
+			return &fabric.ChaincodeError{Status: s.Code, Message: s.Message}
+		}
+	}
+	return err
+}
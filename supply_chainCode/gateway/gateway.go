@@ -0,0 +1,274 @@
+/*
+REST gateway of the supply chain chaincode, replacing app/application/serve.js.
+
+Server turns HTTP requests into txs of the Go client (see client.go) and the errors of the chaincode into HTTP
+status codes (see errors.go). Its backend is any client.Transport: over the Fabric Go SDK (see client/fabric
+and the fabricgw command) the txs are signed by the identity of the SDK, whatever the request says.
+A LocalServer, over an in-memory chaincode (see gatewaysrv.go in the chaincode package), acts as the org in the
+X-MSP-ID header instead, for local testing only: anyone who reaches it can act as any org. NewServer rejects
+requests with the header, so that a client doesn't believe it acts as another org.
+
+Request bodies have the fields of the JSON requests of the chaincode (see schema.go), without schemaVersion.
+Errors are {"Function":"...","Message":"..."}. The OpenAPI spec of the endpoints is served too (see openapi.go).
+
+API:
+
+POST /crudes - deliverCrude, returns {"ID":"CrudeXXX"}
+POST /fuels - refine, returns {"ID":"FuelXXX"}
+POST /fuels/{id}/orders - addFuelOrder of fuel id, returns {"ID":"FuelOrderXXX"}
+POST /plans - deliverFuel, returns {"ID":"PlanXXX"}
+POST /transfers - transfer
+POST /vehicles - registerVehicle
+POST /orders/{id}/cancel - cancelOrder of a Crude or FuelOrder, {"reason":"..."}
+GET /assets/{id} - queryAsset
+GET /assets?type=&owner=&state=&pageSize=&bookmark= - a page of assets (see page.go)
+POST /functions/{name} - any function, {"args":["..."]}, returns its payload
+GET /functions/{name}?arg=...&arg=... - any query, returns its payload
+GET /openapi.yaml
+*/
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/chaincode/supply_chainCode/client"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//header with the MSP ID a LocalServer acts as
+const CallerHeader = "X-MSP-ID"
+
+type callerKey struct{}
+
+/*
+The MSP ID in the CallerHeader of the HTTP request of ctx, empty if there is none.
+*/
+func CallerMSPID(ctx context.Context) string {
+	msp, _ := ctx.Value(callerKey{}).(string)
+	return msp
+}
+
+type Server struct {
+	backend client.Transport
+	client  *client.Client
+	local   bool //whether the CallerHeader is honored
+}
+
+func NewServer(backend client.Transport) *Server {
+	return &Server{backend, client.New(backend), false}
+}
+
+/*
+A Server that acts as the org in the CallerHeader of each request, over a LocalBackend. It should only listen
+on a loopback address.
+*/
+func NewLocalServer(backend client.Transport) *Server {
+	return &Server{backend, client.New(backend), true}
+}
+
+//a failed request, as it is returned
+type requestError struct {
+	status int
+	err    *client.Error
+}
+
+func badRequest(function, format string, a ...interface{}) *requestError {
+	return &requestError{http.StatusBadRequest, &client.Error{Function: function, Message: fmt.Sprintf(format, a...)}}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if s.local {
+		ctx = context.WithValue(ctx, callerKey{}, r.Header.Get(CallerHeader))
+	} else if r.Header.Get(CallerHeader) != "" {
+		err := &client.Error{Message: CallerHeader + " is only honored by a local gateway. The txs are signed by the identity of the gateway"}
+		writeJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var result interface{}
+	var rerr *requestError
+	switch {
+	case r.Method == http.MethodPost && len(path) == 1 && path[0] == "crudes":
+		req := client.CrudeRequest{}
+		if rerr = decode(r, "deliverCrude", &req); rerr == nil {
+			result, rerr = created(s.client.DeliverCrude(ctx, req))
+		}
+	case r.Method == http.MethodPost && len(path) == 1 && path[0] == "fuels":
+		req := client.RefineRequest{}
+		if rerr = decode(r, "refine", &req); rerr == nil {
+			result, rerr = created(s.client.Refine(ctx, req))
+		}
+	case r.Method == http.MethodPost && len(path) == 3 && path[0] == "fuels" && path[2] == "orders":
+		req := client.FuelOrderRequest{}
+		if rerr = decode(r, "addFuelOrder", &req); rerr == nil {
+			req.FuelID = path[1]
+			result, rerr = created(s.client.AddFuelOrder(ctx, req))
+		}
+	case r.Method == http.MethodPost && len(path) == 1 && path[0] == "plans":
+		req := client.DeliverFuelRequest{}
+		if rerr = decode(r, "deliverFuel", &req); rerr == nil {
+			result, rerr = created(s.client.DeliverFuel(ctx, req))
+		}
+	case r.Method == http.MethodPost && len(path) == 1 && path[0] == "transfers":
+		req := client.TransferRequest{}
+		if rerr = decode(r, "transfer", &req); rerr == nil {
+			rerr = failed(s.client.Transfer(ctx, req))
+		}
+	case r.Method == http.MethodPost && len(path) == 1 && path[0] == "vehicles":
+		req := struct {
+			Type      string `json:"type"`
+			VehicleID string `json:"vehicleID"`
+			Capacity  int    `json:"capacity"`
+		}{}
+		if rerr = decode(r, "registerVehicle", &req); rerr == nil {
+			rerr = failed(s.client.RegisterVehicle(ctx, req.Type, req.VehicleID, req.Capacity))
+		}
+	case r.Method == http.MethodPost && len(path) == 3 && path[0] == "orders" && path[2] == "cancel":
+		req := struct {
+			Reason string `json:"reason"`
+		}{}
+		if rerr = decode(r, "cancelOrder", &req); rerr == nil {
+			rerr = failed(s.client.CancelOrder(ctx, path[1], req.Reason))
+		}
+	case r.Method == http.MethodGet && len(path) == 2 && path[0] == "assets":
+		var asset json.RawMessage
+		rerr = failed(s.client.QueryAsset(ctx, path[1], &asset))
+		result = asset
+	case r.Method == http.MethodGet && len(path) == 1 && path[0] == "assets":
+		result, rerr = s.assets(ctx, r)
+	case len(path) == 2 && path[0] == "functions" && (r.Method == http.MethodPost || r.Method == http.MethodGet):
+		s.function(ctx, w, r, path[1])
+		return
+	case r.Method == http.MethodGet && len(path) == 1 && path[0] == "openapi.yaml":
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write([]byte(OpenAPISpec))
+		return
+	default:
+		rerr = &requestError{http.StatusNotFound, &client.Error{Message: fmt.Sprintf("No endpoint %s %s", r.Method, r.URL.Path)}}
+	}
+	if rerr != nil {
+		writeJSON(w, rerr.status, rerr.err)
+	} else if result == nil {
+		w.WriteHeader(http.StatusNoContent)
+	} else if _, ok := result.(newAsset); ok {
+		writeJSON(w, http.StatusCreated, result)
+	} else {
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+//decodes the JSON body of a request of function into v
+func decode(r *http.Request, function string, v interface{}) *requestError {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return badRequest(function, "Invalid %s request: %s", function, err)
+	}
+	return nil
+}
+
+//the response of a request that created an asset
+type newAsset struct {
+	ID string
+}
+
+func created(id string, err error) (interface{}, *requestError) {
+	if err != nil {
+		return nil, failed(err)
+	}
+	return newAsset{id}, nil
+}
+
+func failed(err error) *requestError {
+	if err == nil {
+		return nil
+	}
+	status := Status(err)
+	if e, ok := err.(*client.Error); ok {
+		return &requestError{status, e}
+	}
+	return &requestError{status, &client.Error{Message: err.Error()}}
+}
+
+/*
+type and one of owner and state pick the query; owner and state together are a rich query, which needs a type
+and CouchDB.
+*/
+func (s *Server) assets(ctx context.Context, r *http.Request) (interface{}, *requestError) {
+	q := r.URL.Query()
+	typ, owner, state := q.Get("type"), q.Get("owner"), q.Get("state")
+	pageSize := 0
+	if size := q.Get("pageSize"); size != "" {
+		var err error
+		if pageSize, err = strconv.Atoi(size); err != nil || pageSize < 1 {
+			return nil, badRequest("", "pageSize should be an int number > 0")
+		}
+	}
+	bookmark := q.Get("bookmark")
+	var page client.Page
+	var err error
+	switch {
+	case owner != "" && state != "":
+		if typ == "" {
+			return nil, badRequest("queryAssets", "type is required to query by owner and state")
+		}
+		page, err = s.client.QueryAssets(ctx, typ, map[string]interface{}{"AD.Owner": owner, "AD.State": state}, pageSize, bookmark)
+	case owner != "":
+		page, err = s.client.QueryAssetsByOwner(ctx, owner, typ, pageSize, bookmark)
+	case state != "":
+		page, err = s.client.QueryAssetsByState(ctx, state, typ, pageSize, bookmark)
+	case typ != "":
+		page, err = s.client.QueryAssetsByType(ctx, typ, pageSize, bookmark)
+	default:
+		return nil, badRequest("", "Expecting type, owner or state")
+	}
+	if err != nil {
+		return nil, failed(err)
+	}
+	return page, nil
+}
+
+//any function with the positional args of the body (POST) or of the query (GET)
+func (s *Server) function(ctx context.Context, w http.ResponseWriter, r *http.Request, function string) {
+	var payload []byte
+	var err error
+	if r.Method == http.MethodGet {
+		payload, err = s.backend.Evaluate(ctx, function, r.URL.Query()["arg"])
+	} else {
+		req := struct {
+			Args []string `json:"args"`
+		}{}
+		if rerr := decode(r, function, &req); rerr != nil {
+			writeJSON(w, rerr.status, rerr.err)
+			return
+		}
+		payload, err = s.backend.Submit(ctx, function, req.Args, nil)
+	}
+	if rerr := failed(err); rerr != nil {
+		writeJSON(w, rerr.status, rerr.err)
+		return
+	}
+	if len(payload) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+	} else if json.Valid(payload) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(payload)
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(payload)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		status, b = http.StatusInternalServerError, []byte(`{"Message":"Failed to encode the response"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
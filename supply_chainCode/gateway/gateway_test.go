@@ -0,0 +1,179 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/chaincode/supply_chainCode/client"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//a backend that records the txs and returns payload, or fails them with err
+type recorder struct {
+	function string
+	args     []string
+	caller   string
+	payload  []byte
+	err      error
+}
+
+func (r *recorder) Submit(ctx context.Context, function string, args []string, transient map[string][]byte) ([]byte, error) {
+	r.function, r.args, r.caller = function, args, CallerMSPID(ctx)
+	return r.payload, r.err
+}
+
+func (r *recorder) Evaluate(ctx context.Context, function string, args []string) ([]byte, error) {
+	return r.Submit(ctx, function, args, nil)
+}
+
+func serve(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if s.local {
+		req.Header.Set(CallerHeader, "Org3MSP")
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func TestRoutes(t *testing.T) {
+	r := &recorder{}
+	s := NewLocalServer(r)
+	for _, c := range []struct {
+		method, path, body string
+		status             int
+		function           string
+		args               []string
+	}{
+		{"POST", "/fuels/Fuel0000000001/orders", `{"value":"500.00","quantity":20,"owner":"org3","destination":"org5","timestamp":"2019-01-03T15:00:00Z"}`,
+			http.StatusCreated, "addFuelOrder", []string{`{"destination":"org5","fuelID":"Fuel0000000001","owner":"org3","quantity":20,"schemaVersion":1,"timestamp":"2019-01-03T15:00:00Z","value":500.00}`}},
+		{"POST", "/vehicles", `{"type":"Truck","vehicleID":"Truck1","capacity":100}`,
+			http.StatusNoContent, "registerVehicle", []string{`{"capacity":100,"schemaVersion":1,"type":"Truck","vehicleID":"Truck1"}`}},
+		{"POST", "/orders/Crude0000000001/cancel", `{"reason":"not needed"}`, http.StatusNoContent, "cancelOrder", []string{"Crude0000000001", "not needed"}},
+		{"GET", "/assets?owner=org5&type=FuelOrder&pageSize=10", "", http.StatusOK, "queryAssetsByOwner", []string{"org5", "FuelOrder", "10", ""}},
+		{"GET", "/assets?state=ON_WAY&bookmark=Crude0000000007", "", http.StatusOK, "queryAssetsByState", []string{"ON_WAY", "", "", "Crude0000000007"}},
+		{"GET", "/assets?type=Plan", "", http.StatusOK, "queryAssetByRange", []string{"Plan", "", ""}},
+		{"GET", "/assets?type=Crude&owner=org1&state=ON_WAY", "", http.StatusOK, "queryAssets",
+			[]string{"Crude", `{"AD.Owner":"org1","AD.State":"ON_WAY"}`, "", "", ""}},
+		{"GET", "/functions/queryTariff?arg=FuelOrder&arg=org3", "", http.StatusOK, "queryTariff", []string{"FuelOrder", "org3"}},
+		{"POST", "/functions/setCreditLimit", `{"args":["org5","5000"]}`, http.StatusOK, "setCreditLimit", []string{"org5", "5000"}},
+	} {
+		r.payload = []byte(`{}`)
+		if c.status == http.StatusCreated {
+			r.payload = []byte("FuelOrder0000000001")
+		} else if c.status == http.StatusNoContent {
+			r.payload = nil
+		}
+		w := serve(s, c.method, c.path, c.body)
+		if w.Code != c.status || r.function != c.function || reflect.DeepEqual(r.args, c.args) == false {
+			t.Errorf("%s %s: %d %s, called %s %q, expecting %d %s %q", c.method, c.path, w.Code, w.Body, r.function, r.args,
+				c.status, c.function, c.args)
+		}
+		if r.caller != "Org3MSP" {
+			t.Errorf("%s %s acted as %q", c.method, c.path, r.caller)
+		}
+	}
+	r.payload = []byte("FuelOrder0000000001")
+	if w := serve(s, "POST", "/fuels/Fuel0000000001/orders", `{"quantity":20}`); w.Body.String() != `{"ID":"FuelOrder0000000001"}` {
+		t.Errorf("Response of a new order is %s", w.Body)
+	}
+}
+
+func TestRequestErrors(t *testing.T) {
+	r := &recorder{}
+	s := NewLocalServer(r)
+	for _, c := range []struct {
+		method, path, body string
+		status             int
+		message            string
+	}{
+		{"POST", "/crudes", `{"value":`, http.StatusBadRequest, "Invalid deliverCrude request"},
+		{"POST", "/transfers", `{"assetID":"Crude0000000001","owner":"org3","when":"now"}`, http.StatusBadRequest, `unknown field "when"`},
+		{"GET", "/crudes", "", http.StatusNotFound, "No endpoint GET /crudes"},
+		{"GET", "/assets", "", http.StatusBadRequest, "Expecting type, owner or state"},
+		{"GET", "/assets?owner=org1&state=ON_WAY", "", http.StatusBadRequest, "type is required"},
+		{"GET", "/assets?type=Crude&pageSize=0", "", http.StatusBadRequest, "pageSize should be"},
+	} {
+		w := serve(s, c.method, c.path, c.body)
+		e := client.Error{}
+		json.Unmarshal(w.Body.Bytes(), &e)
+		if w.Code != c.status || strings.Contains(e.Message, c.message) == false {
+			t.Errorf("%s %s: %d %s, expecting %d %q", c.method, c.path, w.Code, w.Body, c.status, c.message)
+		}
+	}
+
+	r.err = &client.Error{Function: "queryAsset", Status: 500, Message: "Could not locate asset"}
+	w := serve(s, "GET", "/assets/Crude0000000009", "")
+	if w.Code != http.StatusNotFound || w.Body.String() != `{"Function":"queryAsset","Status":500,"Message":"Could not locate asset"}` {
+		t.Errorf("GET of a missing asset: %d %s", w.Code, w.Body)
+	}
+}
+
+//only a local server acts as the org in the header
+func TestCallerHeader(t *testing.T) {
+	r := &recorder{payload: []byte(`{}`)}
+	s := NewServer(r)
+	req := httptest.NewRequest("GET", "/assets/Crude0000000001", nil)
+	req.Header.Set(CallerHeader, "Org1MSP")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || r.function != "" {
+		t.Errorf("GET with %s: %d %s, called %q", CallerHeader, w.Code, w.Body, r.function)
+	}
+	if w := serve(s, "GET", "/assets/Crude0000000001", ""); w.Code != http.StatusOK || r.function != "queryAsset" || r.caller != "" {
+		t.Errorf("GET without %s: %d %s, acted as %q", CallerHeader, w.Code, w.Body, r.caller)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	for msg, status := range map[string]int{
+		"Access denied: Org5MSP should have one of the roles [refiner]":   http.StatusForbidden,
+		"INSUFFICIENT_FUNDS: org3 has 10.00 available, 1000.00 is needed": http.StatusConflict,
+		"Could not locate Asset":                                       http.StatusNotFound,
+		"FuelOrderID FuelOrder0000000009 does not exist":                        http.StatusNotFound,
+		"Invalid Smart Contract function name.":                        http.StatusNotFound,
+		"Paginated queries are not supported by this peer":             http.StatusNotImplemented,
+		"Crude0000000001 is CANCELLED":                                          http.StatusConflict,
+		"FuelOrder0000000002 is ON_WAY. Remove it from its plan first":          http.StatusConflict,
+		"Dispute is already resolved":                                  http.StatusConflict,
+		"Failed to put tariff in db":                                   http.StatusInternalServerError,
+		"Invalid deliverCrude request: quantity: should be an integer": http.StatusBadRequest,
+		"Incorrect number of arguments. Expecting 3":                   http.StatusBadRequest,
+	} {
+		if got := Status(&client.Error{Function: "fn", Message: msg}); got != status {
+			t.Errorf("Status of %q is %d, expecting %d", msg, got, status)
+		}
+	}
+	if got := Status(context.DeadlineExceeded); got != http.StatusGatewayTimeout {
+		t.Errorf("Status of a timeout is %d", got)
+	}
+	if got := Status(errStub("connection refused")); got != http.StatusBadGateway {
+		t.Errorf("Status of an error of the backend is %d", got)
+	}
+}
+
+type errStub string
+
+func (e errStub) Error() string {
+	return string(e)
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	w := serve(NewServer(&recorder{}), "GET", "/openapi.yaml", "")
+	if w.Code != http.StatusOK || strings.HasPrefix(w.Body.String(), "openapi: 3") == false {
+		t.Fatalf("GET /openapi.yaml: %d", w.Code)
+	}
+	//every endpoint is in the spec
+	for _, path := range []string{"/crudes:", "/fuels:", "/fuels/{id}/orders:", "/plans:", "/transfers:", "/vehicles:",
+		"/orders/{id}/cancel:", "/assets/{id}:", "/assets:", "/functions/{name}:"} {
+		if strings.Contains(OpenAPISpec, "\n  "+path+"\n") == false {
+			t.Errorf("%s is not in the spec", path)
+		}
+	}
+	if strings.Contains(OpenAPISpec, "\t") {
+		t.Error("The spec has tabs, which YAML doesn't allow for indentation")
+	}
+}
@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"context"
+	"github.com/chaincode/supply_chainCode/client"
+	"sync"
+)
+
+/*
+Backend over a ledger running the chaincode in process (see client.MockTransport), for local testing.
+Served by a LocalServer, every tx acts as the org in the CallerHeader of its request, or as defaultMSP without
+one: setCaller makes the ledger act as it (e.g. sets the Caller of a memstub.Stub). The txs run one at a time.
+*/
+type LocalBackend struct {
+	mu         sync.Mutex
+	transport  *client.MockTransport
+	setCaller  func(msp string)
+	defaultMSP string
+}
+
+func NewLocalBackend(ledger client.Ledger, setCaller func(msp string), defaultMSP string) *LocalBackend {
+	return &LocalBackend{transport: client.NewMockTransport(ledger), setCaller: setCaller, defaultMSP: defaultMSP}
+}
+
+func (b *LocalBackend) Submit(ctx context.Context, function string, args []string, transient map[string][]byte) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.act(ctx)
+	return b.transport.Submit(ctx, function, args, transient)
+}
+
+func (b *LocalBackend) Evaluate(ctx context.Context, function string, args []string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.act(ctx)
+	return b.transport.Evaluate(ctx, function, args)
+}
+
+func (b *LocalBackend) act(ctx context.Context) {
+	msp := CallerMSPID(ctx)
+	if msp == "" {
+		msp = b.defaultMSP
+	}
+	b.setCaller(msp)
+}
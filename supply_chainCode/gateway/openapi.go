@@ -0,0 +1,340 @@
+package gateway
+
+/*
+OpenAPI 3 spec of the gateway, served at /openapi.yaml. The request bodies are the JSON requests of the
+chaincode (see schema.go) and the assets those of all-orgsCC.go, so a change of either should be made here too.
+*/
+const OpenAPISpec = `openapi: 3.0.3
+info:
+  title: Fuel supply chain gateway
+  version: "1"
+  description: >
+    REST gateway of the supply chain chaincode. Every endpoint runs one function of the chaincode.
+    Errors of the chaincode are mapped to status codes by their message (see gateway/errors.go).
+paths:
+  /crudes:
+    post:
+      summary: deliverCrude - a vessel sets off with crude
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema: {$ref: '#/components/schemas/CrudeRequest'}
+      responses:
+        "201": {$ref: '#/components/responses/Created'}
+        default: {$ref: '#/components/responses/Error'}
+  /fuels:
+    post:
+      summary: refine - fuel refined from a crude
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema: {$ref: '#/components/schemas/RefineRequest'}
+      responses:
+        "201": {$ref: '#/components/responses/Created'}
+        default: {$ref: '#/components/responses/Error'}
+  /fuels/{id}/orders:
+    post:
+      summary: addFuelOrder - an order of a fueling station cut from the fuel
+      parameters:
+        - {name: id, in: path, required: true, schema: {type: string}, description: FuelID}
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema: {$ref: '#/components/schemas/FuelOrderRequest'}
+      responses:
+        "201": {$ref: '#/components/responses/Created'}
+        default: {$ref: '#/components/responses/Error'}
+  /plans:
+    post:
+      summary: deliverFuel - a plan of a truck delivering fuel orders
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema: {$ref: '#/components/schemas/DeliverFuelRequest'}
+      responses:
+        "201": {$ref: '#/components/responses/Created'}
+        default: {$ref: '#/components/responses/Error'}
+  /transfers:
+    post:
+      summary: transfer - delivery of a Crude or a FuelOrder to its new owner
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema: {$ref: '#/components/schemas/TransferRequest'}
+      responses:
+        "204": {description: Transferred}
+        default: {$ref: '#/components/responses/Error'}
+  /vehicles:
+    post:
+      summary: registerVehicle - registers a truck or vessel, or updates the capacity of one the caller registered
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [type, vehicleID, capacity]
+              properties:
+                type: {type: string, enum: [Truck, Vessel]}
+                vehicleID: {type: string}
+                capacity: {type: integer}
+      responses:
+        "204": {description: Registered}
+        default: {$ref: '#/components/responses/Error'}
+  /orders/{id}/cancel:
+    post:
+      summary: cancelOrder - cancels a Crude or a FuelOrder and refunds its escrow
+      parameters:
+        - {name: id, in: path, required: true, schema: {type: string}, description: CrudeID or FuelOrderID}
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [reason]
+              properties:
+                reason: {type: string}
+      responses:
+        "204": {description: Cancelled}
+        default: {$ref: '#/components/responses/Error'}
+  /assets/{id}:
+    get:
+      summary: queryAsset - a Crude, Fuel, FuelOrder, Plan or the account of an org
+      parameters:
+        - {name: id, in: path, required: true, schema: {type: string}}
+      responses:
+        "200":
+          description: The asset
+          content:
+            application/json:
+              schema:
+                oneOf:
+                  - {$ref: '#/components/schemas/Crude'}
+                  - {$ref: '#/components/schemas/Fuel'}
+                  - {$ref: '#/components/schemas/FuelOrder'}
+                  - {$ref: '#/components/schemas/FuelDeliveryPlan'}
+                  - {type: object}
+        default: {$ref: '#/components/responses/Error'}
+  /assets:
+    get:
+      summary: A page of assets
+      description: >
+        By owner (queryAssetsByOwner), by state (queryAssetsByState), by owner and state (queryAssets, which
+        needs type and CouchDB) or all of a type (queryAssetByRange).
+      parameters:
+        - {name: type, in: query, schema: {type: string, enum: [Crude, Fuel, FuelOrder, Plan]}}
+        - {name: owner, in: query, schema: {type: string}}
+        - {name: state, in: query, schema: {type: string}}
+        - {name: pageSize, in: query, schema: {type: integer, minimum: 1, maximum: 1000, default: 100}}
+        - {name: bookmark, in: query, schema: {type: string}, description: Bookmark of the previous page}
+      responses:
+        "200":
+          description: The page
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/Page'}
+        default: {$ref: '#/components/responses/Error'}
+  /functions/{name}:
+    parameters:
+      - {name: name, in: path, required: true, schema: {type: string}, description: Function of the chaincode}
+    get:
+      summary: Any function as a query, with its positional args
+      parameters:
+        - {name: arg, in: query, schema: {type: array, items: {type: string}}, style: form, explode: true}
+      responses:
+        "200": {description: The payload of the function}
+        "204": {description: No payload}
+        default: {$ref: '#/components/responses/Error'}
+    post:
+      summary: Any function as a tx, with its positional args
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              properties:
+                args: {type: array, items: {type: string}}
+      responses:
+        "200": {description: The payload of the function}
+        "204": {description: No payload}
+        default: {$ref: '#/components/responses/Error'}
+components:
+  responses:
+    Created:
+      description: The ID of the new asset
+      content:
+        application/json:
+          schema:
+            type: object
+            properties:
+              ID: {type: string}
+    Error:
+      description: >
+        400 rejected by the chaincode, 403 access denied, 404 not found, 409 not allowed in the state of the
+        asset or insufficient funds, 501 not supported by the peer, 502/504 backend unreachable or timed out
+      content:
+        application/json:
+          schema:
+            type: object
+            properties:
+              Function: {type: string}
+              Message: {type: string}
+  schemas:
+    Money:
+      type: string
+      pattern: '^-?[0-9]+(\.[0-9]{1,2})?$'
+      example: "1250.00"
+    Terms:
+      type: object
+      description: Private to the trading pair, sent in the transient map
+      properties:
+        Value: {$ref: '#/components/schemas/Money'}
+        Salt: {type: string}
+    CrudeRequest:
+      type: object
+      required: [value, quantity, owner, estTime, startLocation, destination, vesselID, timestamp]
+      properties:
+        value: {$ref: '#/components/schemas/Money'}
+        quantity: {type: integer}
+        owner: {type: string}
+        estTime: {type: string, format: date-time}
+        startLocation: {type: string}
+        destination: {type: string}
+        vesselID: {type: string}
+        timestamp: {type: string, format: date-time}
+        currency: {type: string, example: EUR}
+        terms: {$ref: '#/components/schemas/Terms'}
+    RefineRequest:
+      type: object
+      required: [value, quantity, owner, density, fuelType, crudeID, timestamp]
+      properties:
+        value: {$ref: '#/components/schemas/Money'}
+        quantity: {type: integer}
+        owner: {type: string}
+        density: {type: number}
+        fuelType: {type: string}
+        crudeID: {type: string}
+        timestamp: {type: string, format: date-time}
+        currency: {type: string}
+    FuelOrderRequest:
+      type: object
+      required: [value, quantity, owner, destination, timestamp]
+      properties:
+        value: {$ref: '#/components/schemas/Money'}
+        quantity: {type: integer}
+        owner: {type: string}
+        destination: {type: string}
+        timestamp: {type: string, format: date-time}
+        currency: {type: string}
+        terms: {$ref: '#/components/schemas/Terms'}
+    Delivery:
+      type: object
+      required: [fuelOrderID, estTime, startLocation, destination]
+      properties:
+        fuelOrderID: {type: string}
+        estTime: {type: string, format: date-time}
+        startLocation: {type: string}
+        destination: {type: string}
+    DeliverFuelRequest:
+      type: object
+      required: [truckID, deliveries]
+      properties:
+        truckID: {type: string}
+        deliveries: {type: array, minItems: 1, items: {$ref: '#/components/schemas/Delivery'}}
+    TransferRequest:
+      type: object
+      required: [assetID, owner, timestamp]
+      properties:
+        assetID: {type: string}
+        owner: {type: string}
+        timestamp: {type: string, format: date-time}
+        planID: {type: string, description: Required for a FuelOrder}
+        measuredQuantity: {type: integer}
+        measuredDensity: {type: number}
+    AssetDetails:
+      type: object
+      properties:
+        Value: {$ref: '#/components/schemas/Money'}
+        Quantity: {type: integer}
+        Owner: {type: string}
+        State: {type: string}
+        Currency: {type: string}
+        Collection: {type: string}
+        TermsHash: {type: string}
+    DeliveryDetails:
+      type: object
+      properties:
+        EstTime: {type: string, format: date-time}
+        Delay: {type: number, description: Seconds}
+        StartingLocation: {type: string}
+        Destination: {type: string}
+    Vehicle:
+      type: object
+      properties:
+        Type: {type: string}
+        ID: {type: string}
+        Capacity: {type: integer}
+    Payment:
+      type: object
+      properties:
+        Payer: {type: string}
+        Payee: {type: string}
+        Amount: {$ref: '#/components/schemas/Money'}
+        Currency: {type: string}
+        Rule: {type: string}
+    Crude:
+      type: object
+      properties:
+        AD: {$ref: '#/components/schemas/AssetDetails'}
+        DD: {$ref: '#/components/schemas/DeliveryDetails'}
+        Veh: {$ref: '#/components/schemas/Vehicle'}
+        Timestamp: {type: string, format: date-time}
+        Payments: {type: array, items: {$ref: '#/components/schemas/Payment'}}
+        Allocated: {type: integer}
+    Fuel:
+      type: object
+      properties:
+        AD: {$ref: '#/components/schemas/AssetDetails'}
+        Density: {type: number}
+        Type: {type: string}
+        CrudeID: {type: string}
+        Timestamp: {type: string, format: date-time}
+        Allocated: {type: integer}
+        CrudeUsed: {type: integer}
+        Yield: {type: number}
+    FuelOrder:
+      type: object
+      properties:
+        AD: {$ref: '#/components/schemas/AssetDetails'}
+        Dest: {type: string}
+        FuelID: {type: string}
+        Timestamp: {type: string, format: date-time}
+        Payments: {type: array, items: {$ref: '#/components/schemas/Payment'}}
+    FuelDeliveryPlan:
+      type: object
+      properties:
+        Veh: {$ref: '#/components/schemas/Vehicle'}
+        Plan: {type: object, additionalProperties: {$ref: '#/components/schemas/DeliveryDetails'}}
+        Status: {type: string}
+        Amendments: {type: array, items: {type: object}}
+        CreatedBy: {type: string, description: The org that can amend or cancel it}
+    Page:
+      type: object
+      properties:
+        Records:
+          type: array
+          items:
+            type: object
+            properties:
+              Key: {type: string}
+              Record: {type: object}
+        FetchedRecordsCount: {type: integer}
+        Bookmark: {type: string, description: To ask the next page with, empty after the last page}
+`
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/chaincode/supply_chainCode/client"
+	"github.com/chaincode/supply_chainCode/gateway"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+//the gateway over the in-memory ledger, as gatewaysrv.go runs it
+func TestGateway(t *testing.T) {
+	n := newTestNet(t)
+	backend := gateway.NewLocalBackend(n.stub, func(msp string) { n.stub.Caller = msp }, "Org1MSP")
+	srv := httptest.NewServer(gateway.NewLocalServer(backend))
+	defer srv.Close()
+	do := func(msp, method, path, body string, status int) map[string]interface{} {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if msp != "" {
+			req.Header.Set(gateway.CallerHeader, msp)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		out := map[string]interface{}{}
+		json.NewDecoder(resp.Body).Decode(&out)
+		if resp.StatusCode != status {
+			t.Fatalf("%s %s as %s: %d %v, expecting %d", method, path, msp, resp.StatusCode, out, status)
+		}
+		return out
+	}
+
+	//as Org1MSP without the header
+	crude := do("", "POST", "/crudes", `{"value":"1000.00","quantity":100,"owner":"org1","estTime":"`+crudeEst+
+		`","startLocation":"org1","destination":"org3","vesselID":"Vessel1","timestamp":"`+dispatched+`"}`, http.StatusCreated)
+	crudeID, _ := crude["ID"].(string)
+	do("Org3MSP", "POST", "/transfers", `{"assetID":"`+crudeID+`","owner":"org3","timestamp":"`+crudeEst+`"}`, http.StatusNoContent)
+	fuel := do("Org3MSP", "POST", "/fuels", `{"value":"800.00","quantity":50,"owner":"org3","density":0.85,"fuelType":"Diesel",`+
+		`"crudeID":"`+crudeID+`","timestamp":"`+refined+`"}`, http.StatusCreated)
+	order := do("Org3MSP", "POST", "/fuels/"+fuel["ID"].(string)+"/orders", `{"value":"500.00","quantity":20,"owner":"org3",`+
+		`"destination":"org5","timestamp":"`+ordered+`"}`, http.StatusCreated)
+	orderID := order["ID"].(string)
+	do("Org4MSP", "POST", "/vehicles", `{"type":"Truck","vehicleID":"Truck1","capacity":100}`, http.StatusNoContent)
+	plan := do("Org4MSP", "POST", "/plans", `{"truckID":"Truck1","deliveries":[{"fuelOrderID":"`+orderID+`","estTime":"`+fuelEst+
+		`","startLocation":"org3","destination":"org5"}]}`, http.StatusCreated)
+	do("Org5MSP", "POST", "/transfers", `{"assetID":"`+orderID+`","owner":"org5","timestamp":"`+fuelEst+`","planID":"`+
+		plan["ID"].(string)+`","measuredQuantity":20,"measuredDensity":0.85}`, http.StatusNoContent)
+
+	asset := do("Org5MSP", "GET", "/assets/"+orderID, "", http.StatusOK)
+	if ad, _ := asset["AD"].(map[string]interface{}); ad["State"] != "DELIVERED" || ad["Owner"] != "org5" {
+		t.Errorf("%s is %v", orderID, asset)
+	}
+	page := do("", "GET", "/assets?owner=org5&type=FuelOrder", "", http.StatusOK)
+	if records, _ := page["Records"].([]interface{}); len(records) != 1 {
+		t.Errorf("FuelOrders of org5 are %v", page)
+	}
+	receipt := do("", "GET", "/functions/queryReceipt?arg="+orderID, "", http.StatusOK)
+	if receipt["Status"] != ReceiptAccepted {
+		t.Errorf("Receipt of %s is %v", orderID, receipt)
+	}
+
+	//the errors of the chaincode
+	do("Org5MSP", "POST", "/crudes", `{"value":"1.00","quantity":1,"owner":"org1","estTime":"`+crudeEst+
+		`","startLocation":"org1","destination":"org3","vesselID":"Vessel1","timestamp":"`+dispatched+`"}`, http.StatusForbidden)
+	do("", "GET", "/assets/Crude0000000009", "", http.StatusNotFound)
+	do("Org5MSP", "POST", "/orders/"+orderID+"/cancel", `{"reason":"too late"}`, http.StatusConflict)
+	do("Org6MSP", "POST", "/orders/"+orderID+"/cancel", `{"reason":"too late"}`, http.StatusForbidden)
+	do("", "GET", "/assets?type=Crude&owner=org3&state=DELIVERED", "", http.StatusNotImplemented)
+	e := do("", "POST", "/crudes", `{"value":"1.00"}`, http.StatusBadRequest)
+	if msg, _ := e["Message"].(string); strings.HasPrefix(msg, "Invalid deliverCrude request") == false {
+		t.Errorf("Error of an incomplete request is %v", e)
+	}
+	n.checkBooks()
+}
+
+func TestLocalBackendActsAsCaller(t *testing.T) {
+	n := newTestNet(t)
+	var callers []string
+	backend := gateway.NewLocalBackend(n.stub, func(msp string) { callers = append(callers, msp); n.stub.Caller = msp }, "Org2MSP")
+	s := gateway.NewLocalServer(backend)
+	req := httptest.NewRequest("GET", "/functions/queryRoles", nil)
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	req.Header.Set(gateway.CallerHeader, "Org6MSP")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	if len(callers) != 2 || callers[0] != "Org2MSP" || callers[1] != "Org6MSP" {
+		t.Errorf("Requests acted as %v", callers)
+	}
+}
+
+//every rule of gateway.StatusRules, hit by the errors of real txs, as they are and as the SDK wraps them
+func TestStatusRules(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.newCrude()
+	fuelID := n.newFuel(crudeID)
+	orderID := n.newFuelOrder(fuelID, "org5")
+	n.newPlan(orderID)
+	unplannedID := n.newFuelOrder(fuelID, "org5")
+	otherFuelID := n.newFuel(crudeID)
+	cancelledPlanID := n.newPlan(n.newFuelOrder(otherFuelID, "org5"))
+	n.as("Org4MSP").ok("cancelPlan", cancelledPlanID, "truck broke down")
+	otherPlanID := n.newPlan(n.newFuelOrder(otherFuelID, "org5"))
+	deliveredID := n.newCrude()
+	n.as("Org3MSP").ok("transfer", deliveredID, "org3", crudeEst)
+	disputeID := n.ok("openDispute", deliveredID, ReasonShortDelivery, "9f86d081884c7d65")
+	resolvedID := n.newCrude()
+	n.as("Org3MSP").ok("transfer", resolvedID, "org3", crudeEst)
+	resolvedDisputeID := n.ok("openDispute", resolvedID, ReasonShortDelivery, "9f86d081884c7d65")
+	n.as("Org1MSP").ok("resolveDispute", resolvedDisputeID, "no shortfall")
+	n.ok("deactivateOrg", "org6")
+
+	cases := []struct {
+		msp, fn string
+		args    []string
+		status  int
+	}{
+		{"Org5MSP", "cancelOrder", []string{crudeID, "not needed"}, http.StatusForbidden},
+		{"Org3MSP", "respondDispute", []string{disputeID, "we measured 100", "e3b0c442"}, http.StatusForbidden},
+		{"Org1MSP", "deliverCrude", []string{"200000.00", "100", "org1", crudeEst, "org1", "org3", "Vessel1", dispatched}, http.StatusConflict},
+		{"Org1MSP", "queryTerms", []string{"Crude0000000009"}, http.StatusNotFound},
+		{"Org4MSP", "deliverFuel", []string{"Truck1", "FuelOrder0000000009", fuelEst, "org3", "org5"}, http.StatusNotFound},
+		{"Org3MSP", "refine", []string{"800.00", "50", "org3", "0.85", "Diesel", "Crude0000000009", refined}, http.StatusNotFound},
+		{"Org3MSP", "addFuelOrder", []string{"500.00", "20", "org3", "org5", "Fuel0000000009", ordered}, http.StatusNotFound},
+		{"Org5MSP", "transfer", []string{orderID, "org5", fuelEst, otherPlanID}, http.StatusNotFound},
+		{"Org1MSP", "noSuchFunction", nil, http.StatusNotFound},
+		{"Org1MSP", "queryAssets", []string{TypeCrude, `{"AD.Owner":"org1"}`}, http.StatusNotImplemented},
+		{"Org1MSP", "queryHistoryForKey", []string{crudeID}, http.StatusNotImplemented},
+		{"Org3MSP", "cancelOrder", []string{orderID, "not needed"}, http.StatusConflict},
+		{"Org5MSP", "transfer", []string{orderID, "org5", fuelEst, cancelledPlanID}, http.StatusConflict},
+		{"Org5MSP", "transfer", []string{unplannedID, "org5", fuelEst, otherPlanID}, http.StatusConflict},
+		{"Org1MSP", "respondDispute", []string{resolvedDisputeID, "late", "e3b0c442"}, http.StatusConflict},
+		{"Org1MSP", "openDispute", []string{deliveredID, ReasonQuality, "9f86d081884c7d65"}, http.StatusConflict},
+		{"Org1MSP", "cancelOrder", []string{crudeID, "not needed"}, http.StatusConflict},
+		{"Org3MSP", "addFuelOrder", []string{"500.00", "20", "org3", "org6", fuelID, ordered}, http.StatusConflict},
+		{"Org1MSP", "registerVehicle", []string{"Truck", "Truck1", "100"}, http.StatusForbidden},
+	}
+	hit := map[int]bool{}
+	check := func(fn string, resp sc.Response, status int) {
+		t.Helper()
+		if resp.Status == shim.OK {
+			t.Fatalf("%s succeeded", fn)
+		}
+		for _, msg := range []string{resp.Message, "chaincode error (status: 500, message: " + resp.Message + ")"} {
+			if got := gateway.Status(&client.Error{Function: fn, Status: resp.Status, Message: msg}); got != status {
+				t.Errorf("Status of %q is %d, expecting %d", msg, got, status)
+			}
+		}
+		for i, rule := range gateway.StatusRules {
+			if rule.Pattern.MatchString(resp.Message) {
+				hit[i] = true
+				break
+			}
+		}
+	}
+	for _, c := range cases {
+		check(c.fn, n.as(c.msp).invoke(c.fn, c.args...), c.status)
+	}
+	//a corrupted record
+	n.stub.MockTransactionStart("x")
+	n.stub.PutState(escrowPeriodKey, []byte("x"))
+	n.stub.MockTransactionEnd("x")
+	check("deliverCrude", n.as("Org1MSP").invoke("deliverCrude", "1000.00", "100", "org1", crudeEst, "org1", "org3", "Vessel1", dispatched),
+		http.StatusInternalServerError)
+	for i, rule := range gateway.StatusRules {
+		if hit[i] == false {
+			t.Errorf("No tx hit the rule %s (%d)", rule.Pattern, rule.Status)
+		}
+	}
+}
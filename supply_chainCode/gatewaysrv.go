@@ -0,0 +1,49 @@
+//go:build gateway
+// +build gateway
+
+/*
+Local REST gateway (see gateway/gateway.go) over the chaincode on the in-memory ledger (see memstub/memstub.go), to try
+the API and test applications without a Fabric network. Built from this directory with
+
+	go build -tags gateway -o gateway .
+
+it starts from a ledger with the accounts opened by initLedger, as Org1MSP, and keeps it in memory only:
+
+	./gateway -addr 127.0.0.1:8080
+	curl -H 'X-MSP-ID: Org1MSP' -d '{"value":"1000.00","quantity":100,...}' localhost:8080/crudes
+
+Every request acts as the org in its X-MSP-ID header, Org1MSP without one, so the gateway listens on the
+loopback interface only, unless -addr says otherwise. Rich and history queries aren't supported in memory.
+The gateway over a Fabric network, which signs the txs with the identity of its SDK, is gateway/fabricgw.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/chaincode/supply_chainCode/gateway"
+	"github.com/chaincode/supply_chainCode/memstub"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"log"
+	"net/http"
+	"os"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8080", "address to listen on, anyone who reaches it can act as any org")
+	flag.Parse()
+
+	stub := memstub.New("supply_chainCode", new(SmartContract))
+	stub.Caller = "Org1MSP"
+	if resp := stub.Init("init", nil); resp.Status != shim.OK {
+		fmt.Fprintf(os.Stderr, "gateway: Init failed: %s\n", resp.Message)
+		os.Exit(1)
+	}
+	if resp := stub.Invoke("initLedger", memstub.Args("initLedger", nil), nil); resp.Status != shim.OK {
+		fmt.Fprintf(os.Stderr, "gateway: initLedger failed: %s\n", resp.Message)
+		os.Exit(1)
+	}
+	backend := gateway.NewLocalBackend(stub, func(msp string) { stub.Caller = msp }, "Org1MSP")
+	log.Printf("gateway listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, gateway.NewLocalServer(backend)))
+}
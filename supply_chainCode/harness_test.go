@@ -0,0 +1,208 @@
+/*
+Test harness of the chaincode on the in-memory ledger (see memstub/memstub.go), so that 'go test' runs it without
+a Fabric network.
+
+The txs are created by the MSP ID the test acts as (see memstub.Stub). Org1MSP instantiates the
+chaincode, so it is the admin and the arbiter of disputes. Every test starts from a new ledger with the
+accounts of org1..org6 opened by initLedger.
+
+The ledger doesn't support paginated queries, rich queries or history, so those are tested up to their args.
+*/
+package main
+
+import (
+	"encoding/json"
+	"github.com/chaincode/supply_chainCode/memstub"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const openingBalance = 100000 * MinorUnits
+
+type testNet struct {
+	t    *testing.T
+	stub *memstub.Stub
+	txs  int
+}
+
+/*
+A new ledger instantiated by Org1MSP, with the accounts opened.
+*/
+func newTestNet(t *testing.T) *testNet {
+	n := newBareNet(t)
+	n.ok("initLedger")
+	return n
+}
+
+//a new ledger before initLedger
+func newBareNet(t *testing.T) *testNet {
+	n := &testNet{t, memstub.New("supply_chainCode", new(SmartContract)), 0}
+	n.stub.Caller = "Org1MSP"
+	if resp := n.stub.Init(n.nextTxID(), nil); resp.Status != shim.OK {
+		t.Fatalf("Init failed: %s", resp.Message)
+	}
+	return n
+}
+
+//the next txs are submitted by msp
+func (n *testNet) as(msp string) *testNet {
+	n.stub.Caller = msp
+	return n
+}
+
+func (n *testNet) nextTxID() string {
+	n.txs++
+	return "tx" + strconv.Itoa(n.txs)
+}
+
+func (n *testNet) invoke(fn string, args ...string) sc.Response {
+	return n.stub.Invoke(n.nextTxID(), memstub.Args(fn, args), nil)
+}
+
+//invokes fn, fails the test if the tx fails and returns the payload
+func (n *testNet) ok(fn string, args ...string) string {
+	n.t.Helper()
+	resp := n.invoke(fn, args...)
+	if resp.Status != shim.OK {
+		n.t.Fatalf("%s%q failed: %s", fn, args, resp.Message)
+	}
+	return string(resp.Payload)
+}
+
+//invokes fn and fails the test unless the tx fails with a message that contains want
+func (n *testNet) fails(want, fn string, args ...string) {
+	n.t.Helper()
+	resp := n.invoke(fn, args...)
+	if resp.Status == shim.OK {
+		n.t.Fatalf("%s%q should fail with %q", fn, args, want)
+	}
+	if strings.Contains(resp.Message, want) == false {
+		n.t.Fatalf("%s%q failed with %q, expecting %q", fn, args, resp.Message, want)
+	}
+}
+
+//invokes fn and decodes its payload into v
+func (n *testNet) query(v interface{}, fn string, args ...string) {
+	n.t.Helper()
+	payload := n.ok(fn, args...)
+	if err := json.Unmarshal([]byte(payload), v); err != nil {
+		n.t.Fatalf("Failed to decode %s: %s", fn, err)
+	}
+}
+
+//decodes the asset id as it is in db into v
+func (n *testNet) asset(id string, v interface{}) {
+	n.t.Helper()
+	abytes, err := GetAsset(n.stub, id)
+	if err != nil || abytes == nil {
+		n.t.Fatalf("Could not locate %s", id)
+	}
+	if err = json.Unmarshal(abytes, v); err != nil {
+		n.t.Fatalf("Failed to decode %s: %s", id, err)
+	}
+}
+
+func (n *testNet) state(id string) string {
+	n.t.Helper()
+	asset := struct{ AD AssetDetails }{}
+	n.asset(id, &asset)
+	return asset.AD.State
+}
+
+func (n *testNet) balance(org string) Money {
+	n.t.Helper()
+	acc, err := GetAccountState(n.stub, org)
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	return acc.Balance
+}
+
+//fails the test unless the balances of the orgs are the expected ones
+func (n *testNet) balances(want map[string]Money) {
+	n.t.Helper()
+	for org, m := range want {
+		if got := n.balance(org); got != m {
+			n.t.Errorf("Balance of %s is %s, expecting %s", org, got, m)
+		}
+	}
+}
+
+/*
+Fails the test unless the balances of the accounts in each currency, the capital and escrow accounts
+included, sum to zero (see journal.go). Payments between currencies break the sums, so it is only
+called on ledgers without them.
+*/
+func (n *testNet) checkBooks() {
+	n.t.Helper()
+	resultsIterator, err := n.stub.GetStateByPartialCompositeKey(TypeAccount, []string{})
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	defer resultsIterator.Close()
+	sums := map[string]Money{}
+	for resultsIterator.HasNext() {
+		kv, _ := resultsIterator.Next()
+		_, attrs, _ := n.stub.SplitCompositeKey(kv.Key)
+		acc, err := GetAccountState(n.stub, attrs[0])
+		if err != nil {
+			n.t.Fatal(err)
+		}
+		sums[acc.Currency] += acc.Balance
+	}
+	for currency, sum := range sums {
+		if sum != 0 {
+			n.t.Errorf("Accounts in %s sum to %s", currency, sum)
+		}
+	}
+}
+
+//types of the events of the last tx
+func (n *testNet) eventTypes() []string {
+	types := []string{}
+	for _, e := range n.stub.Events {
+		payload := EventsPayload{}
+		json.Unmarshal(e.Payload, &payload)
+		for _, ev := range payload.Events {
+			types = append(types, ev.Type)
+		}
+	}
+	return types
+}
+
+func money(s string) Money {
+	m, err := ParseMoney(s)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+//invokes fn with the terms in the transient map
+func (n *testNet) invokePrivate(terms Terms, fn string, args ...string) sc.Response {
+	tbytes, _ := json.Marshal(terms)
+	return n.stub.Invoke(n.nextTxID(), memstub.Args(fn, args), map[string][]byte{TransientTermsKey: tbytes})
+}
+
+func (n *testNet) okPrivate(terms Terms, fn string, args ...string) string {
+	n.t.Helper()
+	resp := n.invokePrivate(terms, fn, args...)
+	if resp.Status != shim.OK {
+		n.t.Fatalf("%s%q failed: %s", fn, args, resp.Message)
+	}
+	return string(resp.Payload)
+}
+
+func (n *testNet) failsPrivate(want string, terms Terms, fn string, args ...string) {
+	n.t.Helper()
+	resp := n.invokePrivate(terms, fn, args...)
+	if resp.Status == shim.OK {
+		n.t.Fatalf("%s%q should fail with %q", fn, args, want)
+	}
+	if strings.Contains(resp.Message, want) == false {
+		n.t.Fatalf("%s%q failed with %q, expecting %q", fn, args, resp.Message, want)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Provenance of assets and org accounts.
+
+queryHistoryForKey walks every modification of a Crude, Fuel, FuelOrder, Plan or org account
+and returns them oldest first, so that an auditor can see who changed what and when.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"strings"
+	"time"
+)
+
+/*
+One modification of a key.
+Value is the state of the key after the tx. It is null when the tx deleted the key.
+*/
+type HistoryEntry struct {
+	TxID      string          `json:"txID"`
+	Timestamp time.Time       `json:"timestamp"`
+	IsDelete  bool            `json:"isDelete"`
+	Value     json.RawMessage `json:"value"`
+}
+
+func IsHistoryKey(key string) bool {
+	for _, prefix := range []string{"Crude", "Fuel", "Plan", "org"} {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+args[0] = key of an asset or org account
+args[1] = from , args[2] = to (optional, RFC3339). Only modifications inside [from,to] are returned.
+An empty string leaves that side of the window open.
+*/
+func (s *SmartContract) queryHistoryForKey(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) < 1 || len(args) > 3 {
+		return shim.Error("Expecting 1 to 3 args")
+	}
+	if IsHistoryKey(args[0]) == false {
+		return shim.Error("Key should be one of {Crude,Fuel,FuelOrder,Plan,org}XXXX")
+	}
+	var from, to time.Time
+	var err error
+	if len(args) > 1 && args[1] != "" {
+		if from, err = RFCtoTime(args[1]); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if len(args) > 2 && args[2] != "" {
+		if to, err = RFCtoTime(args[2]); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return shim.Error("End of time window is before its start")
+	}
+	history, err := GetIDHistory(stub, args[0], from, to)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	historyAsBytes, _ := json.Marshal(history)
+	return shim.Success(historyAsBytes)
+}
+
+/*
+History of an asset or account by its ID. The modifications made before migrateKeys are under
+the plain key, the later ones under the composite key (see keys.go).
+*/
+func GetIDHistory(stub shim.ChaincodeStubInterface, id string, from, to time.Time) ([]HistoryEntry, error) {
+	var key string
+	var err error
+	if AssetType(id) == "" {
+		key, err = AccountKey(stub, id)
+	} else {
+		key, err = AssetKey(stub, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	history, err := GetHistory(stub, id, from, to)
+	if err != nil {
+		return nil, err
+	}
+	later, err := GetHistory(stub, key, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return append(history, later...), nil
+}
+
+/*
+Returns the modifications of key inside [from,to]. A zero from/to means no bound.
+*/
+func GetHistory(stub shim.ChaincodeStubInterface, key string, from, to time.Time) ([]HistoryEntry, error) {
+	resultsIterator, err := stub.GetHistoryForKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get history of %s: %s", key, err)
+	}
+	defer resultsIterator.Close()
+
+	history := []HistoryEntry{}
+	for resultsIterator.HasNext() {
+		km, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var tstamp time.Time
+		if km.Timestamp != nil {
+			tstamp = time.Unix(km.Timestamp.Seconds, int64(km.Timestamp.Nanos)).UTC()
+		}
+		if !from.IsZero() && tstamp.Before(from) {
+			continue
+		}
+		if !to.IsZero() && tstamp.After(to) {
+			continue
+		}
+		var value json.RawMessage
+		if !km.IsDelete && len(km.Value) > 0 {
+			value = json.RawMessage(km.Value)
+		}
+		history = append(history, HistoryEntry{km.TxId, tstamp, km.IsDelete, value})
+	}
+	return history, nil
+}
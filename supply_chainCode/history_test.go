@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+)
+
+//history isn't supported by the in-memory ledger, so only the args are checked
+func TestQueryHistoryForKey(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.newCrude()
+	n.fails("Expecting 1 to 3 args", "queryHistoryForKey")
+	n.fails("Expecting 1 to 3 args", "queryHistoryForKey", crudeID, "", "", "")
+	n.fails("Key should be one of {Crude,Fuel,FuelOrder,Plan,org}XXXX", "queryHistoryForKey", "Truck1")
+	n.fails("RFC3339", "queryHistoryForKey", crudeID, "2019-01-01")
+	n.fails("RFC3339", "queryHistoryForKey", crudeID, "", "yesterday")
+	n.fails("End of time window is before its start", "queryHistoryForKey", crudeID, "2019-01-02T00:00:00Z", "2019-01-01T00:00:00Z")
+	n.fails("Failed to get history of", "queryHistoryForKey", crudeID)
+}
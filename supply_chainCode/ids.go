@@ -0,0 +1,121 @@
+/*
+Asset IDs.
+
+IDs of Crudes, Fuels, FuelOrders and Plans are minted by the chaincode, not picked by the clients.
+Each type has a counter in db (key 'idCounter'+type) and a new asset gets the next number, zero-padded to
+IDDigits digits, e.g. Crude0000000001, Crude0000000002, ... so that the lexical order of the IDs, which the keys
+and the pages are in (see keys.go, page.go), is the order the assets were created in.
+The tx that creates an asset returns its ID as the payload of the response.
+
+If a client picked the number already (ledgers before minted IDs, e.g. Crude12), that number is skipped.
+The IDs of those clients keep their form and come after the minted ones.
+Two txs that create assets of the same type in the same block conflict on the counter and one of them
+has to be resubmitted.
+
+For one release the functions that create an asset still accept the ID of the positional args of the clients
+before minted IDs as their first arg: it is ignored, with a warning in the log of the peer.
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"strconv"
+)
+
+var idsLogger = shim.NewLogger("ids")
+
+//types of assets with minted IDs
+const (
+	TypeCrude     = "Crude"
+	TypeFuel      = "Fuel"
+	TypeFuelOrder = "FuelOrder"
+	TypePlan      = "Plan"
+)
+
+func IsAssetType(typ string) bool {
+	switch typ {
+	case TypeCrude, TypeFuel, TypeFuelOrder, TypePlan:
+		return true
+	}
+	return false
+}
+
+//digits of the number of a minted ID
+const IDDigits = 10
+
+func idCounterKey(typ string) string {
+	return "idCounter" + typ
+}
+
+//the ID of number n of type typ
+func MintedID(typ string, n uint64) string {
+	return fmt.Sprintf("%s%0*d", typ, IDDigits, n)
+}
+
+//an ID is free if no asset has it, nor the ID a client picked with the same number
+func isFreeID(stub shim.ChaincodeStubInterface, typ string, n uint64) (bool, error) {
+	for _, id := range []string{MintedID(typ, n), typ + strconv.FormatUint(n, 10)} {
+		abytes, err := GetAsset(stub, id)
+		if err != nil {
+			return false, err
+		}
+		//an asset of a ledger that isn't migrated yet is still under its plain key (see keys.go)
+		plainbytes, err := stub.GetState(id)
+		if err != nil {
+			return false, errors.New("Failed to read db")
+		}
+		if abytes != nil || plainbytes != nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+/*
+Returns the next free ID of type typ and stores the counter.
+*/
+func NewAssetID(stub shim.ChaincodeStubInterface, typ string) (string, error) {
+	if IsAssetType(typ) == false {
+		return "", fmt.Errorf("%s is not an asset type", typ)
+	}
+	var n uint64
+	cbytes, err := stub.GetState(idCounterKey(typ))
+	if err != nil {
+		return "", fmt.Errorf("Failed to read the ID counter of %s", typ)
+	}
+	if cbytes != nil {
+		if err = json.Unmarshal(cbytes, &n); err != nil {
+			return "", fmt.Errorf("ID counter of %s is corrupted", typ)
+		}
+	}
+	for {
+		n++
+		free, err := isFreeID(stub, typ, n)
+		if err != nil {
+			return "", err
+		}
+		if free {
+			break
+		}
+	}
+	cbytes, _ = json.Marshal(n)
+	if err = stub.PutState(idCounterKey(typ), cbytes); err != nil {
+		return "", fmt.Errorf("Failed to put the ID counter of %s in db", typ)
+	}
+	return MintedID(typ, n), nil
+}
+
+/*
+Drops the ID of type typ an old client passes as the first arg of function. The ID of the new asset is minted
+anyway, so the ID of the client is ignored. To be removed in the next release.
+*/
+func dropLegacyID(function, typ string, args []string) []string {
+	if len(args) == 0 || AssetType(args[0]) != typ {
+		return args
+	}
+	idsLogger.Warningf("%s: the ID %s is ignored, as the chaincode mints the IDs. Positional args with an ID are deprecated and will be rejected by the next release", function, args[0])
+	return args[1:]
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMintedIDs(t *testing.T) {
+	n := newTestNet(t)
+	if id := n.newCrude(); id != "Crude0000000001" {
+		t.Fatalf("ID of the first crude is %s", id)
+	}
+	if id := n.newCrude(); id != "Crude0000000002" {
+		t.Fatalf("ID of the second crude is %s", id)
+	}
+	//an asset of a ledger before minted IDs, under its plain key, keeps its ID
+	n.stub.MockTransactionStart("legacy")
+	n.stub.PutState("Crude3", []byte("{}"))
+	n.stub.MockTransactionEnd("legacy")
+	if id := n.newCrude(); id != "Crude0000000004" {
+		t.Fatalf("ID of the crude after Crude3 is %s", id)
+	}
+	//IDs of the other types have their own counters
+	if id := n.newFuel("Crude0000000001"); id != "Fuel0000000001" {
+		t.Fatalf("ID of the first fuel is %s", id)
+	}
+	//a failed tx doesn't use up an ID
+	n.as("Org3MSP").fails("Not enough crude", "refine", "1.00", "1000", "org3", "0.85", "Diesel", "Crude0000000001", refined)
+	if id := n.newFuel("Crude0000000001"); id != "Fuel0000000002" {
+		t.Fatalf("ID of the second fuel is %s", id)
+	}
+	//the ID of a client before minted IDs is ignored, for one release
+	id := n.as("Org1MSP").ok("deliverCrude", "Crude9", "1000.00", "100", "org1", crudeEst, "org1", "org3", "Vessel1", dispatched, "EUR")
+	if id != "Crude0000000005" {
+		t.Fatalf("ID of a crude with an ID in its args is %s", id)
+	}
+	n.as("Org3MSP").ok("transfer", id, "org3", crudeEst)
+	if id := n.as("Org3MSP").ok("refine", "Fuel9", "1.00", "1", "org3", "0.85", "Diesel", id, refined); id != "Fuel0000000003" {
+		t.Fatalf("ID of a fuel with an ID in its args is %s", id)
+	}
+	orderID := n.as("Org3MSP").ok("addFuelOrder", "FuelOrder9", "500.00", "20", "org3", "org5", "Fuel0000000001", ordered)
+	if orderID != "FuelOrder0000000001" {
+		t.Fatalf("ID of an order with an ID in its args is %s", orderID)
+	}
+	n.as("Org4MSP").ok("registerVehicle", "Truck", "Truck1", "100")
+	if id := n.as("Org4MSP").ok("deliverFuel", "Plan9", "Truck1", orderID, fuelEst, "org3", "org5"); id != "Plan0000000001" {
+		t.Fatalf("ID of a plan with an ID in its args is %s", id)
+	}
+}
@@ -0,0 +1,367 @@
+/*
+Double-entry journal of payments.
+
+Every payment is an immutable JournalEntry that debits the payer's account and credits the payee's account
+by the same amount, with the asset it pays for as Reference. The balance of an account is a checkpoint of
+its journal: it changes only together with the entries posted to it, so that
+
+	balance = opening balance + credits - debits
+
+and the balances of all accounts in a currency, the capital account of the currency the opening balances
+come from included, sum to zero.
+
+Amounts are fixed-point money (see money.go). A payment is in the currency of the asset it pays for.
+An entry keeps that amount and what it debits and credits in the currencies of the two accounts, converted
+with the FX rates on the ledger. A payment to or from an account in another currency without an FX rate
+is rejected.
+
+An entry is put in db three times, under the composite keys
+	(Journal, debit org, time, entryID)
+	(Journal, credit org, time, entryID)
+	(JournalRef, reference, entryID)
+so that the statement of an account and the payments of an asset are both a partial key query.
+
+Accounts of ledgers before the journal are a bare balance and have no entries. Their balance is taken
+as the opening balance of their statement.
+
+API:
+
+getAccountStatement - args[0] = org, args[1] = from , args[2] = to (optional, RFC3339)
+queryJournalByReference - args[0] = ID of an asset (or dispute)
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"sort"
+	"time"
+)
+
+const (
+	BaseCurrency   = "EUR"
+	CapitalAccount = "capital" //where the opening balances of the orgs in BaseCurrency come from
+)
+
+const (
+	journalObjectType    = "Journal"
+	journalRefObjectType = "JournalRef"
+	journalTimeFormat    = "2006-01-02T15:04:05.000000000Z" //fixed width, so keys sort by time
+)
+
+/*
+Put in db with key (Account, org) (see keys.go).
+Entries is the number of journal entries posted to the account and LastTxID the tx of the last one.
+*/
+type Account struct {
+	Org         string
+	Currency    string
+	Balance     Money
+	CreditLimit Money //how far below zero the balance may go (see credit.go)
+	Entries     int
+	LastTxID    string
+}
+
+/*
+Debit is the account the amount is taken from (the payer) and Credit the one it is added to (the payee).
+Amount is in Currency, DebitAmount and CreditAmount in the currencies of the accounts.
+Rule is the tariff the amount was computed with (see tariff.go), if any.
+*/
+type JournalEntry struct {
+	ID           string //txID.NNNN, N-th entry of the tx, zero-padded so the entries of a tx sort in order
+	TxID         string
+	Timestamp    time.Time
+	Debit        string
+	Credit       string
+	Amount       Money
+	Currency     string
+	DebitAmount  Money
+	CreditAmount Money
+	Reference    string //asset the payment is for
+	Memo         string
+	Rule         string
+}
+
+type StatementLine struct {
+	Entry   JournalEntry
+	Change  Money //positive for a credit, negative for a debit
+	Balance Money //balance of the account after the entry
+}
+
+type AccountStatement struct {
+	Org            string
+	Currency       string
+	From           time.Time
+	To             time.Time
+	OpeningBalance Money //at From
+	ClosingBalance Money //at To
+	Lines          []StatementLine
+}
+
+func NewAccount(org string) Account {
+	return Account{org, BaseCurrency, 0, 0, 0, ""}
+}
+
+//account the opening balances in currency come from
+func CapitalAccountOf(currency string) string {
+	if currency == BaseCurrency {
+		return CapitalAccount
+	}
+	return CapitalAccount + currency
+}
+
+/*
+Returns the account of org. Accounts before the journal (a bare balance) are returned as an Account.
+*/
+func GetAccountState(stub shim.ChaincodeStubInterface, org string) (Account, error) {
+	accbytes, err := GetAccount(stub, org)
+	if err != nil {
+		return Account{}, err
+	}
+	if accbytes == nil {
+		return Account{}, fmt.Errorf("Account of %s doesn't exist. Please call initLedger", org)
+	}
+	acc := NewAccount(org)
+	if err = json.Unmarshal(accbytes, &acc.Balance); err == nil {
+		return acc, nil
+	}
+	if err = json.Unmarshal(accbytes, &acc); err != nil {
+		return Account{}, fmt.Errorf("Account of %s is corrupted", org)
+	}
+	return acc, nil
+}
+
+func PutAccountState(stub shim.ChaincodeStubInterface, acc Account) error {
+	accbytes, _ := json.Marshal(acc)
+	if err := PutAccount(stub, acc.Org, accbytes); err != nil {
+		return fmt.Errorf("Failed to add new amount for %s org", acc.Org)
+	}
+	return nil
+}
+
+/*
+Posts the payments as journal entries referencing the asset reference and updates the balances.
+A tx doesn't read its own writes, so all the payments of a tx should be posted with one call.
+Nothing is put in db if a payer doesn't have the funds for its payments (see credit.go).
+*/
+func Post(stub shim.ChaincodeStubInterface, reference, memo string, payments ...Payment) ([]JournalEntry, error) {
+	accounts := map[string]*Account{}
+	for _, p := range payments {
+		for _, org := range []string{p.Payer, p.Payee} {
+			if _, ok := accounts[org]; ok {
+				continue
+			}
+			acc, err := GetAccountState(stub, org)
+			if err != nil {
+				return nil, err
+			}
+			accounts[org] = &acc
+		}
+	}
+	return postEntries(stub, accounts, reference, memo, payments)
+}
+
+//posts the payments between the given accounts and puts the accounts in db.
+func postEntries(stub shim.ChaincodeStubInterface, accounts map[string]*Account, reference, memo string, payments []Payment) ([]JournalEntry, error) {
+	tstamp, err := TxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	txID := stub.GetTxID()
+	entries := []JournalEntry{}
+	payers := []*Account{}
+	for i, p := range payments {
+		if p.Amount < 0 {
+			return nil, errors.New("Amounts to be paid should be positive")
+		}
+		if p.Payer == p.Payee {
+			return nil, fmt.Errorf("%s can't pay itself", p.Payer)
+		}
+		debit, credit := accounts[p.Payer], accounts[p.Payee]
+		currency := p.Currency
+		if currency == "" {
+			currency = debit.Currency
+		}
+		debitAmount, err := Convert(stub, p.Amount, currency, debit.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("Payment of %s in %s: %s", p.Payer, currency, err)
+		}
+		creditAmount, err := Convert(stub, p.Amount, currency, credit.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("Payment to %s in %s: %s", p.Payee, currency, err)
+		}
+		e := JournalEntry{fmt.Sprintf("%s.%04d", txID, i), txID, tstamp, p.Payer, p.Payee, p.Amount, currency,
+			debitAmount, creditAmount, reference, memo, p.Rule}
+		debit.Balance -= debitAmount
+		credit.Balance += creditAmount
+		for _, acc := range []*Account{debit, credit} {
+			acc.Entries++
+			acc.LastTxID = txID
+		}
+		if debitAmount > 0 {
+			payers = append(payers, debit)
+		}
+		entries = append(entries, e)
+	}
+	//the balances after all the payments, so that a payer can be paid and pay in the same tx
+	for _, acc := range payers {
+		if err = CheckFunds(*acc); err != nil {
+			return nil, err
+		}
+	}
+	for i, e := range entries {
+		if err = putJournalEntry(stub, e); err != nil {
+			return nil, err
+		}
+		Emit(stub, EventPaymentSettled, payments[i].Payer, payments[i])
+	}
+	orgs := make([]string, 0, len(accounts))
+	for org := range accounts {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+	for _, org := range orgs {
+		if err = PutAccountState(stub, *accounts[org]); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+func putJournalEntry(stub shim.ChaincodeStubInterface, e JournalEntry) error {
+	ebytes, _ := json.Marshal(e)
+	keys := [][]string{
+		{journalObjectType, e.Debit, e.Timestamp.Format(journalTimeFormat), e.ID},
+		{journalObjectType, e.Credit, e.Timestamp.Format(journalTimeFormat), e.ID},
+	}
+	if e.Reference != "" {
+		keys = append(keys, []string{journalRefObjectType, e.Reference, e.ID})
+	}
+	for _, k := range keys {
+		key, err := stub.CreateCompositeKey(k[0], k[1:])
+		if err != nil {
+			return fmt.Errorf("Failed to create key of journal entry %s", e.ID)
+		}
+		if err = stub.PutState(key, ebytes); err != nil {
+			return fmt.Errorf("Failed to put journal entry %s in db", e.ID)
+		}
+	}
+	return nil
+}
+
+func getJournalEntries(stub shim.ChaincodeStubInterface, objectType string, attrs []string) ([]JournalEntry, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(objectType, attrs)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	entries := []JournalEntry{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		e := JournalEntry{}
+		if err = json.Unmarshal(kv.Value, &e); err != nil {
+			return nil, errors.New("Journal entry is corrupted")
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+/*
+Statement of the account of org inside [from,to]. A zero from/to means no bound.
+*/
+func GetAccountStatement(stub shim.ChaincodeStubInterface, org string, from, to time.Time) (AccountStatement, error) {
+	acc, err := GetAccountState(stub, org)
+	if err != nil {
+		return AccountStatement{}, err
+	}
+	entries, err := getJournalEntries(stub, journalObjectType, []string{org})
+	if err != nil {
+		return AccountStatement{}, err
+	}
+	//the entries are in the order of time, so the balance before them is the opening balance of the account
+	balance := acc.Balance
+	for _, e := range entries {
+		balance -= e.change(org)
+	}
+	st := AccountStatement{org, acc.Currency, from, to, 0, 0, []StatementLine{}}
+	for _, e := range entries {
+		if !from.IsZero() && e.Timestamp.Before(from) {
+			balance += e.change(org)
+			continue
+		}
+		if !to.IsZero() && e.Timestamp.After(to) {
+			break
+		}
+		if len(st.Lines) == 0 {
+			st.OpeningBalance = balance
+		}
+		balance += e.change(org)
+		st.Lines = append(st.Lines, StatementLine{e, e.change(org), balance})
+	}
+	if len(st.Lines) == 0 {
+		st.OpeningBalance = balance
+	}
+	st.ClosingBalance = balance
+	return st, nil
+}
+
+//how much the entry changes the balance of org, in the currency of its account
+func (e JournalEntry) change(org string) Money {
+	if e.Credit == org {
+		return e.CreditAmount
+	}
+	return -e.DebitAmount
+}
+
+/*
+args[0] = org
+args[1] = from , args[2] = to (optional, RFC3339). An empty string leaves that side open.
+*/
+func (s *SmartContract) getAccountStatement(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) < 1 || len(args) > 3 {
+		return shim.Error("Expecting 1 to 3 args")
+	}
+	var from, to time.Time
+	var err error
+	if len(args) > 1 && args[1] != "" {
+		if from, err = RFCtoTime(args[1]); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if len(args) > 2 && args[2] != "" {
+		if to, err = RFCtoTime(args[2]); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return shim.Error("End of time window is before its start")
+	}
+	st, err := GetAccountStatement(stub, args[0], from, to)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	stAsBytes, _ := json.Marshal(st)
+	return shim.Success(stAsBytes)
+}
+
+/*
+args[0] = ID of the asset the payments are for
+*/
+func (s *SmartContract) queryJournalByReference(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 arg")
+	}
+	entries, err := getJournalEntries(stub, journalRefObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	entriesAsBytes, _ := json.Marshal(entries)
+	return shim.Success(entriesAsBytes)
+}
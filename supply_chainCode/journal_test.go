@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAccountStatement(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.newCrude()
+	n.as("Org3MSP").ok("transfer", crudeID, "org3", crudeEst)
+
+	st := AccountStatement{}
+	n.query(&st, "getAccountStatement", "org1")
+	if len(st.Lines) != 2 || st.OpeningBalance != 0 || st.ClosingBalance != openingBalance+money("1000.00") {
+		t.Fatalf("Statement of org1 is %+v", st)
+	}
+	if l := st.Lines[1]; l.Entry.Reference != crudeID || l.Change != money("1000.00") || l.Balance != st.ClosingBalance {
+		t.Errorf("Payment of %s to org1 is %+v", crudeID, l)
+	}
+	//the entries are in the time of the txs, after 2001
+	n.query(&st, "getAccountStatement", "org1", "2000-01-01T00:00:00Z", "2001-01-01T00:00:00Z")
+	if len(st.Lines) != 0 || st.ClosingBalance != 0 {
+		t.Errorf("Statement of org1 in 2000 is %+v", st)
+	}
+	n.query(&st, "getAccountStatement", "org1", "", "")
+	if len(st.Lines) != 2 {
+		t.Errorf("Statement of org1 without bounds has %d lines", len(st.Lines))
+	}
+	n.fails("Expecting 1 to 3 args", "getAccountStatement")
+	n.fails("Expecting 1 to 3 args", "getAccountStatement", "org1", "", "", "")
+	n.fails("RFC3339", "getAccountStatement", "org1", "2019-01-01")
+	n.fails("RFC3339", "getAccountStatement", "org1", "", "2019-01-01")
+	n.fails("End of time window is before its start", "getAccountStatement", "org1", "2019-01-02T00:00:00Z", "2019-01-01T00:00:00Z")
+	n.fails("Account of org9 doesn't exist", "getAccountStatement", "org9")
+
+	var entries []JournalEntry
+	n.query(&entries, "queryJournalByReference", crudeID)
+	//the escrow, the payments to the shipper and the driller
+	if len(entries) != 3 {
+		t.Errorf("%s has %d journal entries, expecting 3", crudeID, len(entries))
+	}
+	//the IDs of the entries of a tx are zero-padded, so they sort in the order they were posted
+	for _, e := range entries {
+		if strings.HasPrefix(e.ID, e.TxID+".") == false || len(e.ID) != len(e.TxID)+5 {
+			t.Errorf("ID of the journal entry %+v isn't txID.NNNN", e)
+		}
+	}
+	n.query(&entries, "queryJournalByReference", "Crude0000000009")
+	if len(entries) != 0 {
+		t.Errorf("Crude0000000009 has %d journal entries", len(entries))
+	}
+	n.fails("Expecting 1 arg", "queryJournalByReference")
+}
@@ -0,0 +1,430 @@
+/*
+Storage layout.
+
+Assets are put in db under composite keys (type, ID), e.g. (FuelOrder, FuelOrder0000000003), and org accounts
+under (Account, org). Next to every Crude, Fuel and FuelOrder there are index entries
+
+	owner~type~id        (owner, type, ID)
+	state~type~id        (state, type, ID)
+	destination~type~id  (destination, type, ID) - Dest of a FuelOrder, DD.Destination of a Crude
+
+with an empty value, kept up to date by PutAsset, as are the lineage indexes of Fuels, FuelOrders and Plans
+(see lineage.go). PutAsset also sets the docType field of the value to the type of the asset, which the rich
+queries select the type on (see richquery.go). Partial composite key queries work on both LevelDB and CouchDB, so the assets of a type,
+owner, state or destination are found without lexical ranges of IDs.
+Receipts, disputes, vehicles and the settings of the chaincode stay under plain keys.
+
+Ledgers created before this layout have their assets and accounts under plain keys ('Crude12', 'org1').
+migrateKeys moves them to composite keys. It should be called once, right after upgrading the chaincode.
+An upgrade that adds an index needs reindexAssets once, to build the entries of the assets already in db.
+It also adds the docType to the assets put before it was set.
+
+API:
+
+queryAssetsByOwner - args[0] = owner, args[1] = type (optional), args[2..3] = page size, bookmark (see page.go)
+queryAssetsByState - args[0] = state, args[1] = type (optional), args[2..3] = page size, bookmark
+queryAssetsByDestination - args[0] = destination, args[1] = type (optional), args[2..3] = page size, bookmark
+migrateKeys - admin only. args = types to migrate, {Crude,Fuel,FuelOrder,Plan,Account} (all if none)
+reindexAssets - admin only. args = types to reindex, {Crude,Fuel,FuelOrder,Plan} (all if none)
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"strings"
+)
+
+const TypeAccount = "Account"
+
+const (
+	IndexOwner       = "owner~type~id"
+	IndexState       = "state~type~id"
+	IndexDestination = "destination~type~id"
+)
+
+//field of the value of an asset with its type
+const DocTypeField = "docType"
+
+/*
+An asset as returned by the queries. Key is the ID of the asset.
+*/
+type AssetRecord struct {
+	Key    string
+	Record json.RawMessage
+}
+
+/*
+Type of an asset from its ID, e.g. 'FuelOrder' for 'FuelOrder0000000003'. Empty if it's not an asset ID.
+*/
+func AssetType(id string) string {
+	//FuelOrder before Fuel, as both are prefixes of a FuelOrder ID
+	for _, typ := range []string{TypeCrude, TypeFuelOrder, TypeFuel, TypePlan} {
+		if strings.HasPrefix(id, typ) {
+			return typ
+		}
+	}
+	return ""
+}
+
+func AssetKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	typ := AssetType(id)
+	if typ == "" {
+		return "", fmt.Errorf("%s is not the ID of a {Crude,Fuel,FuelOrder,Plan}", id)
+	}
+	return stub.CreateCompositeKey(typ, []string{id})
+}
+
+/*
+Returns the asset with this ID or nil if there is none.
+*/
+func GetAsset(stub shim.ChaincodeStubInterface, id string) ([]byte, error) {
+	key, err := AssetKey(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	abytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s", id)
+	}
+	return abytes, nil
+}
+
+/*
+Puts the asset in db and moves its index entries from the previous state of the asset to the new one.
+*/
+func PutAsset(stub shim.ChaincodeStubInterface, id string, value []byte) error {
+	key, err := AssetKey(stub, id)
+	if err != nil {
+		return err
+	}
+	old, err := stub.GetState(key)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s", id)
+	}
+	value, err = withDocType(id, value)
+	if err != nil {
+		return err
+	}
+	oldEntries, err := indexEntries(stub, id, old)
+	if err != nil {
+		return err
+	}
+	newEntries, err := indexEntries(stub, id, value)
+	if err != nil {
+		return err
+	}
+	for _, e := range oldEntries {
+		if containsString(newEntries, e) == false {
+			if err = stub.DelState(e); err != nil {
+				return fmt.Errorf("Failed to delete index entry of %s", id)
+			}
+		}
+	}
+	for _, e := range newEntries {
+		if containsString(oldEntries, e) == false {
+			if err = stub.PutState(e, []byte{0x00}); err != nil {
+				return fmt.Errorf("Failed to put index entry of %s in db", id)
+			}
+		}
+	}
+	return stub.PutState(key, value)
+}
+
+//the value of the asset with its type in DocTypeField
+func withDocType(id string, value []byte) ([]byte, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(value, &fields); err != nil {
+		return nil, fmt.Errorf("Failed to decode %s", id)
+	}
+	fields[DocTypeField], _ = json.Marshal(AssetType(id))
+	value, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode %s", id)
+	}
+	return value, nil
+}
+
+func hasDocType(value []byte) bool {
+	doc := map[string]json.RawMessage{}
+	json.Unmarshal(value, &doc)
+	_, ok := doc[DocTypeField]
+	return ok
+}
+
+//composite keys of the index entries of an asset. Only lineage entries for plans, none for a missing asset.
+func indexEntries(stub shim.ChaincodeStubInterface, id string, value []byte) ([]string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	entries, err := lineageEntries(stub, id, value)
+	if err != nil {
+		return nil, err
+	}
+	//the fields the indexes are built on, whatever the type of the asset
+	asset := struct {
+		AD   *AssetDetails
+		DD   *DeliveryDetails
+		Dest string
+	}{}
+	if err := json.Unmarshal(value, &asset); err != nil {
+		return nil, fmt.Errorf("Failed to decode %s", id)
+	}
+	if asset.AD == nil {
+		return entries, nil
+	}
+	typ := AssetType(id)
+	attrs := [][]string{
+		{IndexOwner, asset.AD.Owner},
+		{IndexState, asset.AD.State},
+	}
+	if asset.Dest != "" {
+		attrs = append(attrs, []string{IndexDestination, asset.Dest})
+	} else if asset.DD != nil && asset.DD.Destination != "" {
+		attrs = append(attrs, []string{IndexDestination, asset.DD.Destination})
+	}
+	for _, a := range attrs {
+		e, err := stub.CreateCompositeKey(a[0], []string{a[1], typ, id})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create index entry of %s: %s", id, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func AccountKey(stub shim.ChaincodeStubInterface, org string) (string, error) {
+	return stub.CreateCompositeKey(TypeAccount, []string{org})
+}
+
+/*
+Returns the account of org or nil if there is none.
+*/
+func GetAccount(stub shim.ChaincodeStubInterface, org string) ([]byte, error) {
+	key, err := AccountKey(stub, org)
+	if err != nil {
+		return nil, err
+	}
+	accbytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read account of %s", org)
+	}
+	return accbytes, nil
+}
+
+func PutAccount(stub shim.ChaincodeStubInterface, org string, value []byte) error {
+	key, err := AccountKey(stub, org)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, value)
+}
+
+/*
+Calls fn with the ID and the value of every asset of type typ, in the order they were created in (see ids.go).
+*/
+func getAssetsOfType(stub shim.ChaincodeStubInterface, typ string, fn func(id string, value []byte) error) error {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(typ, []string{})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		_, attrs, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil || len(attrs) != 1 {
+			return fmt.Errorf("Invalid key of %s", typ)
+		}
+		if err = fn(attrs[0], kv.Value); err != nil {
+			return fmt.Errorf("Failed to decode %s", attrs[0])
+		}
+	}
+	return nil
+}
+
+/*
+Returns a page of the assets that have value in index, e.g. the assets with owner org5 (see page.go).
+If typ isn't empty only assets of that type are returned.
+*/
+func GetAssetsByIndex(stub shim.ChaincodeStubInterface, index, value, typ string, pageSize int32, bookmark string) (Page, error) {
+	attrs := []string{value}
+	if typ != "" {
+		attrs = append(attrs, typ)
+	}
+	resultsIterator, md, err := stub.GetStateByPartialCompositeKeyWithPagination(index, attrs, pageSize, bookmark)
+	return readPage(resultsIterator, md, err, func(key string, _ []byte) (AssetRecord, error) {
+		_, attrs, err := stub.SplitCompositeKey(key)
+		if err != nil || len(attrs) != 3 {
+			return AssetRecord{}, fmt.Errorf("Invalid entry of index %s", index)
+		}
+		abytes, err := GetAsset(stub, attrs[2])
+		if err != nil {
+			return AssetRecord{}, err
+		}
+		if abytes == nil {
+			return AssetRecord{}, fmt.Errorf("Index %s points to %s which doesn't exist", index, attrs[2])
+		}
+		return AssetRecord{attrs[2], abytes}, nil
+	})
+}
+
+func (s *SmartContract) queryAssetsByOwner(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	return queryAssetsByIndex(stub, IndexOwner, args)
+}
+
+func (s *SmartContract) queryAssetsByState(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	return queryAssetsByIndex(stub, IndexState, args)
+}
+
+func (s *SmartContract) queryAssetsByDestination(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	return queryAssetsByIndex(stub, IndexDestination, args)
+}
+
+/*
+args[0] = value of the index
+args[1] = type of the assets (optional, empty for all types)
+args[2] = page size, args[3] = bookmark (optional, see page.go)
+*/
+func queryAssetsByIndex(stub shim.ChaincodeStubInterface, index string, args []string) sc.Response {
+	if len(args) < 1 {
+		return shim.Error("Expecting at least 1 arg")
+	}
+	if args[0] == "" {
+		return shim.Error("Value to look for should not be empty")
+	}
+	typ := ""
+	if len(args) > 1 {
+		typ = args[1]
+		if typ != "" && typ != TypeCrude && typ != TypeFuel && typ != TypeFuelOrder {
+			return shim.Error("Type should be one of {Crude,Fuel,FuelOrder}")
+		}
+	}
+	var pageArgs []string
+	if len(args) > 2 {
+		pageArgs = args[2:]
+	}
+	pageSize, bookmark, err := PageArgs(pageArgs)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	page, err := GetAssetsByIndex(stub, index, args[0], typ, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageAsBytes, _ := json.Marshal(page)
+	return shim.Success(pageAsBytes)
+}
+
+/*
+Moves the assets and accounts of a ledger with plain keys to composite keys and builds their indexes.
+args = types to migrate (all if none). Returns how many keys of each type were moved.
+Keys that are already migrated are not touched, so it can be called again (e.g. per type on a big ledger).
+*/
+func (s *SmartContract) migrateKeys(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	types := args
+	if len(types) == 0 {
+		types = []string{TypeCrude, TypeFuel, TypeFuelOrder, TypePlan, TypeAccount}
+	}
+	moved := map[string]int{}
+	for _, typ := range types {
+		var n int
+		var err error
+		switch {
+		case typ == TypeAccount:
+			n, err = migratePlainKeys(stub, "org", func(key string, value []byte) error {
+				return PutAccount(stub, key, value)
+			})
+		case IsAssetType(typ):
+			//plain Fuel keys are followed by digits, so FuelOrders aren't in the range of Fuel
+			n, err = migratePlainKeys(stub, typ, func(key string, value []byte) error {
+				return PutAsset(stub, key, value)
+			})
+		default:
+			return shim.Error(fmt.Sprintf("Unknown type %s", typ))
+		}
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		moved[typ] = n
+	}
+	movedAsBytes, _ := json.Marshal(moved)
+	return shim.Success(movedAsBytes)
+}
+
+//calls put for every plain key prefix+digits and deletes the plain key.
+func migratePlainKeys(stub shim.ChaincodeStubInterface, prefix string, put func(key string, value []byte) error) (int, error) {
+	resultsIterator, err := stub.GetStateByRange(prefix+"0", prefix+":")
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+	n := 0
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return n, err
+		}
+		if err = put(kv.Key, kv.Value); err != nil {
+			return n, err
+		}
+		if err = stub.DelState(kv.Key); err != nil {
+			return n, errors.New("Failed to delete plain key " + kv.Key)
+		}
+		n++
+	}
+	return n, nil
+}
+
+/*
+Puts the index entries of every asset of the types again, and the docType of those without it.
+args = types to reindex (all if none).
+Returns how many assets of each type were reindexed. Entries that are there already are only written again,
+so it can be called again (e.g. per type on a big ledger).
+*/
+func (s *SmartContract) reindexAssets(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	types := args
+	if len(types) == 0 {
+		types = []string{TypeCrude, TypeFuel, TypeFuelOrder, TypePlan}
+	}
+	reindexed := map[string]int{}
+	for _, typ := range types {
+		if IsAssetType(typ) == false {
+			return shim.Error(fmt.Sprintf("Unknown type %s", typ))
+		}
+		err := getAssetsOfType(stub, typ, func(id string, value []byte) error {
+			entries, err := indexEntries(stub, id, value)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if err = stub.PutState(e, []byte{0x00}); err != nil {
+					return err
+				}
+			}
+			if hasDocType(value) == false {
+				if err = PutAsset(stub, id, value); err != nil {
+					return err
+				}
+			}
+			reindexed[typ]++
+			return nil
+		})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	reindexedAsBytes, _ := json.Marshal(reindexed)
+	return shim.Success(reindexedAsBytes)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+//the indexes follow the owner and the state of an asset
+func TestIndexes(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.newCrude()
+	n.as("Org3MSP").ok("transfer", crudeID, "org3", crudeEst)
+	ids := func(index string, attrs ...string) []string {
+		it, err := n.stub.GetStateByPartialCompositeKey(index, attrs)
+		if err != nil {
+			t.Fatalf("Failed to read index %s: %s", index, err)
+		}
+		defer it.Close()
+		found := []string{}
+		for it.HasNext() {
+			kv, _ := it.Next()
+			_, keyAttrs, _ := n.stub.SplitCompositeKey(kv.Key)
+			found = append(found, keyAttrs[len(keyAttrs)-1])
+		}
+		return found
+	}
+	if found := ids(IndexOwner, "org3", TypeCrude); len(found) != 1 || found[0] != crudeID {
+		t.Errorf("Crudes of org3 are %v", found)
+	}
+	if found := ids(IndexOwner, "org1"); len(found) != 0 {
+		t.Errorf("Assets of org1 are %v", found)
+	}
+	if found := ids(IndexState, "DELIVERED"); len(found) != 1 {
+		t.Errorf("Delivered assets are %v", found)
+	}
+	if found := ids(IndexState, "ON_WAY"); len(found) != 0 {
+		t.Errorf("Assets on their way are %v", found)
+	}
+	if found := ids(IndexDestination, "org3", TypeCrude); len(found) != 1 {
+		t.Errorf("Crudes to org3 are %v", found)
+	}
+}
+
+func TestMigrateKeys(t *testing.T) {
+	n := newTestNet(t)
+	//a ledger before composite keys
+	n.stub.MockTransactionStart("legacy")
+	n.stub.PutState("Crude7", []byte(`{"AD":{"Value":"10.00","Quantity":10,"Owner":"org1","State":"ON_WAY"},"DD":{"StartingLocation":"org1","Destination":"org3"}}`))
+	n.stub.PutState("org9", []byte(`{"Balance":"0"}`))
+	n.stub.MockTransactionEnd("legacy")
+
+	n.as("Org3MSP").fails("Access denied", "migrateKeys")
+	n.as("Org1MSP").fails("Unknown type Truck", "migrateKeys", "Truck")
+	moved := map[string]int{}
+	n.query(&moved, "migrateKeys")
+	if moved[TypeCrude] != 1 || moved[TypeFuel] != 0 || moved[TypeAccount] != 1 {
+		t.Errorf("Moved %v", moved)
+	}
+	if abytes, _ := n.stub.GetState("Crude7"); abytes != nil {
+		t.Errorf("Plain key Crude7 is still in db")
+	}
+	if state := n.state("Crude7"); state != "ON_WAY" {
+		t.Errorf("Crude7 is %s after the migration", state)
+	}
+	//again, with nothing left to move
+	n.query(&moved, "migrateKeys", TypeCrude)
+	if moved[TypeCrude] != 0 {
+		t.Errorf("Moved %d crudes a second time", moved[TypeCrude])
+	}
+}
+
+func TestReindexAssets(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.newCrude()
+	fuelID := n.newFuel(crudeID)
+	orderID := n.newFuelOrder(fuelID, "org5")
+	planID := n.newPlan(orderID)
+	//a ledger from before the lineage indexes
+	n.stub.MockTransactionStart("legacy")
+	for _, index := range []string{IndexCrudeFuel, IndexFuelOrder, IndexOrderPlan} {
+		it, _ := n.stub.GetStateByPartialCompositeKey(index, []string{})
+		for it.HasNext() {
+			kv, _ := it.Next()
+			n.stub.DelState(kv.Key)
+		}
+		it.Close()
+	}
+	//and before the docType
+	key, _ := AssetKey(n.stub, crudeID)
+	abytes, _ := n.stub.GetState(key)
+	doc := map[string]interface{}{}
+	json.Unmarshal(abytes, &doc)
+	if doc[DocTypeField] != TypeCrude {
+		t.Errorf("docType of %s is %v", crudeID, doc[DocTypeField])
+	}
+	delete(doc, DocTypeField)
+	abytes, _ = json.Marshal(doc)
+	n.stub.PutState(key, abytes)
+	n.stub.MockTransactionEnd("legacy")
+	lineage := Lineage{}
+	n.query(&lineage, "traceLineage", planID)
+	if len(lineage.Crudes) != 1 || len(lineage.Crudes[0].Fuels) != 0 {
+		t.Fatalf("Lineage without the indexes is %+v", lineage)
+	}
+
+	n.as("Org3MSP").fails("Access denied", "reindexAssets")
+	n.as("Org1MSP").fails("Unknown type Account", "reindexAssets", TypeAccount)
+	reindexed := map[string]int{}
+	n.query(&reindexed, "reindexAssets")
+	if reindexed[TypeCrude] != 1 || reindexed[TypeFuel] != 1 || reindexed[TypeFuelOrder] != 1 || reindexed[TypePlan] != 1 {
+		t.Errorf("Reindexed %v", reindexed)
+	}
+	n.query(&lineage, "traceLineage", planID)
+	if fuels := lineage.Crudes[0].Fuels; len(fuels) != 1 || len(fuels[0].Orders) != 1 || len(fuels[0].Orders[0].Plans) != 1 {
+		t.Errorf("Lineage after reindexAssets is %+v", lineage)
+	}
+	abytes, _ = n.stub.GetState(key)
+	if hasDocType(abytes) == false {
+		t.Errorf("%s has no docType after reindexAssets", crudeID)
+	}
+}
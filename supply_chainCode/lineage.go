@@ -0,0 +1,302 @@
+/*
+Lineage of assets.
+
+The data model links FuelOrder.FuelID -> Fuel.CrudeID -> Crude and a Plan holds FuelOrders.
+traceLineage takes any asset key (Crude, Fuel, FuelOrder or Plan), finds the Crude(s) it comes from
+and returns the whole tree under them: which fuels were refined from each crude, which orders were cut
+from each fuel and which plans carried each order, with the delivery details and payments of every hop.
+
+The parents are found by their IDs and the children by the lineage indexes, kept up to date by PutAsset
+next to the other index entries of an asset (see keys.go):
+
+	crude~fuel   (CrudeID, FuelID)
+	fuel~order   (FuelID, FuelOrderID)
+	order~plan   (FuelOrderID, PlanID) - one per order of the plan
+
+so a lineage reads the assets of its tree only. Ledgers with assets from before the lineage indexes
+need reindexAssets once (see keys.go).
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"sort"
+)
+
+const (
+	IndexCrudeFuel = "crude~fuel"
+	IndexFuelOrder = "fuel~order"
+	IndexOrderPlan = "order~plan"
+)
+
+type LineagePlan struct {
+	ID       string
+	Veh      Vehicle
+	Delivery DeliveryDetails //delivery details of the order in this plan
+}
+
+type LineageOrder struct {
+	ID    string
+	Order FuelOrder
+	Plans []LineagePlan
+}
+
+type LineageFuel struct {
+	ID     string
+	Fuel   Fuel
+	Orders []LineageOrder
+}
+
+type LineageCrude struct {
+	ID    string
+	Crude Crude
+	Fuels []LineageFuel
+}
+
+/*
+Key is the asset that was traced. Crudes are the roots of the trees it belongs to
+(a Plan may carry orders that come from different crudes).
+*/
+type Lineage struct {
+	Key    string
+	Crudes []LineageCrude
+}
+
+/*
+args[0] = ID of a Crude, Fuel, FuelOrder or Plan
+*/
+func (s *SmartContract) traceLineage(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 arg")
+	}
+	lineage, err := TraceLineage(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	lineageAsBytes, _ := json.Marshal(lineage)
+	return shim.Success(lineageAsBytes)
+}
+
+func TraceLineage(stub shim.ChaincodeStubInterface, key string) (Lineage, error) {
+	//find the crude(s) the asset comes from, following the IDs of its parents
+	roots := map[string]bool{}
+	switch AssetType(key) {
+	case TypeCrude:
+		crude := Crude{}
+		if ok, err := getAssetAs(stub, key, &crude); err != nil || !ok {
+			return Lineage{}, notLocated(key, err)
+		}
+		roots[key] = true
+	case TypeFuel:
+		fuel := Fuel{}
+		if ok, err := getAssetAs(stub, key, &fuel); err != nil || !ok {
+			return Lineage{}, notLocated(key, err)
+		}
+		roots[fuel.CrudeID] = true
+	case TypeFuelOrder:
+		order := FuelOrder{}
+		if ok, err := getAssetAs(stub, key, &order); err != nil || !ok {
+			return Lineage{}, notLocated(key, err)
+		}
+		crudeID, err := crudeOfFuel(stub, order.FuelID)
+		if err != nil {
+			return Lineage{}, err
+		}
+		roots[crudeID] = true
+	case TypePlan:
+		plan := FuelDeliveryPlan{}
+		if ok, err := getAssetAs(stub, key, &plan); err != nil || !ok {
+			return Lineage{}, notLocated(key, err)
+		}
+		for orderID := range plan.Plan {
+			order := FuelOrder{}
+			if _, err := getAssetAs(stub, orderID, &order); err != nil {
+				return Lineage{}, err
+			}
+			crudeID, err := crudeOfFuel(stub, order.FuelID)
+			if err != nil {
+				return Lineage{}, err
+			}
+			roots[crudeID] = true
+		}
+	default:
+		return Lineage{}, fmt.Errorf("Key should be one of {Crude,Fuel,FuelOrder,Plan}XXXX")
+	}
+
+	crudeIDs := []string{}
+	for crudeID := range roots {
+		if crudeID != "" {
+			crudeIDs = append(crudeIDs, crudeID)
+		}
+	}
+	sort.Strings(crudeIDs)
+
+	//and the children of each crude from the lineage indexes
+	lineage := Lineage{key, []LineageCrude{}}
+	plans := map[string]FuelDeliveryPlan{}
+	for _, crudeID := range crudeIDs {
+		lc := LineageCrude{crudeID, Crude{}, []LineageFuel{}}
+		ok, err := getAssetAs(stub, crudeID, &lc.Crude)
+		if err != nil {
+			return Lineage{}, err
+		}
+		if !ok {
+			continue
+		}
+		fuelIDs, err := GetChildIDs(stub, IndexCrudeFuel, crudeID)
+		if err != nil {
+			return Lineage{}, err
+		}
+		for _, fuelID := range fuelIDs {
+			lf, err := traceFuel(stub, fuelID, plans)
+			if err != nil {
+				return Lineage{}, err
+			}
+			lc.Fuels = append(lc.Fuels, lf)
+		}
+		lineage.Crudes = append(lineage.Crudes, lc)
+	}
+	return lineage, nil
+}
+
+//the orders of a fuel and their plans. plans caches the plans read so far, as a plan carries many orders.
+func traceFuel(stub shim.ChaincodeStubInterface, fuelID string, plans map[string]FuelDeliveryPlan) (LineageFuel, error) {
+	lf := LineageFuel{fuelID, Fuel{}, []LineageOrder{}}
+	if _, err := getAssetAs(stub, fuelID, &lf.Fuel); err != nil {
+		return lf, err
+	}
+	orderIDs, err := GetChildIDs(stub, IndexFuelOrder, fuelID)
+	if err != nil {
+		return lf, err
+	}
+	for _, orderID := range orderIDs {
+		lo := LineageOrder{orderID, FuelOrder{}, []LineagePlan{}}
+		if _, err = getAssetAs(stub, orderID, &lo.Order); err != nil {
+			return lf, err
+		}
+		planIDs, err := GetChildIDs(stub, IndexOrderPlan, orderID)
+		if err != nil {
+			return lf, err
+		}
+		for _, planID := range planIDs {
+			plan, ok := plans[planID]
+			if !ok {
+				if _, err = getAssetAs(stub, planID, &plan); err != nil {
+					return lf, err
+				}
+				plans[planID] = plan
+			}
+			if dd, ok := plan.Plan[orderID]; ok {
+				lo.Plans = append(lo.Plans, LineagePlan{planID, plan.Veh, dd})
+			}
+		}
+		lf.Orders = append(lf.Orders, lo)
+	}
+	return lf, nil
+}
+
+//the crude a fuel was refined from, empty if the fuel doesn't exist
+func crudeOfFuel(stub shim.ChaincodeStubInterface, fuelID string) (string, error) {
+	fuel := Fuel{}
+	if _, err := getAssetAs(stub, fuelID, &fuel); err != nil {
+		return "", err
+	}
+	return fuel.CrudeID, nil
+}
+
+/*
+Decodes the asset with this ID into v. Returns false if there is no such asset.
+*/
+func getAssetAs(stub shim.ChaincodeStubInterface, id string, v interface{}) (bool, error) {
+	if id == "" {
+		return false, nil
+	}
+	abytes, err := GetAsset(stub, id)
+	if err != nil || abytes == nil {
+		return false, err
+	}
+	if err = json.Unmarshal(abytes, v); err != nil {
+		return false, fmt.Errorf("Failed to decode %s", id)
+	}
+	return true, nil
+}
+
+func notLocated(id string, err error) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("Could not locate %s", id)
+}
+
+/*
+Composite keys of the lineage index entries of an asset, (parent, ID) in the index of its parent type:
+a Fuel under its Crude, a FuelOrder under its Fuel and a Plan under each of its orders.
+*/
+func lineageEntries(stub shim.ChaincodeStubInterface, id string, value []byte) ([]string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	//the parent IDs, whatever the type of the asset
+	asset := struct {
+		CrudeID string
+		FuelID  string
+		Plan    map[string]json.RawMessage
+	}{}
+	if err := json.Unmarshal(value, &asset); err != nil {
+		return nil, fmt.Errorf("Failed to decode %s", id)
+	}
+	var index string
+	var parents []string
+	switch AssetType(id) {
+	case TypeFuel:
+		index, parents = IndexCrudeFuel, []string{asset.CrudeID}
+	case TypeFuelOrder:
+		index, parents = IndexFuelOrder, []string{asset.FuelID}
+	case TypePlan:
+		index = IndexOrderPlan
+		for orderID := range asset.Plan {
+			parents = append(parents, orderID)
+		}
+		sort.Strings(parents)
+	}
+	entries := []string{}
+	for _, parent := range parents {
+		if parent == "" {
+			continue
+		}
+		e, err := stub.CreateCompositeKey(index, []string{parent, id})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create index entry of %s: %s", id, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+/*
+IDs of the children of an asset in a lineage index, e.g. the fuels refined from a crude in IndexCrudeFuel,
+in the order they were created in (see ids.go).
+*/
+func GetChildIDs(stub shim.ChaincodeStubInterface, index, parentID string) ([]string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(index, []string{parentID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	ids := []string{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attrs, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil || len(attrs) != 2 {
+			return nil, fmt.Errorf("Invalid entry of index %s", index)
+		}
+		ids = append(ids, attrs[1])
+	}
+	return ids, nil
+}
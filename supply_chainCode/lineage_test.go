@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTraceLineage(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.newCrude()
+	fuelID := n.newFuel(crudeID)
+	orderID := n.newFuelOrder(fuelID, "org5")
+	planID := n.newPlan(orderID)
+	//another tree, that the plan doesn't belong to
+	n.newFuel(n.newCrude())
+
+	for _, key := range []string{crudeID, fuelID, orderID, planID} {
+		lineage := Lineage{}
+		n.query(&lineage, "traceLineage", key)
+		if lineage.Key != key || len(lineage.Crudes) != 1 || lineage.Crudes[0].ID != crudeID {
+			t.Fatalf("Lineage of %s is %+v", key, lineage)
+		}
+		fuels := lineage.Crudes[0].Fuels
+		if len(fuels) != 1 || fuels[0].ID != fuelID || len(fuels[0].Orders) != 1 {
+			t.Fatalf("Fuels in the lineage of %s are %+v", key, fuels)
+		}
+		order := fuels[0].Orders[0]
+		if order.ID != orderID || len(order.Plans) != 1 || order.Plans[0].ID != planID || order.Plans[0].Delivery.Destination != "org5" {
+			t.Errorf("Order in the lineage of %s is %+v", key, order)
+		}
+	}
+	n.fails("Could not locate Crude0000000009", "traceLineage", "Crude0000000009")
+	n.fails("Could not locate FuelOrder0000000009", "traceLineage", "FuelOrder0000000009")
+	n.fails("Key should be one of {Crude,Fuel,FuelOrder,Plan}XXXX", "traceLineage", "Truck1")
+	n.fails("Expecting 1 arg", "traceLineage")
+}
@@ -0,0 +1,20 @@
+//go:build !fuelsim && !gateway
+// +build !fuelsim,!gateway
+
+package main
+
+import (
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//built with the fuelsim or gateway tag, the package is the simulator (see fuelsim.go) or the local REST
+//gateway (see gatewaysrv.go) instead
+func main() {
+
+	// Create a new Smart Contract
+	err := shim.Start(new(SmartContract))
+	if err != nil {
+		fmt.Printf("Error creating new Smart Contract: %s", err)
+	}
+}
@@ -0,0 +1,257 @@
+/*
+Package memstub is an in-memory ledger, to run the chaincode without a Fabric network: in its tests, in the
+fuelsim simulator and in the local gateway (see fuelsim.go and gatewaysrv.go of the chaincode). It isn't
+linked into the chaincode itself.
+
+Stub is shim.MockStub with what a peer does around a tx and MockStub doesn't: the writes of a failed tx are
+rolled back, the events of a tx are kept and the transient map of a tx is passed to the chaincode. The time of
+a tx is the wall clock, unless Now is set.
+The creator of a tx is Caller, with a certificate the stub issues itself, so the chaincode finds who called it
+with the client identity library as on a peer.
+It also pages the range and composite-key queries, in the order of the keys, with the key of the next record as
+bookmark. Like MockStub it has no rich or history queries.
+*/
+package memstub
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/msp"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"math/big"
+	"time"
+)
+
+type Stub struct {
+	*shim.MockStub
+	cc        shim.Chaincode
+	Now       time.Time            //time of the next txs, the wall clock if zero
+	Events    []*sc.ChaincodeEvent //of the last tx
+	Caller    string               //MSP ID of the creator of the next txs
+	args      [][]byte
+	transient map[string][]byte
+	writes    []memWrite //of the current tx, to roll it back
+	key       *ecdsa.PrivateKey
+	creators  map[string][]byte //serialized identities, by MSP ID
+}
+
+//a write of a tx and the value it replaced
+type memWrite struct {
+	collection string //empty for the world state
+	key        string
+	old        []byte //nil if the key didn't exist
+}
+
+func New(name string, cc shim.Chaincode) *Stub {
+	return &Stub{shim.NewMockStub(name, cc), cc, time.Time{}, nil, "", nil, nil, nil, nil, map[string][]byte{}}
+}
+
+/*
+Runs Init of the chaincode in tx txID. args[0] is the function, as in the args of a proposal.
+*/
+func (s *Stub) Init(txID string, args [][]byte) sc.Response {
+	return s.run(txID, args, nil, s.cc.Init)
+}
+
+/*
+Runs Invoke of the chaincode in tx txID. transient is the transient map of the tx (nil for none).
+*/
+func (s *Stub) Invoke(txID string, args [][]byte, transient map[string][]byte) sc.Response {
+	return s.run(txID, args, transient, s.cc.Invoke)
+}
+
+func (s *Stub) run(txID string, args [][]byte, transient map[string][]byte, tx func(shim.ChaincodeStubInterface) sc.Response) sc.Response {
+	s.MockTransactionStart(txID)
+	if s.Now.IsZero() == false {
+		s.TxTimestamp = &timestamp.Timestamp{Seconds: s.Now.Unix(), Nanos: int32(s.Now.Nanosecond())}
+	}
+	s.args, s.transient, s.writes = args, transient, nil
+	resp := tx(s)
+	if resp.Status >= shim.ERRORTHRESHOLD {
+		s.rollback()
+	}
+	s.MockTransactionEnd(txID)
+	s.Events = nil
+	for {
+		select {
+		case e := <-s.ChaincodeEventsChannel:
+			//a failed tx has no events
+			if resp.Status < shim.ERRORTHRESHOLD {
+				s.Events = append(s.Events, e)
+			}
+		default:
+			return resp
+		}
+	}
+}
+
+//undoes the writes of the current tx, the last one first
+func (s *Stub) rollback() {
+	for i := len(s.writes) - 1; i >= 0; i-- {
+		w := s.writes[i]
+		switch {
+		case w.collection != "" && w.old == nil:
+			delete(s.PvtState[w.collection], w.key)
+		case w.collection != "":
+			s.MockStub.PutPrivateData(w.collection, w.key, w.old)
+		case w.old == nil:
+			s.MockStub.DelState(w.key)
+		default:
+			s.MockStub.PutState(w.key, w.old)
+		}
+	}
+	s.writes = nil
+}
+
+func (s *Stub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *Stub) GetStringArgs() []string {
+	args := make([]string, 0, len(s.args))
+	for _, a := range s.args {
+		args = append(args, string(a))
+	}
+	return args
+}
+
+func (s *Stub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+func (s *Stub) GetTransient() (map[string][]byte, error) {
+	return s.transient, nil
+}
+
+/*
+The serialized identity of Caller, with a self-signed certificate of the stub.
+*/
+func (s *Stub) GetCreator() ([]byte, error) {
+	if creator, ok := s.creators[s.Caller]; ok {
+		return creator, nil
+	}
+	var err error
+	if s.key == nil {
+		if s.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, err
+		}
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(len(s.creators) + 1)),
+		Subject:      pkix.Name{CommonName: "user@" + s.Caller, Organization: []string{s.Caller}},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &s.key.PublicKey, s.key)
+	if err != nil {
+		return nil, err
+	}
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: s.Caller, IdBytes: cert})
+	if err != nil {
+		return nil, err
+	}
+	s.creators[s.Caller] = creator
+	return creator, nil
+}
+
+func (s *Stub) PutState(key string, value []byte) error {
+	old, _ := s.MockStub.GetState(key)
+	s.writes = append(s.writes, memWrite{"", key, old})
+	return s.MockStub.PutState(key, value)
+}
+
+func (s *Stub) DelState(key string) error {
+	old, _ := s.MockStub.GetState(key)
+	s.writes = append(s.writes, memWrite{"", key, old})
+	return s.MockStub.DelState(key)
+}
+
+func (s *Stub) PutPrivateData(collection, key string, value []byte) error {
+	old, _ := s.MockStub.GetPrivateData(collection, key)
+	s.writes = append(s.writes, memWrite{collection, key, old})
+	return s.MockStub.PutPrivateData(collection, key, value)
+}
+
+func (s *Stub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32,
+	bookmark string) (shim.StateQueryIteratorInterface, *sc.QueryResponseMetadata, error) {
+	resultsIterator, err := s.MockStub.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return page(resultsIterator, pageSize, bookmark)
+}
+
+func (s *Stub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32,
+	bookmark string) (shim.StateQueryIteratorInterface, *sc.QueryResponseMetadata, error) {
+	resultsIterator, err := s.MockStub.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	return page(resultsIterator, pageSize, bookmark)
+}
+
+//the page of the results from the key bookmark on
+func page(resultsIterator shim.StateQueryIteratorInterface, pageSize int32,
+	bookmark string) (shim.StateQueryIteratorInterface, *sc.QueryResponseMetadata, error) {
+	defer resultsIterator.Close()
+	it := &memIterator{}
+	md := &sc.QueryResponseMetadata{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if kv.Key < bookmark {
+			continue
+		}
+		if int32(len(it.kvs)) == pageSize {
+			md.Bookmark = kv.Key
+			break
+		}
+		it.kvs = append(it.kvs, kv)
+	}
+	md.FetchedRecordsCount = int32(len(it.kvs))
+	return it, md, nil
+}
+
+type memIterator struct {
+	kvs []*queryresult.KV
+}
+
+func (it *memIterator) HasNext() bool {
+	return len(it.kvs) > 0
+}
+
+func (it *memIterator) Next() (*queryresult.KV, error) {
+	kv := it.kvs[0]
+	it.kvs = it.kvs[1:]
+	return kv, nil
+}
+
+func (it *memIterator) Close() error {
+	return nil
+}
+
+/*
+The args of a proposal for fn, as Invoke takes them.
+*/
+func Args(fn string, args []string) [][]byte {
+	bargs := [][]byte{[]byte(fn)}
+	for _, a := range args {
+		bargs = append(bargs, []byte(a))
+	}
+	return bargs
+}
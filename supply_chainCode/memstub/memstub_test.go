@@ -0,0 +1,52 @@
+package memstub
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"testing"
+	"time"
+)
+
+//returns who called it and when, and puts its args, failing if asked to
+type echoCC struct{}
+
+func (echoCC) Init(stub shim.ChaincodeStubInterface) sc.Response {
+	return shim.Success(nil)
+}
+
+func (echoCC) Invoke(stub shim.ChaincodeStubInterface) sc.Response {
+	fn, args := stub.GetFunctionAndParameters()
+	for _, a := range args {
+		stub.PutState(a, []byte(fn))
+	}
+	if fn == "fail" {
+		return shim.Error("failed")
+	}
+	msp, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	ts, _ := stub.GetTxTimestamp()
+	return shim.Success([]byte(msp + " " + time.Unix(ts.Seconds, int64(ts.Nanos)).UTC().Format(time.RFC3339)))
+}
+
+func TestStub(t *testing.T) {
+	stub := New("memstub", echoCC{})
+	stub.Now = time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, msp := range []string{"Org1MSP", "Org5MSP", "Org1MSP"} {
+		stub.Caller = msp
+		resp := stub.Invoke("tx1", Args("echo", []string{"a"}), nil)
+		if want := msp + " 2019-03-01T12:00:00Z"; string(resp.Payload) != want {
+			t.Errorf("Tx of %s returned %q %q, expecting %q", msp, resp.Payload, resp.Message, want)
+		}
+	}
+	//the writes of a failed tx are rolled back
+	stub.Invoke("tx2", Args("fail", []string{"a", "b"}), nil)
+	if a, _ := stub.GetState("a"); string(a) != "echo" {
+		t.Errorf("a is %q after a failed tx", a)
+	}
+	if b, _ := stub.GetState("b"); b != nil {
+		t.Errorf("b is %q after a failed tx", b)
+	}
+}
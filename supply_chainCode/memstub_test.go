@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMemStubRollback(t *testing.T) {
+	n := newTestNet(t)
+	//the crude is put before its escrow is locked, which fails
+	n.as("Org1MSP").fails("INSUFFICIENT_FUNDS", "deliverCrude", "200000.00", "100", "org1", crudeEst, "org1", "org3", "Vessel1", dispatched)
+	key, _ := AssetKey(n.stub, "Crude0000000001")
+	if crude, _ := n.stub.GetState(key); crude != nil {
+		t.Errorf("Crude0000000001 is %s after the failed tx", crude)
+	}
+	if len(n.stub.Events) != 0 {
+		t.Errorf("The failed tx has events %v", n.eventTypes())
+	}
+	//and its ID is given to the next crude
+	if id := n.newCrude(); id != "Crude0000000001" {
+		t.Errorf("ID of the crude is %s, expecting Crude0000000001", id)
+	}
+	n.checkBooks()
+}
@@ -0,0 +1,349 @@
+/*
+Money.
+
+Amounts of money (asset values, balances, payments) are Money: an integer number of minor units (cents),
+so sums of payments are exact. In JSON they are decimal strings with 2 decimals, e.g. "1234.50".
+Records written before fixed-point money have float numbers instead. They are read by rounding to cents,
+and migrateMoney rewrites them in the new format.
+
+Every account and every asset has a currency (a 3 letter code, e.g. EUR). A payment is in the currency of
+the asset it pays for. If an account is in another currency, the payment is converted with the FX rate
+set on the ledger by an admin, and rejected if there is none.
+
+API:
+
+setFXRate - admin only. args[0] = from currency, args[1] = to currency, args[2] = rate (decimal, e.g. 0.9150)
+queryFXRate - args[0] = from currency, args[1] = to currency
+migrateMoney - admin only. args = types to migrate, {Account,Crude,Fuel,FuelOrder} (all if none)
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//minor units in a unit of every currency
+const MinorUnits = 100
+
+type Money int64
+
+/*
+Put in db with key 'fxRate'+From+To.
+1 From = Rate To.
+*/
+type FXRate struct {
+	From      string
+	To        string
+	Rate      string //decimal
+	Timestamp time.Time
+}
+
+/*
+Parses a decimal amount with up to 2 decimals, e.g. "12", "-12.5", "12.50".
+*/
+func ParseMoney(s string) (Money, error) {
+	neg := strings.HasPrefix(s, "-")
+	units, cents := strings.TrimPrefix(s, "-"), ""
+	if i := strings.Index(units, "."); i >= 0 {
+		units, cents = units[:i], units[i+1:]
+		if len(cents) == 0 || len(cents) > 2 {
+			return 0, fmt.Errorf("%s should have 1 or 2 decimals", s)
+		}
+	}
+	if units == "" || isDigits(units) == false || isDigits(cents) == false {
+		return 0, fmt.Errorf("%s is not an amount of money", s)
+	}
+	u, err := strconv.ParseInt(units, 10, 64)
+	if err != nil || u > math.MaxInt64/MinorUnits-1 {
+		return 0, fmt.Errorf("%s is too large", s)
+	}
+	c, _ := strconv.ParseInt((cents + "00")[:2], 10, 64)
+	m := Money(u*MinorUnits + c)
+	if neg {
+		m = -m
+	}
+	return m, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+//rounds an amount of units to cents.
+func MoneyFromFloat(f float64) Money {
+	return Money(math.Round(f * MinorUnits))
+}
+
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, m/MinorUnits, m%MinorUnits)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+/*
+Reads a decimal string or, for records before fixed-point money, a float number of units.
+*/
+func (m *Money) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		v, err := ParseMoney(s)
+		if err != nil {
+			return err
+		}
+		*m = v
+		return nil
+	}
+	var f float64
+	if err := json.Unmarshal(b, &f); err != nil {
+		return errors.New("Amount of money should be a decimal string")
+	}
+	*m = MoneyFromFloat(f)
+	return nil
+}
+
+/*
+m*num/den rounded to the nearest cent (halves away from zero).
+*/
+func (m Money) MulDiv(num, den int64) Money {
+	return Money(roundRat(new(big.Rat).SetFrac(new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(num)), big.NewInt(den))))
+}
+
+//r rounded to the nearest integer, halves away from zero.
+func roundRat(r *big.Rat) int64 {
+	q, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	//2*|rem| >= den rounds away from zero
+	if new(big.Int).Lsh(new(big.Int).Abs(rem), 1).Cmp(r.Denom()) >= 0 {
+		q.Add(q, big.NewInt(int64(r.Num().Sign())))
+	}
+	return q.Int64()
+}
+
+func IsCurrency(c string) bool {
+	if len(c) != 3 {
+		return false
+	}
+	for _, r := range c {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+//currency of the asset. Assets before currencies are in BaseCurrency.
+func (ad AssetDetails) AssetCurrency() string {
+	if ad.Currency == "" {
+		return BaseCurrency
+	}
+	return ad.Currency
+}
+
+func fxRateKey(from, to string) string {
+	return "fxRate" + from + to
+}
+
+func parseRate(s string) (*big.Rat, error) {
+	parts := strings.SplitN(s, ".", 2)
+	rate, ok := new(big.Rat).SetString(s)
+	if !ok || parts[0] == "" || isDigits(parts[0]) == false || (len(parts) == 2 && (parts[1] == "" || isDigits(parts[1]) == false)) || rate.Sign() <= 0 {
+		return nil, fmt.Errorf("Rate %s should be a positive decimal number", s)
+	}
+	return rate, nil
+}
+
+func GetFXRate(stub shim.ChaincodeStubInterface, from, to string) (FXRate, error) {
+	rbytes, err := stub.GetState(fxRateKey(from, to))
+	if err != nil {
+		return FXRate{}, errors.New("Failed to read FX rate")
+	}
+	if rbytes == nil {
+		return FXRate{}, fmt.Errorf("No FX rate from %s to %s on the ledger", from, to)
+	}
+	rate := FXRate{}
+	if err = json.Unmarshal(rbytes, &rate); err != nil {
+		return FXRate{}, fmt.Errorf("FX rate from %s to %s is corrupted", from, to)
+	}
+	return rate, nil
+}
+
+/*
+Converts m from one currency to another with the FX rate on the ledger.
+*/
+func Convert(stub shim.ChaincodeStubInterface, m Money, from, to string) (Money, error) {
+	if from == to {
+		return m, nil
+	}
+	fx, err := GetFXRate(stub, from, to)
+	if err != nil {
+		return 0, err
+	}
+	rate, err := parseRate(fx.Rate)
+	if err != nil {
+		return 0, err
+	}
+	return Money(roundRat(new(big.Rat).Mul(new(big.Rat).SetInt64(int64(m)), rate))), nil
+}
+
+/*
+args[0] = from currency, args[1] = to currency, args[2] = rate. 1 from = rate to.
+*/
+func (s *SmartContract) setFXRate(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) != 3 {
+		return shim.Error("Expecting 3 args")
+	}
+	if IsCurrency(args[0]) == false || IsCurrency(args[1]) == false || args[0] == args[1] {
+		return shim.Error("Currencies should be two different 3 letter codes, e.g. EUR")
+	}
+	if _, err := parseRate(args[2]); err != nil {
+		return shim.Error(err.Error())
+	}
+	tstamp, err := TxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	rbytes, _ := json.Marshal(FXRate{args[0], args[1], args[2], tstamp})
+	if err = stub.PutState(fxRateKey(args[0], args[1]), rbytes); err != nil {
+		return shim.Error("Failed to put FX rate in db")
+	}
+	return shim.Success(nil)
+}
+
+func (s *SmartContract) queryFXRate(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Expecting 2 args")
+	}
+	rate, err := GetFXRate(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	rbytes, _ := json.Marshal(rate)
+	return shim.Success(rbytes)
+}
+
+/*
+Rewrites accounts and assets with float amounts in fixed-point money.
+args = types to migrate (all if none).
+Returns how many records of each type were rewritten and, for every account whose float balance wasn't
+a whole number of cents, the fraction that was rounded away.
+*/
+func (s *SmartContract) migrateMoney(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	types := args
+	if len(types) == 0 {
+		types = []string{TypeAccount, TypeCrude, TypeFuel, TypeFuelOrder}
+	}
+	result := struct {
+		Migrated map[string]int
+		Rounding map[string]float64
+	}{map[string]int{}, map[string]float64{}}
+	for _, typ := range types {
+		switch typ {
+		case TypeAccount:
+			resultsIterator, err := stub.GetStateByPartialCompositeKey(TypeAccount, []string{})
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			orgs := []string{}
+			for resultsIterator.HasNext() {
+				kv, err := resultsIterator.Next()
+				if err != nil {
+					resultsIterator.Close()
+					return shim.Error(err.Error())
+				}
+				_, attrs, err := stub.SplitCompositeKey(kv.Key)
+				if err != nil || len(attrs) != 1 {
+					resultsIterator.Close()
+					return shim.Error("Invalid key of account")
+				}
+				if rounded := legacyRounding(kv.Value); rounded != 0 {
+					result.Rounding[attrs[0]] = rounded
+				}
+				orgs = append(orgs, attrs[0])
+			}
+			resultsIterator.Close()
+			for _, org := range orgs {
+				acc, err := GetAccountState(stub, org)
+				if err != nil {
+					return shim.Error(err.Error())
+				}
+				if err = PutAccountState(stub, acc); err != nil {
+					return shim.Error(err.Error())
+				}
+			}
+			result.Migrated[typ] = len(orgs)
+		case TypeCrude, TypeFuel, TypeFuelOrder:
+			assets := map[string]interface{}{}
+			ids := []string{}
+			err := getAssetsOfType(stub, typ, func(id string, b []byte) error {
+				var asset interface{}
+				switch typ {
+				case TypeCrude:
+					asset = &Crude{}
+				case TypeFuel:
+					asset = &Fuel{}
+				default:
+					asset = &FuelOrder{}
+				}
+				ids = append(ids, id)
+				assets[id] = asset
+				return json.Unmarshal(b, asset)
+			})
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			for _, id := range ids {
+				abytes, _ := json.Marshal(assets[id])
+				if err = PutAsset(stub, id, abytes); err != nil {
+					return shim.Error(fmt.Sprintf("Failed to put %s in db", id))
+				}
+			}
+			result.Migrated[typ] = len(ids)
+		default:
+			return shim.Error(fmt.Sprintf("Unknown type %s", typ))
+		}
+	}
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
+
+//the fraction of a cent a float balance loses when it is rounded (0 if it isn't a float balance).
+func legacyRounding(accbytes []byte) float64 {
+	var balance float64
+	if err := json.Unmarshal(accbytes, &balance); err != nil {
+		acc := struct{ Balance interface{} }{}
+		if json.Unmarshal(accbytes, &acc) != nil {
+			return 0
+		}
+		if f, ok := acc.Balance.(float64); ok {
+			balance = f
+		}
+	}
+	//to 6 decimals, the rest is noise of the float
+	return math.Round((balance-float64(MoneyFromFloat(balance))/MinorUnits)*1e6) / 1e6
+}
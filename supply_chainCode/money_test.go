@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	for s, want := range map[string]Money{"12": 1200, "12.5": 1250, "12.50": 1250, "-0.01": -1, "0": 0} {
+		if m, err := ParseMoney(s); err != nil || m != want {
+			t.Errorf("ParseMoney(%q) = %d, %v, expecting %d", s, m, err, want)
+		}
+	}
+	for _, s := range []string{"", ".5", "12.", "12.345", "1,5", "1e3", "--1", "92233720368547758.07"} {
+		if _, err := ParseMoney(s); err == nil {
+			t.Errorf("ParseMoney(%q) should fail", s)
+		}
+	}
+	if s := Money(-1250).String(); s != "-12.50" {
+		t.Errorf("String of -1250 is %s", s)
+	}
+	//halves away from zero
+	if m := Money(1001).MulDiv(1, 2); m != 501 {
+		t.Errorf("10.01/2 is %s", m)
+	}
+	var m Money
+	if err := json.Unmarshal([]byte("1234.567"), &m); err != nil || m != 123457 {
+		t.Errorf("Float 1234.567 is read as %s, %v", m, err)
+	}
+}
+
+func TestFXRate(t *testing.T) {
+	n := newBareNet(t)
+	n.ok("initLedger", "org5:USD")
+	n.fails("Expecting 3 args", "setFXRate", "EUR", "USD")
+	n.fails("two different 3 letter codes", "setFXRate", "EUR", "EUR", "1")
+	n.fails("two different 3 letter codes", "setFXRate", "EUR", "usd", "1.10")
+	n.fails("should be a positive decimal number", "setFXRate", "EUR", "USD", "-1.10")
+	n.fails("should be a positive decimal number", "setFXRate", "EUR", "USD", "1/3")
+	n.as("Org3MSP").fails("Access denied", "setFXRate", "EUR", "USD", "1.10")
+
+	//org5 can't pay for an order in EUR without a rate
+	fuelID := n.newFuel(n.newCrude())
+	n.as("Org3MSP").fails("No FX rate from EUR to USD", "addFuelOrder", "500.00", "20", "org3", "org5", fuelID, ordered)
+
+	n.as("Org1MSP").ok("setFXRate", "EUR", "USD", "1.10")
+	rate := FXRate{}
+	n.query(&rate, "queryFXRate", "EUR", "USD")
+	if rate.Rate != "1.10" {
+		t.Errorf("Rate from EUR to USD is %s", rate.Rate)
+	}
+	n.fails("No FX rate from USD to EUR", "queryFXRate", "USD", "EUR")
+	n.fails("Expecting 2 args", "queryFXRate", "EUR")
+
+	//502.00 EUR (the value and the fee) are 552.20 USD
+	orderID := n.newFuelOrder(fuelID, "org5")
+	n.balances(map[string]Money{"org5": openingBalance - money("552.20"), EscrowAccountOf(orderID): money("502.00")})
+	//the statement of org5 is in USD, the currency of its account
+	st := AccountStatement{}
+	n.query(&st, "getAccountStatement", "org5")
+	if l := st.Lines[len(st.Lines)-1]; l.Entry.Amount != money("502.00") || l.Change != -money("552.20") || st.ClosingBalance != openingBalance-money("552.20") {
+		t.Errorf("Statement of org5 is %+v", st)
+	}
+	planID := n.newPlan(orderID)
+	n.as("Org5MSP").ok("transfer", orderID, "org5", fuelEst, planID)
+	n.balances(map[string]Money{"org3": openingBalance - money("1010.00") + money("500.00"), "org4": openingBalance + money("2.00")})
+}
+
+func TestMigrateMoney(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.newCrude()
+	//records before fixed-point money
+	n.stub.MockTransactionStart("legacy")
+	PutAccount(n.stub, "org6", []byte("1234.567"))
+	crude := map[string]interface{}{}
+	abytes, _ := GetAsset(n.stub, crudeID)
+	json.Unmarshal(abytes, &crude)
+	crude["AD"].(map[string]interface{})["Value"] = 999.999
+	abytes, _ = json.Marshal(crude)
+	PutAsset(n.stub, crudeID, abytes)
+	n.stub.MockTransactionEnd("legacy")
+
+	n.as("Org3MSP").fails("Access denied", "migrateMoney")
+	n.as("Org1MSP").fails("Unknown type Plan", "migrateMoney", "Plan")
+	result := struct {
+		Migrated map[string]int
+		Rounding map[string]float64
+	}{}
+	n.query(&result, "migrateMoney")
+	if result.Migrated[TypeCrude] != 1 || result.Migrated[TypeFuel] != 0 {
+		t.Errorf("Migrated %v", result.Migrated)
+	}
+	if r := result.Rounding["org6"]; r != -0.003 || len(result.Rounding) != 1 {
+		t.Errorf("Rounding is %v", result.Rounding)
+	}
+	acc := Account{}
+	accbytes, _ := GetAccount(n.stub, "org6")
+	if err := json.Unmarshal(accbytes, &acc); err != nil || acc.Balance != money("1234.57") || acc.Org != "org6" {
+		t.Errorf("Account of org6 after the migration is %s", accbytes)
+	}
+	migrated := Crude{}
+	n.asset(crudeID, &migrated)
+	if migrated.AD.Value != money("1000.00") {
+		t.Errorf("Value of %s after the migration is %s", crudeID, migrated.AD.Value)
+	}
+}
+
@@ -0,0 +1,667 @@
+/*
+Registry of the organizations.
+
+Every org of the network is registered on the ledger with its name (e.g. 'org7', used as owner, location
+and payee in the other txs), MSP ID, roles, locations and bank reference, under the key (Org, name).
+Owners, locations, destinations, payers and payees are checked against the registry: they should be
+registered and active. A new fuel station joins with a registerOrg tx, no code change.
+
+Registering or updating an org also gives its MSP ID its roles in the role map (see roles.go), and
+deactivating it takes them away. setRoleMSPs gives the role to, or takes it from, the Roles of the orgs. A deactivated org keeps its account and history but can't take part
+in new txs, until it is reactivated with its MSP ID and roles, as long as no other active org has the MSP ID.
+An MSP ID belongs to one active org: orgs are indexed by MSP ID under (msp~name, MSPID, name), so the orgs
+of an MSP ID are a partial key query.
+
+On instantiate, and on the upgrade of a ledger created before the registry, org1..org6 are registered
+with the roles their MSP IDs have in the role map. An upgrade builds the MSP index of the orgs already registered. initLedger opens the accounts of the registered orgs.
+An org registered later gets its account from registerOrg, which keeps an account that already exists.
+
+API:
+
+registerOrg - admin only. args[0] = JSON org, e.g.
+	{"Name":"org7","MSPID":"Org7MSP","Roles":["retailer"],"Locations":["Patras"],"BankRef":"GR16...",
+	 "Currency":"EUR","OpeningBalance":"50000"}
+	Currency (of its account, BaseCurrency if missing) and OpeningBalance (from capital) are optional.
+updateOrg - admin only. args[0] = JSON org with the Name and the fields to change (MSPID, Roles, Locations, BankRef)
+deactivateOrg - admin only. args[0] = name
+reactivateOrg - admin only. args[0] = name
+queryOrg - args[0] = name
+queryOrgs
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"sort"
+	"strings"
+	"time"
+)
+
+const orgObjectType = "Org"
+
+const IndexMSPOrg = "msp~name"
+
+/*
+Put in db with key (Org, Name).
+*/
+type Org struct {
+	Name       string
+	MSPID      string
+	Roles      []string
+	Locations  []string
+	BankRef    string
+	Active     bool
+	Registered time.Time
+	Updated    time.Time
+}
+
+/*
+registerOrg and updateOrg requests.
+*/
+type OrgRequest struct {
+	Name           string
+	MSPID          string
+	Roles          []string
+	Locations      []string
+	BankRef        string
+	Currency       string
+	OpeningBalance Money
+}
+
+//orgs registered on instantiate, with the roles of their MSP IDs in the role map (see initOrgs)
+var DefaultOrgs = []Org{
+	{"org1", "Org1MSP", nil, nil, "", true, time.Time{}, time.Time{}},
+	{"org2", "Org2MSP", nil, nil, "", true, time.Time{}, time.Time{}},
+	{"org3", "Org3MSP", nil, nil, "", true, time.Time{}, time.Time{}},
+	{"org4", "Org4MSP", nil, nil, "", true, time.Time{}, time.Time{}},
+	{"org5", "Org5MSP", nil, nil, "", true, time.Time{}, time.Time{}},
+	{"org6", "Org6MSP", nil, nil, "", true, time.Time{}, time.Time{}},
+}
+
+func OrgKey(stub shim.ChaincodeStubInterface, name string) (string, error) {
+	return stub.CreateCompositeKey(orgObjectType, []string{name})
+}
+
+/*
+Returns the org or nil if it isn't registered.
+*/
+func GetOrg(stub shim.ChaincodeStubInterface, name string) (*Org, error) {
+	key, err := OrgKey(stub, name)
+	if err != nil {
+		return nil, err
+	}
+	obytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read org %s", name)
+	}
+	if obytes == nil {
+		return nil, nil
+	}
+	org := Org{}
+	if err = json.Unmarshal(obytes, &org); err != nil {
+		return nil, fmt.Errorf("Org %s is corrupted", name)
+	}
+	return &org, nil
+}
+
+/*
+Puts the org in db and moves its entry in the MSP index if its MSP ID changed.
+*/
+func PutOrg(stub shim.ChaincodeStubInterface, org Org) error {
+	key, err := OrgKey(stub, org.Name)
+	if err != nil {
+		return err
+	}
+	prev, err := GetOrg(stub, org.Name)
+	if err != nil {
+		return err
+	}
+	if prev != nil && prev.MSPID != org.MSPID {
+		prevEntry, err := stub.CreateCompositeKey(IndexMSPOrg, []string{prev.MSPID, org.Name})
+		if err != nil {
+			return err
+		}
+		if err = stub.DelState(prevEntry); err != nil {
+			return fmt.Errorf("Failed to delete MSP index entry of %s", org.Name)
+		}
+	}
+	if err = putMSPEntry(stub, org); err != nil {
+		return err
+	}
+	obytes, _ := json.Marshal(org)
+	if err = stub.PutState(key, obytes); err != nil {
+		return fmt.Errorf("Failed to put org %s in db", org.Name)
+	}
+	return nil
+}
+
+/*
+All the registered orgs, active or not, in the order of their names.
+*/
+func GetOrgs(stub shim.ChaincodeStubInterface) ([]Org, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(orgObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	orgs := []Org{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		org := Org{}
+		if err = json.Unmarshal(kv.Value, &org); err != nil {
+			return nil, fmt.Errorf("Failed to decode %s", kv.Key)
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, nil
+}
+
+func putMSPEntry(stub shim.ChaincodeStubInterface, org Org) error {
+	entry, err := stub.CreateCompositeKey(IndexMSPOrg, []string{org.MSPID, org.Name})
+	if err != nil {
+		return err
+	}
+	if err = stub.PutState(entry, []byte{0x00}); err != nil {
+		return fmt.Errorf("Failed to put MSP index entry of %s in db", org.Name)
+	}
+	return nil
+}
+
+/*
+Returns the active org with the MSP ID or nil if there is none.
+*/
+func GetOrgOfMSP(stub shim.ChaincodeStubInterface, msp string) (*Org, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(IndexMSPOrg, []string{msp})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attrs, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil || len(attrs) != 2 {
+			return nil, fmt.Errorf("Invalid MSP index entry %s", kv.Key)
+		}
+		org, err := GetOrg(stub, attrs[1])
+		if err != nil {
+			return nil, err
+		}
+		if org != nil && org.Active && org.MSPID == msp {
+			return org, nil
+		}
+	}
+	return nil, nil
+}
+
+//the roles of an MSP ID are the roles of its org, so an MSP ID belongs to one active org.
+func checkMSPFree(stub shim.ChaincodeStubInterface, msp, name string) error {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(IndexMSPOrg, []string{msp})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		_, attrs, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil || len(attrs) != 2 {
+			return fmt.Errorf("Invalid MSP index entry %s", kv.Key)
+		}
+		if attrs[1] == name {
+			continue
+		}
+		org, err := GetOrg(stub, attrs[1])
+		if err != nil {
+			return err
+		}
+		if org != nil && org.Active && org.MSPID == msp {
+			return fmt.Errorf("%s is the MSP ID of %s", msp, org.Name)
+		}
+	}
+	return nil
+}
+
+/*
+Registers the default orgs if there are no orgs registered, with the roles their MSP IDs have in the role map.
+Called on instantiate and upgrade (after initRoleMap), so ledgers created before the registry keep working.
+On the upgrade of a ledger with orgs it builds their MSP index, which ledgers before it don't have.
+*/
+func initOrgs(stub shim.ChaincodeStubInterface) error {
+	orgs, err := GetOrgs(stub)
+	if err != nil {
+		return err
+	}
+	if len(orgs) > 0 {
+		for _, org := range orgs {
+			if err = putMSPEntry(stub, org); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	rm, err := GetRoleMap(stub)
+	if err != nil {
+		return err
+	}
+	tstamp, err := TxTime(stub)
+	if err != nil {
+		return err
+	}
+	for _, org := range DefaultOrgs {
+		org.Roles = rolesOf(rm, org.MSPID)
+		org.Registered, org.Updated = tstamp, tstamp
+		if err = PutOrg(stub, org); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (org Org) HasRole(role string) bool {
+	return containsString(org.Roles, role)
+}
+
+/*
+Checks that name is a registered and active org and, if roles are given, that it plays one of them.
+*/
+func CheckOrg(stub shim.ChaincodeStubInterface, name string, roles ...string) error {
+	if name == "" {
+		return errors.New("Org should not be empty")
+	}
+	org, err := GetOrg(stub, name)
+	if err != nil {
+		return err
+	}
+	if org == nil {
+		return fmt.Errorf("%s is not a registered org", name)
+	}
+	if org.Active == false {
+		return fmt.Errorf("%s is deactivated", name)
+	}
+	if len(roles) == 0 {
+		return nil
+	}
+	for _, role := range roles {
+		if org.HasRole(role) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s should have one of the roles %v", name, roles)
+}
+
+func CheckOrgs(stub shim.ChaincodeStubInterface, names ...string) error {
+	for _, name := range names {
+		if err := CheckOrg(stub, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//names that can't be orgs: asset IDs, system accounts and wildcards
+func validOrgName(name string) bool {
+	return name != "" && AssetType(name) == "" && IsCapitalAccount(name) == false && IsEscrowAccount(name) == false &&
+		strings.ContainsAny(name, "*:\x00") == false
+}
+
+func validateRoles(roles []string) error {
+	for _, role := range roles {
+		if IsRole(role) == false {
+			return fmt.Errorf("Unknown role %s", role)
+		}
+	}
+	return nil
+}
+
+/*
+Gives org.MSPID the roles of org in the role map and takes away the roles it no longer has
+(all of them if it is deactivated). prevMSP is the MSP ID the org had before, if it changed.
+*/
+func syncRoles(stub shim.ChaincodeStubInterface, org Org, prevMSP string) error {
+	rm, err := GetRoleMap(stub)
+	if err != nil {
+		return err
+	}
+	for role := range rm {
+		if IsRole(role) == false {
+			continue
+		}
+		msps := []string{}
+		for _, msp := range rm[role] {
+			if msp != org.MSPID && msp != prevMSP {
+				msps = append(msps, msp)
+			}
+		}
+		rm[role] = msps
+	}
+	if org.Active {
+		for _, role := range org.Roles {
+			rm[role] = append(rm[role], org.MSPID)
+		}
+	}
+	if len(rm[RoleAdmin]) == 0 {
+		return errors.New("There should be at least one admin")
+	}
+	return PutRoleMap(stub, rm)
+}
+
+/*
+Gives role to the active orgs whose MSP IDs have it in the role map and takes it from the others,
+so that the Roles of the orgs stay those of their MSP IDs after a setRoleMSPs.
+*/
+func syncOrgRole(stub shim.ChaincodeStubInterface, rm RoleMap, role string) error {
+	orgs, err := GetOrgs(stub)
+	if err != nil {
+		return err
+	}
+	tstamp, err := TxTime(stub)
+	if err != nil {
+		return err
+	}
+	for _, org := range orgs {
+		if org.Active == false || org.HasRole(role) == rm.HasRole(org.MSPID, role) {
+			continue
+		}
+		if org.HasRole(role) {
+			roles := []string{}
+			for _, r := range org.Roles {
+				if r != role {
+					roles = append(roles, r)
+				}
+			}
+			org.Roles = roles
+		} else {
+			org.Roles = append(org.Roles, role)
+		}
+		org.Updated = tstamp
+		if err = PutOrg(stub, org); err != nil {
+			return err
+		}
+		Emit(stub, EventOrgUpdated, org.Name, org)
+	}
+	return nil
+}
+
+//roles of msp in the role map
+func rolesOf(rm RoleMap, msp string) []string {
+	roles := []string{}
+	for role := range rm {
+		if rm.HasRole(msp, role) {
+			roles = append(roles, role)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+func decodeOrgRequest(s string) (OrgRequest, error) {
+	req := OrgRequest{}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return OrgRequest{}, fmt.Errorf("Invalid org: %s", err)
+	}
+	if err := validateRoles(req.Roles); err != nil {
+		return OrgRequest{}, err
+	}
+	return req, nil
+}
+
+/*
+args[0] = JSON OrgRequest
+Opens the account of the org, unless it has one already (a ledger created before the registry).
+*/
+func (s *SmartContract) registerOrg(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 arg")
+	}
+	req, err := decodeOrgRequest(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if validOrgName(req.Name) == false {
+		return shim.Error("Name should not be empty, an asset ID, a system account or contain '*' or ':'")
+	}
+	if req.MSPID == "" {
+		return shim.Error("MSPID should not be empty")
+	}
+	if existing, err := GetOrg(stub, req.Name); err != nil || existing != nil {
+		return shim.Error(fmt.Sprintf("%s is already registered", req.Name))
+	}
+	if err = checkMSPFree(stub, req.MSPID, req.Name); err != nil {
+		return shim.Error(err.Error())
+	}
+	if req.Currency == "" {
+		req.Currency = BaseCurrency
+	}
+	if IsCurrency(req.Currency) == false {
+		return shim.Error("Currency should be a 3 letter code, e.g. EUR")
+	}
+	if req.OpeningBalance < 0 {
+		return shim.Error("OpeningBalance should not be negative")
+	}
+	tstamp, err := TxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	org := Org{req.Name, req.MSPID, req.Roles, req.Locations, req.BankRef, true, tstamp, tstamp}
+	accbytes, err := GetAccount(stub, org.Name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if accbytes == nil {
+		if err = openAccounts(stub, map[string]string{org.Name: req.Currency}, req.OpeningBalance); err != nil {
+			return shim.Error(err.Error())
+		}
+	} else if req.OpeningBalance != 0 {
+		return shim.Error(fmt.Sprintf("%s already has an account, so it has no opening balance", org.Name))
+	}
+	if err = syncRoles(stub, org, ""); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = PutOrg(stub, org); err != nil {
+		return shim.Error(err.Error())
+	}
+	Emit(stub, EventOrgRegistered, org.Name, org)
+	return shim.Success(nil)
+}
+
+/*
+Opens the accounts of orgs (name -> currency) with an opening balance from the capital account of
+their currency.
+*/
+func openAccounts(stub shim.ChaincodeStubInterface, orgs map[string]string, openingBalance Money) error {
+	accounts := map[string]*Account{}
+	payments := []Payment{}
+	names := make([]string, 0, len(orgs))
+	for name := range orgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		acc := NewAccount(name)
+		acc.Currency = orgs[name]
+		capital := CapitalAccountOf(acc.Currency)
+		if _, ok := accounts[capital]; !ok {
+			capacc, err := GetAccountState(stub, capital)
+			if err != nil {
+				capacc = NewAccount(capital)
+				capacc.Currency = acc.Currency
+			}
+			accounts[capital] = &capacc
+		}
+		accounts[name] = &acc
+		if openingBalance > 0 {
+			payments = append(payments, Payment{capital, name, openingBalance, acc.Currency, ""})
+		}
+	}
+	_, err := postEntries(stub, accounts, "", "opening balance", payments)
+	return err
+}
+
+/*
+args[0] = JSON OrgRequest. The fields that are missing keep their value. Currency and OpeningBalance can't be changed.
+*/
+func (s *SmartContract) updateOrg(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 arg")
+	}
+	req, err := decodeOrgRequest(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if req.Currency != "" || req.OpeningBalance != 0 {
+		return shim.Error("Currency and OpeningBalance can't be updated")
+	}
+	org, err := GetOrg(stub, req.Name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if org == nil {
+		return shim.Error(fmt.Sprintf("%s is not a registered org", req.Name))
+	}
+	if org.Active == false {
+		return shim.Error(fmt.Sprintf("%s is deactivated", req.Name))
+	}
+	prevMSP := org.MSPID
+	if req.MSPID != "" {
+		if err = checkMSPFree(stub, req.MSPID, org.Name); err != nil {
+			return shim.Error(err.Error())
+		}
+		org.MSPID = req.MSPID
+	}
+	if req.Roles != nil {
+		org.Roles = req.Roles
+	}
+	if req.Locations != nil {
+		org.Locations = req.Locations
+	}
+	if req.BankRef != "" {
+		org.BankRef = req.BankRef
+	}
+	if org.Updated, err = TxTime(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = syncRoles(stub, *org, prevMSP); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = PutOrg(stub, *org); err != nil {
+		return shim.Error(err.Error())
+	}
+	Emit(stub, EventOrgUpdated, org.Name, *org)
+	return shim.Success(nil)
+}
+
+/*
+args[0] = name of the org
+*/
+func (s *SmartContract) deactivateOrg(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 arg")
+	}
+	org, err := GetOrg(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if org == nil {
+		return shim.Error(fmt.Sprintf("%s is not a registered org", args[0]))
+	}
+	if org.Active == false {
+		return shim.Error(fmt.Sprintf("%s is already deactivated", args[0]))
+	}
+	org.Active = false
+	if org.Updated, err = TxTime(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = syncRoles(stub, *org, ""); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = PutOrg(stub, *org); err != nil {
+		return shim.Error(err.Error())
+	}
+	Emit(stub, EventOrgUpdated, org.Name, *org)
+	return shim.Success(nil)
+}
+
+/*
+args[0] = name of the org
+It gets back the roles it had, so its MSP ID should not be the MSP ID of another active org.
+*/
+func (s *SmartContract) reactivateOrg(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 arg")
+	}
+	org, err := GetOrg(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if org == nil {
+		return shim.Error(fmt.Sprintf("%s is not a registered org", args[0]))
+	}
+	if org.Active {
+		return shim.Error(fmt.Sprintf("%s is already active", args[0]))
+	}
+	if err = checkMSPFree(stub, org.MSPID, org.Name); err != nil {
+		return shim.Error(err.Error())
+	}
+	org.Active = true
+	if org.Updated, err = TxTime(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = syncRoles(stub, *org, ""); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = PutOrg(stub, *org); err != nil {
+		return shim.Error(err.Error())
+	}
+	Emit(stub, EventOrgUpdated, org.Name, *org)
+	return shim.Success(nil)
+}
+
+func (s *SmartContract) queryOrg(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 arg")
+	}
+	org, err := GetOrg(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if org == nil {
+		return shim.Error(fmt.Sprintf("%s is not a registered org", args[0]))
+	}
+	obytes, _ := json.Marshal(org)
+	return shim.Success(obytes)
+}
+
+func (s *SmartContract) queryOrgs(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	orgs, err := GetOrgs(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	orgsAsBytes, _ := json.Marshal(orgs)
+	return shim.Success(orgsAsBytes)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"testing"
+)
+
+const org7 = `{"Name":"org7","MSPID":"Org7MSP","Roles":["retailer"],"Locations":["Patras"],"BankRef":"GR1601101250000000012300695","OpeningBalance":"50000"}`
+
+func TestDefaultOrgs(t *testing.T) {
+	n := newTestNet(t)
+	var orgs []Org
+	n.query(&orgs, "queryOrgs")
+	if len(orgs) != len(DefaultOrgs) {
+		t.Fatalf("%d orgs are registered on instantiate", len(orgs))
+	}
+	org := Org{}
+	n.query(&org, "queryOrg", "org1")
+	//the roles of Org1MSP in the role map, with admin and arbiter as it instantiated the chaincode
+	if org.MSPID != "Org1MSP" || org.HasRole(RoleAdmin) == false || org.HasRole(RoleArbiter) == false || org.HasRole(RoleDriller) == false {
+		t.Errorf("org1 is %+v", org)
+	}
+	n.query(&org, "queryOrg", "org5")
+	if len(org.Roles) != 1 || org.Roles[0] != RoleRetailer || org.Active == false {
+		t.Errorf("org5 is %+v", org)
+	}
+	n.fails("org9 is not a registered org", "queryOrg", "org9")
+	n.fails("Expecting 1 arg", "queryOrg")
+}
+
+func TestRegisterOrg(t *testing.T) {
+	n := newTestNet(t)
+	n.ok("registerOrg", org7)
+	if types := n.eventTypes(); len(types) == 0 || types[len(types)-1] != EventOrgRegistered {
+		t.Errorf("Events of registerOrg are %v", types)
+	}
+	n.balances(map[string]Money{"org7": money("50000.00"), CapitalAccount: -6*openingBalance - money("50000.00")})
+	rm := RoleMap{}
+	n.query(&rm, "queryRoles")
+	if rm.HasRole("Org7MSP", RoleRetailer) == false {
+		t.Errorf("Org7MSP isn't a retailer after its registration: %v", rm)
+	}
+
+	//the new fuel station orders and receives fuel
+	orderID := n.newFuelOrder(n.newFuel(n.newCrude()), "org7")
+	n.as("Org4MSP").ok("registerVehicle", "Truck", "Truck1", "100")
+	planID := n.ok("deliverFuel", "Truck1", orderID, fuelEst, "org3", "org7")
+	n.as("Org7MSP").ok("transfer", orderID, "org7", fuelEst, planID)
+	n.balances(map[string]Money{"org7": money("50000.00") - money("502.00")})
+	n.checkBooks()
+}
+
+func TestRegisterOrgErrors(t *testing.T) {
+	n := newTestNet(t)
+	org := func(fields string) string {
+		return `{"MSPID":"Org7MSP","Roles":["retailer"],` + fields + `}`
+	}
+	n.fails("Expecting 1 arg", "registerOrg")
+	n.fails("Invalid org", "registerOrg", "org7")
+	n.fails("Invalid org", "registerOrg", org(`"Name":"org7","Colour":"red"`))
+	n.fails("Unknown role pilot", "registerOrg", `{"Name":"org7","MSPID":"Org7MSP","Roles":["pilot"]}`)
+	for _, name := range []string{"", "Crude0000000005", "FuelOrder0000000001", CapitalAccount, EscrowAccountOf("Crude0000000001"), "*", "org:7"} {
+		n.fails("Name should not be empty", "registerOrg", org(`"Name":"`+name+`"`))
+	}
+	n.fails("MSPID should not be empty", "registerOrg", `{"Name":"org7"}`)
+	n.fails("org5 is already registered", "registerOrg", org(`"Name":"org5"`))
+	n.fails("Org5MSP is the MSP ID of org5", "registerOrg", `{"Name":"org7","MSPID":"Org5MSP"}`)
+	n.fails("Currency should be a 3 letter code", "registerOrg", org(`"Name":"org7","Currency":"eur"`))
+	n.fails("OpeningBalance should not be negative", "registerOrg", org(`"Name":"org7","OpeningBalance":"-1"`))
+	n.as("Org5MSP").fails("Access denied", "registerOrg", org7)
+
+	//the failed txs registered nothing
+	n.as("Org1MSP").fails("org7 is not a registered org", "queryOrg", "org7")
+	n.fails("Account of org7 doesn't exist", "queryAvailableFunds", "org7")
+}
+
+func TestUpdateOrg(t *testing.T) {
+	n := newTestNet(t)
+	n.ok("registerOrg", org7)
+	n.ok("updateOrg", `{"Name":"org7","MSPID":"Org7bMSP","Roles":["retailer","distributor"]}`)
+	org := Org{}
+	n.query(&org, "queryOrg", "org7")
+	if org.MSPID != "Org7bMSP" || len(org.Roles) != 2 || org.BankRef == "" || len(org.Locations) != 1 {
+		t.Errorf("org7 after its update is %+v", org)
+	}
+	rm := RoleMap{}
+	n.query(&rm, "queryRoles")
+	if rm.HasRole("Org7MSP", RoleRetailer) || rm.HasRole("Org7bMSP", RoleDistributor) == false {
+		t.Errorf("Role map after the update of org7 is %v", rm)
+	}
+	n.as("Org7bMSP").ok("registerVehicle", "Truck", "Truck7", "50")
+
+	n.as("Org1MSP")
+	n.fails("Expecting 1 arg", "updateOrg")
+	n.fails("Currency and OpeningBalance can't be updated", "updateOrg", `{"Name":"org7","Currency":"USD"}`)
+	n.fails("Currency and OpeningBalance can't be updated", "updateOrg", `{"Name":"org7","OpeningBalance":"10"}`)
+	n.fails("org9 is not a registered org", "updateOrg", `{"Name":"org9","BankRef":"x"}`)
+	n.fails("Org5MSP is the MSP ID of org5", "updateOrg", `{"Name":"org7","MSPID":"Org5MSP"}`)
+	n.fails("Unknown role", "updateOrg", `{"Name":"org7","Roles":["king"]}`)
+	n.fails("There should be at least one admin", "updateOrg", `{"Name":"org1","Roles":["driller"]}`)
+	n.as("Org7bMSP").fails("Access denied", "updateOrg", `{"Name":"org7","Roles":["admin"]}`)
+}
+
+func TestDeactivateOrg(t *testing.T) {
+	n := newTestNet(t)
+	n.ok("registerOrg", org7)
+	fuelID := n.newFuel(n.newCrude())
+	n.as("Org1MSP").ok("deactivateOrg", "org7")
+	n.as("Org3MSP").fails("org7 is deactivated", "addFuelOrder", "500.00", "20", "org3", "org7", fuelID, ordered)
+	n.as("Org7MSP").fails("Access denied", "registerVehicle", "Truck", "Truck7", "50")
+	//its account is kept
+	n.balances(map[string]Money{"org7": money("50000.00")})
+
+	n.as("Org1MSP")
+	n.fails("org7 is already deactivated", "deactivateOrg", "org7")
+	n.fails("org7 is deactivated", "updateOrg", `{"Name":"org7","BankRef":"x"}`)
+	n.fails("org9 is not a registered org", "deactivateOrg", "org9")
+	n.fails("Expecting 1 arg", "deactivateOrg")
+	n.fails("There should be at least one admin", "deactivateOrg", "org1")
+	//its MSP ID can be used by another org
+	n.ok("registerOrg", `{"Name":"org8","MSPID":"Org7MSP","Roles":["retailer"]}`)
+	n.as("Org5MSP").fails("Access denied", "deactivateOrg", "org5")
+}
+
+func TestReactivateOrg(t *testing.T) {
+	n := newTestNet(t)
+	n.ok("registerOrg", org7)
+	n.ok("deactivateOrg", "org7")
+	n.ok("reactivateOrg", "org7")
+	if types := n.eventTypes(); len(types) == 0 || types[len(types)-1] != EventOrgUpdated {
+		t.Errorf("Events of reactivateOrg are %v", types)
+	}
+	org := Org{}
+	n.query(&org, "queryOrg", "org7")
+	if org.Active == false || len(org.Roles) != 1 {
+		t.Errorf("org7 after its reactivation is %+v", org)
+	}
+	rm := RoleMap{}
+	n.query(&rm, "queryRoles")
+	if rm.HasRole("Org7MSP", RoleRetailer) == false {
+		t.Errorf("Org7MSP isn't a retailer after its reactivation: %v", rm)
+	}
+	fuelID := n.newFuel(n.newCrude())
+	n.newFuelOrder(fuelID, "org7")
+
+	n.as("Org1MSP")
+	n.fails("org7 is already active", "reactivateOrg", "org7")
+	n.fails("org9 is not a registered org", "reactivateOrg", "org9")
+	n.fails("Expecting 1 arg", "reactivateOrg")
+	//not while another org has its MSP ID
+	n.ok("deactivateOrg", "org7")
+	n.ok("registerOrg", `{"Name":"org8","MSPID":"Org7MSP","Roles":["retailer"]}`)
+	n.fails("Org7MSP is the MSP ID of org8", "reactivateOrg", "org7")
+	n.as("Org5MSP").fails("Access denied", "reactivateOrg", "org7")
+}
+
+//an upgrade builds the MSP index of the orgs registered before it
+func TestMSPIndexOnUpgrade(t *testing.T) {
+	n := newTestNet(t)
+	n.ok("registerOrg", org7)
+	n.stub.MockTransactionStart("legacy")
+	it, _ := n.stub.GetStateByPartialCompositeKey(IndexMSPOrg, []string{})
+	for it.HasNext() {
+		kv, _ := it.Next()
+		n.stub.DelState(kv.Key)
+	}
+	it.Close()
+	n.stub.MockTransactionEnd("legacy")
+	if resp := n.stub.Init(n.nextTxID(), nil); resp.Status != shim.OK {
+		t.Fatalf("Upgrade failed: %s", resp.Message)
+	}
+	n.fails("Org7MSP is the MSP ID of org7", "registerOrg", `{"Name":"org8","MSPID":"Org7MSP"}`)
+	n.fails("Org5MSP is the MSP ID of org5", "updateOrg", `{"Name":"org7","MSPID":"Org5MSP"}`)
+	//the entry of an MSP ID that changed is moved
+	n.ok("updateOrg", `{"Name":"org7","MSPID":"Org7bMSP"}`)
+	n.ok("registerOrg", `{"Name":"org8","MSPID":"Org7MSP"}`)
+	n.fails("Org7bMSP is the MSP ID of org7", "registerOrg", `{"Name":"org9","MSPID":"Org7bMSP"}`)
+}
@@ -0,0 +1,99 @@
+/*
+Pagination of the asset queries.
+
+queryAssetByRange, queryAssetsByOwner/State/Destination and queryAssets take a page size and a bookmark
+as their last two (optional) args and return one page:
+
+	{"Records": [{"Key": "FuelOrder0000000001", "Record": {...}}, ...], "FetchedRecordsCount": 100, "Bookmark": "..."}
+
+The first page is asked with an empty bookmark, the next one with the Bookmark of the previous page.
+The last page has fewer records than the page size (possibly none).
+Records are in the order of their keys, which is lexical: minted IDs have a fixed number of digits
+(see ids.go), so the assets of a type come in the order they were created in, Crude0000000009 before Crude0000000010.
+Pages come from the paginated queries of the peer, so they can only be asked in queries, not in txs
+that change the ledger.
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"strconv"
+)
+
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+type Page struct {
+	Records             []AssetRecord
+	FetchedRecordsCount int32
+	Bookmark            string //to ask the next page with
+}
+
+/*
+args[0] = page size (optional, DefaultPageSize if missing or empty)
+args[1] = bookmark (optional)
+*/
+func PageArgs(args []string) (int32, string, error) {
+	if len(args) > 2 {
+		return 0, "", errors.New("Expecting page size and bookmark after the args of the query")
+	}
+	pageSize := int64(DefaultPageSize)
+	if len(args) > 0 && args[0] != "" {
+		var err error
+		pageSize, err = strconv.ParseInt(args[0], 10, 32)
+		if err != nil || pageSize <= 0 || pageSize > MaxPageSize {
+			return 0, "", fmt.Errorf("Page size should be an int number in [1,%d]", MaxPageSize)
+		}
+	}
+	bookmark := ""
+	if len(args) > 1 {
+		bookmark = args[1]
+	}
+	return int32(pageSize), bookmark, nil
+}
+
+/*
+Reads a page of a paginated query. record turns a result of the query into the record of the page.
+*/
+func readPage(resultsIterator shim.StateQueryIteratorInterface, md *sc.QueryResponseMetadata, err error,
+	record func(key string, value []byte) (AssetRecord, error)) (Page, error) {
+	if err != nil {
+		return Page{}, err
+	}
+	if resultsIterator == nil || md == nil {
+		return Page{}, errors.New("Paginated queries are not supported by this peer")
+	}
+	defer resultsIterator.Close()
+	records := []AssetRecord{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return Page{}, err
+		}
+		r, err := record(kv.Key, kv.Value)
+		if err != nil {
+			return Page{}, err
+		}
+		records = append(records, r)
+	}
+	return Page{records, md.FetchedRecordsCount, md.Bookmark}, nil
+}
+
+/*
+A page of the assets of type typ, in the order they were created in (see ids.go).
+*/
+func GetAssetsPage(stub shim.ChaincodeStubInterface, typ string, pageSize int32, bookmark string) (Page, error) {
+	resultsIterator, md, err := stub.GetStateByPartialCompositeKeyWithPagination(typ, []string{}, pageSize, bookmark)
+	return readPage(resultsIterator, md, err, func(key string, value []byte) (AssetRecord, error) {
+		_, attrs, err := stub.SplitCompositeKey(key)
+		if err != nil || len(attrs) != 1 {
+			return AssetRecord{}, fmt.Errorf("Invalid key of %s", typ)
+		}
+		return AssetRecord{attrs[0], value}, nil
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+)
+
+//rich queries and history aren't supported by the in-memory ledger, so only their args are checked
+func TestPagedQueries(t *testing.T) {
+	n := newTestNet(t)
+	n.newCrude()
+	n.fails("Expecting at least 1 arg", "queryAssetByRange")
+	n.fails("Arg should be one of {Crude,Fuel,FuelOrder,Plan}", "queryAssetByRange", "Vessel")
+	n.fails("Page size should be an int number in [1,1000]", "queryAssetByRange", TypeCrude, "0")
+	n.fails("Page size should be an int number in [1,1000]", "queryAssetByRange", TypeCrude, "1001")
+	n.fails("Expecting page size and bookmark", "queryAssetByRange", TypeCrude, "10", "", "x")
+	n.newCrude()
+	n.newCrude()
+	//Crude0000000001, Crude0000000002 and then Crude0000000003 with no bookmark after it
+	page := Page{}
+	n.query(&page, "queryAssetByRange", TypeCrude, "2")
+	if page.FetchedRecordsCount != 2 || page.Records[1].Key != "Crude0000000002" || page.Bookmark == "" {
+		t.Fatalf("First page of the crudes is %+v", page)
+	}
+	n.query(&page, "queryAssetByRange", TypeCrude, "2", page.Bookmark)
+	if page.FetchedRecordsCount != 1 || page.Records[0].Key != "Crude0000000003" || page.Bookmark != "" {
+		t.Errorf("Last page of the crudes is %+v", page)
+	}
+
+	//the pages are in the order the crudes were created in, past Crude0000000009
+	for i := 0; i < 8; i++ {
+		n.newCrude()
+	}
+	n.query(&page, "queryAssetByRange", TypeCrude, "9")
+	n.query(&page, "queryAssetByRange", TypeCrude, "9", page.Bookmark)
+	if page.FetchedRecordsCount != 2 || page.Records[0].Key != "Crude0000000010" || page.Records[1].Key != "Crude0000000011" {
+		t.Errorf("Page after Crude0000000009 is %+v", page)
+	}
+	n.query(&page, "queryAssetsByOwner", "org1", TypeCrude, "10")
+	if page.FetchedRecordsCount != 10 || page.Records[9].Key != "Crude0000000010" {
+		t.Errorf("First page of the crudes of org1 is %+v", page)
+	}
+
+	for _, fn := range []string{"queryAssetsByOwner", "queryAssetsByState", "queryAssetsByDestination"} {
+		n.fails("Expecting at least 1 arg", fn)
+		n.fails("Value to look for should not be empty", fn, "")
+		n.fails("Type should be one of {Crude,Fuel,FuelOrder}", fn, "org1", TypePlan)
+		n.fails("Page size should be an int number", fn, "org1", TypeCrude, "ten")
+		n.query(&page, fn, "org1", "")
+	}
+	n.query(&page, "queryAssetsByOwner", "org1", TypeCrude, "20")
+	if page.FetchedRecordsCount != 11 || page.Bookmark != "" {
+		t.Errorf("Crudes of org1 are %+v", page)
+	}
+
+	n.fails("Expecting at least 2 args", "queryAssets", TypeCrude)
+	n.fails("Type should be one of {Crude,Fuel,FuelOrder}", "queryAssets", TypePlan, `{}`)
+	n.fails("Selector is not valid JSON", "queryAssets", TypeCrude, `{"AD.Owner":`)
+	n.fails("Selector should be a JSON object", "queryAssets", TypeCrude, `[]`)
+	n.fails("Operator $where is not allowed", "queryAssets", TypeCrude, `{"$where":"1"}`)
+	n.fails("Field AD.Value can't be queried for Crude", "queryAssets", TypeCrude, `{"AD.Value":{"$gt":0}}`)
+	n.fails("Sort is not valid JSON", "queryAssets", TypeCrude, `{"AD.Owner":"org1"}`, `[`)
+	n.fails("Sort should be a JSON array", "queryAssets", TypeCrude, `{"AD.Owner":"org1"}`, `{"Timestamp":"asc"}`)
+	n.fails("Field AD.Value can't be sorted for Crude", "queryAssets", TypeCrude, `{"AD.Owner":"org1"}`, `["AD.Value"]`)
+	n.fails("Sort of Timestamp should be asc or desc", "queryAssets", TypeCrude, `{"AD.Owner":"org1"}`, `[{"Timestamp":"up"}]`)
+	n.fails("Page size should be an int number", "queryAssets", TypeCrude, `{"AD.Owner":"org1"}`, "", "-1")
+	n.fails("Paginated queries are not supported by this peer", "queryAssets", TypeFuelOrder,
+		`{"$or":[{"Dest":"org5"},{"AD.Quantity":{"$gte":10}}]}`, `[{"Timestamp":"desc"}]`, "10")
+}
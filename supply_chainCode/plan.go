@@ -0,0 +1,220 @@
+/*
+Reconciliation of delivery plans against the FuelOrders added by the refinery.
+
+A courier could redistribute litres between fueling stations (see notes), so a FuelDeliveryPlan
+should always agree with the FuelOrders: same destination, each order carried by one plan only,
+and no more fuel than the truck can carry. deliverFuel rejects plans that don't agree and
+reconcilePlan reports the mismatches of an existing plan.
+
+API:
+
+registerVehicle - args[0] = type ('Truck' or 'Vessel'), args[1] = ID, args[2] = capacity. Only the org that
+registered a vehicle updates it.
+reconcilePlan - args[0] = PlanID
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"sort"
+	"strconv"
+)
+
+const (
+	MismatchDestination = "DESTINATION"
+	MismatchQuantity    = "QUANTITY"
+	MismatchDuplicate   = "DUPLICATE"
+	MismatchMissing     = "MISSING"
+)
+
+type PlanMismatch struct {
+	Kind    string
+	OrderID string
+	Detail  string
+}
+
+type PlanReconciliation struct {
+	PlanID        string
+	Veh           Vehicle
+	TotalQuantity int
+	Mismatches    []PlanMismatch
+	Reconciled    bool
+}
+
+/*
+Vehicles are put in db with key type+ID, e.g. 'Truck1234'.
+*/
+func VehicleKey(typ, id string) string {
+	return typ + id
+}
+
+func GetVehicle(stub shim.ChaincodeStubInterface, typ, id string) (Vehicle, error) {
+	vehbytes, _ := stub.GetState(VehicleKey(typ, id))
+	if vehbytes == nil {
+		return Vehicle{}, fmt.Errorf("%s %s is not registered", typ, id)
+	}
+	veh := Vehicle{}
+	if err := json.Unmarshal(vehbytes, &veh); err != nil {
+		return Vehicle{}, fmt.Errorf("%s %s is corrupted", typ, id)
+	}
+	return veh, nil
+}
+
+/*
+A vehicle as it is in db, with the MSP ID of the org that registered it.
+*/
+type RegisteredVehicle struct {
+	Vehicle
+	RegisteredBy string
+}
+
+/*
+args[0] = type ('Truck' or 'Vessel'), args[1] = ID, args[2] = capacity
+Registering an existing vehicle again updates its capacity, only by the org that registered it.
+*/
+func (s *SmartContract) registerVehicle(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleShipper, RoleDistributor, RoleRefiner); err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if args[0] != "Truck" && args[0] != "Vessel" {
+		return shim.Error("Vehicle type should be one of {Truck,Vessel}")
+	}
+	if args[1] == "" {
+		return shim.Error("Vehicle ID should not be empty")
+	}
+	capacity, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || capacity <= 0 {
+		return shim.Error("Capacity should be a positive int number")
+	}
+	msp, err := getCallerMSPID(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to get MSP ID of caller: %s", err))
+	}
+	if vehbytes, _ := stub.GetState(VehicleKey(args[0], args[1])); vehbytes != nil {
+		old := RegisteredVehicle{}
+		if err = json.Unmarshal(vehbytes, &old); err != nil {
+			return shim.Error(fmt.Sprintf("%s %s is corrupted", args[0], args[1]))
+		}
+		if old.RegisteredBy != msp {
+			return shim.Error(fmt.Sprintf("Access denied: %s %s is registered by %s", args[0], args[1], old.RegisteredBy))
+		}
+	}
+	veh := RegisteredVehicle{Vehicle{args[0], args[1], int(capacity)}, msp}
+	vehAsBytes, _ := json.Marshal(veh)
+	err = stub.PutState(VehicleKey(args[0], args[1]), vehAsBytes)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to add %s %s", args[0], args[1]))
+	}
+	return shim.Success(nil)
+}
+
+//IDs of the plan's orders, sorted.
+func (p FuelDeliveryPlan) OrderIDs() []FuelOrderID {
+	ids := make([]FuelOrderID, 0, len(p.Plan))
+	for id := range p.Plan {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+/*
+Checks that the order can be put in a new plan that delivers it with dd.
+*/
+func validatePlanOrder(id FuelOrderID, fuelOrder FuelOrder, dd DeliveryDetails) error {
+	if fuelOrder.AD.State != "READY_FOR_DISTRIBUTION" {
+		return fmt.Errorf("FuelOrderID %s is %s. It is already in another plan or delivered", id, fuelOrder.AD.State)
+	}
+	if fuelOrder.Dest != dd.Destination {
+		return fmt.Errorf("FuelOrderID %s should be delivered to %s, not %s", id, fuelOrder.Dest, dd.Destination)
+	}
+	return nil
+}
+
+func validatePlanCapacity(veh Vehicle, total int) error {
+	if total > veh.Capacity {
+		return fmt.Errorf("%s %s can carry %d but the plan has %d", veh.Type, veh.ID, veh.Capacity, total)
+	}
+	return nil
+}
+
+/*
+args[0] = PlanID
+*/
+func (s *SmartContract) reconcilePlan(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 arg")
+	}
+	rec, err := ReconcilePlan(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	recAsBytes, _ := json.Marshal(rec)
+	return shim.Success(recAsBytes)
+}
+
+func ReconcilePlan(stub shim.ChaincodeStubInterface, planID string) (PlanReconciliation, error) {
+	dplanAsBytes, _ := GetAsset(stub, planID)
+	if dplanAsBytes == nil {
+		return PlanReconciliation{}, errors.New("Could not locate Plan")
+	}
+	dplan := FuelDeliveryPlan{}
+	json.Unmarshal(dplanAsBytes, &dplan)
+
+	//which other plans carry the same orders, from the lineage index (see lineage.go)
+	otherPlans := map[FuelOrderID][]string{}
+	for orderID := range dplan.Plan {
+		planIDs, err := GetChildIDs(stub, IndexOrderPlan, orderID)
+		if err != nil {
+			return PlanReconciliation{}, err
+		}
+		for _, id := range planIDs {
+			if id == planID {
+				continue
+			}
+			other := FuelDeliveryPlan{}
+			if _, err = getAssetAs(stub, id, &other); err != nil {
+				return PlanReconciliation{}, err
+			}
+			//a cancelled plan keeps its stops only as history
+			if other.Cancelled() == false {
+				otherPlans[orderID] = append(otherPlans[orderID], id)
+			}
+		}
+	}
+
+	rec := PlanReconciliation{planID, dplan.Veh, 0, []PlanMismatch{}, true}
+	for _, orderID := range dplan.OrderIDs() {
+		dd := dplan.Plan[orderID]
+		fuelOrderbytes, _ := GetAsset(stub, orderID)
+		if fuelOrderbytes == nil {
+			rec.Mismatches = append(rec.Mismatches, PlanMismatch{MismatchMissing, orderID, "FuelOrder does not exist"})
+			continue
+		}
+		fuelOrder := FuelOrder{}
+		json.Unmarshal(fuelOrderbytes, &fuelOrder)
+		rec.TotalQuantity += fuelOrder.AD.Quantity
+		if fuelOrder.Dest != dd.Destination {
+			rec.Mismatches = append(rec.Mismatches, PlanMismatch{MismatchDestination, orderID,
+				fmt.Sprintf("FuelOrder goes to %s but plan delivers it to %s", fuelOrder.Dest, dd.Destination)})
+		}
+		if plans, ok := otherPlans[orderID]; ok {
+			rec.Mismatches = append(rec.Mismatches, PlanMismatch{MismatchDuplicate, orderID,
+				fmt.Sprintf("FuelOrder is also in %v", plans)})
+		}
+	}
+	if rec.Veh.Capacity > 0 {
+		if err := validatePlanCapacity(rec.Veh, rec.TotalQuantity); err != nil {
+			rec.Mismatches = append(rec.Mismatches, PlanMismatch{MismatchQuantity, "", err.Error()})
+		}
+	}
+	rec.Reconciled = len(rec.Mismatches) == 0
+	return rec, nil
+}
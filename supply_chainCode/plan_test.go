@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRegisterVehicle(t *testing.T) {
+	n := newTestNet(t)
+	n.as("Org4MSP")
+	n.fails("Expecting 3", "registerVehicle", "Truck", "Truck1")
+	n.fails("Vehicle type should be one of {Truck,Vessel}", "registerVehicle", "Train", "Train1", "100")
+	n.fails("Vehicle ID should not be empty", "registerVehicle", "Truck", "", "100")
+	for _, c := range []string{"0", "-5", "1.5", "lots"} {
+		n.fails("Capacity should be a positive int number", "registerVehicle", "Truck", "Truck1", c)
+	}
+	n.as("Org5MSP").fails("Access denied", "registerVehicle", "Truck", "Truck1", "100")
+
+	n.as("Org4MSP").ok("registerVehicle", "Truck", "Truck1", "100")
+	//only the distributor that registered the truck can update it
+	n.as("Org2MSP").fails("Access denied: Truck Truck1 is registered by Org4MSP", "registerVehicle", "Truck", "Truck1", "1")
+	n.as("Org4MSP").ok("registerVehicle", "Truck", "Truck1", "30")
+	veh, err := GetVehicle(n.stub, "Truck", "Truck1")
+	if err != nil || veh.Capacity != 30 {
+		t.Errorf("Truck1 is %+v, %v", veh, err)
+	}
+	//a vehicle with no registrant is not claimed by whoever updates it
+	n.stub.MockTransactionStart("x")
+	n.stub.PutState(VehicleKey("Truck", "Truck2"), []byte(`{"Type":"Truck","ID":"Truck2","Capacity":10}`))
+	n.stub.MockTransactionEnd("x")
+	n.as("Org4MSP").fails("Access denied: Truck Truck2 is registered by ", "registerVehicle", "Truck", "Truck2", "20")
+	orderID := n.newFuelOrder(n.newFuel(n.newCrude()), "org5")
+	other := n.newFuelOrder("Fuel0000000001", "org5")
+	n.as("Org4MSP")
+	n.fails("Truck Truck9 is not registered", "deliverFuel", "Truck9", orderID, fuelEst, "org3", "org5")
+	n.fails("Truck Truck1 can carry 30 but the plan has 40", "deliverFuel", "Truck1", orderID, fuelEst, "org3", "org5", other, fuelEst, "org3", "org5")
+}
+
+func TestReconcilePlan(t *testing.T) {
+	n := newTestNet(t)
+	orderID := n.newFuelOrder(n.newFuel(n.newCrude()), "org5")
+	planID := n.newPlan(orderID)
+	rec := PlanReconciliation{}
+	n.query(&rec, "reconcilePlan", planID)
+	if rec.Reconciled == false || rec.TotalQuantity != 20 || rec.Veh.ID != "Truck1" {
+		t.Fatalf("Reconciliation of %s is %+v", planID, rec)
+	}
+
+	//an order whose destination was changed behind the plan
+	order := FuelOrder{}
+	n.asset(orderID, &order)
+	order.Dest = "org6"
+	orderbytes, _ := json.Marshal(order)
+	n.stub.MockTransactionStart("tamper")
+	PutAsset(n.stub, orderID, orderbytes)
+	n.stub.MockTransactionEnd("tamper")
+	rec = PlanReconciliation{}
+	n.query(&rec, "reconcilePlan", planID)
+	if rec.Reconciled || len(rec.Mismatches) != 1 || rec.Mismatches[0].Kind != MismatchDestination || rec.Mismatches[0].OrderID != orderID {
+		t.Errorf("Reconciliation of %s is %+v", planID, rec)
+	}
+
+	//and another plan that carries the same order
+	dup := FuelDeliveryPlan{}
+	n.asset(planID, &dup)
+	dupbytes, _ := json.Marshal(dup)
+	n.stub.MockTransactionStart("duplicate")
+	PutAsset(n.stub, "Plan0000000007", dupbytes)
+	n.stub.MockTransactionEnd("duplicate")
+	rec = PlanReconciliation{}
+	n.query(&rec, "reconcilePlan", planID)
+	if len(rec.Mismatches) != 2 || rec.Mismatches[1].Kind != MismatchDuplicate || rec.Mismatches[1].Detail != "FuelOrder is also in [Plan0000000007]" {
+		t.Errorf("Reconciliation of %s is %+v", planID, rec)
+	}
+	n.fails("Could not locate Plan", "reconcilePlan", "Plan0000000009")
+	n.fails("Expecting 1 arg", "reconcilePlan")
+}
@@ -0,0 +1,537 @@
+/*
+Private commercial terms.
+
+The value of a Crude or a FuelOrder is a term between its supplier and its buyer only. It is passed in the
+transient map of the tx (so it isn't written in the tx of the block) under the key 'terms', e.g.
+
+	{"Value":"1250.00","Salt":"6f1d0c2b9a8e7d3c"}
+
+and put in the private data collection of the trading pair, 'pair-<org>-<org>' with the orgs in order
+(see collections_config.json, which is shipped with the chaincode). The public asset has Value 0, the
+Collection and the TermsHash: SHA256 of assetID, value, currency and salt, so that an org that is shown the
+terms can check them against the ledger (verifyTerms) without the other orgs learning the value.
+The salt is chosen by the client and should be random, or a small value could be found from its hash.
+
+The public escrow of a private asset only holds its transport fee (see escrow.go). Its value is escrowed in
+the collection: the escrow of the asset is put there too, under the same key, and the pair keeps what each
+of them has locked in such escrows. The buyer should have the funds for it: what it has locked in the pair,
+the new value included, is checked against its public balance and credit limit, after the fee (see credit.go).
+So an org can't take on more private deals with a supplier than it could pay in public, while the other orgs
+only learn that the tx went through.
+
+On transfer the buyer pays the supplier from the private escrow and the rest of it is released, as in public.
+The private payments are journaled as entries with the terms (Entries, returned by queryTerms) and the pair
+keeps what each of them has paid the other, per currency, to be cleared through their banks. The carrier is
+paid in public, as before. A cancelled asset gets its private escrow refunded, and a disputed delivery has it
+released when the dispute is resolved, with nothing paid to the supplier unless the arbiter awards it.
+
+The value is committed on the public ledger by the TermsHash of the asset, and every write to the collection
+by its hash in the block, so a party can prove the escrow and the payments of the pair to a third org.
+A tx on a private asset should be endorsed by peers of the pair, so the endorsement policy should be
+satisfiable by the two orgs of a pair (see upgradeChaincode in scripts/myutils.sh).
+
+Values passed as positional args (public) are still accepted, but the transient map is the supported way.
+An org registered after the chaincode was instantiated trades privately once the collections of its pairs
+are added to collections_config.json and the chaincode is upgraded.
+
+API:
+
+queryTerms - args[0] = assetID. Only on the peers of the pair.
+verifyTerms - args[0] = assetID, transient 'terms' = the terms to check. Returns whether they match the TermsHash.
+queryPairSettlement - args[0], args[1] = orgs, args[2] = currency (optional, BaseCurrency if missing). Only on the peers of the pair.
+*/
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"sort"
+	"time"
+)
+
+const (
+	TransientTermsKey = "terms"
+	MinSaltLength     = 16
+)
+
+/*
+Terms as passed in the transient map.
+*/
+type Terms struct {
+	Value Money
+	Salt  string
+}
+
+/*
+Put in the collection of the pair with key 'Terms'+assetID.
+Parties are the supplier and the buyer, Payments what the buyer paid the supplier for the asset and Entries
+the private journal of the asset: the escrow of its value and what was paid from it.
+*/
+type PrivateTerms struct {
+	AssetID  string
+	Parties  []string
+	Value    Money
+	Currency string
+	Salt     string
+	Payments []Payment
+	Entries  []JournalEntry `json:",omitempty"`
+}
+
+/*
+Put in the collection of the pair with key 'Settlement'+currency.
+Paid is what each org of the pair has paid the other for private assets, Locked what it has in their
+private escrows.
+*/
+type PairSettlement struct {
+	Parties  []string
+	Currency string
+	Paid     map[string]Money
+	Entries  int
+	LastTxID string
+	Locked   map[string]Money
+}
+
+//the collection shared by two orgs (see collections_config.json)
+func PairCollection(a, b string) string {
+	pair := []string{a, b}
+	sort.Strings(pair)
+	return "pair-" + pair[0] + "-" + pair[1]
+}
+
+func TermsKey(assetID string) string {
+	return "Terms" + assetID
+}
+
+func SettlementKey(currency string) string {
+	return "Settlement" + currency
+}
+
+func TermsHash(assetID string, value Money, currency, salt string) string {
+	sum := sha256.Sum256([]byte(assetID + "\x00" + value.String() + "\x00" + currency + "\x00" + salt))
+	return hex.EncodeToString(sum[:])
+}
+
+func (t PrivateTerms) Hash() string {
+	return TermsHash(t.AssetID, t.Value, t.Currency, t.Salt)
+}
+
+func (t PrivateTerms) Collection() string {
+	return PairCollection(t.Parties[0], t.Parties[1])
+}
+
+/*
+Returns the terms in the transient map of the tx or nil if there are none.
+*/
+func GetTransientTerms(stub shim.ChaincodeStubInterface) (*Terms, error) {
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return nil, errors.New("Failed to read the transient map")
+	}
+	tbytes, ok := transient[TransientTermsKey]
+	if !ok {
+		return nil, nil
+	}
+	terms := Terms{}
+	if err = json.Unmarshal(tbytes, &terms); err != nil {
+		return nil, fmt.Errorf("Invalid terms in the transient map: %s", err)
+	}
+	if terms.Value < 0 {
+		return nil, errors.New("Value of the terms should not be negative")
+	}
+	if len(terms.Salt) < MinSaltLength {
+		return nil, fmt.Errorf("Salt of the terms should have at least %d characters", MinSaltLength)
+	}
+	return &terms, nil
+}
+
+/*
+If the tx has terms in its transient map, makes them the private terms of a new asset between supplier
+and buyer and clears the value of ad, which should have been passed as 0. Returns nil for a public asset.
+*/
+func NewPrivateTerms(stub shim.ChaincodeStubInterface, ad *AssetDetails, supplier, buyer string) (*PrivateTerms, error) {
+	terms, err := GetTransientTerms(stub)
+	if err != nil || terms == nil {
+		return nil, err
+	}
+	if ad.Value != 0 {
+		return nil, errors.New("Value should be 0 in the args when the terms are in the transient map")
+	}
+	if supplier == buyer {
+		return nil, fmt.Errorf("%s can't have private terms with itself", supplier)
+	}
+	parties := []string{supplier, buyer}
+	ad.Collection = PairCollection(supplier, buyer)
+	return &PrivateTerms{"", parties, terms.Value, ad.AssetCurrency(), terms.Salt, nil, nil}, nil
+}
+
+/*
+Puts the terms of the new asset id in the collection of the pair and their hash on ad.
+*/
+func PutNewTerms(stub shim.ChaincodeStubInterface, id string, ad *AssetDetails, t *PrivateTerms) error {
+	if t == nil {
+		return nil
+	}
+	t.AssetID = id
+	ad.TermsHash = t.Hash()
+	return PutTerms(stub, *t)
+}
+
+func PutTerms(stub shim.ChaincodeStubInterface, t PrivateTerms) error {
+	tbytes, _ := json.Marshal(t)
+	if err := stub.PutPrivateData(t.Collection(), TermsKey(t.AssetID), tbytes); err != nil {
+		return fmt.Errorf("Failed to put terms of %s in %s", t.AssetID, t.Collection())
+	}
+	return nil
+}
+
+/*
+Returns the private terms of the asset, checked against its TermsHash, or nil if its value is public.
+*/
+func GetTerms(stub shim.ChaincodeStubInterface, id string, ad AssetDetails) (*PrivateTerms, error) {
+	if ad.Collection == "" {
+		return nil, nil
+	}
+	tbytes, err := stub.GetPrivateData(ad.Collection, TermsKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read terms of %s from %s", id, ad.Collection)
+	}
+	if tbytes == nil {
+		return nil, fmt.Errorf("Terms of %s are in %s. The tx should be endorsed by a peer of the pair", id, ad.Collection)
+	}
+	t := PrivateTerms{}
+	if err = json.Unmarshal(tbytes, &t); err != nil {
+		return nil, fmt.Errorf("Terms of %s are corrupted", id)
+	}
+	if t.Hash() != ad.TermsHash {
+		return nil, fmt.Errorf("Terms of %s don't match their hash on the ledger", id)
+	}
+	return &t, nil
+}
+
+/*
+The asset details with the value of the private terms, if any, for computing payments.
+*/
+func (t *PrivateTerms) Details(ad AssetDetails) AssetDetails {
+	if t != nil {
+		ad.Value = t.Value
+	}
+	return ad
+}
+
+func GetPairSettlement(stub shim.ChaincodeStubInterface, a, b, currency string) (PairSettlement, error) {
+	collection := PairCollection(a, b)
+	sbytes, err := stub.GetPrivateData(collection, SettlementKey(currency))
+	if err != nil {
+		return PairSettlement{}, fmt.Errorf("Failed to read settlement from %s", collection)
+	}
+	parties := []string{a, b}
+	sort.Strings(parties)
+	s := PairSettlement{parties, currency, map[string]Money{}, 0, "", map[string]Money{}}
+	if sbytes == nil {
+		return s, nil
+	}
+	if err = json.Unmarshal(sbytes, &s); err != nil {
+		return PairSettlement{}, fmt.Errorf("Settlement of %s is corrupted", collection)
+	}
+	return s, nil
+}
+
+func PutPairSettlement(stub shim.ChaincodeStubInterface, s PairSettlement) error {
+	collection := PairCollection(s.Parties[0], s.Parties[1])
+	sbytes, _ := json.Marshal(s)
+	if err := stub.PutPrivateData(collection, SettlementKey(s.Currency), sbytes); err != nil {
+		return fmt.Errorf("Failed to put settlement in %s", collection)
+	}
+	return nil
+}
+
+/*
+Returns the private escrow of the asset or nil if it has none (assets before private escrows).
+*/
+func GetPrivateEscrow(stub shim.ChaincodeStubInterface, t *PrivateTerms) (*Escrow, error) {
+	ebytes, err := stub.GetPrivateData(t.Collection(), EscrowKey(t.AssetID))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read escrow of %s from %s", t.AssetID, t.Collection())
+	}
+	if ebytes == nil {
+		return nil, nil
+	}
+	e := Escrow{}
+	if err = json.Unmarshal(ebytes, &e); err != nil {
+		return nil, fmt.Errorf("Escrow of %s is corrupted", t.AssetID)
+	}
+	return &e, nil
+}
+
+func putPrivateEscrow(stub shim.ChaincodeStubInterface, t *PrivateTerms, e Escrow) error {
+	ebytes, _ := json.Marshal(e)
+	if err := stub.PutPrivateData(t.Collection(), EscrowKey(e.AssetID), ebytes); err != nil {
+		return fmt.Errorf("Failed to put escrow of %s in %s", e.AssetID, t.Collection())
+	}
+	return nil
+}
+
+/*
+Journals the payments in the terms, which the caller puts in the collection. They are paid through the banks
+of the pair, so the amounts are in the currency of the terms for both of them.
+*/
+func (t *PrivateTerms) journal(stub shim.ChaincodeStubInterface, memo string, payments []Payment) error {
+	tstamp, err := TxTime(stub)
+	if err != nil {
+		return err
+	}
+	txID := stub.GetTxID()
+	for i, p := range payments {
+		//p marks the private entries of the tx, apart from its public ones
+		t.Entries = append(t.Entries, JournalEntry{fmt.Sprintf("%s.p%04d", txID, i), txID, tstamp, p.Payer, p.Payee,
+			p.Amount, t.Currency, p.Amount, p.Amount, t.AssetID, memo, p.Rule})
+	}
+	return nil
+}
+
+/*
+Locks the private value of a new asset in the collection of its pair, with the escrow period of the public
+escrows. feeDebited is what the public escrow of the fee cost the buyer in this tx, in the currency of its
+account, which the check of its funds doesn't see yet.
+*/
+func LockPrivateEscrow(stub shim.ChaincodeStubInterface, t *PrivateTerms, feeDebited Money) error {
+	if t == nil {
+		return nil
+	}
+	buyer := t.Parties[1]
+	s, err := GetPairSettlement(stub, t.Parties[0], t.Parties[1], t.Currency)
+	if err != nil {
+		return err
+	}
+	s.Locked[buyer] += t.Value
+	acc, err := GetAccountState(stub, buyer)
+	if err != nil {
+		return err
+	}
+	locked, err := Convert(stub, s.Locked[buyer], t.Currency, acc.Currency)
+	if err != nil {
+		return fmt.Errorf("Private escrow of %s in %s: %s", t.AssetID, t.Currency, err)
+	}
+	acc.Balance -= feeDebited + locked
+	if err = CheckFunds(acc); err != nil {
+		return err
+	}
+	tstamp, err := TxTime(stub)
+	if err != nil {
+		return err
+	}
+	period, err := GetEscrowPeriod(stub)
+	if err != nil {
+		return err
+	}
+	debited, err := Convert(stub, t.Value, t.Currency, acc.Currency)
+	if err != nil {
+		return err
+	}
+	e := Escrow{t.AssetID, buyer, t.Value, t.Currency, debited, tstamp, tstamp.Add(period), time.Time{}, EscrowLocked}
+	if err = putPrivateEscrow(stub, t, e); err != nil {
+		return err
+	}
+	if err = t.journal(stub, "escrow of "+t.AssetID, []Payment{{buyer, EscrowAccountOf(t.AssetID), t.Value, t.Currency, ""}}); err != nil {
+		return err
+	}
+	if err = PutTerms(stub, *t); err != nil {
+		return err
+	}
+	return PutPairSettlement(stub, s)
+}
+
+/*
+Pays the private escrow of the asset back to the buyer, if it is LOCKED.
+*/
+func RefundPrivateEscrow(stub shim.ChaincodeStubInterface, t *PrivateTerms, memo string) error {
+	e, err := GetPrivateEscrow(stub, t)
+	if err != nil || e == nil || e.Status != EscrowLocked {
+		return err
+	}
+	s, err := GetPairSettlement(stub, t.Parties[0], t.Parties[1], t.Currency)
+	if err != nil {
+		return err
+	}
+	if e.Closed, err = TxTime(stub); err != nil {
+		return err
+	}
+	e.Status = EscrowRefunded
+	s.Locked[e.Buyer] -= e.Amount
+	if err = putPrivateEscrow(stub, t, *e); err != nil {
+		return err
+	}
+	if err = t.journal(stub, memo, []Payment{{EscrowAccountOf(t.AssetID), e.Buyer, e.Amount, t.Currency, ""}}); err != nil {
+		return err
+	}
+	if err = PutTerms(stub, *t); err != nil {
+		return err
+	}
+	return PutPairSettlement(stub, s)
+}
+
+//releases the private escrow of the asset, if it has private terms, without paying the supplier
+func releasePrivateEscrow(stub shim.ChaincodeStubInterface, id string, memo string) error {
+	ad, err := getAssetDetails(stub, id)
+	if err != nil {
+		return err
+	}
+	t, err := GetTerms(stub, id, ad)
+	if err != nil || t == nil {
+		return err
+	}
+	return SettlePrivately(stub, t, memo, nil)
+}
+
+/*
+Pays for the asset inside the collection of its pair: what the buyer owes is paid from the private escrow
+of the asset, if it is still LOCKED, which is then released. The payments are kept and journaled with the
+terms and added to what the payer has paid in the settlement of the pair. Without payments a LOCKED private
+escrow is paid back to the buyer, e.g. on the resolution of a dispute.
+*/
+func SettlePrivately(stub shim.ChaincodeStubInterface, t *PrivateTerms, memo string, payments []Payment) error {
+	for _, p := range payments {
+		if p.Payer != t.Parties[1] || p.Payee != t.Parties[0] {
+			return fmt.Errorf("Only %s pays %s for %s in private", t.Parties[1], t.Parties[0], t.AssetID)
+		}
+	}
+	e, err := GetPrivateEscrow(stub, t)
+	if err != nil {
+		return err
+	}
+	locked := e != nil && e.Status == EscrowLocked
+	if len(payments) == 0 && locked == false {
+		return nil
+	}
+	s, err := GetPairSettlement(stub, t.Parties[0], t.Parties[1], t.Currency)
+	if err != nil {
+		return err
+	}
+	journaled := payments
+	if locked {
+		tstamp, err := TxTime(stub)
+		if err != nil {
+			return err
+		}
+		journaled = e.release(payments, tstamp)
+		s.Locked[e.Buyer] -= e.Amount
+		if err = putPrivateEscrow(stub, t, *e); err != nil {
+			return err
+		}
+	}
+	for _, p := range payments {
+		s.Paid[p.Payer] += p.Amount
+		s.Entries++
+	}
+	s.LastTxID = stub.GetTxID()
+	t.Payments = append(t.Payments, payments...)
+	if err = t.journal(stub, memo, journaled); err != nil {
+		return err
+	}
+	if err = PutTerms(stub, *t); err != nil {
+		return err
+	}
+	return PutPairSettlement(stub, s)
+}
+
+/*
+Pays for a delivered asset: oa[0] is the carrier, paid in public, and oa[1], if any, the supplier, paid in the
+collection of the pair when the terms are private (see Pay). Returns the payments to keep on the public asset.
+*/
+func PayDelivery(stub shim.ChaincodeStubInterface, id string, ad AssetDetails, t *PrivateTerms, oa []OrgAmount) ([]Payment, error) {
+	if t == nil {
+		if err := Pay(stub, id, ad, oa); err != nil {
+			return nil, err
+		}
+		return NewPayments(ad, oa), nil
+	}
+	if err := Pay(stub, id, ad, oa[:1]); err != nil {
+		return nil, err
+	}
+	if err := SettlePrivately(stub, t, "delivery of "+id, NewPayments(ad, oa[1:])); err != nil {
+		return nil, err
+	}
+	return NewPayments(ad, oa[:1]), nil
+}
+
+func getAssetDetails(stub shim.ChaincodeStubInterface, id string) (AssetDetails, error) {
+	assetAsBytes, _ := GetAsset(stub, id)
+	if assetAsBytes == nil {
+		return AssetDetails{}, errors.New("Could not locate Asset")
+	}
+	asset := struct{ AD AssetDetails }{}
+	if err := json.Unmarshal(assetAsBytes, &asset); err != nil {
+		return AssetDetails{}, fmt.Errorf("%s is corrupted", id)
+	}
+	return asset.AD, nil
+}
+
+func (s *SmartContract) queryTerms(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 arg")
+	}
+	ad, err := getAssetDetails(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	t, err := GetTerms(stub, args[0], ad)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if t == nil {
+		return shim.Error(fmt.Sprintf("Value of %s is public", args[0]))
+	}
+	tbytes, _ := json.Marshal(t)
+	return shim.Success(tbytes)
+}
+
+/*
+args[0] = assetID, transient 'terms' = {Value,Salt} as shown by a party of the asset.
+Any org can check them, as only their hash is read.
+*/
+func (s *SmartContract) verifyTerms(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 arg")
+	}
+	ad, err := getAssetDetails(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if ad.Collection == "" {
+		return shim.Error(fmt.Sprintf("Value of %s is public", args[0]))
+	}
+	terms, err := GetTransientTerms(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if terms == nil {
+		return shim.Error("Expecting the terms in the transient map")
+	}
+	result := struct {
+		AssetID string
+		Valid   bool
+	}{args[0], TermsHash(args[0], terms.Value, ad.AssetCurrency(), terms.Salt) == ad.TermsHash}
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
+
+func (s *SmartContract) queryPairSettlement(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Expecting 2 orgs and optionally a currency")
+	}
+	currency := OptionalArg(args, 2)
+	if currency == "" {
+		currency = BaseCurrency
+	}
+	settlement, err := GetPairSettlement(stub, args[0], args[1], currency)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	sbytes, _ := json.Marshal(settlement)
+	return shim.Success(sbytes)
+}
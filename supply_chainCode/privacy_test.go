@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const salt = "6f1d0c2b9a8e7d3c"
+
+func TestPrivateCrude(t *testing.T) {
+	n := newTestNet(t)
+	terms := Terms{money("1000.00"), salt}
+	crudeID := n.as("Org1MSP").okPrivate(terms, "deliverCrude", "0", "100", "org1", crudeEst, "org1", "org3", "Vessel1", dispatched)
+	crude := Crude{}
+	n.asset(crudeID, &crude)
+	if crude.AD.Value != 0 || crude.AD.Collection != "pair-org1-org3" || crude.AD.TermsHash != TermsHash(crudeID, terms.Value, BaseCurrency, salt) {
+		t.Fatalf("Public %s is %+v", crudeID, crude.AD)
+	}
+	//only the fee is escrowed
+	n.balances(map[string]Money{"org3": openingBalance - money("10.00"), EscrowAccountOf(crudeID): money("10.00")})
+
+	pt := PrivateTerms{}
+	n.query(&pt, "queryTerms", crudeID)
+	if pt.Value != terms.Value || pt.AssetID != crudeID || len(pt.Parties) != 2 || pt.Parties[1] != "org3" {
+		t.Errorf("Terms of %s are %+v", crudeID, pt)
+	}
+	result := struct {
+		AssetID string
+		Valid   bool
+	}{}
+	if err := json.Unmarshal([]byte(n.as("Org5MSP").okPrivate(terms, "verifyTerms", crudeID)), &result); err != nil || result.Valid == false {
+		t.Errorf("The terms of %s don't verify: %+v, %v", crudeID, result, err)
+	}
+	json.Unmarshal([]byte(n.okPrivate(Terms{money("900.00"), salt}, "verifyTerms", crudeID)), &result)
+	if result.Valid {
+		t.Errorf("Other terms of %s verify", crudeID)
+	}
+
+	//the carrier is paid in public, the driller in the collection
+	n.as("Org3MSP").ok("transfer", crudeID, "org3", crudeEst)
+	n.balances(map[string]Money{
+		"org1": openingBalance,
+		"org2": openingBalance + money("10.00"),
+		"org3": openingBalance - money("10.00"),
+	})
+	settlement := PairSettlement{}
+	n.query(&settlement, "queryPairSettlement", "org3", "org1")
+	if settlement.Paid["org3"] != money("1000.00") || settlement.Entries != 1 || settlement.Currency != BaseCurrency {
+		t.Errorf("Settlement of org1 and org3 is %+v", settlement)
+	}
+	n.query(&pt, "queryTerms", crudeID)
+	if len(pt.Payments) != 1 || pt.Payments[0].Payee != "org1" {
+		t.Errorf("Private payments of %s are %+v", crudeID, pt.Payments)
+	}
+	n.checkBooks()
+}
+
+func TestPrivateFuelOrder(t *testing.T) {
+	n := newTestNet(t)
+	fuelID := n.newFuel(n.newCrude())
+	orderID := n.as("Org3MSP").okPrivate(Terms{money("480.00"), salt}, "addFuelOrder", "0", "20", "org3", "org5", fuelID, ordered)
+	n.balances(map[string]Money{"org5": openingBalance - money("2.00")})
+	planID := n.newPlan(orderID)
+	n.as("Org5MSP").ok("transfer", orderID, "org5", fuelEst, planID)
+	n.balances(map[string]Money{
+		"org3": openingBalance - money("1010.00"),
+		"org4": openingBalance + money("2.00"),
+		"org5": openingBalance - money("2.00"),
+	})
+	settlement := PairSettlement{}
+	n.query(&settlement, "queryPairSettlement", "org3", "org5", BaseCurrency)
+	if settlement.Paid["org5"] != money("480.00") || settlement.Parties[0] != "org3" {
+		t.Errorf("Settlement of org3 and org5 is %+v", settlement)
+	}
+	n.checkBooks()
+}
+
+func TestPrivacyErrors(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.newCrude()
+	args := []string{"0", "100", "org1", crudeEst, "org1", "org3", "Vessel1", dispatched}
+	n.failsPrivate("Value should be 0 in the args", Terms{money("1000.00"), salt}, "deliverCrude", append([]string{"1000.00"}, args[1:]...)...)
+	n.failsPrivate("Salt of the terms should have at least 16 characters", Terms{money("1000.00"), "short"}, "deliverCrude", args...)
+	n.failsPrivate("Value of the terms should not be negative", Terms{-1, salt}, "deliverCrude", args...)
+
+	n.fails("Value of Crude0000000001 is public", "queryTerms", crudeID)
+	n.fails("Value of Crude0000000001 is public", "verifyTerms", crudeID)
+	n.fails("Could not locate Asset", "queryTerms", "Crude0000000009")
+	n.fails("Expecting 1 arg", "queryTerms")
+	n.fails("Expecting 1 arg", "verifyTerms")
+	privateID := n.okPrivate(Terms{money("1000.00"), salt}, "deliverCrude", args...)
+	//without terms in the transient map
+	n.fails("Expecting the terms in the transient map", "verifyTerms", privateID)
+	n.fails("Expecting 2 orgs and optionally a currency", "queryPairSettlement", "org1")
+
+	//the pair has settled nothing yet
+	settlement := PairSettlement{}
+	n.query(&settlement, "queryPairSettlement", "org1", "org3")
+	if settlement.Entries != 0 || len(settlement.Paid) != 0 {
+		t.Errorf("Settlement of org1 and org3 is %+v", settlement)
+	}
+}
+
+//the private escrow of a pair, read from the collection as its peers would
+func (n *testNet) privateEscrow(id, collection string) Escrow {
+	n.t.Helper()
+	ebytes, _ := n.stub.GetPrivateData(collection, EscrowKey(id))
+	e := Escrow{}
+	if err := json.Unmarshal(ebytes, &e); err != nil {
+		n.t.Fatalf("No private escrow of %s in %s", id, collection)
+	}
+	return e
+}
+
+func TestPrivateEscrow(t *testing.T) {
+	n := newTestNet(t)
+	args := []string{"0", "100", "org1", crudeEst, "org1", "org3", "Vessel1", dispatched}
+	//org3 can't pay more than its balance after the fee
+	n.as("Org1MSP").failsPrivate(ErrInsufficientFunds, Terms{openingBalance - money("5.00"), salt}, "deliverCrude", args...)
+	crudeID := n.okPrivate(Terms{money("60000.00"), salt}, "deliverCrude", args...)
+	if e := n.privateEscrow(crudeID, "pair-org1-org3"); e.Status != EscrowLocked || e.Amount != money("60000.00") || e.Buyer != "org3" {
+		t.Fatalf("Private escrow of %s is %+v", crudeID, e)
+	}
+	//the locks of the pair add up
+	n.failsPrivate(ErrInsufficientFunds, Terms{money("50000.00"), salt}, "deliverCrude", args...)
+	otherID := n.okPrivate(Terms{money("30000.00"), salt}, "deliverCrude", args...)
+	settlement := PairSettlement{}
+	n.query(&settlement, "queryPairSettlement", "org1", "org3")
+	if settlement.Locked["org3"] != money("90000.00") {
+		t.Errorf("Settlement of org1 and org3 is %+v", settlement)
+	}
+
+	//a cancellation refunds the private escrow
+	n.ok("cancelOrder", otherID, "no longer needed")
+	if e := n.privateEscrow(otherID, "pair-org1-org3"); e.Status != EscrowRefunded {
+		t.Errorf("Private escrow of cancelled %s is %+v", otherID, e)
+	}
+	pt := PrivateTerms{}
+	n.query(&pt, "queryTerms", otherID)
+	if len(pt.Entries) != 2 || pt.Entries[1].Debit != EscrowAccountOf(otherID) || pt.Entries[1].Credit != "org3" {
+		t.Errorf("Private journal of %s is %+v", otherID, pt.Entries)
+	}
+
+	//a delivery pays the driller from the private escrow
+	n.as("Org3MSP").ok("transfer", crudeID, "org3", crudeEst)
+	if e := n.privateEscrow(crudeID, "pair-org1-org3"); e.Status != EscrowReleased {
+		t.Errorf("Private escrow of delivered %s is %+v", crudeID, e)
+	}
+	n.query(&settlement, "queryPairSettlement", "org1", "org3")
+	if settlement.Locked["org3"] != 0 || settlement.Paid["org3"] != money("60000.00") {
+		t.Errorf("Settlement of org1 and org3 is %+v", settlement)
+	}
+	n.query(&pt, "queryTerms", crudeID)
+	if len(pt.Entries) != 2 || pt.Entries[0].Credit != EscrowAccountOf(crudeID) || pt.Entries[1].Credit != "org1" ||
+		pt.Entries[1].Amount != money("60000.00") || pt.Entries[1].ID == pt.Entries[0].ID {
+		t.Errorf("Private journal of %s is %+v", crudeID, pt.Entries)
+	}
+	n.checkBooks()
+}
+
+func TestPrivateEscrowOfDisputedDelivery(t *testing.T) {
+	n := newTestNet(t)
+	fuelID := n.newFuel(n.newCrude())
+	orderID := n.as("Org3MSP").okPrivate(Terms{money("480.00"), salt}, "addFuelOrder", "0", "20", "org3", "org5", fuelID, ordered)
+	planID := n.newPlan(orderID)
+	n.as("Org5MSP").ok("transfer", orderID, "org5", fuelEst, planID, "20", "0.95")
+	if e := n.privateEscrow(orderID, "pair-org3-org5"); e.Status != EscrowLocked {
+		t.Fatalf("Private escrow of disputed %s is %+v", orderID, e)
+	}
+	var disputes []disputeRecord
+	n.query(&disputes, "queryDisputes", DisputeOpen, "org5")
+	n.as("Org1MSP").ok("resolveDispute", disputes[0].Key, "fuel sent back")
+	if e := n.privateEscrow(orderID, "pair-org3-org5"); e.Status != EscrowReleased {
+		t.Errorf("Private escrow of %s is %+v after its dispute", orderID, e)
+	}
+	settlement := PairSettlement{}
+	n.query(&settlement, "queryPairSettlement", "org3", "org5")
+	if settlement.Locked["org5"] != 0 || len(settlement.Paid) != 0 {
+		t.Errorf("Settlement of org3 and org5 is %+v", settlement)
+	}
+	//a settlement is always put with what is locked, so it is read back as it was put
+	sbytes, _ := n.stub.GetPrivateData("pair-org3-org5", SettlementKey(BaseCurrency))
+	if strings.Contains(string(sbytes), `"Locked":{"org5":`) == false {
+		t.Errorf("Settlement of org3 and org5 in db is %s", sbytes)
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Quantity conservation between Crude, Fuel and FuelOrder.
+
+Refining consumes crude: a Fuel of quantity q uses q/yield of its Crude, where yield is the refining
+yield ratio kept on the ledger (1 - yield is the loss of the refining process).
+Cutting a FuelOrder consumes its quantity of the Fuel.
+Crude and Fuel keep how much of them has been Allocated to children and a tx that would allocate
+more than what remains is rejected, so retailers can't be sold fuel that was never produced.
+
+API:
+
+setRefiningYield - admin only. args[0] = yield ratio in (0,1]
+auditQuantities - args[0] = CrudeID. Sums of the quantities of the crude and all of its children, found by the
+lineage indexes (see lineage.go).
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"math"
+	"strconv"
+)
+
+const refiningYieldKey = "refiningYield"
+
+//yield used until an admin sets one: no losses.
+const DefaultRefiningYield = 1.0
+
+type FuelQuantityAudit struct {
+	FuelID          string
+	Quantity        int
+	Allocated       int
+	Remaining       int
+	CrudeUsed       int
+	Yield           float64
+	OrderedQuantity int //sum of the quantities of the fuel's orders
+	Balanced        bool
+}
+
+/*
+A crude is Balanced when the crude its fuels used sums up to its Allocated quantity
+and every fuel is Balanced with its orders.
+*/
+type QuantityAudit struct {
+	CrudeID          string
+	Quantity         int
+	Allocated        int
+	Remaining        int
+	CrudeUsedByFuels int
+	Fuels            []FuelQuantityAudit
+	Balanced         bool
+}
+
+func GetRefiningYield(stub shim.ChaincodeStubInterface) (float64, error) {
+	ybytes, err := stub.GetState(refiningYieldKey)
+	if err != nil {
+		return 0, errors.New("Failed to read the refining yield")
+	}
+	if ybytes == nil {
+		return DefaultRefiningYield, nil
+	}
+	var yield float64
+	if err = json.Unmarshal(ybytes, &yield); err != nil {
+		return 0, errors.New("Refining yield is corrupted")
+	}
+	return yield, nil
+}
+
+/*
+args[0] = yield ratio, a float in (0,1]
+*/
+func (s *SmartContract) setRefiningYield(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 arg")
+	}
+	yield, err := strconv.ParseFloat(args[0], 64)
+	if err != nil || yield <= 0 || yield > 1 {
+		return shim.Error("Yield should be a float number in (0,1]")
+	}
+	ybytes, _ := json.Marshal(yield)
+	if err = stub.PutState(refiningYieldKey, ybytes); err != nil {
+		return shim.Error("Failed to put refining yield in db")
+	}
+	return shim.Success(nil)
+}
+
+//quantity of crude needed to refine fuelQuantity of fuel.
+func CrudeNeeded(fuelQuantity int, yield float64) int {
+	//the small epsilon keeps e.g. 90/0.9 from rounding up to 101
+	return int(math.Ceil(float64(fuelQuantity)/yield - 1e-9))
+}
+
+func (c *Crude) Remaining() int {
+	return c.AD.Quantity - c.Allocated
+}
+
+func (c *Crude) allocate(q int) error {
+	if q > c.Remaining() {
+		return fmt.Errorf("Not enough crude: %d needed but only %d remaining", q, c.Remaining())
+	}
+	c.Allocated += q
+	return nil
+}
+
+func (f *Fuel) Remaining() int {
+	return f.AD.Quantity - f.Allocated
+}
+
+func (f *Fuel) allocate(q int) error {
+	if q > f.Remaining() {
+		return fmt.Errorf("Not enough fuel: %d needed but only %d remaining", q, f.Remaining())
+	}
+	f.Allocated += q
+	return nil
+}
+
+/*
+args[0] = CrudeID
+*/
+func (s *SmartContract) auditQuantities(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 arg")
+	}
+	crudeAsBytes, _ := GetAsset(stub, args[0])
+	if crudeAsBytes == nil {
+		return shim.Error("Could not locate crude")
+	}
+	crude := Crude{}
+	json.Unmarshal(crudeAsBytes, &crude)
+
+	audit := QuantityAudit{args[0], crude.AD.Quantity, crude.Allocated, crude.Remaining(), 0, []FuelQuantityAudit{}, true}
+	//the children of the crude from the lineage indexes (see lineage.go)
+	fuelIDs, err := GetChildIDs(stub, IndexCrudeFuel, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, fuelID := range fuelIDs {
+		fuel := Fuel{}
+		if _, err = getAssetAs(stub, fuelID, &fuel); err != nil {
+			return shim.Error(err.Error())
+		}
+		fa := FuelQuantityAudit{fuelID, fuel.AD.Quantity, fuel.Allocated, fuel.Remaining(), fuel.CrudeUsed, fuel.Yield, 0, true}
+		orderIDs, err := GetChildIDs(stub, IndexFuelOrder, fuelID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		for _, orderID := range orderIDs {
+			order := FuelOrder{}
+			if _, err = getAssetAs(stub, orderID, &order); err != nil {
+				return shim.Error(err.Error())
+			}
+			//a cancelled order gave its quantity back to the fuel (see amend.go)
+			if order.AD.State != StateCancelled {
+				fa.OrderedQuantity += order.AD.Quantity
+			}
+		}
+		audit.Fuels = append(audit.Fuels, fa)
+		audit.CrudeUsedByFuels += fuel.CrudeUsed
+	}
+	audit.Balanced = audit.CrudeUsedByFuels == audit.Allocated
+	for i := range audit.Fuels {
+		audit.Fuels[i].Balanced = audit.Fuels[i].OrderedQuantity == audit.Fuels[i].Allocated
+		audit.Balanced = audit.Balanced && audit.Fuels[i].Balanced
+	}
+	auditAsBytes, _ := json.Marshal(audit)
+	return shim.Success(auditAsBytes)
+}
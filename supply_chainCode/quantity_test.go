@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCrudeNeeded(t *testing.T) {
+	for _, c := range []struct {
+		fuel  int
+		yield float64
+		want  int
+	}{{50, 1, 50}, {90, 0.9, 100}, {20, 0.5, 40}, {10, 0.3, 34}} {
+		if q := CrudeNeeded(c.fuel, c.yield); q != c.want {
+			t.Errorf("CrudeNeeded(%d, %v) = %d, expecting %d", c.fuel, c.yield, q, c.want)
+		}
+	}
+}
+
+func TestRefiningYield(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.newCrude()
+	n.newFuel(crudeID)
+	n.as("Org1MSP").fails("Expecting 1 arg", "setRefiningYield")
+	for _, y := range []string{"0", "1.5", "-0.5", "half"} {
+		n.fails("Yield should be a float number in (0,1]", "setRefiningYield", y)
+	}
+	n.as("Org3MSP").fails("Access denied", "setRefiningYield", "0.5")
+
+	//half of the crude is lost, so 20 of fuel use 40 of it
+	n.as("Org1MSP").ok("setRefiningYield", "0.5")
+	fuelID := n.as("Org3MSP").ok("refine", "300.00", "20", "org3", "0.85", "Diesel", crudeID, refined)
+	fuel := Fuel{}
+	n.asset(fuelID, &fuel)
+	if fuel.CrudeUsed != 40 || fuel.Yield != 0.5 {
+		t.Errorf("%s used %d of %s with yield %v", fuelID, fuel.CrudeUsed, crudeID, fuel.Yield)
+	}
+	n.fails("Not enough crude: 12 needed but only 10 remaining", "refine", "1.00", "6", "org3", "0.85", "Diesel", crudeID, refined)
+}
+
+func TestAuditQuantities(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.newCrude()
+	fuelID := n.newFuel(crudeID)
+	n.newFuelOrder(fuelID, "org5")
+	cancelled := n.newFuelOrder(fuelID, "org6")
+	n.as("Org6MSP").ok("cancelOrder", cancelled, "station closed")
+	//another tree, that isn't audited with the crude
+	n.newFuelOrder(n.newFuel(n.newCrude()), "org5")
+	n.as("Org3MSP").fails("Not enough fuel: 40 needed but only 30 remaining", "addFuelOrder", "1.00", "40", "org3", "org5", fuelID, ordered)
+
+	audit := QuantityAudit{}
+	n.query(&audit, "auditQuantities", crudeID)
+	if audit.Quantity != 100 || audit.Allocated != 50 || audit.Remaining != 50 || audit.CrudeUsedByFuels != 50 || audit.Balanced == false {
+		t.Errorf("Audit of %s is %+v", crudeID, audit)
+	}
+	//the cancelled order gave its quantity back
+	if len(audit.Fuels) != 1 || audit.Fuels[0].OrderedQuantity != 20 || audit.Fuels[0].Allocated != 20 || audit.Fuels[0].Balanced == false {
+		t.Errorf("Audit of the fuels of %s is %+v", crudeID, audit.Fuels)
+	}
+	n.fails("Could not locate crude", "auditQuantities", "Crude0000000009")
+	n.fails("Expecting 1 arg", "auditQuantities")
+}
+
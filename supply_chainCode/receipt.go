@@ -0,0 +1,172 @@
+/*
+Proof of delivery.
+
+At handover the receiving org measures what actually arrived and supplies it to transfer.
+A DeliveryReceipt is put in db next to the asset (key 'Receipt'+assetID) with the shortfall against
+the order and the deviation from the density the refiner declared for the fuel.
+
+Within tolerances the receiver pays for the declared quantity (ACCEPTED).
+A quantity shortfall above tolerance makes the receiver pay only for what arrived (ADJUSTED).
+A density deviation above tolerance means the fuel is not the one that was refined, so nothing is
+paid and a QUALITY dispute is opened on the asset (see disputes.go).
+
+API:
+
+setTolerances - admin only. args[0] = quantity tolerance, args[1] = density tolerance (fractions, e.g. 0.01)
+queryReceipt - args[0] = assetID
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	ReceiptAccepted = "ACCEPTED"
+	ReceiptAdjusted = "ADJUSTED"
+	ReceiptDisputed = "DISPUTED"
+)
+
+const tolerancesKey = "tolerances"
+
+/*
+Tolerances are fractions of the expected value.
+e.g. Quantity = 0.005 accepts 995 litres for an order of 1000.
+*/
+type Tolerances struct {
+	Quantity float64
+	Density  float64
+}
+
+var DefaultTolerances = Tolerances{0.005, 0.01}
+
+/*
+Put in db with key 'Receipt'+assetID.
+ExpectedDensity is 0 for crude, which has no declared density.
+*/
+type DeliveryReceipt struct {
+	AssetID           string
+	Receiver          string
+	MeasuredQuantity  int
+	MeasuredDensity   float64
+	ExpectedQuantity  int
+	ExpectedDensity   float64
+	QuantityShortfall int
+	DensityDeviation  float64 //relative to ExpectedDensity
+	Tol               Tolerances
+	Status            string
+	Timestamp         time.Time
+	DisputeID         string //dispute opened for a DISPUTED receipt
+}
+
+func ReceiptKey(assetID string) string {
+	return "Receipt" + assetID
+}
+
+func GetTolerances(stub shim.ChaincodeStubInterface) (Tolerances, error) {
+	tbytes, err := stub.GetState(tolerancesKey)
+	if err != nil {
+		return Tolerances{}, errors.New("Failed to read the tolerances")
+	}
+	if tbytes == nil {
+		return DefaultTolerances, nil
+	}
+	tol := Tolerances{}
+	if err = json.Unmarshal(tbytes, &tol); err != nil {
+		return Tolerances{}, errors.New("Tolerances are corrupted")
+	}
+	return tol, nil
+}
+
+/*
+args[0] = quantity tolerance, args[1] = density tolerance. Both are fractions in [0,1).
+*/
+func (s *SmartContract) setTolerances(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) != 2 {
+		return shim.Error("Expecting 2 args")
+	}
+	qtol, err := strconv.ParseFloat(args[0], 64)
+	if err != nil || qtol < 0 || qtol >= 1 {
+		return shim.Error("Quantity tolerance should be a float number in [0,1)")
+	}
+	dtol, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || dtol < 0 || dtol >= 1 {
+		return shim.Error("Density tolerance should be a float number in [0,1)")
+	}
+	tbytes, _ := json.Marshal(Tolerances{qtol, dtol})
+	if err = stub.PutState(tolerancesKey, tbytes); err != nil {
+		return shim.Error("Failed to put tolerances in db")
+	}
+	return shim.Success(nil)
+}
+
+//construct a new DeliveryReceipt based on supplied measurements and decide its status.
+func NewDeliveryReceipt(id, receiver, measQuant, measDens string, expQuant int, expDens float64, tstamp time.Time, tol Tolerances) (DeliveryReceipt, error) {
+	quantity, err := strconv.ParseInt(measQuant, 10, 64)
+	if err != nil || quantity < 0 {
+		return DeliveryReceipt{}, errors.New("Measured quantity is not an int number")
+	}
+	density, err := strconv.ParseFloat(measDens, 64)
+	if err != nil || density < 0 {
+		return DeliveryReceipt{}, errors.New("Measured density is not a float number")
+	}
+	r := DeliveryReceipt{id, receiver, int(quantity), density, expQuant, expDens, 0, 0, tol, ReceiptAccepted, tstamp, ""}
+	if r.MeasuredQuantity < r.ExpectedQuantity {
+		r.QuantityShortfall = r.ExpectedQuantity - r.MeasuredQuantity
+	}
+	if r.ExpectedDensity > 0 {
+		r.DensityDeviation = math.Abs(r.MeasuredDensity-r.ExpectedDensity) / r.ExpectedDensity
+	}
+	switch {
+	case r.DensityDeviation > tol.Density:
+		r.Status = ReceiptDisputed
+	case float64(r.QuantityShortfall) > tol.Quantity*float64(r.ExpectedQuantity):
+		r.Status = ReceiptAdjusted
+	}
+	return r, nil
+}
+
+/*
+Quantity the receiver pays for. Nothing is paid for a DISPUTED delivery.
+*/
+func (r DeliveryReceipt) PayableQuantity() int {
+	switch r.Status {
+	case ReceiptAdjusted:
+		return r.MeasuredQuantity
+	case ReceiptDisputed:
+		return 0
+	}
+	return r.ExpectedQuantity
+}
+
+func PutReceipt(stub shim.ChaincodeStubInterface, r DeliveryReceipt) error {
+	rbytes, _ := json.Marshal(r)
+	if err := stub.PutState(ReceiptKey(r.AssetID), rbytes); err != nil {
+		return fmt.Errorf("Failed to put receipt of %s in db", r.AssetID)
+	}
+	return nil
+}
+
+/*
+args[0] = ID of the delivered Crude or FuelOrder
+*/
+func (s *SmartContract) queryReceipt(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 arg")
+	}
+	rbytes, _ := stub.GetState(ReceiptKey(args[0]))
+	if rbytes == nil {
+		return shim.Error("Could not locate receipt")
+	}
+	return shim.Success(rbytes)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTransferWithReceipt(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.newCrude()
+	//90 arrived, so the refiner pays for 90
+	n.as("Org3MSP").ok("transfer", crudeID, "org3", crudeEst, "90", "0")
+	r := DeliveryReceipt{}
+	n.query(&r, "queryReceipt", crudeID)
+	if r.Status != ReceiptAdjusted || r.QuantityShortfall != 10 {
+		t.Errorf("Receipt of %s is %s with shortfall %d", crudeID, r.Status, r.QuantityShortfall)
+	}
+	n.balances(map[string]Money{
+		"org1": openingBalance + money("900.00"),
+		"org2": openingBalance + money("9.00"),
+		"org3": openingBalance - money("909.00"),
+	})
+	n.checkBooks()
+	n.fails("Could not locate receipt", "queryReceipt", "Crude0000000009")
+	n.fails("Expecting 1 arg", "queryReceipt")
+}
+
+func TestTolerances(t *testing.T) {
+	n := newTestNet(t)
+	n.fails("Expecting 2 args", "setTolerances", "0.2")
+	n.fails("Quantity tolerance should be a float number in [0,1)", "setTolerances", "1", "0.01")
+	n.fails("Density tolerance should be a float number in [0,1)", "setTolerances", "0.2", "-0.01")
+	n.as("Org3MSP").fails("Access denied", "setTolerances", "0.2", "0.01")
+	//a shortfall of 10% is within a tolerance of 20%, so the refiner pays for all of it
+	n.as("Org1MSP").ok("setTolerances", "0.2", "0.01")
+	crudeID := n.newCrude()
+	n.as("Org3MSP").ok("transfer", crudeID, "org3", crudeEst, "90", "0")
+	r := DeliveryReceipt{}
+	n.query(&r, "queryReceipt", crudeID)
+	if r.Status != ReceiptAccepted || r.Tol.Quantity != 0.2 {
+		t.Errorf("Receipt of %s is %+v", crudeID, r)
+	}
+	n.balances(map[string]Money{"org1": openingBalance + money("1000.00")})
+}
+
+func TestTransferDisputedQuality(t *testing.T) {
+	n := newTestNet(t)
+	orderID := n.newFuelOrder(n.newFuel(n.newCrude()), "org5")
+	planID := n.newPlan(orderID)
+	n.as("Org5MSP").ok("transfer", orderID, "org5", fuelEst, planID, "20", "0.95")
+	if state := n.state(orderID); state != "DISPUTED" {
+		t.Fatalf("%s is %s after a delivery of another fuel", orderID, state)
+	}
+	//nothing is paid, the escrow stays locked
+	n.balances(map[string]Money{"org3": openingBalance - money("1010.00"), EscrowAccountOf(orderID): money("502.00")})
+	var disputes []struct {
+		Key    string
+		Record Dispute
+	}
+	n.query(&disputes, "queryDisputes", DisputeOpen, "org5")
+	if len(disputes) != 1 || disputes[0].Record.Reason != ReasonQuality {
+		t.Fatalf("Open disputes of org5 are %+v", disputes)
+	}
+
+	//the station keeps the fuel for 300.00, paid from the escrow, and gets the rest of the escrow back
+	n.as("Org1MSP").ok("resolveDispute", disputes[0].Key, "accepted at a discount", "org5", "org3", "300.00")
+	if state := n.state(orderID); state != "DELIVERED" {
+		t.Errorf("%s is %s after its dispute was resolved", orderID, state)
+	}
+	n.balances(map[string]Money{
+		"org3":                   openingBalance - money("1010.00") + money("300.00"),
+		"org5":                   openingBalance - money("300.00"),
+		EscrowAccountOf(orderID): 0,
+	})
+	e := Escrow{}
+	n.query(&e, "queryEscrow", orderID)
+	if e.Status != EscrowReleased {
+		t.Errorf("Escrow of %s is %s after the dispute was resolved", orderID, e.Status)
+	}
+	n.checkBooks()
+}
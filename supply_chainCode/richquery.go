@@ -0,0 +1,269 @@
+/*
+Rich queries of assets (CouchDB state database only).
+
+queryAssets runs a Mango selector over the assets of one type, e.g. all ON_WAY FuelOrders to org5
+refined before a time:
+
+	queryAssets FuelOrder '{"AD.State":"ON_WAY","Dest":"org5","Timestamp":{"$lt":"2019-01-02T09:00:00Z"}}' '[{"Timestamp":"asc"}]'
+
+Only the fields in QueryFields (dotted paths, as in the JSON of the assets) and the operators below can be
+used, so a client can't run expensive or unexpected queries (e.g. $regex) on the peers.
+Timestamps are compared as strings, which is the order of time as long as they are in UTC.
+AD.Value is a decimal string (see money.go), which doesn't compare as a number, so it can't be queried.
+The selector is restricted to the type by the docType field PutAsset sets on every asset (see keys.go).
+
+The indexes the queries rely on are in META-INF/statedb/couchdb/indexes and are deployed with the chaincode.
+They all start with docType, so CouchDB can use them for the type. A sort needs an index on the sorted fields.
+Assets put before the docType was set are not found until reindexAssets adds it.
+
+API:
+
+queryAssets - args[0] = type {Crude,Fuel,FuelOrder}, args[1] = selector, args[2] = sort (optional),
+	args[3..4] = page size, bookmark (optional, see page.go)
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"strings"
+)
+
+/*
+Fields a selector or a sort can refer to, per type of asset.
+*/
+var QueryFields = map[string][]string{
+	TypeCrude: {"AD.Quantity", "AD.Owner", "AD.State", "AD.Currency",
+		"DD.EstTime", "DD.Delay", "DD.StartingLocation", "DD.Destination",
+		"Veh.Type", "Veh.ID", "Timestamp", "Allocated"},
+	TypeFuel: {"AD.Quantity", "AD.Owner", "AD.State", "AD.Currency",
+		"Density", "Type", "CrudeID", "Timestamp", "Allocated", "CrudeUsed", "Yield"},
+	TypeFuelOrder: {"AD.Quantity", "AD.Owner", "AD.State", "AD.Currency",
+		"Dest", "FuelID", "Timestamp"},
+}
+
+//operators that combine selectors
+var combinationOps = []string{"$and", "$or", "$nor", "$not"}
+
+//operators that compare a field with a value
+var conditionOps = []string{"$eq", "$ne", "$gt", "$gte", "$lt", "$lte", "$exists", "$in", "$nin"}
+
+func IsQueryField(typ, field string) bool {
+	return containsString(QueryFields[typ], field)
+}
+
+/*
+Checks that selector only uses the fields of typ in QueryFields and the allowed operators.
+*/
+func ValidateSelector(typ string, selector interface{}) error {
+	sel, ok := selector.(map[string]interface{})
+	if !ok {
+		return errors.New("Selector should be a JSON object")
+	}
+	for k, v := range sel {
+		switch {
+		case k == "$not":
+			if err := ValidateSelector(typ, v); err != nil {
+				return err
+			}
+		case containsString(combinationOps, k):
+			sels, ok := v.([]interface{})
+			if !ok || len(sels) == 0 {
+				return fmt.Errorf("%s should be followed by an array of selectors", k)
+			}
+			for _, s := range sels {
+				if err := ValidateSelector(typ, s); err != nil {
+					return err
+				}
+			}
+		case strings.HasPrefix(k, "$"):
+			return fmt.Errorf("Operator %s is not allowed", k)
+		case IsQueryField(typ, k) == false:
+			return fmt.Errorf("Field %s can't be queried for %s", k, typ)
+		default:
+			if err := validateCondition(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//the value of a field in a selector: either a value to match or an object of condition operators.
+func validateCondition(field string, cond interface{}) error {
+	ops, ok := cond.(map[string]interface{})
+	if !ok {
+		if isQueryValue(cond) == false {
+			return fmt.Errorf("%s should be compared with a string, number or bool", field)
+		}
+		return nil
+	}
+	if len(ops) == 0 {
+		return fmt.Errorf("Condition of %s is empty", field)
+	}
+	for op, v := range ops {
+		switch op {
+		case "$exists":
+			if _, ok := v.(bool); !ok {
+				return fmt.Errorf("%s of %s should be a bool", op, field)
+			}
+		case "$in", "$nin":
+			values, ok := v.([]interface{})
+			if !ok {
+				return fmt.Errorf("%s of %s should be an array", op, field)
+			}
+			for _, value := range values {
+				if isQueryValue(value) == false {
+					return fmt.Errorf("%s of %s should contain strings, numbers or bools", op, field)
+				}
+			}
+		default:
+			if containsString(conditionOps, op) == false {
+				return fmt.Errorf("Operator %s is not allowed", op)
+			}
+			if isQueryValue(v) == false {
+				return fmt.Errorf("%s of %s should be a string, number or bool", op, field)
+			}
+		}
+	}
+	return nil
+}
+
+func isQueryValue(v interface{}) bool {
+	switch v.(type) {
+	case string, json.Number, float64, bool:
+		return true
+	}
+	return false
+}
+
+/*
+sort is an array of fields or {field: "asc"|"desc"} objects, as in CouchDB.
+*/
+func ValidateSort(typ string, sort interface{}) error {
+	fields, ok := sort.([]interface{})
+	if !ok {
+		return errors.New("Sort should be a JSON array")
+	}
+	for _, f := range fields {
+		switch f := f.(type) {
+		case string:
+			if IsQueryField(typ, f) == false {
+				return fmt.Errorf("Field %s can't be sorted for %s", f, typ)
+			}
+		case map[string]interface{}:
+			if len(f) != 1 {
+				return errors.New("Each sort object should have one field")
+			}
+			for field, dir := range f {
+				if IsQueryField(typ, field) == false {
+					return fmt.Errorf("Field %s can't be sorted for %s", field, typ)
+				}
+				if dir != "asc" && dir != "desc" {
+					return fmt.Errorf("Sort of %s should be asc or desc", field)
+				}
+			}
+		default:
+			return errors.New("Sort should contain fields or {field: asc|desc} objects")
+		}
+	}
+	return nil
+}
+
+/*
+Builds the CouchDB query of a validated selector and sort (nil for no sort), restricted to the assets of typ.
+The sort starts with docType, as the indexes do.
+*/
+func AssetsQuery(typ string, selector, sort interface{}) (string, error) {
+	typeSelector := map[string]interface{}{DocTypeField: typ}
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{"$and": []interface{}{typeSelector, selector}},
+	}
+	if sort != nil {
+		//CouchDB only uses an index for a sort on its fields, from the first, all in the same direction
+		fields := sort.([]interface{})
+		dir := "asc"
+		if len(fields) > 0 {
+			if f, ok := fields[0].(map[string]interface{}); ok {
+				for _, d := range f {
+					dir = d.(string)
+				}
+			}
+		}
+		query["sort"] = append([]interface{}{map[string]interface{}{DocTypeField: dir}}, fields...)
+	}
+	qbytes, err := json.Marshal(query)
+	if err != nil {
+		return "", errors.New("Failed to encode the query")
+	}
+	return string(qbytes), nil
+}
+
+func decodeQueryJSON(s string, v interface{}) error {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+/*
+args[0] = type, one of {Crude,Fuel,FuelOrder}
+args[1] = Mango selector
+args[2] = sort (optional, empty for none)
+args[3] = page size, args[4] = bookmark (optional)
+Returns a page of the assets (see page.go).
+*/
+func (s *SmartContract) queryAssets(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) < 2 {
+		return shim.Error("Expecting at least 2 args")
+	}
+	typ := args[0]
+	if _, ok := QueryFields[typ]; !ok {
+		return shim.Error("Type should be one of {Crude,Fuel,FuelOrder}")
+	}
+	var selector, sort interface{}
+	if err := decodeQueryJSON(args[1], &selector); err != nil {
+		return shim.Error("Selector is not valid JSON")
+	}
+	if err := ValidateSelector(typ, selector); err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) > 2 && args[2] != "" {
+		if err := decodeQueryJSON(args[2], &sort); err != nil {
+			return shim.Error("Sort is not valid JSON")
+		}
+		if err := ValidateSort(typ, sort); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	var pageArgs []string
+	if len(args) > 3 {
+		pageArgs = args[3:]
+	}
+	pageSize, bookmark, err := PageArgs(pageArgs)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	query, err := AssetsQuery(typ, selector, sort)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	resultsIterator, md, err := stub.GetQueryResultWithPagination(query, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Rich queries need CouchDB as state database: %s", err))
+	}
+	page, err := readPage(resultsIterator, md, nil, func(key string, value []byte) (AssetRecord, error) {
+		_, attrs, err := stub.SplitCompositeKey(key)
+		if err != nil || len(attrs) != 1 {
+			return AssetRecord{}, fmt.Errorf("Invalid key of %s", typ)
+		}
+		return AssetRecord{attrs[0], value}, nil
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageAsBytes, _ := json.Marshal(page)
+	return shim.Success(pageAsBytes)
+}
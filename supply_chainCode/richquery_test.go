@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAssetsQuery(t *testing.T) {
+	query, err := AssetsQuery(TypeCrude, map[string]interface{}{"AD.Owner": "org1"}, []interface{}{"Timestamp"})
+	want := `{"selector":{"$and":[{"docType":"Crude"},{"AD.Owner":"org1"}]},"sort":[{"docType":"asc"},"Timestamp"]}`
+	if err != nil || query != want {
+		t.Errorf("Query is %s, expecting %s", query, want)
+	}
+	query, err = AssetsQuery(TypeFuel, map[string]interface{}{"Type": "Diesel"}, []interface{}{map[string]interface{}{"Density": "desc"}})
+	want = `{"selector":{"$and":[{"docType":"Fuel"},{"Type":"Diesel"}]},"sort":[{"docType":"desc"},{"Density":"desc"}]}`
+	if err != nil || query != want {
+		t.Errorf("Query is %s, expecting %s", query, want)
+	}
+}
@@ -0,0 +1,188 @@
+/*
+Role based access control.
+
+Each org plays one or more roles in the supply chain (see the header of all-orgsCC.go).
+The mapping role -> MSP IDs is kept on the ledger under key 'roleMap', so that a new org
+can join (or an org can change role) with an admin tx instead of redeploying the chaincode.
+
+API:
+
+setRoleMSPs - admin only. args[0] = role, args[1..] = MSP IDs of active orgs that play this role (see orgs.go).
+queryRoles
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	RoleAdmin       = "admin"
+	RoleDriller     = "driller"
+	RoleShipper     = "shipper"
+	RoleRefiner     = "refiner"
+	RoleDistributor = "distributor"
+	RoleRetailer    = "retailer"
+	RoleArbiter     = "arbiter"
+)
+
+const roleMapKey = "roleMap"
+
+/*
+Put in db with key 'roleMap'.
+key = role , value = MSP IDs of the orgs playing that role.
+*/
+type RoleMap map[string][]string
+
+/*
+Returns the MSP ID of the org that submitted the tx, from its client certificate.
+*/
+func getCallerMSPID(stub shim.ChaincodeStubInterface) (string, error) {
+	return cid.GetMSPID(stub)
+}
+
+/*
+The role model of the network as described in the header of all-orgsCC.go.
+The org that instantiates the chaincode becomes the admin and the arbiter of disputes.
+*/
+func DefaultRoleMap(adminMSP string) RoleMap {
+	return RoleMap{
+		RoleAdmin:       {adminMSP},
+		RoleDriller:     {"Org1MSP"},
+		RoleShipper:     {"Org2MSP"},
+		RoleRefiner:     {"Org3MSP"},
+		RoleDistributor: {"Org4MSP"},
+		RoleRetailer:    {"Org5MSP", "Org6MSP"},
+		RoleArbiter:     {adminMSP},
+	}
+}
+
+func IsRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleDriller, RoleShipper, RoleRefiner, RoleDistributor, RoleRetailer, RoleArbiter:
+		return true
+	}
+	return false
+}
+
+func (rm RoleMap) HasRole(msp, role string) bool {
+	for _, m := range rm[role] {
+		if m == msp {
+			return true
+		}
+	}
+	return false
+}
+
+func GetRoleMap(stub shim.ChaincodeStubInterface) (RoleMap, error) {
+	rmbytes, err := stub.GetState(roleMapKey)
+	if err != nil {
+		return nil, errors.New("Failed to read the role map")
+	}
+	if rmbytes == nil {
+		return nil, errors.New("Role map doesn't exist. Instantiate or upgrade the chaincode first")
+	}
+	rm := RoleMap{}
+	if err = json.Unmarshal(rmbytes, &rm); err != nil {
+		return nil, errors.New("Role map is corrupted")
+	}
+	return rm, nil
+}
+
+func PutRoleMap(stub shim.ChaincodeStubInterface, rm RoleMap) error {
+	rmbytes, _ := json.Marshal(rm)
+	if err := stub.PutState(roleMapKey, rmbytes); err != nil {
+		return errors.New("Failed to put the role map in db")
+	}
+	return nil
+}
+
+/*
+Create the default role map if there isn't one already.
+Called on instantiate and upgrade, so an existing mapping survives upgrades.
+*/
+func initRoleMap(stub shim.ChaincodeStubInterface) error {
+	if rmbytes, _ := stub.GetState(roleMapKey); rmbytes != nil {
+		return nil
+	}
+	msp, err := getCallerMSPID(stub)
+	if err != nil {
+		return fmt.Errorf("Failed to get MSP ID of caller: %s", err)
+	}
+	return PutRoleMap(stub, DefaultRoleMap(msp))
+}
+
+/*
+Succeeds if the caller's org plays at least one of the supplied roles.
+*/
+func checkRole(stub shim.ChaincodeStubInterface, roles ...string) error {
+	msp, err := getCallerMSPID(stub)
+	if err != nil {
+		return fmt.Errorf("Failed to get MSP ID of caller: %s", err)
+	}
+	rm, err := GetRoleMap(stub)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if rm.HasRole(msp, role) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Access denied: %s should have one of the roles %v", msp, roles)
+}
+
+/*
+args[0] = role
+args[1..] = MSP IDs. They replace the previous MSP IDs of the role.
+Each MSP ID should be the MSP ID of an active org, whose Roles are updated in the same tx.
+*/
+func (s *SmartContract) setRoleMSPs(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) < 1 {
+		return shim.Error("Expecting at least 1 arg")
+	}
+	role := args[0]
+	if IsRole(role) == false {
+		return shim.Error(fmt.Sprintf("Unknown role %s", role))
+	}
+	msps := args[1:]
+	if role == RoleAdmin && len(msps) == 0 {
+		return shim.Error("There should be at least one admin")
+	}
+	for _, msp := range msps {
+		if msp == "" {
+			return shim.Error("MSP ID should not be empty")
+		}
+		if org, err := GetOrgOfMSP(stub, msp); err != nil || org == nil {
+			return shim.Error(fmt.Sprintf("%s is not the MSP ID of an active org", msp))
+		}
+	}
+	rm, err := GetRoleMap(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	rm[role] = msps
+	if err = PutRoleMap(stub, rm); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = syncOrgRole(stub, rm, role); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func (s *SmartContract) queryRoles(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	rmbytes, _ := stub.GetState(roleMapKey)
+	if rmbytes == nil {
+		return shim.Error("Could not locate role map")
+	}
+	return shim.Success(rmbytes)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	n := newTestNet(t)
+	rm := RoleMap{}
+	n.query(&rm, "queryRoles")
+	if rm.HasRole("Org1MSP", RoleAdmin) == false || rm.HasRole("Org3MSP", RoleRefiner) == false {
+		t.Errorf("Default role map is %v", rm)
+	}
+	n.fails("Expecting at least 1 arg", "setRoleMSPs")
+	n.fails("Unknown role pilot", "setRoleMSPs", "pilot", "Org1MSP")
+	n.fails("There should be at least one admin", "setRoleMSPs", RoleAdmin)
+	n.fails("MSP ID should not be empty", "setRoleMSPs", RoleRetailer, "Org5MSP", "")
+	n.fails("Org9MSP is not the MSP ID of an active org", "setRoleMSPs", RoleRetailer, "Org5MSP", "Org9MSP")
+	n.as("Org3MSP").fails("Access denied", "setRoleMSPs", RoleRefiner, "Org3MSP", "Org6MSP")
+
+	//Org6MSP refines too, as org6 only
+	n.as("Org1MSP").ok("setRoleMSPs", RoleRefiner, "Org3MSP", "Org6MSP")
+	crudeID := n.newCrude()
+	n.as("Org3MSP").ok("transfer", crudeID, "org3", crudeEst)
+	n.as("Org6MSP").fails("Access denied: only org3 can create an asset it owns", "refine", "1.00", "1", "org3", "0.85", "Diesel", crudeID, refined)
+	n.fails("Access denied: Crude0000000001 is owned by org3", "refine", "1.00", "1", "org6", "0.85", "Diesel", crudeID, refined)
+	//and the arbiter is another org
+	n.as("Org1MSP").ok("setRoleMSPs", RoleArbiter, "Org4MSP")
+	disputeID := n.ok("openDispute", crudeID, ReasonOther, "2c26b46b")
+	n.fails("Access denied", "resolveDispute", disputeID, "ok")
+	n.as("Org4MSP").ok("resolveDispute", disputeID, "ok")
+
+	//the roles of the orgs are those of their MSP IDs in the role map
+	n.query(&rm, "queryRoles")
+	org := Org{}
+	for _, name := range []string{"org1", "org3", "org4", "org6"} {
+		n.query(&org, "queryOrg", name)
+		if roles := rolesOf(rm, org.MSPID); len(roles) != len(org.Roles) {
+			t.Errorf("Roles of %s are %v, %v in the role map", name, org.Roles, roles)
+		}
+	}
+	if n.query(&org, "queryOrg", "org1"); org.HasRole(RoleArbiter) {
+		t.Errorf("org1 is still the arbiter: %+v", org)
+	}
+	if n.query(&org, "queryOrg", "org6"); org.HasRole(RoleRefiner) == false || org.HasRole(RoleRetailer) == false {
+		t.Errorf("org6 doesn't refine: %+v", org)
+	}
+	n.as("Org1MSP").ok("setRoleMSPs", RoleRefiner, "Org3MSP")
+	if n.query(&org, "queryOrg", "org6"); org.HasRole(RoleRefiner) || org.HasRole(RoleRetailer) == false {
+		t.Errorf("org6 still refines: %+v", org)
+	}
+	//so updating an org keeps the roles it was given
+	n.as("Org1MSP").ok("setRoleMSPs", RoleArbiter, "Org1MSP", "Org4MSP")
+	n.ok("updateOrg", `{"Name":"org4","BankRef":"GR16"}`)
+	if n.query(&rm, "queryRoles"); rm.HasRole("Org4MSP", RoleArbiter) == false {
+		t.Errorf("Org4MSP lost the arbiter role on an update of org4: %v", rm)
+	}
+}
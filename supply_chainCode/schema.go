@@ -0,0 +1,629 @@
+/*
+JSON requests.
+
+Besides the positional args documented on each function, every function can be called with a single JSON
+document as its only arg, e.g.
+
+	{"schemaVersion":1,"value":10,"quantity":100,"owner":"org1",...}
+
+The document is validated against the schema of the function (unknown fields, missing required fields,
+wrong types and formats are reported per field) and then turned into the positional args, so both forms
+go through the same checks. Positional args are deprecated and will be removed in a later version.
+A function whose first arg is itself a JSON document (registerOrg, updateOrg, setTariff) takes a document
+without schemaVersion as that positional arg, as before.
+
+API:
+
+querySchemas - args[0] = function name (optional). Returns the JSON Schema of the requests.
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const SchemaVersion = 1
+
+var schemaLogger = shim.NewLogger("schema")
+
+//types of the fields of a request
+const (
+	FieldString   = "string"
+	FieldOrg      = "org" //name of an org, checked against the registry by the function (see orgs.go)
+	FieldNumber   = "number"
+	FieldInteger  = "integer"
+	FieldDateTime = "datetime" //RFC3339 string
+	FieldArray    = "array"    //array of objects with the Items fields
+	FieldStrings  = "strings"  //array of strings, one positional arg each. Only as the last field.
+	FieldJSON     = "json"     //JSON object or array, passed on as its JSON text
+)
+
+type Field struct {
+	Name        string
+	Type        string
+	Required    bool
+	Description string
+	Items       []Field
+}
+
+/*
+Fields are in the order of the positional args.
+Args builds the positional args when they can't just follow the order of the fields.
+*/
+type Schema struct {
+	Function string
+	Fields   []Field
+	Args     func(values map[string][]string) ([]string, error)
+}
+
+type FieldError struct {
+	Field string
+	Error string
+}
+
+var deliveryFields = []Field{
+	{"fuelOrderID", FieldString, true, "FuelOrder to deliver", nil},
+	{"estTime", FieldDateTime, true, "estimated time of delivery", nil},
+	{"startLocation", FieldOrg, true, "", nil},
+	{"destination", FieldOrg, true, "should be the Dest of the FuelOrder", nil},
+}
+
+var Schemas = map[string]Schema{
+	"deliverCrude": {"deliverCrude", []Field{
+		{"value", FieldNumber, true, "0 if the terms are in the transient map (see privacy.go)", nil},
+		{"quantity", FieldInteger, true, "", nil},
+		{"owner", FieldOrg, true, "", nil},
+		{"estTime", FieldDateTime, true, "estimated time of arrival", nil},
+		{"startLocation", FieldOrg, true, "", nil},
+		{"destination", FieldOrg, true, "", nil},
+		{"vesselID", FieldString, true, "", nil},
+		{"timestamp", FieldDateTime, true, "", nil},
+		{"currency", FieldString, false, "of the value, e.g. EUR (the default)", nil},
+	}, nil},
+	"refine": {"refine", []Field{
+		{"value", FieldNumber, true, "", nil},
+		{"quantity", FieldInteger, true, "", nil},
+		{"owner", FieldOrg, true, "", nil},
+		{"density", FieldNumber, true, "", nil},
+		{"fuelType", FieldString, true, "", nil},
+		{"crudeID", FieldString, true, "crude the fuel is refined from", nil},
+		{"timestamp", FieldDateTime, true, "", nil},
+		{"currency", FieldString, false, "of the value, e.g. EUR (the default)", nil},
+	}, nil},
+	"addFuelOrder": {"addFuelOrder", []Field{
+		{"value", FieldNumber, true, "0 if the terms are in the transient map (see privacy.go)", nil},
+		{"quantity", FieldInteger, true, "", nil},
+		{"owner", FieldOrg, true, "", nil},
+		{"destination", FieldOrg, true, "fueling station of the order", nil},
+		{"fuelID", FieldString, true, "fuel the order is cut from", nil},
+		{"timestamp", FieldDateTime, true, "", nil},
+		{"currency", FieldString, false, "of the value, e.g. EUR (the default)", nil},
+	}, nil},
+	"deliverFuel": {"deliverFuel", []Field{
+		{"truckID", FieldString, true, "a registered truck", nil},
+		{"deliveries", FieldArray, true, "", deliveryFields},
+	}, nil},
+	"transfer": {"transfer", []Field{
+		{"assetID", FieldString, true, "Crude or FuelOrder", nil},
+		{"owner", FieldOrg, true, "new owner", nil},
+		{"timestamp", FieldDateTime, true, "time of delivery", nil},
+		{"planID", FieldString, false, "required for a FuelOrder", nil},
+		{"measuredQuantity", FieldInteger, false, "proof of delivery", nil},
+		{"measuredDensity", FieldNumber, false, "proof of delivery", nil},
+	}, transferArgs},
+	"registerVehicle": {"registerVehicle", []Field{
+		{"type", FieldString, true, "'Truck' or 'Vessel'", nil},
+		{"vehicleID", FieldString, true, "", nil},
+		{"capacity", FieldInteger, true, "", nil},
+	}, nil},
+	"openDispute": {"openDispute", []Field{
+		{"assetID", FieldString, true, "Crude or FuelOrder", nil},
+		{"reason", FieldString, true, "SHORT_DELIVERY, QUALITY, LATE_DELIVERY, PAYMENT or OTHER", nil},
+		{"evidenceHash", FieldString, true, "", nil},
+	}, nil},
+	"respondDispute": {"respondDispute", []Field{
+		{"disputeID", FieldString, true, "", nil},
+		{"statement", FieldString, true, "", nil},
+		{"evidenceHash", FieldString, true, "", nil},
+	}, nil},
+	"resolveDispute": {"resolveDispute", []Field{
+		{"disputeID", FieldString, true, "", nil},
+		{"resolution", FieldString, true, "", nil},
+		{"payer", FieldOrg, false, "of the compensating payment", nil},
+		{"payee", FieldOrg, false, "of the compensating payment", nil},
+		{"amount", FieldNumber, false, "of the compensating payment, in the currency of the asset", nil},
+	}, nil},
+	"queryHistoryForKey": {"queryHistoryForKey", []Field{
+		{"key", FieldString, true, "", nil},
+		{"from", FieldDateTime, false, "", nil},
+		{"to", FieldDateTime, false, "", nil},
+	}, nil},
+	"queryAsset": {"queryAsset", []Field{
+		{"id", FieldString, true, "ID of an asset, or an org for its account", nil},
+	}, nil},
+	"queryAssetByRange": {"queryAssetByRange", append([]Field{
+		{"type", FieldString, true, "Crude, Fuel, FuelOrder or Plan", nil},
+	}, pageFields...), nil},
+	"queryAssetsByOwner": {"queryAssetsByOwner", append([]Field{
+		{"owner", FieldOrg, true, "", nil},
+		{"type", FieldString, false, "Crude, Fuel or FuelOrder, all types if missing", nil},
+	}, pageFields...), nil},
+	"queryAssetsByState": {"queryAssetsByState", append([]Field{
+		{"state", FieldString, true, "", nil},
+		{"type", FieldString, false, "Crude, Fuel or FuelOrder, all types if missing", nil},
+	}, pageFields...), nil},
+	"queryAssetsByDestination": {"queryAssetsByDestination", append([]Field{
+		{"destination", FieldOrg, true, "", nil},
+		{"type", FieldString, false, "Crude, Fuel or FuelOrder, all types if missing", nil},
+	}, pageFields...), nil},
+	"queryAssets": {"queryAssets", append([]Field{
+		{"type", FieldString, true, "Crude, Fuel or FuelOrder", nil},
+		{"selector", FieldJSON, true, "Mango selector (see richquery.go)", nil},
+		{"sort", FieldJSON, false, "", nil},
+	}, pageFields...), nil},
+	"traceLineage": {"traceLineage", []Field{
+		{"assetID", FieldString, true, "Crude, Fuel, FuelOrder or Plan", nil},
+	}, nil},
+	"auditQuantities": {"auditQuantities", []Field{
+		{"crudeID", FieldString, true, "", nil},
+	}, nil},
+	"setRefiningYield": {"setRefiningYield", []Field{
+		{"yield", FieldNumber, true, "in (0,1]", nil},
+	}, nil},
+	"reconcilePlan": {"reconcilePlan", []Field{
+		{"planID", FieldString, true, "", nil},
+	}, nil},
+	"queryReceipt": {"queryReceipt", []Field{
+		{"assetID", FieldString, true, "delivered Crude or FuelOrder", nil},
+	}, nil},
+	"setTolerances": {"setTolerances", []Field{
+		{"quantity", FieldNumber, true, "fraction in [0,1)", nil},
+		{"density", FieldNumber, true, "fraction in [0,1)", nil},
+	}, nil},
+	"queryDisputes": {"queryDisputes", []Field{
+		{"status", FieldString, false, "all if missing", nil},
+		{"org", FieldString, false, "MSP ID or org name, all if missing", nil},
+	}, allArgs("status", "org")},
+	"initLedger": {"initLedger", []Field{
+		{"currencies", FieldStrings, false, "org:currency pairs, e.g. org5:USD", nil},
+	}, nil},
+	"setRoleMSPs": {"setRoleMSPs", []Field{
+		{"role", FieldString, true, "", nil},
+		{"mspIDs", FieldStrings, false, "replace the MSP IDs of the role", nil},
+	}, nil},
+	"queryRoles": {"queryRoles", []Field{}, nil},
+	"querySchemas": {"querySchemas", []Field{
+		{"function", FieldString, false, "all functions if missing", nil},
+	}, nil},
+	"getAccountStatement": {"getAccountStatement", []Field{
+		{"org", FieldOrg, true, "", nil},
+		{"from", FieldDateTime, false, "", nil},
+		{"to", FieldDateTime, false, "", nil},
+	}, nil},
+	"queryJournalByReference": {"queryJournalByReference", []Field{
+		{"reference", FieldString, true, "ID of an asset or a dispute", nil},
+	}, nil},
+	"migrateKeys": {"migrateKeys", []Field{
+		{"types", FieldStrings, false, "Crude, Fuel, FuelOrder, Plan or Account, all if missing", nil},
+	}, nil},
+	"reindexAssets": {"reindexAssets", []Field{
+		{"types", FieldStrings, false, "Crude, Fuel, FuelOrder or Plan, all if missing", nil},
+	}, nil},
+	"setFXRate": {"setFXRate", []Field{
+		{"from", FieldString, true, "currency", nil},
+		{"to", FieldString, true, "currency", nil},
+		{"rate", FieldNumber, true, "1 from = rate to", nil},
+	}, nil},
+	"queryFXRate": {"queryFXRate", []Field{
+		{"from", FieldString, true, "currency", nil},
+		{"to", FieldString, true, "currency", nil},
+	}, nil},
+	"migrateMoney": {"migrateMoney", []Field{
+		{"types", FieldStrings, false, "Account, Crude, Fuel or FuelOrder, all if missing", nil},
+	}, nil},
+	"setCreditLimit": {"setCreditLimit", []Field{
+		{"org", FieldOrg, true, "", nil},
+		{"limit", FieldNumber, true, "in the currency of the account of the org", nil},
+	}, nil},
+	"queryAvailableFunds": {"queryAvailableFunds", []Field{
+		{"org", FieldOrg, true, "", nil},
+	}, nil},
+	"setTariff": {"setTariff", []Field{
+		{"tariff", FieldJSON, true, "Tariff without Version and Timestamp (see tariff.go)", nil},
+	}, nil},
+	"queryTariff": {"queryTariff", []Field{
+		{"type", FieldString, true, "Crude or FuelOrder", nil},
+		{"from", FieldString, true, "org, or '*' for any", nil},
+		{"to", FieldString, true, "org, or '*' for any", nil},
+		{"version", FieldInteger, false, "the tariff of the route if missing", nil},
+	}, nil},
+	"queryEscrow": {"queryEscrow", []Field{
+		{"assetID", FieldString, true, "", nil},
+	}, nil},
+	"refundEscrow": {"refundEscrow", []Field{
+		{"assetID", FieldString, true, "", nil},
+	}, nil},
+	"setEscrowPeriod": {"setEscrowPeriod", []Field{
+		{"hours", FieldInteger, true, "", nil},
+	}, nil},
+	"queryTerms": {"queryTerms", []Field{
+		{"assetID", FieldString, true, "", nil},
+	}, nil},
+	"verifyTerms": {"verifyTerms", []Field{
+		{"assetID", FieldString, true, "the terms are in the transient map (see privacy.go)", nil},
+	}, nil},
+	"queryPairSettlement": {"queryPairSettlement", []Field{
+		{"org", FieldOrg, true, "", nil},
+		{"counterparty", FieldOrg, true, "", nil},
+		{"currency", FieldString, false, "BaseCurrency if missing", nil},
+	}, nil},
+	"cancelOrder": {"cancelOrder", []Field{
+		{"orderID", FieldString, true, "Crude or FuelOrder", nil},
+		{"reason", FieldString, true, "", nil},
+	}, nil},
+	"amendPlan": {"amendPlan", []Field{
+		{"planID", FieldString, true, "", nil},
+		{"reason", FieldString, true, "", nil},
+		{"amendment", FieldJSON, true, "PlanAmendmentRequest (see amend.go)", nil},
+	}, nil},
+	"cancelPlan": {"cancelPlan", []Field{
+		{"planID", FieldString, true, "", nil},
+		{"reason", FieldString, true, "", nil},
+	}, nil},
+	"registerOrg": {"registerOrg", []Field{
+		{"org", FieldJSON, true, "OrgRequest (see orgs.go)", nil},
+	}, nil},
+	"updateOrg": {"updateOrg", []Field{
+		{"org", FieldJSON, true, "OrgRequest with the Name and the fields to change (see orgs.go)", nil},
+	}, nil},
+	"deactivateOrg": {"deactivateOrg", []Field{
+		{"name", FieldOrg, true, "", nil},
+	}, nil},
+	"reactivateOrg": {"reactivateOrg", []Field{
+		{"name", FieldOrg, true, "", nil},
+	}, nil},
+	"queryOrg": {"queryOrg", []Field{
+		{"name", FieldOrg, true, "", nil},
+	}, nil},
+	"queryOrgs": {"queryOrgs", []Field{}, nil},
+}
+
+//the optional page size and bookmark of the queries that return a page (see page.go)
+var pageFields = []Field{
+	{"pageSize", FieldInteger, false, "", nil},
+	{"bookmark", FieldString, false, "of the previous page", nil},
+}
+
+//all the fields as positional args, the missing ones as "", for functions that expect every arg.
+func allArgs(names ...string) func(values map[string][]string) ([]string, error) {
+	return func(values map[string][]string) ([]string, error) {
+		args := []string{}
+		for _, name := range names {
+			if v, ok := values[name]; ok {
+				args = append(args, v...)
+			} else {
+				args = append(args, "")
+			}
+		}
+		return args, nil
+	}
+}
+
+//a Crude has no plan, so the measurements follow the timestamp.
+func transferArgs(values map[string][]string) ([]string, error) {
+	args := []string{values["assetID"][0], values["owner"][0], values["timestamp"][0]}
+	if strings.HasPrefix(args[0], "FuelOrder") {
+		if values["planID"] == nil {
+			return nil, errors.New("planID: is required to transfer a FuelOrder")
+		}
+		args = append(args, values["planID"][0])
+	} else if values["planID"] != nil {
+		return nil, errors.New("planID: only a FuelOrder is transferred with a plan")
+	}
+	_, mq := values["measuredQuantity"]
+	_, md := values["measuredDensity"]
+	if mq != md {
+		return nil, errors.New("measuredQuantity, measuredDensity: should be supplied together")
+	}
+	if mq {
+		args = append(args, values["measuredQuantity"][0], values["measuredDensity"][0])
+	}
+	return args, nil
+}
+
+func IsJSONRequest(args []string) bool {
+	return len(args) == 1 && strings.HasPrefix(strings.TrimSpace(args[0]), "{")
+}
+
+/*
+Turns the args of function into positional args.
+JSON requests are validated and converted, positional args are returned as they are.
+*/
+func RequestArgs(function string, args []string) ([]string, error) {
+	schema, ok := Schemas[function]
+	if !ok || IsJSONRequest(args) == false || schema.isPositionalJSON(args[0]) {
+		if ok {
+			schemaLogger.Warningf("positional args of %s are deprecated. Use a JSON request (schemaVersion %d)", function, SchemaVersion)
+		}
+		return args, nil
+	}
+	dec := json.NewDecoder(strings.NewReader(args[0]))
+	dec.UseNumber()
+	req := map[string]interface{}{}
+	if err := dec.Decode(&req); err != nil {
+		return nil, fmt.Errorf("Invalid %s request: not a JSON object", function)
+	}
+	errs := []FieldError{}
+	version, ok := req["schemaVersion"].(json.Number)
+	if !ok {
+		errs = append(errs, FieldError{"schemaVersion", "is required"})
+	} else if version.String() != strconv.Itoa(SchemaVersion) {
+		errs = append(errs, FieldError{"schemaVersion", fmt.Sprintf("should be %d", SchemaVersion)})
+	}
+	delete(req, "schemaVersion")
+	values := map[string][]string{}
+	errs = append(errs, validateFields("", schema.Fields, req, values)...)
+	if len(errs) > 0 {
+		return nil, requestError(function, errs)
+	}
+	if schema.Args != nil {
+		args, err := schema.Args(values)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s request: %s", function, err)
+		}
+		return args, nil
+	}
+	//optional fields that are missing become "" unless no field after them is supplied
+	positional := []string{}
+	last := 0
+	for _, f := range schema.Fields {
+		if v, ok := values[f.Name]; ok {
+			positional = append(positional, v...)
+			last = len(positional)
+		} else {
+			positional = append(positional, "")
+		}
+	}
+	return positional[:last], nil
+}
+
+/*
+Whether arg is the positional arg of a function whose first arg is a JSON document, e.g. the org of
+registerOrg, rather than a JSON request: it has no schemaVersion, or isn't even valid JSON.
+*/
+func (schema Schema) isPositionalJSON(arg string) bool {
+	if len(schema.Fields) == 0 || schema.Fields[0].Type != FieldJSON {
+		return false
+	}
+	doc := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(arg), &doc); err != nil {
+		return true
+	}
+	_, ok := doc["schemaVersion"]
+	return !ok
+}
+
+/*
+Checks req against fields and puts the value(s) of every field, as positional args, in values.
+*/
+func validateFields(prefix string, fields []Field, req map[string]interface{}, values map[string][]string) []FieldError {
+	errs := []FieldError{}
+	known := map[string]bool{}
+	for _, f := range fields {
+		known[f.Name] = true
+		v, ok := req[f.Name]
+		if !ok || v == nil {
+			if f.Required {
+				errs = append(errs, FieldError{prefix + f.Name, "is required"})
+			}
+			continue
+		}
+		s, fieldErrs := validateField(prefix+f.Name, f, v)
+		if len(fieldErrs) > 0 {
+			errs = append(errs, fieldErrs...)
+			continue
+		}
+		values[f.Name] = s
+	}
+	unknown := []string{}
+	for name := range req {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		errs = append(errs, FieldError{prefix + name, "is not a field of the request"})
+	}
+	return errs
+}
+
+func validateField(name string, f Field, v interface{}) ([]string, []FieldError) {
+	fail := func(msg string) ([]string, []FieldError) {
+		return nil, []FieldError{{name, msg}}
+	}
+	switch f.Type {
+	case FieldString, FieldOrg, FieldDateTime:
+		s, ok := v.(string)
+		if !ok || s == "" {
+			return fail("should be a non empty string")
+		}
+		if f.Type == FieldOrg && validOrgName(s) == false {
+			return fail("should be the name of an org (e.g. 'org1')")
+		}
+		if f.Type == FieldDateTime {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return fail("should be an RFC3339 date-time")
+			}
+		}
+		return []string{s}, nil
+	case FieldNumber:
+		n, ok := v.(json.Number)
+		if !ok {
+			return fail("should be a number")
+		}
+		if _, err := n.Float64(); err != nil {
+			return fail("should be a number")
+		}
+		return []string{n.String()}, nil
+	case FieldInteger:
+		n, ok := v.(json.Number)
+		if !ok {
+			return fail("should be an integer")
+		}
+		if _, err := n.Int64(); err != nil {
+			return fail("should be an integer")
+		}
+		return []string{n.String()}, nil
+	case FieldStrings:
+		items, ok := v.([]interface{})
+		if !ok {
+			return fail("should be an array of strings")
+		}
+		ss := []string{}
+		for _, item := range items {
+			s, ok := item.(string)
+			if !ok || s == "" {
+				return fail("should be an array of non empty strings")
+			}
+			ss = append(ss, s)
+		}
+		return ss, nil
+	case FieldJSON:
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+		default:
+			return fail("should be a JSON object or array")
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fail("should be a JSON object or array")
+		}
+		return []string{string(b)}, nil
+	case FieldArray:
+		items, ok := v.([]interface{})
+		if !ok || len(items) == 0 {
+			return fail("should be a non empty array")
+		}
+		flat := []string{}
+		errs := []FieldError{}
+		for i, item := range items {
+			obj, ok := item.(map[string]interface{})
+			itemName := fmt.Sprintf("%s[%d]", name, i)
+			if !ok {
+				errs = append(errs, FieldError{itemName, "should be an object"})
+				continue
+			}
+			itemValues := map[string][]string{}
+			itemErrs := validateFields(itemName+".", f.Items, obj, itemValues)
+			if len(itemErrs) > 0 {
+				errs = append(errs, itemErrs...)
+				continue
+			}
+			for _, itemField := range f.Items {
+				flat = append(flat, itemValues[itemField.Name]...)
+			}
+		}
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		return flat, nil
+	}
+	return fail("has an unknown type")
+}
+
+func requestError(function string, errs []FieldError) error {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("Invalid %s request: ", function))
+	for i, e := range errs {
+		if i > 0 {
+			buffer.WriteString("; ")
+		}
+		buffer.WriteString(e.Field + ": " + e.Error)
+	}
+	return errors.New(buffer.String())
+}
+
+/*
+JSON Schema (draft-07) of the request of a function.
+*/
+func (schema Schema) JSONSchema() map[string]interface{} {
+	props, required := jsonSchemaProperties(schema.Fields)
+	props["schemaVersion"] = map[string]interface{}{"const": SchemaVersion}
+	required = append([]string{"schemaVersion"}, required...)
+	return map[string]interface{}{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"$id":                  fmt.Sprintf("supply_chainCode/v%d/%s", SchemaVersion, schema.Function),
+		"title":                schema.Function,
+		"type":                 "object",
+		"properties":           props,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+func jsonSchemaProperties(fields []Field) (map[string]interface{}, []string) {
+	props := map[string]interface{}{}
+	required := []string{}
+	for _, f := range fields {
+		var p map[string]interface{}
+		switch f.Type {
+		case FieldOrg:
+			p = map[string]interface{}{"type": "string", "pattern": "^org"}
+		case FieldDateTime:
+			p = map[string]interface{}{"type": "string", "format": "date-time"}
+		case FieldArray:
+			itemProps, itemRequired := jsonSchemaProperties(f.Items)
+			p = map[string]interface{}{"type": "array", "minItems": 1, "items": map[string]interface{}{
+				"type": "object", "properties": itemProps, "required": itemRequired, "additionalProperties": false}}
+		case FieldString:
+			p = map[string]interface{}{"type": "string", "minLength": 1}
+		case FieldStrings:
+			p = map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string", "minLength": 1}}
+		case FieldJSON:
+			p = map[string]interface{}{"type": []string{"object", "array"}}
+		default:
+			p = map[string]interface{}{"type": f.Type}
+		}
+		if f.Description != "" {
+			p["description"] = f.Description
+		}
+		props[f.Name] = p
+		if f.Required {
+			required = append(required, f.Name)
+		}
+	}
+	return props, required
+}
+
+/*
+args[0] = function name (optional). Without it the schemas of all functions are returned.
+*/
+func (s *SmartContract) querySchemas(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) > 1 {
+		return shim.Error("Expecting at most 1 arg")
+	}
+	if len(args) == 1 {
+		schema, ok := Schemas[args[0]]
+		if !ok {
+			return shim.Error(fmt.Sprintf("There is no schema for %s", args[0]))
+		}
+		schemaAsBytes, _ := json.Marshal(schema.JSONSchema())
+		return shim.Success(schemaAsBytes)
+	}
+	all := map[string]interface{}{}
+	for function, schema := range Schemas {
+		all[function] = schema.JSONSchema()
+	}
+	schemasAsBytes, _ := json.Marshal(all)
+	return shim.Success(schemasAsBytes)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+)
+
+const crudeRequest = `{"schemaVersion":1,"value":1000,"quantity":100,"owner":"org1","estTime":"2019-01-03T12:00:00Z",
+	"startLocation":"org1","destination":"org3","vesselID":"Vessel1","timestamp":"2019-01-01T08:00:00Z"}`
+
+func TestJSONRequests(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.as("Org1MSP").ok("deliverCrude", crudeRequest)
+	crude := Crude{}
+	n.asset(crudeID, &crude)
+	if crude.AD.Value != money("1000.00") || crude.AD.Currency != BaseCurrency || crude.Veh.ID != "Vessel1" {
+		t.Fatalf("%s of a JSON request is %+v", crudeID, crude)
+	}
+	fuelID := n.newFuel(crudeID)
+	orderID := n.as("Org3MSP").ok("addFuelOrder", `{"schemaVersion":1,"value":500.00,"quantity":20,"owner":"org3","destination":"org5","fuelID":"`+fuelID+`","timestamp":"`+ordered+`"}`)
+	n.as("Org4MSP").ok("registerVehicle", `{"schemaVersion":1,"type":"Truck","vehicleID":"Truck1","capacity":100}`)
+	planID := n.ok("deliverFuel", `{"schemaVersion":1,"truckID":"Truck1","deliveries":[{"fuelOrderID":"`+orderID+`","estTime":"`+fuelEst+`","startLocation":"org3","destination":"org5"}]}`)
+
+	n.as("Org5MSP")
+	n.fails("Invalid transfer request: planID: is required to transfer a FuelOrder", "transfer", `{"schemaVersion":1,"assetID":"`+orderID+`","owner":"org5","timestamp":"`+fuelEst+`"}`)
+	n.fails("measuredQuantity, measuredDensity: should be supplied together", "transfer", `{"schemaVersion":1,"assetID":"`+orderID+`","owner":"org5","timestamp":"`+fuelEst+`","planID":"`+planID+`","measuredQuantity":20}`)
+	n.ok("transfer", `{"schemaVersion":1,"assetID":"`+orderID+`","owner":"org5","timestamp":"`+fuelEst+`","planID":"`+planID+`"}`)
+	if state := n.state(orderID); state != "DELIVERED" {
+		t.Errorf("%s is %s after a JSON transfer", orderID, state)
+	}
+	n.as("Org3MSP").fails("planID: only a FuelOrder is transferred with a plan", "transfer", `{"schemaVersion":1,"assetID":"`+crudeID+`","owner":"org3","timestamp":"`+crudeEst+`","planID":"`+planID+`"}`)
+}
+
+func TestJSONRequestErrors(t *testing.T) {
+	n := newTestNet(t)
+	n.as("Org1MSP")
+	n.fails("Invalid deliverCrude request: not a JSON object", "deliverCrude", `{"value":`)
+	n.fails("schemaVersion: is required", "deliverCrude", `{"value":1000}`)
+	n.fails("schemaVersion: should be 1", "deliverCrude", `{"schemaVersion":2}`)
+	//every field is reported
+	n.fails("quantity: is required; owner: is required", "deliverCrude", `{"schemaVersion":1,"value":1000}`)
+	n.fails("colour: is not a field of the request", "registerVehicle", `{"schemaVersion":1,"type":"Truck","vehicleID":"Truck1","capacity":1,"colour":"red"}`)
+	n.fails("capacity: should be an integer", "registerVehicle", `{"schemaVersion":1,"type":"Truck","vehicleID":"Truck1","capacity":1.5}`)
+	n.fails("value: should be a number", "deliverCrude", `{"schemaVersion":1,"value":true}`)
+	n.fails("from: should be an RFC3339 date-time", "queryHistoryForKey", `{"schemaVersion":1,"key":"Crude0000000001","from":"2019-01-01"}`)
+	n.fails("owner: should be the name of an org", "deliverCrude", `{"schemaVersion":1,"owner":"Crude0000000001"}`)
+	n.fails("deliveries: should be a non empty array", "deliverFuel", `{"schemaVersion":1,"truckID":"Truck1","deliveries":[]}`)
+	n.fails("deliveries[0].destination: is required", "deliverFuel", `{"schemaVersion":1,"truckID":"Truck1","deliveries":[{"fuelOrderID":"FuelOrder0000000001","estTime":"`+fuelEst+`","startLocation":"org3"}]}`)
+	n.fails("org: should be a JSON object or array", "registerOrg", `{"schemaVersion":1,"org":"org7"}`)
+	n.fails("types: should be an array of non empty strings", "migrateKeys", `{"schemaVersion":1,"types":["Crude",""]}`)
+	//a JSON document without schemaVersion is still the positional arg of a function that takes one
+	n.fails("Invalid org", "registerOrg", `{"Name":`)
+}
+
+func TestJSONRequestsOfDocuments(t *testing.T) {
+	n := newTestNet(t)
+	n.as("Org1MSP").ok("registerOrg", `{"schemaVersion":1,"org":{"Name":"org7","MSPID":"Org7MSP","Roles":["retailer"],"OpeningBalance":100}}`)
+	org := Org{}
+	n.query(&org, "queryOrg", `{"schemaVersion":1,"name":"org7"}`)
+	if org.MSPID != "Org7MSP" || org.HasRole(RoleRetailer) == false {
+		t.Errorf("org7 of a JSON request is %+v", org)
+	}
+	n.ok("setRoleMSPs", `{"schemaVersion":1,"role":"retailer","mspIDs":["Org5MSP","Org6MSP","Org7MSP"]}`)
+	var disputes []disputeRecord
+	n.query(&disputes, "queryDisputes", `{"schemaVersion":1}`)
+	if len(disputes) != 0 {
+		t.Errorf("Disputes are %+v", disputes)
+	}
+}
+
+//every function of the API has the schema of its JSON request
+func TestSchemasOfRoutes(t *testing.T) {
+	for _, fn := range routes {
+		schema, ok := Schemas[fn]
+		if !ok {
+			t.Errorf("There is no schema for %s", fn)
+		} else if schema.Function != fn {
+			t.Errorf("Schema of %s is the schema of %s", fn, schema.Function)
+		}
+	}
+	if len(Schemas) != len(routes) {
+		t.Errorf("%d schemas for %d functions", len(Schemas), len(routes))
+	}
+}
+
+func TestQuerySchemas(t *testing.T) {
+	n := newTestNet(t)
+	all := map[string]map[string]interface{}{}
+	n.query(&all, "querySchemas")
+	if len(all) != len(Schemas) || all["transfer"] == nil {
+		t.Fatalf("Schemas are %v", all)
+	}
+	schema := map[string]interface{}{}
+	n.query(&schema, "querySchemas", "deliverCrude")
+	props, _ := schema["properties"].(map[string]interface{})
+	required, _ := schema["required"].([]interface{})
+	if props["vesselID"] == nil || props["schemaVersion"] == nil || len(required) == 0 {
+		t.Errorf("Schema of deliverCrude is %v", schema)
+	}
+	n.fails("There is no schema for mintMoney", "querySchemas", "mintMoney")
+	n.fails("Expecting at most 1 arg", "querySchemas", "transfer", "refine")
+}
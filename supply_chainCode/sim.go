@@ -0,0 +1,496 @@
+//go:build fuelsim
+// +build fuelsim
+
+/*
+Offline simulator of the supply chain.
+
+RunSimulation runs the chaincode on the in-memory ledger (see memstub/memstub.go) with synthetic traffic, day by day:
+
+	00:00 - vessels of the driller (org1) set off with crude for the refinery (org3)
+	06:00 - fuel stations order fuel, which the refinery cuts from the fuel it has refined
+	07:00 - the distributor (org4) plans a trip for each of its trucks, oldest orders first, within capacity
+	09:00 - the trucks deliver, a stop every StopInterval
+	20:00 - the vessels arrive and their crude is refined an hour later
+
+Deliveries are a few minutes early or, with LateRate, up to MaxDelay late, and with FraudRate the carrier
+shorts one. Every delivery is measured by its receiver (see receipt.go), so a shortfall above the tolerances
+is detected. Stations are org5, org6 and, if there are more, orgs registered after them (org7, ...).
+
+The metrics of a run (throughput, pay and penalties of the carriers, detected frauds, final balances) are
+meant to tune the tariffs and the fleet before they are set on the network. The fuelsim command runs it
+(see fuelsim.go). Like the command it is only built with the fuelsim tag, so it isn't in the chaincode.
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/chaincode/supply_chainCode/memstub"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Parameters of a run. Prices are per unit of quantity.
+*/
+type SimConfig struct {
+	Days           int
+	Seed           int64
+	Start          time.Time //first day
+	Stations       int
+	VesselsPerDay  float64 //on average
+	VesselQuantity int     //of crude a vessel brings
+	CrudePrice     Money   //paid by the refinery to the driller
+	FuelPrice      Money   //paid by the stations to the refinery
+	OrderRate      float64 //probability that a station orders on a day
+	MinOrder       int
+	MaxOrder       int
+	Trucks         int
+	TruckCapacity  int
+	StopInterval   time.Duration
+	LateRate       float64 //probability that a delivery is late
+	MaxDelay       time.Duration
+	FraudRate      float64 //probability that the carrier shorts a delivery
+	MaxShortfall   float64 //fraction of the quantity a carrier shorts at most
+	Tolerances     Tolerances
+	Tariffs        []string //JSON tariffs set before the first day (see tariff.go)
+}
+
+var DefaultSimConfig = SimConfig{30, 1, time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), 20, 1, 1000,
+	10 * MinorUnits, 25 * MinorUnits, 0.6, 20, 100, 3, 300, 30 * time.Minute, 0.2, 3 * time.Hour,
+	0.02, 0.05, DefaultTolerances, nil}
+
+/*
+Delays are in seconds, as in DeliveryDetails. Deliveries are of crude and fuel.
+*/
+type SimMetrics struct {
+	Days                int
+	Txs                 int
+	FailedTxs           map[string]int //by function and error, e.g. 'addFuelOrder: INSUFFICIENT_FUNDS'
+	CrudeDelivered      int
+	FuelRefined         int
+	OrdersPlaced        int
+	OrdersUnfilled      int //no fuel to cut them from, or the station couldn't pay
+	OrdersDelivered     int
+	FuelDelivered       int
+	Backlog             int     //orders waiting for a truck after the last day
+	Throughput          float64 //fuel delivered a day
+	Trips               int
+	TruckLoad           float64 //fuel carried / capacity of the trips
+	Deliveries          int
+	LateDeliveries      int
+	AvgDelay            float64 //of the late deliveries
+	CarrierPay          Money
+	PenaltiesPaid       Money //taken off the pay of the carriers
+	BonusesPaid         Money
+	FraudAttempts       int
+	FraudsDetected      int //shorted deliveries whose receipt isn't ACCEPTED
+	ShortfallUndetected int //quantity shorted within the tolerances
+	Balances            map[string]Money
+}
+
+type simFuel struct {
+	id        string
+	remaining int
+}
+
+type simOrder struct {
+	id       string
+	station  string
+	quantity int
+}
+
+//a stop of a trip
+type simStop struct {
+	order  simOrder
+	planID string
+	est    time.Time
+}
+
+type simulation struct {
+	cfg      SimConfig
+	stub     *memstub.Stub
+	rnd      *rand.Rand
+	m        SimMetrics
+	stations []string
+	fuels    []simFuel  //with fuel left, oldest first
+	pending  []simOrder //waiting for a truck, oldest first
+	carried  int
+	delays   float64 //sum of the late deliveries
+}
+
+func (cfg SimConfig) validate() error {
+	switch {
+	case cfg.Days < 1 || cfg.Stations < 1 || cfg.Trucks < 1:
+		return errors.New("Days, Stations and Trucks should be at least 1")
+	case cfg.VesselsPerDay < 0 || cfg.VesselQuantity < 1:
+		return errors.New("VesselsPerDay should not be negative and VesselQuantity should be at least 1")
+	case cfg.CrudePrice < 0 || cfg.FuelPrice < 0:
+		return errors.New("Prices should not be negative")
+	case cfg.MinOrder < 1 || cfg.MaxOrder < cfg.MinOrder:
+		return errors.New("MinOrder should be at least 1 and at most MaxOrder")
+	case cfg.TruckCapacity < cfg.MaxOrder:
+		return errors.New("TruckCapacity should be at least MaxOrder, or some orders never fit in a truck")
+	case cfg.StopInterval < 0 || cfg.MaxDelay < time.Second:
+		return errors.New("StopInterval should not be negative and MaxDelay should be at least a second")
+	}
+	for _, p := range []float64{cfg.OrderRate, cfg.LateRate, cfg.FraudRate, cfg.MaxShortfall} {
+		if p < 0 || p > 1 {
+			return errors.New("OrderRate, LateRate, FraudRate and MaxShortfall should be in [0,1]")
+		}
+	}
+	return nil
+}
+
+/*
+Runs cfg on a new ledger. The same cfg (and Seed) gives the same metrics.
+*/
+func RunSimulation(cfg SimConfig) (SimMetrics, error) {
+	if err := cfg.validate(); err != nil {
+		return SimMetrics{}, err
+	}
+	s := &simulation{cfg: cfg, rnd: rand.New(rand.NewSource(cfg.Seed))}
+	s.m.Days = cfg.Days
+	s.m.FailedTxs = map[string]int{}
+	s.stub = memstub.New("supply_chainCode", new(SmartContract))
+	s.stub.Caller = "Org1MSP"
+	//positional args are what the simulator submits, so their deprecation isn't news
+	schemaLogger.SetLevel(shim.LogError)
+	defer schemaLogger.SetLevel(shim.LogWarning)
+
+	if err := s.setup(); err != nil {
+		return SimMetrics{}, err
+	}
+	for d := 0; d < cfg.Days; d++ {
+		s.day(cfg.Start.AddDate(0, 0, d))
+	}
+	if err := s.finish(); err != nil {
+		return SimMetrics{}, err
+	}
+	return s.m, nil
+}
+
+func (s *simulation) invoke(t time.Time, msp, fn string, args ...string) sc.Response {
+	s.stub.Caller = msp
+	s.stub.Now = t
+	s.m.Txs++
+	return s.stub.Invoke("sim"+strconv.Itoa(s.m.Txs), memstub.Args(fn, args), nil)
+}
+
+/*
+Submits fn as msp at time t. Returns the payload and whether the tx succeeded; a failed tx is counted
+by its function and the start of its error.
+*/
+func (s *simulation) submit(t time.Time, msp, fn string, args ...string) (string, bool) {
+	resp := s.invoke(t, msp, fn, args...)
+	if resp.Status >= shim.ERRORTHRESHOLD {
+		reason := resp.Message
+		if i := strings.Index(reason, ":"); i > 0 {
+			reason = reason[:i]
+		}
+		s.m.FailedTxs[fn+": "+reason]++
+		return "", false
+	}
+	return string(resp.Payload), true
+}
+
+//like submit, for the txs without which there is nothing to simulate
+func (s *simulation) must(t time.Time, msp, fn string, args ...string) error {
+	if resp := s.invoke(t, msp, fn, args...); resp.Status >= shim.ERRORTHRESHOLD {
+		return fmt.Errorf("%s failed: %s", fn, resp.Message)
+	}
+	return nil
+}
+
+//the network before the first day: accounts, stations, tolerances, tariffs and trucks
+func (s *simulation) setup() error {
+	t := s.cfg.Start.AddDate(0, 0, -1)
+	s.stub.Now = t
+	if resp := s.stub.Init("sim0", nil); resp.Status >= shim.ERRORTHRESHOLD {
+		return fmt.Errorf("Init failed: %s", resp.Message)
+	}
+	if err := s.must(t, "Org1MSP", "initLedger"); err != nil {
+		return err
+	}
+	for i := 0; i < s.cfg.Stations; i++ {
+		name := "org" + strconv.Itoa(5+i)
+		if i >= 2 {
+			org := fmt.Sprintf(`{"Name":%q,"MSPID":%q,"Roles":[%q],"OpeningBalance":"100000"}`, name, simMSPID(name), RoleRetailer)
+			if err := s.must(t, "Org1MSP", "registerOrg", org); err != nil {
+				return err
+			}
+		}
+		s.stations = append(s.stations, name)
+	}
+	tol := s.cfg.Tolerances
+	if err := s.must(t, "Org1MSP", "setTolerances", strconv.FormatFloat(tol.Quantity, 'f', -1, 64),
+		strconv.FormatFloat(tol.Density, 'f', -1, 64)); err != nil {
+		return err
+	}
+	for _, tariff := range s.cfg.Tariffs {
+		if err := s.must(t, "Org1MSP", "setTariff", tariff); err != nil {
+			return err
+		}
+	}
+	for i := 1; i <= s.cfg.Trucks; i++ {
+		if err := s.must(t, "Org4MSP", "registerVehicle", "Truck", simTruck(i), strconv.Itoa(s.cfg.TruckCapacity)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//MSP ID of an org of the simulation, e.g. Org7MSP for org7
+func simMSPID(org string) string {
+	return "Org" + strings.TrimPrefix(org, "org") + "MSP"
+}
+
+func simTruck(i int) string {
+	return "Truck" + strconv.Itoa(i)
+}
+
+func rfc(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
+
+func (s *simulation) day(day time.Time) {
+	//the vessels set off, to arrive in the evening
+	vessels := int(s.cfg.VesselsPerDay)
+	if s.rnd.Float64() < s.cfg.VesselsPerDay-float64(vessels) {
+		vessels++
+	}
+	crudeIDs := []string{}
+	for v := 0; v < vessels; v++ {
+		q := s.cfg.VesselQuantity
+		est := day.Add(20 * time.Hour)
+		id, ok := s.submit(day, "Org1MSP", "deliverCrude", (s.cfg.CrudePrice * Money(q)).String(), strconv.Itoa(q),
+			"org1", rfc(est), "org1", "org3", "Vessel"+strconv.Itoa(v+1), rfc(day))
+		if ok {
+			crudeIDs = append(crudeIDs, id)
+		}
+	}
+	s.order(day.Add(6 * time.Hour))
+	stops := s.plan(day.Add(7*time.Hour), day.Add(9*time.Hour))
+	for _, stop := range stops {
+		s.deliverFuel(stop)
+	}
+	for _, id := range crudeIDs {
+		s.deliverCrude(id, day.Add(20*time.Hour))
+	}
+}
+
+//the stations order, in random order, from the oldest fuel that has enough left
+func (s *simulation) order(t time.Time) {
+	for _, i := range s.rnd.Perm(len(s.stations)) {
+		if s.rnd.Float64() >= s.cfg.OrderRate {
+			continue
+		}
+		q := s.cfg.MinOrder + s.rnd.Intn(s.cfg.MaxOrder-s.cfg.MinOrder+1)
+		f := -1
+		for j := range s.fuels {
+			if s.fuels[j].remaining >= q {
+				f = j
+				break
+			}
+		}
+		if f < 0 {
+			s.m.OrdersUnfilled++
+			continue
+		}
+		station := s.stations[i]
+		id, ok := s.submit(t, "Org3MSP", "addFuelOrder", (s.cfg.FuelPrice * Money(q)).String(), strconv.Itoa(q),
+			"org3", station, s.fuels[f].id, rfc(t))
+		if ok == false {
+			s.m.OrdersUnfilled++
+			continue
+		}
+		s.m.OrdersPlaced++
+		s.fuels[f].remaining -= q
+		if s.fuels[f].remaining == 0 {
+			s.fuels = append(s.fuels[:f], s.fuels[f+1:]...)
+		}
+		s.pending = append(s.pending, simOrder{id, station, q})
+	}
+}
+
+/*
+Plans a trip for every truck, filling it with the oldest orders that fit. The first stop is at start.
+Returns the stops of all trips in the order they are delivered.
+*/
+func (s *simulation) plan(t, start time.Time) []simStop {
+	stops := []simStop{}
+	for i := 1; i <= s.cfg.Trucks && len(s.pending) > 0; i++ {
+		load := 0
+		trip := []simStop{}
+		rest := []simOrder{}
+		args := []string{simTruck(i)}
+		for _, o := range s.pending {
+			if load+o.quantity > s.cfg.TruckCapacity {
+				rest = append(rest, o)
+				continue
+			}
+			est := start.Add(time.Duration(len(trip)) * s.cfg.StopInterval)
+			trip = append(trip, simStop{o, "", est})
+			args = append(args, o.id, rfc(est), "org3", o.station)
+			load += o.quantity
+		}
+		planID, ok := s.submit(t, "Org4MSP", "deliverFuel", args...)
+		if ok == false {
+			continue
+		}
+		s.m.Trips++
+		s.carried += load
+		s.pending = rest
+		for _, stop := range trip {
+			stop.planID = planID
+			stops = append(stops, stop)
+		}
+	}
+	sort.SliceStable(stops, func(i, j int) bool { return stops[i].est.Before(stops[j].est) })
+	return stops
+}
+
+/*
+Delay of a delivery: up to 15 minutes early or, with LateRate, up to MaxDelay late. Whole seconds, as in RFC3339.
+*/
+func (s *simulation) delay() time.Duration {
+	if s.rnd.Float64() < s.cfg.LateRate {
+		return time.Duration(1+s.rnd.Int63n(int64(s.cfg.MaxDelay/time.Second))) * time.Second
+	}
+	return -time.Duration(s.rnd.Int63n(15*60+1)) * time.Second
+}
+
+//quantity that arrives of q and whether the carrier shorted it
+func (s *simulation) measure(q int) (int, bool) {
+	if s.rnd.Float64() >= s.cfg.FraudRate {
+		return q, false
+	}
+	short := int(math.Ceil(float64(q) * s.cfg.MaxShortfall * s.rnd.Float64()))
+	if short < 1 {
+		short = 1
+	}
+	if short > q {
+		short = q
+	}
+	return q - short, true
+}
+
+//the vessel arrives and the refinery refines what arrived an hour later
+func (s *simulation) deliverCrude(id string, est time.Time) {
+	q := s.cfg.VesselQuantity
+	delay := s.delay()
+	at := est.Add(delay)
+	measured, shorted := s.measure(q)
+	if _, ok := s.submit(at, "Org3MSP", "transfer", id, "org3", rfc(at), strconv.Itoa(measured), "0"); !ok {
+		return
+	}
+	s.m.CrudeDelivered += measured
+	s.delivered(TypeCrude, id, "org1", "org3", q, measured, delay, shorted)
+
+	yield, err := GetRefiningYield(s.stub)
+	if err != nil {
+		return
+	}
+	fq := int(float64(measured) * yield)
+	if fq < 1 {
+		return
+	}
+	t := at.Add(time.Hour)
+	fuelID, ok := s.submit(t, "Org3MSP", "refine", (s.cfg.FuelPrice * Money(fq)).String(), strconv.Itoa(fq), "org3",
+		"0.85", "Diesel", id, rfc(t))
+	if ok {
+		s.m.FuelRefined += fq
+		s.fuels = append(s.fuels, simFuel{fuelID, fq})
+	}
+}
+
+func (s *simulation) deliverFuel(stop simStop) {
+	o := stop.order
+	delay := s.delay()
+	at := stop.est.Add(delay)
+	measured, shorted := s.measure(o.quantity)
+	if _, ok := s.submit(at, simMSPID(o.station), "transfer", o.id, o.station, rfc(at), stop.planID,
+		strconv.Itoa(measured), "0.85"); !ok {
+		return
+	}
+	s.m.OrdersDelivered++
+	s.m.FuelDelivered += measured
+	s.delivered(TypeFuelOrder, o.id, "org3", o.station, o.quantity, measured, delay, shorted)
+}
+
+/*
+Reads the receipt and the payment of the carrier of a delivered asset into the metrics. The pay of the
+carrier is compared with the fee of its tariff for the quantity paid for: less is a penalty, more a bonus.
+*/
+func (s *simulation) delivered(typ, id, from, to string, q, measured int, delay time.Duration, shorted bool) {
+	s.m.Deliveries++
+	if delay > 0 {
+		s.m.LateDeliveries++
+		s.delays += delay.Seconds()
+	}
+	receipt := DeliveryReceipt{}
+	rbytes, _ := s.stub.GetState(ReceiptKey(id))
+	if err := json.Unmarshal(rbytes, &receipt); err != nil {
+		return
+	}
+	if shorted {
+		s.m.FraudAttempts++
+		if receipt.Status != ReceiptAccepted {
+			s.m.FraudsDetected++
+		} else {
+			s.m.ShortfallUndetected += q - measured
+		}
+	}
+	tariff, err := GetTariff(s.stub, typ, from, to)
+	if err != nil {
+		return
+	}
+	asset := struct{ Payments []Payment }{}
+	abytes, _ := GetAsset(s.stub, id)
+	json.Unmarshal(abytes, &asset)
+	var pay Money
+	for _, p := range asset.Payments {
+		if p.Payee == tariff.Carrier && p.Rule != "" {
+			pay += p.Amount
+		}
+	}
+	fee := tariff.Fee(receipt.PayableQuantity())
+	s.m.CarrierPay += pay
+	if pay < fee {
+		s.m.PenaltiesPaid += fee - pay
+	} else {
+		s.m.BonusesPaid += pay - fee
+	}
+}
+
+//the metrics that are known after the last day
+func (s *simulation) finish() error {
+	s.m.Backlog = len(s.pending)
+	s.m.Throughput = float64(s.m.FuelDelivered) / float64(s.m.Days)
+	if s.m.Trips > 0 {
+		s.m.TruckLoad = float64(s.carried) / float64(s.m.Trips*s.cfg.TruckCapacity)
+	}
+	if s.m.LateDeliveries > 0 {
+		s.m.AvgDelay = s.delays / float64(s.m.LateDeliveries)
+	}
+	orgs, err := GetOrgs(s.stub)
+	if err != nil {
+		return err
+	}
+	s.m.Balances = map[string]Money{}
+	for _, org := range orgs {
+		acc, err := GetAccountState(s.stub, org.Name)
+		if err != nil {
+			return err
+		}
+		s.m.Balances[org.Name] = acc.Balance
+	}
+	return nil
+}
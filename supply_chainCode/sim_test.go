@@ -0,0 +1,93 @@
+//go:build fuelsim
+// +build fuelsim
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//a short run, so that the tests stay fast
+func shortSimConfig() SimConfig {
+	cfg := DefaultSimConfig
+	cfg.Days = 5
+	cfg.Stations = 3
+	return cfg
+}
+
+func TestRunSimulation(t *testing.T) {
+	m, err := RunSimulation(shortSimConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.CrudeDelivered == 0 || m.FuelRefined == 0 || m.OrdersDelivered == 0 || m.Trips == 0 {
+		t.Fatalf("Nothing was delivered: %+v", m)
+	}
+	if m.OrdersDelivered+m.Backlog != m.OrdersPlaced {
+		t.Errorf("%d orders delivered and %d waiting out of %d placed", m.OrdersDelivered, m.Backlog, m.OrdersPlaced)
+	}
+	if m.TruckLoad <= 0 || m.TruckLoad > 1 {
+		t.Errorf("TruckLoad is %v", m.TruckLoad)
+	}
+	if _, ok := m.Balances["org7"]; ok == false {
+		t.Errorf("Balances are %v, expecting the registered station org7", m.Balances)
+	}
+	//money only moves between the accounts
+	var sum Money
+	for _, b := range m.Balances {
+		sum += b
+	}
+	if want := Money(7) * openingBalance; sum > want {
+		t.Errorf("Balances sum to %s, more than the %s opened", sum, want)
+	}
+}
+
+func TestRunSimulationIsDeterministic(t *testing.T) {
+	cfg := shortSimConfig()
+	first, err := RunSimulation(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := RunSimulation(cfg)
+	if reflect.DeepEqual(first, second) == false {
+		t.Errorf("Two runs with seed %d differ:\n%+v\n%+v", cfg.Seed, first, second)
+	}
+	cfg.Seed++
+	if other, _ := RunSimulation(cfg); reflect.DeepEqual(first, other) {
+		t.Errorf("Runs with seeds %d and %d are the same", cfg.Seed-1, cfg.Seed)
+	}
+}
+
+func TestSimulationFrauds(t *testing.T) {
+	cfg := shortSimConfig()
+	cfg.FraudRate = 1
+	cfg.MaxShortfall = 0.2
+	m, err := RunSimulation(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.FraudAttempts != m.Deliveries || m.FraudsDetected == 0 {
+		t.Errorf("%d frauds attempted and %d detected in %d deliveries", m.FraudAttempts, m.FraudsDetected, m.Deliveries)
+	}
+}
+
+func TestSimConfigErrors(t *testing.T) {
+	for want, change := range map[string]func(*SimConfig){
+		"Days, Stations and Trucks":     func(c *SimConfig) { c.Trucks = 0 },
+		"VesselQuantity":                func(c *SimConfig) { c.VesselQuantity = 0 },
+		"Prices should not be negative": func(c *SimConfig) { c.FuelPrice = -1 },
+		"MinOrder should be at least 1": func(c *SimConfig) { c.MinOrder = c.MaxOrder + 1 },
+		"TruckCapacity should be":       func(c *SimConfig) { c.TruckCapacity = c.MaxOrder - 1 },
+		"MaxDelay should be at least":   func(c *SimConfig) { c.MaxDelay = 0 },
+		"should be in [0,1]":            func(c *SimConfig) { c.FraudRate = 1.5 },
+		"setTariff failed":              func(c *SimConfig) { c.Tariffs = []string{`{"Route":`} },
+	} {
+		cfg := shortSimConfig()
+		change(&cfg)
+		if _, err := RunSimulation(cfg); err == nil || strings.Contains(err.Error(), want) == false {
+			t.Errorf("RunSimulation returned %v, expecting %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	dispatched   = "2019-01-01T08:00:00Z"
+	crudeEst     = "2019-01-03T12:00:00Z"
+	refined      = "2019-01-03T14:00:00Z"
+	ordered      = "2019-01-03T15:00:00Z"
+	fuelEst      = "2019-01-04T09:00:00Z"
+	halfHourLate = "2019-01-04T09:30:00Z"
+)
+
+/*
+Fixtures of the supply chain: 100 crude of 1000.00 from org1 to org3, 50 diesel refined from it and
+an order of 20 of it for 500.00 to org5, carried by Truck1 of org4.
+*/
+func (n *testNet) newCrude() string {
+	n.t.Helper()
+	return n.as("Org1MSP").ok("deliverCrude", "1000.00", "100", "org1", crudeEst, "org1", "org3", "Vessel1", dispatched)
+}
+
+//refines a crude, delivered to org3 first if it is ON_WAY
+func (n *testNet) newFuel(crudeID string) string {
+	n.t.Helper()
+	if n.state(crudeID) == "ON_WAY" {
+		n.as("Org3MSP").ok("transfer", crudeID, "org3", crudeEst)
+	}
+	return n.as("Org3MSP").ok("refine", "800.00", "50", "org3", "0.85", "Diesel", crudeID, refined)
+}
+
+func (n *testNet) newFuelOrder(fuelID, dest string) string {
+	n.t.Helper()
+	return n.as("Org3MSP").ok("addFuelOrder", "500.00", "20", "org3", dest, fuelID, ordered)
+}
+
+//a plan of Truck1 delivering the orders to org5
+func (n *testNet) newPlan(orderIDs ...string) string {
+	n.t.Helper()
+	n.as("Org4MSP").ok("registerVehicle", "Truck", "Truck1", "100")
+	args := []string{"Truck1"}
+	for _, id := range orderIDs {
+		args = append(args, id, fuelEst, "org3", "org5")
+	}
+	return n.as("Org4MSP").ok("deliverFuel", args...)
+}
+
+func TestSupplyChain(t *testing.T) {
+	n := newTestNet(t)
+	n.balances(map[string]Money{"org1": openingBalance, "org3": openingBalance, "org5": openingBalance})
+
+	crudeID := n.newCrude()
+	if crudeID != "Crude0000000001" {
+		t.Fatalf("ID of the first crude is %s", crudeID)
+	}
+	//the refiner pays the value and the fee of the shipper (0.10 per unit) into escrow
+	n.balances(map[string]Money{"org3": openingBalance - money("1010.00"), EscrowAccountOf(crudeID): money("1010.00")})
+
+	//on time, so the shipper gets the whole fee
+	n.as("Org3MSP").ok("transfer", crudeID, "org3", crudeEst)
+	if state := n.state(crudeID); state != "DELIVERED" {
+		t.Errorf("%s is %s after its transfer", crudeID, state)
+	}
+	n.balances(map[string]Money{
+		"org1":                   openingBalance + money("1000.00"),
+		"org2":                   openingBalance + money("10.00"),
+		"org3":                   openingBalance - money("1010.00"),
+		EscrowAccountOf(crudeID): 0,
+	})
+
+	fuelID := n.newFuel(crudeID)
+	crude := Crude{}
+	n.asset(crudeID, &crude)
+	if crude.Allocated != 50 {
+		t.Errorf("%s has %d allocated, expecting 50", crudeID, crude.Allocated)
+	}
+
+	orderID := n.newFuelOrder(fuelID, "org5")
+	n.balances(map[string]Money{"org5": openingBalance - money("502.00")})
+	fuel := Fuel{}
+	n.asset(fuelID, &fuel)
+	if fuel.Allocated != 20 {
+		t.Errorf("%s has %d allocated, expecting 20", fuelID, fuel.Allocated)
+	}
+
+	planID := n.newPlan(orderID)
+	if state := n.state(orderID); state != "ON_WAY" {
+		t.Fatalf("%s is %s after it was put in %s", orderID, state, planID)
+	}
+
+	n.as("Org5MSP").ok("transfer", orderID, "org5", fuelEst, planID)
+	n.balances(map[string]Money{
+		"org3":                   openingBalance - money("1010.00") + money("500.00"),
+		"org4":                   openingBalance + money("2.00"),
+		"org5":                   openingBalance - money("502.00"),
+		EscrowAccountOf(orderID): 0,
+	})
+	order := FuelOrder{}
+	n.asset(orderID, &order)
+	if order.AD.State != "DELIVERED" || order.AD.Owner != "org5" {
+		t.Errorf("%s is %s and owned by %s after its transfer", orderID, order.AD.State, order.AD.Owner)
+	}
+	if len(order.Payments) != 2 {
+		t.Errorf("%s has %d payments, expecting 2", orderID, len(order.Payments))
+	}
+	if types := n.eventTypes(); len(types) == 0 || types[len(types)-1] != EventFuelOrderDelivered {
+		t.Errorf("Events of the transfer are %v", types)
+	}
+
+	var entries []JournalEntry
+	n.query(&entries, "queryJournalByReference", orderID)
+	//the escrow, the payments to the carrier and the refiner
+	if len(entries) != 3 {
+		t.Errorf("%s has %d journal entries, expecting 3", orderID, len(entries))
+	}
+	n.checkBooks()
+}
+
+func TestTransferLate(t *testing.T) {
+	n := newTestNet(t)
+	orderID := n.newFuelOrder(n.newFuel(n.newCrude()), "org5")
+	planID := n.newPlan(orderID)
+	//the penalty (36.00 an hour) is more than the fee of the carrier, so the rest of the escrow goes back
+	n.as("Org5MSP").ok("transfer", orderID, "org5", halfHourLate, planID)
+	n.balances(map[string]Money{
+		"org3": openingBalance - money("1010.00") + money("500.00"),
+		"org4": openingBalance,
+		"org5": openingBalance - money("500.00"),
+	})
+	plan := FuelDeliveryPlan{}
+	n.asset(planID, &plan)
+	if delay := plan.Plan[orderID].Delay; delay != 1800 {
+		t.Errorf("Delay of %s is %v, expecting 1800", orderID, delay)
+	}
+	n.checkBooks()
+}
+
+func TestDeliverCrudeErrors(t *testing.T) {
+	n := newTestNet(t)
+	args := []string{"1000.00", "100", "org1", crudeEst, "org1", "org3", "Vessel1", dispatched}
+	with := func(i int, v string) []string {
+		a := append([]string{}, args...)
+		a[i] = v
+		return a
+	}
+	n.as("Org1MSP")
+	n.fails("Incorrect number of arguments", "deliverCrude", args[:7]...)
+	n.fails("Incorrect number of arguments", "deliverCrude", append(args, "EUR", "x")...)
+	n.fails("Value is not an amount", "deliverCrude", with(0, "10.001")...)
+	n.fails("Value is not an amount", "deliverCrude", with(0, "-1")...)
+	n.fails("Quantity is not an int number", "deliverCrude", with(1, "1.5")...)
+	n.fails("Owner should be an active org", "deliverCrude", with(2, "org9")...)
+	n.fails("Time is not in RFC3339 format", "deliverCrude", with(3, "2019-01-03 12:00")...)
+	n.fails("Starting Location should be an active org", "deliverCrude", with(4, "org9")...)
+	n.fails("Destination should be an active org", "deliverCrude", with(5, "org9")...)
+	n.fails("Time not provided in RFC3339 format", "deliverCrude", with(7, "yesterday")...)
+	n.fails("Currency should be a 3 letter code", "deliverCrude", append(args, "EURO")...)
+	n.fails("No FX rate from USD to EUR", "deliverCrude", append(args, "USD")...)
+	n.as("Org3MSP").fails("Access denied", "deliverCrude", args...)
+	//the shipper dispatches only the crude it owns
+	n.as("Org2MSP").fails("Access denied: only org1 can create an asset it owns", "deliverCrude", args...)
+
+	//the buyer can't pay for it
+	n.as("Org1MSP").ok("setCreditLimit", "org3", "0")
+	n.fails(ErrInsufficientFunds, "deliverCrude", with(0, "200000.00")...)
+	n.balances(map[string]Money{"org3": openingBalance})
+	if id := n.newCrude(); id != "Crude0000000001" {
+		t.Errorf("Failed txs used up IDs, the first crude is %s", id)
+	}
+}
+
+func TestRefineErrors(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.newCrude()
+	n.as("Org3MSP").ok("transfer", crudeID, "org3", crudeEst)
+	args := []string{"800.00", "50", "org3", "0.85", "Diesel", crudeID, refined}
+	with := func(i int, v string) []string {
+		a := append([]string{}, args...)
+		a[i] = v
+		return a
+	}
+	n.as("Org3MSP")
+	n.fails("Incorrect number of arguments", "refine", args[:6]...)
+	n.fails("Value is not an amount", "refine", with(0, "abc")...)
+	n.fails("Quantity is not an int number", "refine", with(1, "-5")...)
+	n.fails("Density should be a float number", "refine", with(3, "dense")...)
+	n.fails("Time not provided in RFC3339 format", "refine", with(6, "2019-01-03")...)
+	n.fails("ID of crude doesn't exist", "refine", with(5, "Crude0000000009")...)
+	n.fails("Not enough crude", "refine", with(1, "101")...)
+	n.as("Org1MSP").fails("Access denied", "refine", args...)
+
+	//only a delivered crude of the refiner is refined
+	onWayID := n.newCrude()
+	n.as("Org3MSP").fails("Crude0000000002 is ON_WAY. Only a DELIVERED crude can be refined", "refine", with(5, onWayID)...)
+	n.as("Org1MSP").ok("cancelOrder", onWayID, "not needed")
+	n.as("Org3MSP").fails("Crude0000000002 is CANCELLED", "refine", with(5, onWayID)...)
+	disputedID := n.newCrude()
+	n.as("Org3MSP").ok("transfer", disputedID, "org3", crudeEst)
+	n.ok("openDispute", disputedID, ReasonQuality, "2c26b46b")
+	n.fails("Crude0000000003 is DISPUTED", "refine", with(5, disputedID)...)
+	n.as("Org1MSP").ok("setRoleMSPs", RoleRefiner, "Org3MSP", "Org6MSP")
+	n.as("Org6MSP").fails("Access denied: Crude0000000001 is owned by org3, not org6", "refine", with(2, "org6")...)
+
+	n.as("Org1MSP").ok("setRefiningYield", "0.5")
+	//50 fuel needs 100 crude at 0.5
+	n.as("Org3MSP").ok("refine", args...)
+	n.fails("Not enough crude", "refine", with(1, "1")...)
+}
+
+func TestAddFuelOrderErrors(t *testing.T) {
+	n := newTestNet(t)
+	fuelID := n.newFuel(n.newCrude())
+	args := []string{"500.00", "20", "org3", "org5", fuelID, ordered}
+	with := func(i int, v string) []string {
+		a := append([]string{}, args...)
+		a[i] = v
+		return a
+	}
+	n.as("Org3MSP")
+	n.fails("Incorrect number of arguments", "addFuelOrder", args[:5]...)
+	n.fails("Value is not an amount", "addFuelOrder", with(0, "1,5")...)
+	n.fails("Quantity is not an int number", "addFuelOrder", with(1, "twenty")...)
+	n.fails("Destination should be a fueling station", "addFuelOrder", with(3, "org4")...)
+	n.fails("Destination should be a fueling station", "addFuelOrder", with(3, "org9")...)
+	n.fails("FuelID doens't exist", "addFuelOrder", with(4, "Fuel0000000009")...)
+	n.fails("Time not provided in RFC3339 format", "addFuelOrder", with(5, "15:00")...)
+	n.fails("Not enough fuel", "addFuelOrder", with(1, "51")...)
+	n.as("Org5MSP").fails("Access denied", "addFuelOrder", args...)
+
+	//another refiner can't sell the fuel of org3
+	n.as("Org1MSP").ok("setRoleMSPs", RoleRefiner, "Org3MSP", "Org6MSP")
+	n.as("Org6MSP").fails("Access denied: only org3 can create an asset it owns", "addFuelOrder", args...)
+	n.fails("Access denied: Fuel0000000001 is owned by org3, not org6", "addFuelOrder", with(2, "org6")...)
+	n.balances(map[string]Money{"org6": openingBalance})
+}
+
+func TestDeliverFuelErrors(t *testing.T) {
+	n := newTestNet(t)
+	fuelID := n.newFuel(n.newCrude())
+	orderID := n.newFuelOrder(fuelID, "org5")
+	otherID := n.newFuelOrder(fuelID, "org6")
+	n.as("Org4MSP").ok("registerVehicle", "Truck", "Truck1", "30")
+	stop := []string{orderID, fuelEst, "org3", "org5"}
+	plan := func(stops ...string) []string {
+		return append([]string{"Truck1"}, stops...)
+	}
+	n.fails("Expecting more args", "deliverFuel")
+	n.fails("Truck Truck9 is not registered", "deliverFuel", append([]string{"Truck9"}, stop...)...)
+	n.fails("At least one delivery should be specified", "deliverFuel", plan()...)
+	n.fails("Arguments dont match", "deliverFuel", plan(stop[:3]...)...)
+	n.fails("more than once in the plan", "deliverFuel", plan(append(stop, stop...)...)...)
+	n.fails("FuelOrderID FuelOrder0000000009 does not exist", "deliverFuel", plan("FuelOrder0000000009", fuelEst, "org3", "org5")...)
+	n.fails("Time is not in RFC3339 format", "deliverFuel", plan(orderID, "9am", "org3", "org5")...)
+	n.fails("Destination should be an active org", "deliverFuel", plan(orderID, fuelEst, "org3", "org9")...)
+	n.fails("should be delivered to org5, not org6", "deliverFuel", plan(orderID, fuelEst, "org3", "org6")...)
+	n.fails("can carry 30 but the plan has 40", "deliverFuel", plan(append(stop, otherID, fuelEst, "org3", "org6")...)...)
+	n.as("Org5MSP").fails("Access denied", "deliverFuel", plan(stop...)...)
+
+	//the failed txs left the order as it was
+	if state := n.state(orderID); state != "READY_FOR_DISTRIBUTION" {
+		t.Fatalf("%s is %s after failed plans", orderID, state)
+	}
+	n.as("Org4MSP").ok("deliverFuel", plan(stop...)...)
+	n.fails("already in another plan", "deliverFuel", plan(stop...)...)
+}
+
+func TestTransferErrors(t *testing.T) {
+	n := newTestNet(t)
+	fuelID := n.newFuel(n.newCrude())
+	crudeID := n.newCrude()
+	orderID := n.newFuelOrder(fuelID, "org5")
+	otherID := n.newFuelOrder(fuelID, "org5")
+
+	n.as("Org3MSP")
+	n.fails("Wrong # of arguments", "transfer", crudeID, "org3")
+	n.fails("Wrong # of arguments", "transfer", crudeID, "org3", crudeEst, "100", "0", "x", "y")
+	n.fails("Owner should be an active org", "transfer", crudeID, "org9", crudeEst)
+	n.fails("Timestamp not in RFC3339 format", "transfer", crudeID, "org3", "noon")
+	n.fails("Could not locate Asset", "transfer", "Crude0000000009", "org3", crudeEst)
+	n.fails("Expecting {CrudeID,owner,curtime}", "transfer", crudeID, "org3", crudeEst, "100")
+	n.fails("Measured quantity is not an int number", "transfer", crudeID, "org3", crudeEst, "lots", "0")
+	n.fails("Measured density is not a float number", "transfer", crudeID, "org3", crudeEst, "100", "heavy")
+	n.fails("not a valid ID or it's not deliverable", "transfer", fuelID, "org3", crudeEst)
+	n.as("Org5MSP").fails("Access denied", "transfer", crudeID, "org3", crudeEst)
+
+	//an order that isn't in a plan isn't ON_WAY
+	n.fails("Expecting {FuelOrderID,owner,curtime,PlanID}", "transfer", orderID, "org5", fuelEst)
+	n.fails("state is not ON_WAY", "transfer", orderID, "org5", fuelEst, "Plan0000000001")
+
+	planID := n.newPlan(orderID)
+	n.as("Org5MSP")
+	n.fails("PlanID is not of the form", "transfer", orderID, "org5", fuelEst, "Trip1")
+	n.fails("Could not locate Plan", "transfer", orderID, "org5", fuelEst, "Plan0000000009")
+	n.as("Org4MSP").ok("deliverFuel", "Truck1", otherID, fuelEst, "org3", "org5")
+	n.as("Org5MSP").fails("didn't exist in any plan", "transfer", orderID, "org5", fuelEst, "Plan0000000002")
+	n.as("Org3MSP").fails("Access denied", "transfer", orderID, "org5", fuelEst, planID)
+	//a retailer can't receive the order of another station, nor have it received by another org
+	n.as("Org6MSP").fails("Access denied: only org5", "transfer", orderID, "org5", fuelEst, planID)
+	n.as("Org6MSP").fails("New owner should be org5", "transfer", orderID, "org6", fuelEst, planID)
+	n.as("Org5MSP").fails("New owner should be org5", "transfer", orderID, "org6", fuelEst, planID)
+
+	//a delivered asset can't be transferred again
+	n.as("Org3MSP").ok("transfer", crudeID, "org3", crudeEst)
+	n.fails("state is not ON_WAY", "transfer", crudeID, "org3", crudeEst)
+	n.as("Org5MSP").ok("transfer", orderID, "org5", fuelEst, planID)
+	n.fails("state is not ON_WAY", "transfer", orderID, "org5", fuelEst, planID)
+	n.balances(map[string]Money{"org1": openingBalance + money("2000.00"), "org4": openingBalance + money("2.00")})
+}
+
+func TestQueryAsset(t *testing.T) {
+	n := newTestNet(t)
+	crudeID := n.newCrude()
+	crude := Crude{}
+	n.query(&crude, "queryAsset", crudeID)
+	if crude.AD.Owner != "org1" || crude.DD.Destination != "org3" || crude.AD.Value != money("1000.00") {
+		t.Errorf("%s is %+v", crudeID, crude)
+	}
+	acc := Account{}
+	n.query(&acc, "queryAsset", "org1")
+	if acc.Balance != openingBalance || acc.Currency != BaseCurrency {
+		t.Errorf("Account of org1 is %+v", acc)
+	}
+	n.fails("Could not locate asset", "queryAsset", "Crude0000000009")
+	n.fails("Could not locate asset", "queryAsset", "org9")
+	n.fails("Incorect # of args", "queryAsset")
+}
+
+func TestInitLedger(t *testing.T) {
+	n := newBareNet(t)
+	n.as("Org3MSP").fails("Access denied", "initLedger")
+	n.as("Org1MSP").fails("org:currency pairs", "initLedger", "org5")
+	n.fails("org:currency pairs", "initLedger", "org5:dollars")
+	n.fails("not a registered org", "initLedger", "org9:USD")
+	n.ok("initLedger", "org5:USD")
+	if types := n.eventTypes(); len(types) == 0 || types[len(types)-1] != EventLedgerInitialized {
+		t.Errorf("Events of initLedger are %v", types)
+	}
+	acc, _ := GetAccountState(n.stub, "org5")
+	if acc.Currency != "USD" || acc.Balance != openingBalance {
+		t.Errorf("Account of org5 is %+v", acc)
+	}
+	n.balances(map[string]Money{"org1": openingBalance, CapitalAccount: -5 * openingBalance, CapitalAccountOf("USD"): -openingBalance})
+	n.fails("called already", "initLedger")
+}
+
+func TestUnknownFunction(t *testing.T) {
+	n := newTestNet(t)
+	n.fails("Invalid Smart Contract function name", "mintMoney", "org1")
+}
+
+//the functions of the API (see the header of all-orgsCC.go)
+var routes = []string{
+	"deliverCrude", "refine", "addFuelOrder", "deliverFuel", "transfer",
+	"queryAsset", "queryAssetByRange", "queryAssetsByOwner", "queryAssetsByState", "queryAssetsByDestination", "queryAssets",
+	"queryHistoryForKey", "traceLineage", "auditQuantities", "setRefiningYield",
+	"registerVehicle", "reconcilePlan", "queryReceipt", "setTolerances",
+	"openDispute", "respondDispute", "resolveDispute", "queryDisputes",
+	"initLedger", "setRoleMSPs", "queryRoles", "querySchemas",
+	"getAccountStatement", "queryJournalByReference", "migrateKeys", "reindexAssets",
+	"setFXRate", "queryFXRate", "migrateMoney", "setCreditLimit", "queryAvailableFunds",
+	"setTariff", "queryTariff", "queryEscrow", "refundEscrow", "setEscrowPeriod",
+	"queryTerms", "verifyTerms", "queryPairSettlement",
+	"cancelOrder", "amendPlan", "cancelPlan",
+	"registerOrg", "updateOrg", "deactivateOrg", "reactivateOrg", "queryOrg", "queryOrgs",
+}
+
+//every function of the API is routed by Invoke
+func TestRoutes(t *testing.T) {
+	n := newTestNet(t)
+	for _, fn := range routes {
+		resp := n.invoke(fn)
+		if strings.Contains(resp.Message, "Invalid Smart Contract function name") {
+			t.Errorf("%s is not routed", fn)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+/*
+Tariffs of the carriers.
+
+A tariff is the contract of a carrier for the deliveries of a type of asset on a route (From -> To):
+
+	Rate     - paid per unit of the delivered quantity
+	Grace    - seconds of delay without penalty
+	Penalty  - taken off the pay for the delay after the grace period. Curve is one of
+	           LINEAR  - PerHour of delay
+	           CAPPED  - PerHour of delay, at most Cap
+	           STEPPED - the Amount of the last of the Steps whose After (seconds) the delay reached
+	Bonus    - optional, added to the pay for an early delivery: PerHour early, at most Cap (0 for no cap)
+
+The pay is never negative. From and To can be '*' for any org. The tariff of a delivery is the first of
+(From,To), (From,*), (*,To), (*,*) on the ledger and, if there is none, the default tariff of the type:
+org2 carries Crude and org4 FuelOrders, for 0.10 per unit and 36.00 per hour late (1 per 100 seconds).
+
+Every change of a tariff is a new version, and every version is kept. The payments of a delivery record
+the rule they were computed with, e.g. 'Crude/org1/org3@v2' (a default is version 0 of the tariff of its
+type from '*' to '*'), so that historic payouts stay explainable.
+
+API:
+
+setTariff - admin only. args[0] = JSON tariff, e.g.
+	{"AssetType":"Crude","From":"org1","To":"org3","Carrier":"org2","Rate":"0.10","Grace":600,
+	 "Penalty":{"Curve":"CAPPED","PerHour":"36.00","Cap":"50.00"},"Bonus":{"PerHour":"10.00","Cap":"20.00"}}
+queryTariff - args[0] = type, args[1] = from, args[2] = to, args[3] = version (optional, the current one if missing)
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CurveLinear  = "LINEAR"
+	CurveCapped  = "CAPPED"
+	CurveStepped = "STEPPED"
+)
+
+const (
+	tariffObjectType        = "Tariff"
+	tariffVersionObjectType = "TariffVersion"
+	AnyOrg                  = "*"
+)
+
+type PenaltyStep struct {
+	After  int64 //seconds of delay after the grace period
+	Amount Money
+}
+
+type PenaltyRule struct {
+	Curve   string
+	PerHour Money         `json:",omitempty"`
+	Cap     Money         `json:",omitempty"`
+	Steps   []PenaltyStep `json:",omitempty"`
+}
+
+type BonusRule struct {
+	PerHour Money
+	Cap     Money
+}
+
+/*
+Put in db with key (Tariff, type, from, to) and, per version, (TariffVersion, type, from, to, version).
+*/
+type Tariff struct {
+	AssetType string
+	From      string
+	To        string
+	Carrier   string
+	Rate      Money //per unit
+	Grace     int64 //seconds
+	Penalty   PenaltyRule
+	Bonus     *BonusRule `json:",omitempty"`
+	Version   int
+	Timestamp time.Time
+}
+
+var DefaultTariffs = map[string]Tariff{
+	TypeCrude:     {TypeCrude, AnyOrg, AnyOrg, "org2", 10, 0, PenaltyRule{CurveLinear, 3600, 0, nil}, nil, 0, time.Time{}},
+	TypeFuelOrder: {TypeFuelOrder, AnyOrg, AnyOrg, "org4", 10, 0, PenaltyRule{CurveLinear, 3600, 0, nil}, nil, 0, time.Time{}},
+}
+
+//the rule a payment was computed with
+func (t Tariff) Rule() string {
+	return fmt.Sprintf("%s/%s/%s@v%d", t.AssetType, t.From, t.To, t.Version)
+}
+
+//pay of the carrier before penalties and bonuses
+func (t Tariff) Fee(quantity int) Money {
+	return t.Rate * Money(quantity)
+}
+
+/*
+Pay of the carrier for delivering quantity with delay (seconds, negative if early).
+*/
+func (t Tariff) CarrierPay(quantity int, delay float64) Money {
+	pay := t.Fee(quantity)
+	seconds := int64(math.Round(delay))
+	switch {
+	case seconds > t.Grace:
+		pay -= t.Penalty.amount(seconds - t.Grace)
+	case seconds < 0 && t.Bonus != nil:
+		bonus := t.Bonus.PerHour.MulDiv(-seconds, 3600)
+		if t.Bonus.Cap > 0 && bonus > t.Bonus.Cap {
+			bonus = t.Bonus.Cap
+		}
+		pay += bonus
+	}
+	if pay < 0 {
+		return 0
+	}
+	return pay
+}
+
+//penalty for seconds late after the grace period
+func (p PenaltyRule) amount(seconds int64) Money {
+	switch p.Curve {
+	case CurveStepped:
+		var penalty Money
+		for _, s := range p.Steps {
+			if seconds >= s.After {
+				penalty = s.Amount
+			}
+		}
+		return penalty
+	case CurveCapped:
+		penalty := p.PerHour.MulDiv(seconds, 3600)
+		if penalty > p.Cap {
+			return p.Cap
+		}
+		return penalty
+	}
+	return p.PerHour.MulDiv(seconds, 3600)
+}
+
+func (t Tariff) validate(stub shim.ChaincodeStubInterface) error {
+	if t.AssetType != TypeCrude && t.AssetType != TypeFuelOrder {
+		return errors.New("AssetType should be one of {Crude,FuelOrder}")
+	}
+	for _, org := range []string{t.From, t.To} {
+		if org == AnyOrg {
+			continue
+		}
+		if err := CheckOrg(stub, org); err != nil {
+			return fmt.Errorf("From and To should be active orgs or '*': %s", err)
+		}
+	}
+	if err := CheckOrg(stub, t.Carrier); err != nil {
+		return fmt.Errorf("Carrier should be an active org: %s", err)
+	}
+	if t.Rate < 0 || t.Grace < 0 {
+		return errors.New("Rate and Grace should not be negative")
+	}
+	p := t.Penalty
+	switch p.Curve {
+	case CurveLinear, CurveCapped:
+		if p.PerHour < 0 || p.Cap < 0 || len(p.Steps) > 0 {
+			return fmt.Errorf("A %s penalty has a non negative PerHour (and Cap) and no Steps", p.Curve)
+		}
+		if p.Curve == CurveLinear && p.Cap != 0 {
+			return errors.New("A LINEAR penalty has no Cap. Use a CAPPED one")
+		}
+	case CurveStepped:
+		if p.PerHour != 0 || p.Cap != 0 || len(p.Steps) == 0 {
+			return errors.New("A STEPPED penalty has only Steps")
+		}
+		for i, s := range p.Steps {
+			if s.After < 0 || s.Amount < 0 || (i > 0 && s.After <= p.Steps[i-1].After) {
+				return errors.New("Steps should have non negative amounts, in increasing order of After")
+			}
+		}
+	default:
+		return errors.New("Curve of the penalty should be one of {LINEAR,CAPPED,STEPPED}")
+	}
+	if t.Bonus != nil && (t.Bonus.PerHour < 0 || t.Bonus.Cap < 0) {
+		return errors.New("PerHour and Cap of the bonus should not be negative")
+	}
+	return nil
+}
+
+func getTariffState(stub shim.ChaincodeStubInterface, objectType string, attrs []string) (*Tariff, error) {
+	key, err := stub.CreateCompositeKey(objectType, attrs)
+	if err != nil {
+		return nil, err
+	}
+	tbytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to read tariff")
+	}
+	if tbytes == nil {
+		return nil, nil
+	}
+	t := Tariff{}
+	if err = json.Unmarshal(tbytes, &t); err != nil {
+		return nil, fmt.Errorf("Tariff %s is corrupted", strings.Join(attrs, "/"))
+	}
+	return &t, nil
+}
+
+/*
+Tariff of a delivery of typ from an org to another. An empty from or to is any org.
+*/
+func GetTariff(stub shim.ChaincodeStubInterface, typ, from, to string) (Tariff, error) {
+	if from == "" {
+		from = AnyOrg
+	}
+	if to == "" {
+		to = AnyOrg
+	}
+	for _, route := range [][]string{{from, to}, {from, AnyOrg}, {AnyOrg, to}, {AnyOrg, AnyOrg}} {
+		t, err := getTariffState(stub, tariffObjectType, []string{typ, route[0], route[1]})
+		if err != nil {
+			return Tariff{}, err
+		}
+		if t != nil {
+			return *t, nil
+		}
+	}
+	t, ok := DefaultTariffs[typ]
+	if !ok {
+		return Tariff{}, fmt.Errorf("No tariff for %s", typ)
+	}
+	return t, nil
+}
+
+/*
+args[0] = JSON tariff (without Version and Timestamp, the chaincode sets them)
+*/
+func (s *SmartContract) setTariff(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if err := checkRole(stub, RoleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 arg")
+	}
+	t := Tariff{}
+	dec := json.NewDecoder(strings.NewReader(args[0]))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&t); err != nil {
+		return shim.Error(fmt.Sprintf("Invalid tariff: %s", err))
+	}
+	if err := t.validate(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+	route := []string{t.AssetType, t.From, t.To}
+	current, err := getTariffState(stub, tariffObjectType, route)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	t.Version = 1
+	if current != nil {
+		t.Version = current.Version + 1
+	}
+	if t.Timestamp, err = TxTime(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+	tbytes, _ := json.Marshal(t)
+	keys := [][]string{
+		append([]string{tariffObjectType}, route...),
+		append([]string{tariffVersionObjectType}, append(route, fmt.Sprintf("%06d", t.Version))...),
+	}
+	for _, k := range keys {
+		key, err := stub.CreateCompositeKey(k[0], k[1:])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err = stub.PutState(key, tbytes); err != nil {
+			return shim.Error("Failed to put tariff in db")
+		}
+	}
+	return shim.Success([]byte(t.Rule()))
+}
+
+/*
+args[0] = type, args[1] = from, args[2] = to ('*' for any org)
+args[3] = version (optional). Without a version the tariff that applies to the route is returned.
+*/
+func (s *SmartContract) queryTariff(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 3 && len(args) != 4 {
+		return shim.Error("Expecting 3 or 4 args")
+	}
+	var t Tariff
+	if len(args) == 3 {
+		var err error
+		if t, err = GetTariff(stub, args[0], args[1], args[2]); err != nil {
+			return shim.Error(err.Error())
+		}
+	} else {
+		version, err := strconv.Atoi(args[3])
+		if err != nil || version < 0 {
+			return shim.Error("Version should be an int number")
+		}
+		def, ok := DefaultTariffs[args[0]]
+		switch {
+		case version == 0 && ok && args[1] == AnyOrg && args[2] == AnyOrg:
+			t = def
+		default:
+			found, err := getTariffState(stub, tariffVersionObjectType, []string{args[0], args[1], args[2], fmt.Sprintf("%06d", version)})
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if found == nil {
+				return shim.Error("Could not locate tariff")
+			}
+			t = *found
+		}
+	}
+	tbytes, _ := json.Marshal(t)
+	return shim.Success(tbytes)
+}
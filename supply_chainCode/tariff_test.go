@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+)
+
+const crudeTariff = `{"AssetType":"Crude","From":"org1","To":"org3","Carrier":"org2","Rate":"0.20","Grace":600,
+	"Penalty":{"Curve":"CAPPED","PerHour":"36.00","Cap":"5.00"},"Bonus":{"PerHour":"10.00","Cap":"20.00"}}`
+
+func TestTariff(t *testing.T) {
+	n := newTestNet(t)
+	if rule := n.ok("setTariff", crudeTariff); rule != "Crude/org1/org3@v1" {
+		t.Fatalf("Rule of the new tariff is %s", rule)
+	}
+	//the fee of the tariff is escrowed
+	crudeID := n.newCrude()
+	n.balances(map[string]Money{"org3": openingBalance - money("1020.00")})
+
+	//2 hours early, so the shipper gets a bonus of 20.00 (the cap), more than the escrow holds
+	n.as("Org3MSP").ok("transfer", crudeID, "org3", "2019-01-03T10:00:00Z")
+	n.balances(map[string]Money{
+		"org1": openingBalance + money("1000.00"),
+		"org2": openingBalance + money("40.00"),
+		"org3": openingBalance - money("1040.00"),
+	})
+	crude := Crude{}
+	n.asset(crudeID, &crude)
+	if len(crude.Payments) == 0 || crude.Payments[0].Rule != "Crude/org1/org3@v1" {
+		t.Errorf("Payments of %s are %+v", crudeID, crude.Payments)
+	}
+
+	//an hour late, so the penalty is the cap
+	crudeID = n.newCrude()
+	n.as("Org3MSP").ok("transfer", crudeID, "org3", "2019-01-03T13:00:00Z")
+	n.balances(map[string]Money{"org2": openingBalance + money("40.00") + money("15.00")})
+	n.checkBooks()
+}
+
+func TestTariffVersions(t *testing.T) {
+	n := newTestNet(t)
+	n.ok("setTariff", crudeTariff)
+	if rule := n.ok("setTariff", `{"AssetType":"Crude","From":"org1","To":"org3","Carrier":"org2","Rate":"0.30","Penalty":{"Curve":"LINEAR","PerHour":"36.00"}}`); rule != "Crude/org1/org3@v2" {
+		t.Fatalf("Rule of the new version is %s", rule)
+	}
+	tariff := Tariff{}
+	n.query(&tariff, "queryTariff", TypeCrude, "org1", "org3")
+	if tariff.Version != 2 || tariff.Rate != money("0.30") {
+		t.Errorf("Current tariff is %+v", tariff)
+	}
+	n.query(&tariff, "queryTariff", TypeCrude, "org1", "org3", "1")
+	if tariff.Version != 1 || tariff.Rate != money("0.20") || tariff.Bonus == nil {
+		t.Errorf("Version 1 of the tariff is %+v", tariff)
+	}
+	//other routes have the default tariff
+	n.query(&tariff, "queryTariff", TypeFuelOrder, "org3", "org5")
+	if tariff.Carrier != "org4" || tariff.Version != 0 {
+		t.Errorf("Tariff of FuelOrders is %+v", tariff)
+	}
+	n.query(&tariff, "queryTariff", TypeFuelOrder, AnyOrg, AnyOrg, "0")
+	if tariff.Carrier != "org4" {
+		t.Errorf("Default tariff of FuelOrders is %+v", tariff)
+	}
+	n.fails("Could not locate tariff", "queryTariff", TypeCrude, "org1", "org3", "3")
+	n.fails("Version should be an int number", "queryTariff", TypeCrude, "org1", "org3", "v1")
+	n.fails("No tariff for Fuel", "queryTariff", TypeFuel, "org1", "org3")
+	n.fails("Expecting 3 or 4 args", "queryTariff", TypeCrude)
+}
+
+func TestSetTariffErrors(t *testing.T) {
+	n := newTestNet(t)
+	tariff := func(fields string) string {
+		return `{"AssetType":"FuelOrder","From":"*","To":"org5","Carrier":"org4","Rate":"0.10",` + fields + `}`
+	}
+	n.fails("Expecting 1 arg", "setTariff")
+	n.fails("Invalid tariff", "setTariff", tariff(`"Penalty":{"Curve":"LINEAR"},"Colour":"red"`))
+	n.fails("Invalid tariff", "setTariff", tariff(`"Rate":"0.101","Penalty":{"Curve":"LINEAR"}`))
+	n.fails("AssetType should be one of", "setTariff", `{"AssetType":"Fuel","From":"*","To":"*","Carrier":"org4","Penalty":{"Curve":"LINEAR"}}`)
+	n.fails("From and To should be active orgs", "setTariff", `{"AssetType":"Crude","From":"org9","To":"*","Carrier":"org2","Penalty":{"Curve":"LINEAR"}}`)
+	n.fails("Carrier should be an active org", "setTariff", tariff(`"Carrier":"org9","Penalty":{"Curve":"LINEAR"}`))
+	n.fails("Rate and Grace should not be negative", "setTariff", tariff(`"Grace":-1,"Penalty":{"Curve":"LINEAR"}`))
+	n.fails("A LINEAR penalty has no Cap", "setTariff", tariff(`"Penalty":{"Curve":"LINEAR","PerHour":"1.00","Cap":"2.00"}`))
+	n.fails("A CAPPED penalty has a non negative PerHour", "setTariff", tariff(`"Penalty":{"Curve":"CAPPED","PerHour":"-1.00"}`))
+	n.fails("A STEPPED penalty has only Steps", "setTariff", tariff(`"Penalty":{"Curve":"STEPPED"}`))
+	n.fails("in increasing order of After", "setTariff", tariff(`"Penalty":{"Curve":"STEPPED","Steps":[{"After":600,"Amount":"1.00"},{"After":60,"Amount":"2.00"}]}`))
+	n.fails("Curve of the penalty should be one of", "setTariff", tariff(`"Penalty":{"Curve":"EXPONENTIAL"}`))
+	n.fails("PerHour and Cap of the bonus should not be negative", "setTariff", tariff(`"Penalty":{"Curve":"LINEAR"},"Bonus":{"PerHour":"-1.00","Cap":"0"}`))
+	n.as("Org4MSP").fails("Access denied", "setTariff", tariff(`"Penalty":{"Curve":"LINEAR"}`))
+
+	//a stepped penalty: 1.00 after 10 minutes, 1.50 after an hour
+	n.as("Org1MSP").ok("setTariff", tariff(`"Penalty":{"Curve":"STEPPED","Steps":[{"After":600,"Amount":"1.00"},{"After":3600,"Amount":"1.50"}]}`))
+	orderID := n.newFuelOrder(n.newFuel(n.newCrude()), "org5")
+	planID := n.newPlan(orderID)
+	n.as("Org5MSP").ok("transfer", orderID, "org5", halfHourLate, planID)
+	n.balances(map[string]Money{"org4": openingBalance + money("1.00")})
+}